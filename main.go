@@ -13,6 +13,7 @@ import (
 	_ "github.com/ekristen/dockit/pkg/commands/initcontainer"
 	_ "github.com/ekristen/dockit/pkg/commands/pki"
 	_ "github.com/ekristen/dockit/pkg/commands/rbac"
+	_ "github.com/ekristen/dockit/pkg/commands/registryconfig"
 )
 
 func main() {
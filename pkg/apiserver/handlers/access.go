@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ekristen/dockit/pkg/db"
+	"github.com/ekristen/dockit/pkg/docker"
+	"github.com/ekristen/dockit/pkg/utils"
+)
+
+var UnknownServiceError = errors.New("unknown service")
+
+// impliedActions returns the registry actions a permission action grants
+func impliedActions(action db.PermissionAction) []string {
+	switch action {
+	case db.Push:
+		return []string{string(db.Pull), string(db.Push)}
+	case db.Admin:
+		return []string{string(db.Pull), string(db.Push), "delete", string(db.Admin)}
+	default:
+		return []string{string(action)}
+	}
+}
+
+// permissionMatches reports whether a permission applies to the requested scope
+func permissionMatches(p *db.Permission, scope docker.Scope) bool {
+	switch p.Type {
+	case db.Namespace:
+		return scope.Type == string(db.Repository) && strings.HasPrefix(scope.Name, p.Name+"/")
+	default:
+		return string(p.Type) == scope.Type && p.Name == scope.Name
+	}
+}
+
+// permissionAllowsIP reports whether the cidr condition of a permission allows the client ip,
+// a permission without a condition applies from anywhere
+func permissionAllowsIP(p *db.Permission, ip string) bool {
+	if p.CIDRs == "" {
+		return true
+	}
+
+	nets, err := utils.ParseCIDRs(strings.Split(p.CIDRs, ","))
+	if err != nil {
+		return false
+	}
+
+	return utils.ContainsIP(nets, ip)
+}
+
+// entityIDs returns the id of the user and the ids of its active groups, the groups must be loaded
+func (h *handlers) entityIDs(user *db.User) []int64 {
+	ids := []int64{user.ID}
+	for _, g := range user.Groups {
+		if g.Active {
+			ids = append(ids, g.ID)
+		}
+	}
+
+	return ids
+}
+
+// lookupService returns the registered service for a token audience. When no services are
+// registered every audience is accepted and nil is returned, otherwise an unknown audience
+// returns UnknownServiceError.
+func (h *handlers) lookupService(name string) (*db.Service, error) {
+	var service db.Service
+	sql := h.db.Where("name = ?", name).First(&service)
+	if sql.Error == nil {
+		return &service, nil
+	}
+	if sql.Error != gorm.ErrRecordNotFound {
+		return nil, sql.Error
+	}
+
+	var count int64
+	if sql := h.db.Model(&db.Service{}).Count(&count); sql.Error != nil {
+		return nil, sql.Error
+	}
+	if count > 0 {
+		return nil, UnknownServiceError
+	}
+
+	return nil, nil
+}
+
+// resolveAccess returns the subset of the requested scopes that the entities have been granted from the
+// client ip, permissions bound to a service only apply to tokens for that service. The implicit permissions
+// are evaluated along with the ones stored in the database.
+func (h *handlers) resolveAccess(entityIDs []int64, implicit []db.Permission, service *db.Service, ip string, scopes []docker.Scope) ([]docker.Scope, error) {
+	granted := []docker.Scope{}
+	if len(scopes) == 0 {
+		return granted, nil
+	}
+
+	names := []string{}
+	for _, s := range scopes {
+		names = append(names, s.Name)
+		names = append(names, namespacePrefixes(s.Name)...)
+	}
+
+	serviceIDs := []int64{0}
+	if service != nil {
+		serviceIDs = append(serviceIDs, service.ID)
+	}
+
+	var permissions []db.Permission
+	sql := h.db.
+		Where("entity_id IN ?", entityIDs).
+		Where("service_id IN ?", serviceIDs).
+		Where("name IN ?", names).
+		Where("expires_at IS NULL OR expires_at > ?", time.Now().UTC()).
+		Find(&permissions)
+	if sql.Error != nil {
+		return nil, sql.Error
+	}
+
+	permissions = append(permissions, implicit...)
+
+	// the cidr condition does not depend on the scope, it is only parsed once per permission
+	fromIP := make([]bool, len(permissions))
+	for i := range permissions {
+		fromIP[i] = permissionAllowsIP(&permissions[i], ip)
+	}
+
+	for _, scope := range scopes {
+		allowed := map[string]bool{}
+		for i := range permissions {
+			if fromIP[i] && permissionMatches(&permissions[i], scope) {
+				for _, a := range impliedActions(permissions[i].Action) {
+					allowed[a] = true
+				}
+			}
+		}
+
+		actions := []string{}
+		for _, a := range scope.Actions {
+			if allowed[a] {
+				actions = append(actions, a)
+			}
+		}
+
+		if len(actions) > 0 {
+			granted = append(granted, docker.Scope{
+				Type:    scope.Type,
+				Class:   scope.Class,
+				Name:    scope.Name,
+				Actions: actions,
+			})
+		}
+	}
+
+	return granted, nil
+}
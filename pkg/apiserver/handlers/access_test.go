@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ekristen/dockit/pkg/db"
+	"github.com/ekristen/dockit/pkg/docker"
+)
+
+func TestPermissionMatches(t *testing.T) {
+	cases := []struct {
+		Permission db.Permission
+		Scope      docker.Scope
+		Expected   bool
+	}{
+		{db.Permission{Type: db.Namespace, Name: "team"}, docker.Scope{Type: "repository", Name: "team/app"}, true},
+		{db.Permission{Type: db.Namespace, Name: "team"}, docker.Scope{Type: "repository", Name: "team/sub/app"}, true},
+		{db.Permission{Type: db.Namespace, Name: "team"}, docker.Scope{Type: "repository", Name: "teamx/app"}, false},
+		{db.Permission{Type: db.Namespace, Name: "team"}, docker.Scope{Type: "repository", Name: "team"}, false},
+		{db.Permission{Type: db.Repository, Name: "team/app"}, docker.Scope{Type: "repository", Name: "team/app"}, true},
+		{db.Permission{Type: db.Repository, Name: "team/app"}, docker.Scope{Type: "repository", Name: "team/app2"}, false},
+		{db.Permission{Type: db.Registry, Name: "catalog"}, docker.Scope{Type: "registry", Name: "catalog"}, true},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.Expected, permissionMatches(&c.Permission, c.Scope), c.Permission.String()+" "+c.Scope.Name)
+	}
+}
+
+func Test_ResolveAccessCIDR(t *testing.T) {
+	h := newTestHandlers(t)
+
+	ci := &db.Group{Name: "ci", Active: true}
+	assert.NoError(t, h.db.Create(ci).Error)
+
+	assert.NoError(t, h.db.Create(&db.Permission{Type: db.Namespace, Name: "prod", Action: db.Push, EntityID: ci.ID, CIDRs: "10.20.0.0/16,192.168.1.5"}).Error)
+	assert.NoError(t, h.db.Create(&db.Permission{Type: db.Namespace, Name: "prod", Action: db.Pull, EntityID: ci.ID}).Error)
+
+	scopes := []docker.Scope{
+		{Type: "repository", Name: "prod/web", Actions: []string{"pull", "push"}},
+		{Type: "repository", Name: "prod/api", Actions: []string{"push"}},
+	}
+
+	cases := []struct {
+		IP       string
+		Expected []docker.Scope
+	}{
+		{"10.20.3.4", []docker.Scope{
+			{Type: "repository", Name: "prod/web", Actions: []string{"pull", "push"}},
+			{Type: "repository", Name: "prod/api", Actions: []string{"push"}},
+		}},
+		{"192.168.1.5", []docker.Scope{
+			{Type: "repository", Name: "prod/web", Actions: []string{"pull", "push"}},
+			{Type: "repository", Name: "prod/api", Actions: []string{"push"}},
+		}},
+		{"172.16.0.1", []docker.Scope{
+			{Type: "repository", Name: "prod/web", Actions: []string{"pull"}},
+		}},
+		{"", []docker.Scope{
+			{Type: "repository", Name: "prod/web", Actions: []string{"pull"}},
+		}},
+	}
+
+	for _, c := range cases {
+		granted, err := h.resolveAccess([]int64{ci.ID}, nil, nil, c.IP, scopes)
+		assert.NoError(t, err)
+		assert.Equal(t, c.Expected, granted, c.IP)
+	}
+}
@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+
+	"github.com/ekristen/dockit/pkg/apiserver/response"
+	"github.com/ekristen/dockit/pkg/db"
+	"github.com/ekristen/dockit/pkg/httpauth"
+)
+
+// userAuth authenticates the request of an active user using basic auth or a bearer token for the admin api
+func (h *handlers) userAuth(log *logrus.Entry, w http.ResponseWriter, r *http.Request) (*db.User, error) {
+	if strings.HasPrefix(r.Header.Get("Authorization"), httpauth.BEARER_SCHEMA) {
+		return h.bearerAuth(log, r)
+	}
+
+	return h.basicAuth(log, w, r)
+}
+
+// basicAuth authenticates the request of an active user using basic auth only
+func (h *handlers) basicAuth(log *logrus.Entry, w http.ResponseWriter, r *http.Request) (*db.User, error) {
+	auth, err := httpauth.Parse(r)
+	if err != nil {
+		log.WithError(err).Debug("unable to parse auth header")
+		return nil, UnauthorizedError
+	}
+
+	// only the username is considered so an ip lockout does not prevent an admin from unlocking it
+	keys := []string{userLockoutKey(auth.Username())}
+	if err := h.checkLockout(w, log, keys); err != nil {
+		return nil, err
+	}
+
+	var user db.User
+	sql := h.db.Preload("Groups").Where("username = ? AND active = ?", auth.Username(), true).First(&user)
+	if sql.Error != nil {
+		if sql.Error == gorm.ErrRecordNotFound {
+			if err := h.recordFailure(log, keys); err != nil {
+				log.WithError(err).Error("unable to record failure")
+			}
+			return nil, UnauthorizedError
+		}
+
+		log.WithError(sql.Error).Error("unable to query database")
+		return nil, DBError
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(auth.Password())); err != nil {
+		log.WithError(err).Debug("invalid password")
+		if err := h.recordFailure(log, keys); err != nil {
+			log.WithError(err).Error("unable to record failure")
+		}
+		return nil, UnauthorizedError
+	}
+
+	if err := h.resetFailures(keys[0]); err != nil {
+		log.WithError(err).Error("unable to reset failures")
+	}
+
+	return &user, nil
+}
+
+// adminAuth authenticates the request using basic auth and ensures the user is an admin
+func (h *handlers) adminAuth(log *logrus.Entry, w http.ResponseWriter, r *http.Request) (*db.User, error) {
+	user, err := h.userAuth(log, w, r)
+	if err != nil {
+		return nil, err
+	}
+
+	if !user.Admin {
+		log.WithField("user", user.Username).Debug("user is not an admin")
+		return nil, ForbiddenError
+	}
+
+	return user, nil
+}
+
+// passwordAuth authenticates an active user by username and password, failures count towards
+// the lockout of the username and, when enabled, the client ip
+func (h *handlers) passwordAuth(log *logrus.Entry, w http.ResponseWriter, r *http.Request, username, password string) (*db.User, error) {
+	keys := h.lockoutKeys(r, username)
+	if err := h.checkLockout(w, log, keys); err != nil {
+		return nil, err
+	}
+
+	var user db.User
+	sql := h.db.Preload("Groups").Where("username = ? AND active = ?", username, true).First(&user)
+	if sql.Error != nil {
+		if sql.Error == gorm.ErrRecordNotFound {
+			log.Debug("unknown user")
+			if err := h.recordFailure(log, keys); err != nil {
+				log.WithError(err).Error("unable to record failure")
+			}
+			return nil, UnauthorizedError
+		}
+
+		log.WithError(sql.Error).Error("unable to query database")
+		return nil, DBError
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+		log.WithError(err).Debug("invalid password")
+		if err := h.recordFailure(log, keys); err != nil {
+			log.WithError(err).Error("unable to record failure")
+		}
+		return nil, UnauthorizedError
+	}
+
+	if err := h.resetFailures(keys[0]); err != nil {
+		log.WithError(err).Error("unable to reset failures")
+	}
+
+	return &user, nil
+}
+
+// bearerUser returns the active user of the dockit access token in the authorization header and the
+// claims of the token, nil is returned when the request has no valid and unrevoked token
+func (h *handlers) bearerUser(log *logrus.Entry, r *http.Request) (*db.User, *TokenClaims) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return nil, nil
+	}
+
+	claims := &TokenClaims{}
+	if _, err := jwt.ParseWithClaims(strings.TrimPrefix(header, "Bearer "), claims, h.verificationKey); err != nil {
+		log.WithError(err).Debug("invalid bearer token")
+		return nil, nil
+	}
+	if claims.Issuer != h.config.TokenIssuer {
+		log.WithField("issuer", claims.Issuer).Debug("bearer token issued by another issuer")
+		return nil, nil
+	}
+
+	_, user, err := h.activeToken(db.AccessTokenType, "jti = ?", claims.Id)
+	if err != nil {
+		log.WithError(err).Error("unable to query database")
+		return nil, nil
+	}
+	if user == nil {
+		return nil, nil
+	}
+
+	return user, claims
+}
+
+// sendAuthError sends the appropriate response for an error returned by userAuth or adminAuth
+func sendAuthError(w http.ResponseWriter, r *http.Request, err error) {
+	code := 401
+	switch err {
+	case DBError:
+		code = 500
+	case LockedOutError:
+		code = 429
+	case ForbiddenError:
+		code = 403
+	}
+
+	response.New(w, r).AddError(err).Send(code)
+}
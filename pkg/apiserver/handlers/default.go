@@ -1,26 +1,107 @@
 package handlers
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"time"
 
-	"github.com/ekristen/dockit/pkg/apiserver/types"
 	"github.com/ekristen/dockit/pkg/common"
+	"github.com/ekristen/dockit/pkg/envelope"
+	"github.com/ekristen/dockit/pkg/issuer"
+	"github.com/ekristen/dockit/pkg/signer"
 	"gorm.io/gorm"
 )
 
 var DBError = errors.New("database error")
 var UnauthorizedError = errors.New("unauthorized")
+var ForbiddenError = errors.New("forbidden")
+
+// Config --
+type Config struct {
+	// LockoutThreshold is the number of failed attempts before a lockout, 0 disables lockouts
+	LockoutThreshold int
+	// LockoutDuration is the duration of the first lockout, it doubles on every subsequent lockout
+	LockoutDuration time.Duration
+	// LockoutMaxDuration caps the lockout duration and is how long failures are remembered
+	LockoutMaxDuration time.Duration
+	// LockoutIP also locks out client ips, behind a proxy that is not trusted every client shares its ip
+	LockoutIP bool
+
+	// TokenIssuer is the issuer of all tokens, it has to match the issuer configured on the registry
+	TokenIssuer string
+	// TokenTTL is the default lifetime of a token
+	TokenTTL time.Duration
+	// TokenMaxTTL caps the lifetime of a token including user and group overrides, 0 disables the cap
+	TokenMaxTTL time.Duration
+	// RobotTokenTTL is the lifetime of a token for a robot user without an override, 0 uses TokenTTL
+	RobotTokenTTL time.Duration
+	// RefreshTokenTTL is the lifetime of a refresh token
+	RefreshTokenTTL time.Duration
+	// PersonalTokenMaxTTL caps the lifetime of a personal access token
+	PersonalTokenMaxTTL time.Duration
+
+	// GroupMaxDepth is the number of levels of nested groups that are resolved
+	GroupMaxDepth int
+
+	// PersonalNamespace is the template of the namespace every user can push to, empty disables it
+	PersonalNamespace string
+	// GroupNamespace is the template of the namespace the members of a group can push to, empty disables it
+	GroupNamespace string
+
+	// SharedNamespaces are the namespaces in which the first user to push a repository becomes its owner
+	SharedNamespaces []string
+
+	// EventsToken is a bearer token any registry can post notifications with, registries that are
+	// registered as a service should use the events token of the service instead
+	EventsToken string
+
+	// Keyring decrypts the pki private keys, nil when they are stored unencrypted
+	Keyring *envelope.Keyring
+
+	// Signer signs with the private keys that are held outside of the database, like in a hsm
+	Signer signer.Signer
+
+	// Issuer generates and imports the signing pki managed with the admin api, nil when it is not managed by dockit
+	Issuer *issuer.Issuer
+	// PKIPrepublish is how long a generated or imported pki is published before it is activated
+	PKIPrepublish time.Duration
+	// Algorithms are the algorithms the registries accept, a pki signing with another one is not activated
+	Algorithms []string
+
+	// TrustedProxies are the networks whose forwarded headers are trusted to resolve the client ip
+	TrustedProxies []*net.IPNet
+}
 
 type handlers struct {
-	db *gorm.DB
+	db     *gorm.DB
+	config *Config
 }
 
-func New(db *gorm.DB) *handlers {
+func New(db *gorm.DB, config *Config) *handlers {
+	if config == nil {
+		config = &Config{}
+	}
+	if config.TokenIssuer == "" {
+		config.TokenIssuer = common.AppVersion.Name
+	}
+	if config.TokenTTL == 0 {
+		config.TokenTTL = 300 * time.Second
+	}
+	if config.GroupMaxDepth < 1 {
+		config.GroupMaxDepth = 5
+	}
+	if config.RefreshTokenTTL == 0 {
+		config.RefreshTokenTTL = 30 * 24 * time.Hour
+	}
+	if config.PersonalTokenMaxTTL == 0 {
+		config.PersonalTokenMaxTTL = DefaultPersonalTokenMaxTTL
+	}
+
 	return &handlers{
-		db: db,
+		db:     db,
+		config: config,
 	}
 }
 
@@ -30,15 +111,3 @@ func (h *handlers) Root(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(200)
 	w.Write([]byte(data))
 }
-
-func sendErrorResponse(w http.ResponseWriter, code int, err error) {
-	res := types.Response{
-		Success: false,
-		Errors:  types.Errors{err},
-	}
-	w.WriteHeader(code)
-	if err := json.NewEncoder(w).Encode(res); err != nil {
-		w.WriteHeader(500)
-		w.Write([]byte(`{"success": false}`))
-	}
-}
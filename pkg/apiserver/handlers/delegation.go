@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ekristen/dockit/pkg/common"
+	"github.com/ekristen/dockit/pkg/db"
+)
+
+// withinNamespaces reports whether a permission target is one of the namespaces or inside of one
+func withinNamespaces(namespaces []string, permType db.PermissionType, name string) bool {
+	for _, ns := range namespaces {
+		if permType == db.Namespace && name == ns {
+			return true
+		}
+		if (permType == db.Namespace || permType == db.Repository) && strings.HasPrefix(name, ns+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// adminNamespaces returns the namespaces the user has been granted admin on from the client ip of the request
+func (h *handlers) adminNamespaces(r *http.Request, user *db.User) ([]string, error) {
+	groups, err := h.resolveGroups(user)
+	if err != nil {
+		return nil, err
+	}
+	user.Groups = groups
+
+	var permissions []db.Permission
+	sql := h.db.
+		Where("entity_id IN ?", h.entityIDs(user)).
+		Where("service_id = ?", 0).
+		Where("type = ? AND action = ?", db.Namespace, db.Admin).
+		Where("expires_at IS NULL OR expires_at > ?", time.Now().UTC()).
+		Find(&permissions)
+	if sql.Error != nil {
+		return nil, sql.Error
+	}
+
+	ip, _ := r.Context().Value(common.ContextKeyRemoteAddr).(string)
+
+	namespaces := []string{}
+	for i := range permissions {
+		if permissionAllowsIP(&permissions[i], ip) {
+			namespaces = append(namespaces, permissions[i].Name)
+		}
+	}
+
+	return namespaces, nil
+}
+
+// canManagePermission reports whether the user can grant and revoke a permission on the target, global admins
+// can manage every permission, namespace admins the permissions within their namespaces and owners the
+// permissions of their repositories
+func (h *handlers) canManagePermission(r *http.Request, user *db.User, permType db.PermissionType, name string) (bool, error) {
+	if user.Admin {
+		return true, nil
+	}
+
+	namespaces, err := h.adminNamespaces(r, user)
+	if err != nil {
+		return false, err
+	}
+	if withinNamespaces(namespaces, permType, name) {
+		return true, nil
+	}
+
+	if permType == db.Repository {
+		return h.canAdminRepository(r, user, name)
+	}
+
+	return false, nil
+}
+
+// canManageGroup reports whether the user can manage the members of a group. Besides global admins only
+// namespace admins can, and only if everything a new member would gain through the group and the groups it
+// is nested in is within their namespaces. Token policies are not bound to a namespace, so groups with one
+// are left to global admins.
+func (h *handlers) canManageGroup(r *http.Request, user *db.User, group *db.Group) (bool, error) {
+	if user.Admin {
+		return true, nil
+	}
+
+	namespaces, err := h.adminNamespaces(r, user)
+	if err != nil || len(namespaces) == 0 {
+		return false, err
+	}
+
+	groups, err := h.groupAncestors(group)
+	if err != nil {
+		return false, err
+	}
+
+	ids := make([]int64, 0, len(groups))
+	for _, g := range groups {
+		if g.TokenTTL != 0 || g.TokenClaims != "" {
+			return false, nil
+		}
+
+		ids = append(ids, g.ID)
+
+		if h.config.GroupNamespace != "" {
+			ns := expandNamespace(h.config.GroupNamespace, GroupPlaceholder, g.Name)
+			if !withinNamespaces(namespaces, db.Namespace, ns) {
+				return false, nil
+			}
+		}
+	}
+
+	var permissions []db.Permission
+	sql := h.db.
+		Where("entity_id IN ?", ids).
+		Where("expires_at IS NULL OR expires_at > ?", time.Now().UTC()).
+		Find(&permissions)
+	if sql.Error != nil {
+		return false, sql.Error
+	}
+
+	// a group without permissions has nothing that could be delegated
+	if len(permissions) == 0 {
+		return false, nil
+	}
+
+	for _, p := range permissions {
+		if !withinNamespaces(namespaces, p.Type, p.Name) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
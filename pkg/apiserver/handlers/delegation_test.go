@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ekristen/dockit/pkg/db"
+)
+
+func Test_WithinNamespaces(t *testing.T) {
+	namespaces := []string{"team-a", "shared/tools"}
+
+	assert.True(t, withinNamespaces(namespaces, db.Namespace, "team-a"))
+	assert.True(t, withinNamespaces(namespaces, db.Namespace, "team-a/sub"))
+	assert.True(t, withinNamespaces(namespaces, db.Repository, "team-a/app"))
+	assert.True(t, withinNamespaces(namespaces, db.Repository, "shared/tools/lint"))
+
+	assert.False(t, withinNamespaces(namespaces, db.Repository, "team-a"))
+	assert.False(t, withinNamespaces(namespaces, db.Namespace, "team-ab"))
+	assert.False(t, withinNamespaces(namespaces, db.Repository, "team-ab/app"))
+	assert.False(t, withinNamespaces(namespaces, db.Namespace, "shared"))
+	assert.False(t, withinNamespaces(nil, db.Namespace, "team-a"))
+}
+
+func Test_DelegatedGroupActions(t *testing.T) {
+	h := newTestHandlers(t)
+	testAdmin(t, h)
+
+	lead := &db.User{Username: "lead", Password: "leadpw", Active: true}
+	assert.NoError(t, h.db.Create(lead).Error)
+	assert.NoError(t, h.db.Create(&db.Permission{Type: db.Namespace, Name: "team-a", Action: db.Admin, EntityID: lead.ID}).Error)
+
+	bob := &db.User{Username: "bob", Password: "bobpw", Active: true}
+	assert.NoError(t, h.db.Create(bob).Error)
+
+	devs := &db.Group{Name: "devs", Active: true}
+	assert.NoError(t, h.db.Create(devs).Error)
+	assert.NoError(t, h.db.Create(&db.Permission{Type: db.Namespace, Name: "team-a", Action: db.Push, EntityID: devs.ID}).Error)
+
+	ops := &db.Group{Name: "ops", Active: true}
+	assert.NoError(t, h.db.Create(ops).Error)
+	assert.NoError(t, h.db.Create(&db.Permission{Type: db.Namespace, Name: "team-b", Action: db.Push, EntityID: ops.ID}).Error)
+
+	ci := &db.Group{Name: "ci", Active: true, TokenTTL: 3600}
+	assert.NoError(t, h.db.Create(ci).Error)
+	assert.NoError(t, h.db.Create(&db.Permission{Type: db.Namespace, Name: "team-a", Action: db.Push, EntityID: ci.ID}).Error)
+
+	nested := &db.Group{Name: "nested", Active: true}
+	assert.NoError(t, h.db.Create(nested).Error)
+	assert.NoError(t, h.db.Create(&db.Permission{Type: db.Repository, Name: "team-a/app", Action: db.Pull, EntityID: nested.ID}).Error)
+	assert.NoError(t, h.addChildGroup(ci, nested))
+
+	empty := &db.Group{Name: "empty", Active: true}
+	assert.NoError(t, h.db.Create(empty).Error)
+
+	member := func(group, action, username, password string) int {
+		vars := map[string]string{"rbac_type": "group", "rbac_entity": group, "action": action, "rbac_type_2": "user", "rbac_entity_2": "bob"}
+		return testRequest(t, h.Action, username, password, "PUT", "/v2/rbac/group/"+group+"/"+action+"/user/bob", vars, nil, nil)
+	}
+
+	cases := []struct {
+		Group    string
+		Action   string
+		Username string
+		Expected int
+	}{
+		// everything the group grants is within the namespace of the namespace admin
+		{"devs", "add-member", "lead", 200},
+		{"devs", "remove-member", "lead", 200},
+		// the group grants outside of it
+		{"ops", "add-member", "lead", 403},
+		// a group without permissions has nothing to delegate
+		{"empty", "add-member", "lead", 403},
+		// token policies are not bound to a namespace, on the group or a group it is nested in
+		{"ci", "add-member", "lead", 403},
+		{"nested", "add-member", "lead", 403},
+		// only memberships are delegated
+		{"devs", "disable", "lead", 403},
+		{"devs", "add", "lead", 403},
+		// global admins manage every group
+		{"ci", "add-member", "admin", 200},
+		{"ops", "add-member", "admin", 200},
+	}
+
+	passwords := map[string]string{"lead": "leadpw", "admin": "adminpw"}
+	for _, c := range cases {
+		assert.Equal(t, c.Expected, member(c.Group, c.Action, c.Username, passwords[c.Username]), c.Username+" "+c.Action+" "+c.Group)
+	}
+
+	var groups []*db.Group
+	assert.NoError(t, h.db.Model(bob).Association("Groups").Find(&groups))
+	names := []string{}
+	for _, g := range groups {
+		names = append(names, g.Name)
+	}
+	assert.ElementsMatch(t, []string{"ci", "ops"}, names)
+
+	// namespace admins can list the permissions of a group they can manage
+	var permissions []db.Permission
+	vars := map[string]string{"rbac_type": "group", "rbac_entity": "devs", "action": "permissions"}
+	assert.Equal(t, 200, testRequest(t, h.Action, "lead", "leadpw", "GET", "/v2/rbac/group/devs/permissions", vars, nil, &permissions))
+	assert.Len(t, permissions, 1)
+
+	vars = map[string]string{"rbac_type": "user", "rbac_entity": "bob", "action": "disable"}
+	assert.Equal(t, 403, testRequest(t, h.Action, "lead", "leadpw", "PUT", "/v2/rbac/user/bob/disable", vars, nil, nil))
+
+	// a namespace admin from outside its network is none
+	assert.NoError(t, h.db.Model(&db.Permission{}).Where("entity_id = ?", lead.ID).Update("cidrs", "10.0.0.0/8").Error)
+	assert.Equal(t, 403, member("devs", "add-member", "lead", "leadpw"))
+}
@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/ekristen/dockit/pkg/apiserver/response"
+	"github.com/ekristen/dockit/pkg/common"
+	"github.com/ekristen/dockit/pkg/db"
+	"github.com/ekristen/dockit/pkg/httpauth"
+	"github.com/ekristen/dockit/pkg/metrics"
+)
+
+// maxEventsBody limits the size of a notification body
+const maxEventsBody = 10 << 20
+
+// RegistryEvents is the notification envelope posted by a distribution registry
+type RegistryEvents struct {
+	Events []RegistryEvent `json:"events"`
+}
+
+// RegistryEvent is a single distribution notification, only the fields dockit stores are decoded
+type RegistryEvent struct {
+	ID        string    `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	Action    string    `json:"action"`
+	Target    struct {
+		MediaType  string `json:"mediaType"`
+		Size       int64  `json:"size"`
+		Digest     string `json:"digest"`
+		Repository string `json:"repository"`
+		Tag        string `json:"tag"`
+	} `json:"target"`
+	Request struct {
+		Addr      string `json:"addr"`
+		UserAgent string `json:"useragent"`
+	} `json:"request"`
+	Actor struct {
+		Name string `json:"name"`
+	} `json:"actor"`
+}
+
+// RepositoryHistory is the event history of a repository
+type RepositoryHistory struct {
+	Repository   string     `json:"repository"`
+	LastPushed   *time.Time `json:"last_pushed,omitempty"`
+	LastPushedBy string     `json:"last_pushed_by,omitempty"`
+	LastPulled   *time.Time `json:"last_pulled,omitempty"`
+	Events       []db.Event `json:"events"`
+}
+
+// isManifestEvent reports whether an event is about a manifest rather than a blob, deletes are always kept
+// because distribution does not include the media type for them
+func isManifestEvent(e *RegistryEvent) bool {
+	if e.Action == "delete" || e.Target.Tag != "" {
+		return true
+	}
+
+	mediaType := e.Target.MediaType
+	return strings.Contains(mediaType, "manifest") || strings.Contains(mediaType, "image.index")
+}
+
+// eventsAuth authenticates a registry posting notifications and returns the name of its service,
+// the service is empty for the global events token
+func (h *handlers) eventsAuth(log *logrus.Entry, r *http.Request) (string, error) {
+	auth, err := httpauth.Parse(r)
+	if err != nil || auth.Schema() != httpauth.BEARER_SCHEMA {
+		return "", UnauthorizedError
+	}
+
+	if h.config.EventsToken != "" && subtle.ConstantTimeCompare([]byte(auth.Token()), []byte(h.config.EventsToken)) == 1 {
+		return "", nil
+	}
+
+	var service db.Service
+	sql := h.db.Where("events_token = ?", hashToken(auth.Token())).First(&service)
+	if sql.Error != nil {
+		if sql.Error == gorm.ErrRecordNotFound {
+			return "", UnauthorizedError
+		}
+
+		log.WithError(sql.Error).Error("unable to query database")
+		return "", DBError
+	}
+
+	return service.Name, nil
+}
+
+// Events receives the notifications of a distribution registry and stores the manifest events
+func (h *handlers) Events(w http.ResponseWriter, r *http.Request) {
+	reqID := r.Context().Value(common.ContextReqIDKey)
+	log := logrus.WithField("reqID", reqID)
+
+	service, err := h.eventsAuth(log, r)
+	if err != nil {
+		sendAuthError(w, r, err)
+		return
+	}
+
+	var envelope RegistryEvents
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxEventsBody)).Decode(&envelope); err != nil {
+		response.New(w, r).AddError(fmt.Errorf("invalid events: %s", err)).Send(400)
+		return
+	}
+
+	received := time.Now().UTC()
+
+	events := []db.Event{}
+	for i := range envelope.Events {
+		e := &envelope.Events[i]
+		if e.ID == "" || !isManifestEvent(e) {
+			continue
+		}
+
+		// an event without a timestamp would sort before every other and be pruned right away
+		timestamp := e.Timestamp.UTC()
+		if e.Timestamp.IsZero() {
+			timestamp = received
+		}
+
+		events = append(events, db.Event{
+			EventID:    e.ID,
+			Service:    service,
+			Action:     e.Action,
+			Repository: e.Target.Repository,
+			Tag:        e.Target.Tag,
+			Digest:     e.Target.Digest,
+			MediaType:  e.Target.MediaType,
+			Size:       e.Target.Size,
+			Actor:      e.Actor.Name,
+			ClientAddr: e.Request.Addr,
+			UserAgent:  e.Request.UserAgent,
+			Timestamp:  &timestamp,
+		})
+	}
+
+	var pushes []db.Event
+	if len(h.config.SharedNamespaces) > 0 {
+		pushes, err = h.newRepositoryPushes(events)
+		if err != nil {
+			log.WithError(err).Error("unable to query database")
+			response.New(w, r).AddError(DBError).Send(500)
+			return
+		}
+	}
+
+	if len(events) > 0 {
+		// registries retry failed deliveries so events that were already stored are skipped
+		sql := h.db.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "event_id"}}, DoNothing: true}).Create(&events)
+		if sql.Error != nil {
+			log.WithError(sql.Error).Error("unable to store events")
+			response.New(w, r).AddError(DBError).Send(500)
+			return
+		}
+	}
+
+	// only the push that created a repository makes its actor the owner
+	for i := range pushes {
+		var users []db.User
+		if sql := h.db.Where("username = ?", pushes[i].Actor).Limit(1).Find(&users); sql.Error != nil {
+			log.WithError(sql.Error).Error("unable to query database")
+			continue
+		}
+		if len(users) == 0 {
+			continue
+		}
+
+		if err := h.claimOwnership(log, &users[0], &pushes[i]); err != nil {
+			log.WithError(err).Error("unable to record repository owner")
+		}
+	}
+
+	metrics.EventsReceived.Add(int64(len(events)))
+
+	log.WithField("service", service).WithField("received", len(envelope.Events)).WithField("stored", len(events)).Debug("registry events")
+
+	response.New(w, r).Success().Send(200)
+}
+
+func (h *handlers) RepositoryHistory(w http.ResponseWriter, r *http.Request) {
+	reqID := r.Context().Value(common.ContextReqIDKey)
+	log := logrus.WithField("reqID", reqID)
+
+	res := response.New(w, r)
+
+	if _, err := h.adminAuth(log, w, r); err != nil {
+		sendAuthError(w, r, err)
+		return
+	}
+
+	history := RepositoryHistory{Repository: mux.Vars(r)["name"]}
+
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l < 1 || l > 1000 {
+			res.AddError(errors.New("invalid limit: must be between 1 and 1000")).Send(400)
+			return
+		}
+		limit = l
+	}
+
+	query := h.db.Where("repository = ?", history.Repository)
+	if tag := r.URL.Query().Get("tag"); tag != "" {
+		query = query.Where("tag = ?", tag)
+	}
+	if action := r.URL.Query().Get("action"); action != "" {
+		query = query.Where("action = ?", action)
+	}
+
+	sql := query.Order("timestamp DESC").Limit(limit).Find(&history.Events)
+	if sql.Error != nil {
+		log.WithError(sql.Error).Error("unable to query database")
+		res.AddError(DBError).Send(500)
+		return
+	}
+
+	var pushed []db.Event
+	sql = h.db.Where("repository = ? AND action = ?", history.Repository, "push").Order("timestamp DESC").Limit(1).Find(&pushed)
+	if sql.Error != nil {
+		log.WithError(sql.Error).Error("unable to query database")
+		res.AddError(DBError).Send(500)
+		return
+	}
+	if len(pushed) > 0 {
+		history.LastPushed = pushed[0].Timestamp
+		history.LastPushedBy = pushed[0].Actor
+	}
+
+	var pulled []db.Event
+	sql = h.db.Where("repository = ? AND action = ?", history.Repository, "pull").Order("timestamp DESC").Limit(1).Find(&pulled)
+	if sql.Error != nil {
+		log.WithError(sql.Error).Error("unable to query database")
+		res.AddError(DBError).Send(500)
+		return
+	}
+	if len(pulled) > 0 {
+		history.LastPulled = pulled[0].Timestamp
+	}
+
+	res.AddData(history).Send(200)
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ekristen/dockit/pkg/db"
+)
+
+// postEvents posts a notification envelope to the events handler with the bearer token
+func postEvents(t *testing.T, h *handlers, token string, events ...RegistryEvent) int {
+	data, err := json.Marshal(RegistryEvents{Events: events})
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest("POST", "/v2/events", bytes.NewReader(data))
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	rec := httptest.NewRecorder()
+	h.Events(rec, req)
+
+	return rec.Code
+}
+
+// registryEvent returns a manifest event for a tag of a repository
+func registryEvent(id, action, repository, tag string, timestamp time.Time) RegistryEvent {
+	e := RegistryEvent{ID: id, Action: action, Timestamp: timestamp}
+	e.Target.Repository = repository
+	e.Target.Tag = tag
+	e.Target.MediaType = "application/vnd.docker.distribution.manifest.v2+json"
+	e.Actor.Name = "alice"
+
+	return e
+}
+
+func Test_Events(t *testing.T) {
+	h := newTestHandlers(t)
+	h.config.EventsToken = "events-token"
+
+	pushed := time.Date(2022, 3, 1, 12, 0, 0, 0, time.FixedZone("CET", 60*60))
+	push := registryEvent("1", "push", "apps/web", "v1", pushed)
+
+	blob := registryEvent("2", "push", "apps/web", "", pushed)
+	blob.Target.MediaType = "application/octet-stream"
+
+	assert.Equal(t, 401, postEvents(t, h, "wrong", push))
+	assert.Equal(t, 200, postEvents(t, h, "events-token", push, blob, registryEvent("", "push", "apps/web", "v1", pushed)))
+
+	var events []db.Event
+	assert.NoError(t, h.db.Find(&events).Error)
+	assert.Len(t, events, 1)
+	assert.Equal(t, "1", events[0].EventID)
+	assert.Equal(t, "alice", events[0].Actor)
+	assert.True(t, pushed.Equal(*events[0].Timestamp))
+
+	// registries retry deliveries, an event that is already stored is skipped
+	retried := registryEvent("1", "push", "apps/web", "v2", pushed)
+	assert.Equal(t, 200, postEvents(t, h, "events-token", retried, registryEvent("3", "pull", "apps/web", "v1", pushed)))
+
+	events = nil
+	assert.NoError(t, h.db.Order("event_id").Find(&events).Error)
+	assert.Len(t, events, 2)
+	assert.Equal(t, "v1", events[0].Tag)
+	assert.Equal(t, "3", events[1].EventID)
+
+	// an event without a timestamp gets the time it was received
+	before := time.Now().UTC()
+	assert.Equal(t, 200, postEvents(t, h, "events-token", registryEvent("4", "push", "apps/web", "v2", time.Time{})))
+
+	var event db.Event
+	assert.NoError(t, h.db.Where("event_id = ?", "4").First(&event).Error)
+	assert.False(t, event.Timestamp.Before(before))
+	assert.False(t, event.Timestamp.After(time.Now().UTC()))
+}
+
+func Test_RepositoryHistory(t *testing.T) {
+	h := newTestHandlers(t)
+	testAdmin(t, h)
+	h.config.EventsToken = "events-token"
+
+	start := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, 200, postEvents(t, h, "events-token",
+		registryEvent("1", "push", "apps/web", "v1", start),
+		registryEvent("2", "push", "apps/web", "v2", start.Add(time.Hour)),
+		registryEvent("3", "pull", "apps/web", "v1", start.Add(2*time.Hour)),
+		registryEvent("4", "push", "apps/api", "v1", start.Add(3*time.Hour)),
+	))
+
+	vars := map[string]string{"name": "apps/web"}
+
+	assert.Equal(t, 401, testRequest(t, h.RepositoryHistory, "", "", "GET", "/v2/admin/repositories/apps/web/history", vars, nil, nil))
+
+	var history RepositoryHistory
+	assert.Equal(t, 200, adminRequest(t, h.RepositoryHistory, "GET", "/v2/admin/repositories/apps/web/history", vars, nil, &history))
+	assert.Equal(t, "apps/web", history.Repository)
+	assert.Len(t, history.Events, 3)
+	assert.Equal(t, "3", history.Events[0].EventID)
+	assert.True(t, start.Add(time.Hour).Equal(*history.LastPushed))
+	assert.Equal(t, "alice", history.LastPushedBy)
+	assert.True(t, start.Add(2*time.Hour).Equal(*history.LastPulled))
+
+	history = RepositoryHistory{}
+	assert.Equal(t, 200, adminRequest(t, h.RepositoryHistory, "GET", "/v2/admin/repositories/apps/web/history?tag=v1&action=push", vars, nil, &history))
+	assert.Len(t, history.Events, 1)
+	assert.Equal(t, "1", history.Events[0].EventID)
+
+	history = RepositoryHistory{}
+	assert.Equal(t, 200, adminRequest(t, h.RepositoryHistory, "GET", "/v2/admin/repositories/apps/web/history?limit=1", vars, nil, &history))
+	assert.Len(t, history.Events, 1)
+
+	assert.Equal(t, 400, adminRequest(t, h.RepositoryHistory, "GET", "/v2/admin/repositories/apps/web/history?limit=0", vars, nil, nil))
+
+	history = RepositoryHistory{}
+	assert.Equal(t, 200, adminRequest(t, h.RepositoryHistory, "GET", "/v2/admin/repositories/apps/db/history", map[string]string{"name": "apps/db"}, nil, &history))
+	assert.Empty(t, history.Events)
+	assert.Nil(t, history.LastPushed)
+}
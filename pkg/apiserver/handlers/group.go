@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ekristen/dockit/pkg/db"
+)
+
+var GroupCycleError = errors.New("group membership would create a cycle")
+
+// resolveGroups returns the active groups of a user including the groups inherited through nested groups,
+// the direct groups of the user must be loaded. Resolution stops at GroupMaxDepth levels and a disabled
+// group does not pass on the groups it is a member of.
+func (h *handlers) resolveGroups(user *db.User) ([]*db.Group, error) {
+	seen := map[int64]bool{}
+	resolved := []*db.Group{}
+
+	current := []int64{}
+	for _, g := range user.Groups {
+		if g.Active && !seen[g.ID] {
+			seen[g.ID] = true
+			resolved = append(resolved, g)
+			current = append(current, g.ID)
+		}
+	}
+
+	for depth := 1; depth < h.config.GroupMaxDepth && len(current) > 0; depth++ {
+		var parents []*db.Group
+		sql := h.db.
+			Joins("JOIN group_groups ON group_groups.parent_id = groups.id").
+			Where("group_groups.child_id IN ? AND groups.active = ?", current, true).
+			Find(&parents)
+		if sql.Error != nil {
+			return nil, sql.Error
+		}
+
+		current = []int64{}
+		for _, p := range parents {
+			if !seen[p.ID] {
+				seen[p.ID] = true
+				resolved = append(resolved, p)
+				current = append(current, p.ID)
+			}
+		}
+	}
+
+	return resolved, nil
+}
+
+// isDescendant reports whether the group is reachable from the ancestor through nested groups
+func (h *handlers) isDescendant(ancestorID, groupID int64) (bool, error) {
+	seen := map[int64]bool{ancestorID: true}
+	current := []int64{ancestorID}
+
+	for len(current) > 0 {
+		var children []int64
+		sql := h.db.Table("group_groups").Where("parent_id IN ?", current).Pluck("child_id", &children)
+		if sql.Error != nil {
+			return false, sql.Error
+		}
+
+		current = []int64{}
+		for _, id := range children {
+			if id == groupID {
+				return true, nil
+			}
+			if !seen[id] {
+				seen[id] = true
+				current = append(current, id)
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// addChildGroup makes the child a member of the parent, rejecting memberships that would create a cycle
+func (h *handlers) addChildGroup(parent, child *db.Group) error {
+	if parent.ID == child.ID {
+		return GroupCycleError
+	}
+
+	cycle, err := h.isDescendant(child.ID, parent.ID)
+	if err != nil {
+		return err
+	}
+	if cycle {
+		return GroupCycleError
+	}
+
+	return h.db.Model(parent).Association("Children").Append(child)
+}
+
+// groupPrincipals returns the members of a group holding a permission, members of nested groups are
+// included with the chain of groups they inherit the permission through. The members of the group are the
+// first level like the direct groups of a user in resolveGroups, so both stop at GroupMaxDepth levels.
+func (h *handlers) groupPrincipals(group *db.Group, permission string) ([]Principal, error) {
+	type level struct {
+		group *db.Group
+		via   []string
+	}
+
+	principals := []Principal{}
+	seen := map[int64]bool{group.ID: true}
+	current := []level{{group: group, via: []string{fmt.Sprintf("group:%s", group.Name)}}}
+
+	for depth := 1; len(current) > 0; depth++ {
+		next := []level{}
+
+		for _, l := range current {
+			var g db.Group
+			sql := h.db.Preload("Users").Preload("Children").Where("id = ?", l.group.ID).First(&g)
+			if sql.Error != nil {
+				return nil, sql.Error
+			}
+
+			via := strings.Join(l.via, " > ")
+
+			for _, u := range g.Users {
+				principals = append(principals, Principal{Type: "user", Name: u.Username, Permission: permission, Via: via})
+			}
+
+			// the members of a nested group would be one level deeper than resolved
+			if depth >= h.config.GroupMaxDepth {
+				continue
+			}
+
+			for _, c := range g.Children {
+				if seen[c.ID] || !c.Active {
+					continue
+				}
+				seen[c.ID] = true
+
+				principals = append(principals, Principal{Type: "group", Name: c.Name, Permission: permission, Via: via})
+				next = append(next, level{group: c, via: append(append([]string{}, l.via...), fmt.Sprintf("group:%s", c.Name))})
+			}
+		}
+
+		current = next
+	}
+
+	return principals, nil
+}
+
+// groupAncestors returns the group and every group it is nested in, disabled groups are included
+// because they can be enabled again at any time
+func (h *handlers) groupAncestors(group *db.Group) ([]*db.Group, error) {
+	seen := map[int64]bool{group.ID: true}
+	groups := []*db.Group{group}
+	current := []int64{group.ID}
+
+	for len(current) > 0 {
+		var parents []*db.Group
+		sql := h.db.
+			Joins("JOIN group_groups ON group_groups.parent_id = groups.id").
+			Where("group_groups.child_id IN ?", current).
+			Find(&parents)
+		if sql.Error != nil {
+			return nil, sql.Error
+		}
+
+		current = []int64{}
+		for _, p := range parents {
+			if !seen[p.ID] {
+				seen[p.ID] = true
+				groups = append(groups, p)
+				current = append(current, p.ID)
+			}
+		}
+	}
+
+	return groups, nil
+}
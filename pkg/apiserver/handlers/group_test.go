@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ekristen/dockit/pkg/db"
+)
+
+// testGroupChain creates the groups g1 to gN where every group is nested in the next one, bob is a member of g1
+func testGroupChain(t *testing.T, h *handlers, n int) (*db.User, []*db.Group) {
+	groups := []*db.Group{}
+	for i := 1; i <= n; i++ {
+		g := &db.Group{Name: fmt.Sprintf("g%d", i), Active: true}
+		assert.NoError(t, h.db.Create(g).Error)
+		groups = append(groups, g)
+	}
+
+	for i := 0; i < n-1; i++ {
+		assert.NoError(t, h.addChildGroup(groups[i+1], groups[i]))
+	}
+
+	bob := &db.User{Username: "bob", Password: "bobpw", Active: true}
+	assert.NoError(t, h.db.Create(bob).Error)
+	assert.NoError(t, h.db.Model(groups[0]).Association("Users").Append(bob))
+	assert.NoError(t, h.db.Preload("Groups").First(bob, bob.ID).Error)
+
+	return bob, groups
+}
+
+func groupNames(groups []*db.Group) []string {
+	names := []string{}
+	for _, g := range groups {
+		names = append(names, g.Name)
+	}
+
+	return names
+}
+
+func principalNames(principals []Principal, principalType string) []string {
+	names := []string{}
+	for _, p := range principals {
+		if p.Type == principalType {
+			names = append(names, p.Name)
+		}
+	}
+
+	return names
+}
+
+func Test_GroupMaxDepth(t *testing.T) {
+	h := newTestHandlers(t)
+	h.config.GroupMaxDepth = 3
+
+	bob, groups := testGroupChain(t, h, 4)
+
+	resolved, err := h.resolveGroups(bob)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"g1", "g2", "g3"}, groupNames(resolved))
+
+	// who-can agrees with the groups that are resolved for a token
+	for _, g := range groups {
+		principals, err := h.groupPrincipals(g, "namespace:team:pull")
+		assert.NoError(t, err)
+
+		inherits := false
+		for _, r := range resolved {
+			inherits = inherits || r.ID == g.ID
+		}
+
+		if inherits {
+			assert.Contains(t, principalNames(principals, "user"), "bob", g.Name)
+		} else {
+			assert.NotContains(t, principalNames(principals, "user"), "bob", g.Name)
+		}
+	}
+
+	// g1 is a member of g3 through g2, its members would be a fourth level
+	principals, err := h.groupPrincipals(groups[2], "namespace:team:pull")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"g2", "g1"}, principalNames(principals, "group"))
+	assert.Equal(t, "group:g3 > group:g2 > group:g1", principals[len(principals)-1].Via)
+
+	principals, err = h.groupPrincipals(groups[3], "namespace:team:pull")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"g3", "g2"}, principalNames(principals, "group"))
+	assert.Empty(t, principalNames(principals, "user"))
+
+	// a disabled group does not pass on the groups it is a member of
+	assert.NoError(t, h.db.Model(groups[1]).Update("active", false).Error)
+	resolved, err = h.resolveGroups(bob)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"g1"}, groupNames(resolved))
+
+	principals, err = h.groupPrincipals(groups[2], "namespace:team:pull")
+	assert.NoError(t, err)
+	assert.Empty(t, principals)
+}
+
+func Test_GroupCycle(t *testing.T) {
+	h := newTestHandlers(t)
+
+	bob, groups := testGroupChain(t, h, 3)
+
+	assert.Equal(t, GroupCycleError, h.addChildGroup(groups[0], groups[2]))
+	assert.Equal(t, GroupCycleError, h.addChildGroup(groups[0], groups[0]))
+	assert.NoError(t, h.addChildGroup(groups[2], &db.Group{Name: "other", Active: true}))
+
+	// a cycle that exists anyway, for example from a concurrent change, is not followed forever
+	assert.NoError(t, h.db.Exec("INSERT INTO group_groups (parent_id, child_id) VALUES (?, ?)", groups[0].ID, groups[2].ID).Error)
+
+	resolved, err := h.resolveGroups(bob)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"g1", "g2", "g3"}, groupNames(resolved))
+
+	principals, err := h.groupPrincipals(groups[0], "namespace:team:pull")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bob"}, principalNames(principals, "user"))
+	assert.Equal(t, []string{"g3", "g2", "other"}, principalNames(principals, "group"))
+
+	ancestors, err := h.groupAncestors(groups[0])
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"g1", "g2", "g3"}, groupNames(ancestors))
+}
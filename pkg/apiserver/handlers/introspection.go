@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/sirupsen/logrus"
+
+	"github.com/ekristen/dockit/pkg/apiserver/response"
+	"github.com/ekristen/dockit/pkg/common"
+	"github.com/ekristen/dockit/pkg/db"
+	"github.com/ekristen/dockit/pkg/docker"
+)
+
+// Introspection is the RFC 7662 introspection response, inactive tokens only report active false
+type Introspection struct {
+	Active    bool           `json:"active"`
+	Scope     string         `json:"scope,omitempty"`
+	Username  string         `json:"username,omitempty"`
+	TokenType string         `json:"token_type,omitempty"`
+	ExpiresAt int64          `json:"exp,omitempty"`
+	IssuedAt  int64          `json:"iat,omitempty"`
+	NotBefore int64          `json:"nbf,omitempty"`
+	Subject   string         `json:"sub,omitempty"`
+	Audience  string         `json:"aud,omitempty"`
+	Issuer    string         `json:"iss,omitempty"`
+	JTI       string         `json:"jti,omitempty"`
+	Access    []docker.Scope `json:"access,omitempty"`
+}
+
+// Introspect reports whether an access or refresh token is active and what it grants
+func (h *handlers) Introspect(w http.ResponseWriter, r *http.Request) {
+	reqID := r.Context().Value(common.ContextReqIDKey)
+	log := logrus.WithField("reqID", reqID)
+
+	if _, err := h.adminAuth(log, w, r); err != nil {
+		sendAuthError(w, r, err)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		response.New(w, r).AddError(err).Send(400)
+		return
+	}
+
+	raw := r.PostForm.Get("token")
+	if raw == "" {
+		response.New(w, r).AddError(errors.New("missing token parameter")).Send(400)
+		return
+	}
+
+	var result *Introspection
+	var err error
+	if strings.Count(raw, ".") == 2 {
+		result, err = h.introspectAccessToken(log, raw)
+	} else if strings.HasPrefix(raw, PersonalTokenPrefix) {
+		result, err = h.introspectOpaqueToken(db.PersonalTokenType, raw)
+	} else {
+		result, err = h.introspectOpaqueToken(db.RefreshTokenType, raw)
+	}
+	if err != nil {
+		log.WithError(err).Error("unable to query database")
+		response.New(w, r).AddError(DBError).Send(500)
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(200)
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.WithError(err).Error("unable to encode json")
+	}
+}
+
+func (h *handlers) introspectAccessToken(log *logrus.Entry, raw string) (*Introspection, error) {
+	claims := &TokenClaims{}
+	if _, err := jwt.ParseWithClaims(raw, claims, h.verificationKey); err != nil {
+		log.WithError(err).Debug("invalid access token")
+		return &Introspection{}, nil
+	}
+
+	if claims.Issuer != h.config.TokenIssuer {
+		log.WithField("issuer", claims.Issuer).Debug("access token issued by another issuer")
+		return &Introspection{}, nil
+	}
+
+	token, user, err := h.activeToken(db.AccessTokenType, "jti = ?", claims.Id)
+	if err != nil || token == nil {
+		return &Introspection{}, err
+	}
+
+	return &Introspection{
+		Active:    true,
+		Scope:     token.Scope,
+		Username:  user.Username,
+		TokenType: "access_token",
+		ExpiresAt: claims.ExpiresAt,
+		IssuedAt:  claims.IssuedAt,
+		NotBefore: claims.NotBefore,
+		Subject:   claims.Subject,
+		Audience:  claims.Audience,
+		Issuer:    claims.Issuer,
+		JTI:       claims.Id,
+		Access:    claims.Access,
+	}, nil
+}
+
+// introspectOpaqueToken introspects a refresh or personal access token, only their hash is stored
+func (h *handlers) introspectOpaqueToken(tokenType db.TokenType, raw string) (*Introspection, error) {
+	token, user, err := h.activeToken(tokenType, "hash = ?", hashToken(raw))
+	if err != nil || token == nil {
+		return &Introspection{}, err
+	}
+
+	return &Introspection{
+		Active:    true,
+		Username:  user.Username,
+		Scope:     token.Scope,
+		TokenType: string(tokenType) + "_token",
+		ExpiresAt: token.ExpiresAt.Unix(),
+		IssuedAt:  token.IssuedAt.Unix(),
+		Subject:   user.Username,
+		Audience:  token.Service,
+		Issuer:    h.config.TokenIssuer,
+		JTI:       token.JTI,
+	}, nil
+}
+
+// activeToken returns a recorded token and its user if the token is active and the user is enabled,
+// nil is returned for an unknown or inactive token
+func (h *handlers) activeToken(tokenType db.TokenType, query string, args ...interface{}) (*db.Token, *db.User, error) {
+	var tokens []db.Token
+	if sql := h.db.Where("type = ?", tokenType).Where(query, args...).Limit(1).Find(&tokens); sql.Error != nil {
+		return nil, nil, sql.Error
+	}
+	if len(tokens) == 0 || !tokens[0].Active() {
+		return nil, nil, nil
+	}
+
+	var users []db.User
+	if sql := h.db.Where("id = ? AND active = ?", tokens[0].UserID, true).Limit(1).Find(&users); sql.Error != nil {
+		return nil, nil, sql.Error
+	}
+	if len(users) == 0 {
+		return nil, nil, nil
+	}
+
+	return &tokens[0], &users[0], nil
+}
+
+// verificationKey returns the public key of the unexpired signing certificate in the x5c header of a token,
+// the header is only used to select one of our own certificates
+func (h *handlers) verificationKey(t *jwt.Token) (interface{}, error) {
+	x5c, ok := t.Header["x5c"].([]interface{})
+	if !ok || len(x5c) == 0 {
+		return nil, errors.New("missing x5c header")
+	}
+	leaf, ok := x5c[0].(string)
+	if !ok {
+		return nil, errors.New("invalid x5c header")
+	}
+
+	var pkis []db.PKI
+	if sql := h.db.Where("ca = ? AND expires_at > ?", false, time.Now().UTC()).Find(&pkis); sql.Error != nil {
+		return nil, sql.Error
+	}
+
+	for _, pki := range pkis {
+		block, _ := pem.Decode([]byte(pki.X509))
+		if block == nil || base64.StdEncoding.EncodeToString(block.Bytes) != leaf {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		return cert.PublicKey, nil
+	}
+
+	return nil, errors.New("unknown signing certificate")
+}
@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/ekristen/dockit/pkg/apiserver/response"
+	"github.com/ekristen/dockit/pkg/common"
+	"github.com/ekristen/dockit/pkg/db"
+	"github.com/ekristen/dockit/pkg/metrics"
+)
+
+var LockedOutError = errors.New("too many failed attempts, try again later")
+
+// userLockoutKey returns the lockout key for a username
+func userLockoutKey(username string) string {
+	return fmt.Sprintf("user:%s", username)
+}
+
+// lockoutKeys returns the lockout keys for the username and, when ip lockouts are enabled, the client ip of the request
+func (h *handlers) lockoutKeys(r *http.Request, username string) []string {
+	keys := []string{userLockoutKey(username)}
+	if !h.config.LockoutIP {
+		return keys
+	}
+
+	if ip, ok := r.Context().Value(common.ContextKeyRemoteAddr).(string); ok && ip != "" {
+		keys = append(keys, fmt.Sprintf("ip:%s", ip))
+	}
+
+	return keys
+}
+
+// lockoutDuration returns the duration of a lockout, doubling for every previous lockout up to max
+func lockoutDuration(base, max time.Duration, previous int) time.Duration {
+	d := base
+	for i := 0; i < previous; i++ {
+		d = d * 2
+		if d >= max {
+			return max
+		}
+	}
+
+	if d > max {
+		return max
+	}
+
+	return d
+}
+
+// lockedUntil returns the latest time any of the keys are locked until, nil if none are locked
+func (h *handlers) lockedUntil(keys []string) (*time.Time, error) {
+	if h.config.LockoutThreshold <= 0 {
+		return nil, nil
+	}
+
+	var lockouts []db.Lockout
+	sql := h.db.Where("`key` IN ? AND locked_until > ?", keys, time.Now().UTC()).Find(&lockouts)
+	if sql.Error != nil {
+		return nil, sql.Error
+	}
+
+	var until *time.Time
+	for _, l := range lockouts {
+		if until == nil || l.LockedUntil.After(*until) {
+			until = l.LockedUntil
+		}
+	}
+
+	return until, nil
+}
+
+// recordFailure increments the failure count of every key, locking out any key that reaches the threshold. Every
+// step is a single statement on the row of the key, so failures counted by several api servers at the same time
+// all add up and only one of them locks the key out.
+func (h *handlers) recordFailure(log *logrus.Entry, keys []string) error {
+	metrics.AuthFailures.Add(1)
+
+	if h.config.LockoutThreshold <= 0 {
+		return nil
+	}
+
+	now := time.Now().UTC()
+
+	for _, key := range keys {
+		sql := h.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&db.Lockout{Key: key})
+		if sql.Error != nil {
+			return sql.Error
+		}
+
+		// failures are forgotten after the max lockout duration has passed without another failure
+		sql = h.db.Model(&db.Lockout{}).Where("`key` = ? AND last_failure < ?", key, now.Add(-h.config.LockoutMaxDuration)).
+			Updates(map[string]interface{}{"failures": 0, "lockouts": 0})
+		if sql.Error != nil {
+			return sql.Error
+		}
+
+		sql = h.db.Model(&db.Lockout{}).Where("`key` = ?", key).
+			Updates(map[string]interface{}{"failures": gorm.Expr("failures + 1"), "last_failure": now})
+		if sql.Error != nil {
+			return sql.Error
+		}
+
+		var lockout db.Lockout
+		if sql := h.db.Where("`key` = ?", key).First(&lockout); sql.Error != nil {
+			return sql.Error
+		}
+		if lockout.Failures < h.config.LockoutThreshold {
+			continue
+		}
+
+		until := now.Add(lockoutDuration(h.config.LockoutDuration, h.config.LockoutMaxDuration, lockout.Lockouts))
+
+		// the lockout count is compared so a key is locked out once when api servers reach the threshold together
+		sql = h.db.Model(&db.Lockout{}).Where("`key` = ? AND failures >= ? AND lockouts = ?", key, h.config.LockoutThreshold, lockout.Lockouts).
+			Updates(map[string]interface{}{"failures": 0, "lockouts": gorm.Expr("lockouts + 1"), "locked_until": until})
+		if sql.Error != nil {
+			return sql.Error
+		}
+		if sql.RowsAffected == 0 {
+			continue
+		}
+
+		metrics.Lockouts.Add(1)
+
+		log.WithField("key", key).WithField("until", until).Warn("locked out due to failed attempts")
+	}
+
+	return nil
+}
+
+// resetFailures clears the failure count for a key after a successful authentication, the lockout count is kept so
+// the next lockout still escalates until it is forgotten after the max lockout duration without a failure
+func (h *handlers) resetFailures(key string) error {
+	if h.config.LockoutThreshold <= 0 {
+		return nil
+	}
+
+	return h.db.Model(&db.Lockout{}).Where("`key` = ?", key).Updates(map[string]interface{}{"failures": 0, "locked_until": nil}).Error
+}
+
+// checkLockout returns LockedOutError if any of the keys are locked out, setting the Retry-After header
+func (h *handlers) checkLockout(w http.ResponseWriter, log *logrus.Entry, keys []string) error {
+	until, err := h.lockedUntil(keys)
+	if err != nil {
+		log.WithError(err).Error("unable to query database")
+		return DBError
+	}
+
+	if until != nil {
+		metrics.LockedRequests.Add(1)
+		log.WithField("until", until).Debug("request rejected due to lockout")
+		w.Header().Set("Retry-After", fmt.Sprintf("%d", int(time.Until(*until).Seconds())+1))
+		return LockedOutError
+	}
+
+	return nil
+}
+
+func (h *handlers) Lockouts(w http.ResponseWriter, r *http.Request) {
+	reqID := r.Context().Value(common.ContextReqIDKey)
+	log := logrus.WithField("reqID", reqID)
+
+	if _, err := h.adminAuth(log, w, r); err != nil {
+		sendAuthError(w, r, err)
+		return
+	}
+
+	var lockouts []db.Lockout
+	sql := h.db.Order("locked_until DESC").Find(&lockouts)
+	if sql.Error != nil {
+		log.WithError(sql.Error).Error("unable to query database")
+		response.New(w, r).AddError(DBError).Send(500)
+		return
+	}
+
+	response.New(w, r).AddData(lockouts).Send(200)
+}
+
+func (h *handlers) Unlock(w http.ResponseWriter, r *http.Request) {
+	reqID := r.Context().Value(common.ContextReqIDKey)
+	log := logrus.WithField("reqID", reqID)
+
+	if _, err := h.adminAuth(log, w, r); err != nil {
+		sendAuthError(w, r, err)
+		return
+	}
+
+	params := mux.Vars(r)
+	key := fmt.Sprintf("%s:%s", params["type"], params["name"])
+
+	var lockout db.Lockout
+	sql := h.db.Where("`key` = ?", key).First(&lockout)
+	if sql.Error != nil {
+		if sql.Error == gorm.ErrRecordNotFound {
+			response.New(w, r).AddError(fmt.Errorf("no lockout found: %s", key)).Send(404)
+			return
+		}
+
+		log.WithError(sql.Error).Error("unable to query database")
+		response.New(w, r).AddError(DBError).Send(500)
+		return
+	}
+
+	if sql := h.db.Delete(&lockout); sql.Error != nil {
+		log.WithError(sql.Error).Error("unable to query database")
+		response.New(w, r).AddError(DBError).Send(500)
+		return
+	}
+
+	log.WithField("key", key).Info("lockout removed")
+
+	response.New(w, r).Success().Send(200)
+}
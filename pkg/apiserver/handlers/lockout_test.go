@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"context"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ekristen/dockit/pkg/common"
+	"github.com/ekristen/dockit/pkg/db"
+)
+
+func TestLockoutDuration(t *testing.T) {
+	cases := []struct {
+		Previous int
+		Expected time.Duration
+	}{
+		{Previous: 0, Expected: time.Minute},
+		{Previous: 1, Expected: 2 * time.Minute},
+		{Previous: 3, Expected: 8 * time.Minute},
+		{Previous: 6, Expected: time.Hour},
+		{Previous: 100, Expected: time.Hour},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.Expected, lockoutDuration(time.Minute, time.Hour, c.Previous))
+	}
+}
+
+func TestLockoutKeys(t *testing.T) {
+	h := newTestHandlers(t)
+
+	req := httptest.NewRequest("GET", "/v2/token", nil)
+	req = req.WithContext(context.WithValue(req.Context(), common.ContextKeyRemoteAddr, "203.0.113.10"))
+
+	// behind a proxy that is not trusted every client shares an ip, so ips are only locked out when enabled
+	assert.Equal(t, []string{"user:bob"}, h.lockoutKeys(req, "bob"))
+
+	h.config.LockoutIP = true
+	assert.Equal(t, []string{"user:bob", "ip:203.0.113.10"}, h.lockoutKeys(req, "bob"))
+}
+
+func TestRecordFailure(t *testing.T) {
+	h := newTestHandlers(t)
+	h.config.LockoutThreshold = 3
+	h.config.LockoutDuration = time.Minute
+	h.config.LockoutMaxDuration = time.Hour
+
+	log := logrus.WithField("test", t.Name())
+	keys := []string{"user:bob", "ip:203.0.113.10"}
+
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, h.recordFailure(log, keys))
+	}
+
+	until, err := h.lockedUntil(keys)
+	assert.NoError(t, err)
+	assert.Nil(t, until)
+
+	rec := httptest.NewRecorder()
+	assert.NoError(t, h.checkLockout(rec, log, keys))
+
+	assert.NoError(t, h.recordFailure(log, keys))
+
+	until, err = h.lockedUntil([]string{"ip:203.0.113.10"})
+	assert.NoError(t, err)
+	if assert.NotNil(t, until) {
+		assert.WithinDuration(t, time.Now().Add(time.Minute), *until, 5*time.Second)
+	}
+
+	rec = httptest.NewRecorder()
+	assert.Equal(t, LockedOutError, h.checkLockout(rec, log, []string{"user:bob"}))
+	assert.Equal(t, "60", rec.Header().Get("Retry-After"))
+
+	// the next lockout doubles
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, h.recordFailure(log, []string{"user:bob"}))
+	}
+
+	var lockout db.Lockout
+	assert.NoError(t, h.db.Where("`key` = ?", "user:bob").First(&lockout).Error)
+	assert.Equal(t, 2, lockout.Lockouts)
+	assert.WithinDuration(t, time.Now().Add(2*time.Minute), *lockout.LockedUntil, 5*time.Second)
+
+	// a successful authentication clears the failures, the next lockout still escalates
+	assert.NoError(t, h.recordFailure(log, []string{"user:bob"}))
+	assert.NoError(t, h.resetFailures("user:bob"))
+	assert.NoError(t, h.db.Where("`key` = ?", "user:bob").First(&lockout).Error)
+	assert.Equal(t, 0, lockout.Failures)
+	assert.Equal(t, 2, lockout.Lockouts)
+	assert.Nil(t, lockout.LockedUntil)
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, h.recordFailure(log, []string{"user:bob"}))
+	}
+	assert.NoError(t, h.db.Where("`key` = ?", "user:bob").First(&lockout).Error)
+	assert.Equal(t, 3, lockout.Lockouts)
+	assert.WithinDuration(t, time.Now().Add(4*time.Minute), *lockout.LockedUntil, 5*time.Second)
+
+	// failures are forgotten after the max duration without another failure
+	forgotten := time.Now().UTC().Add(-2 * time.Hour)
+	assert.NoError(t, h.db.Model(&lockout).Updates(map[string]interface{}{"last_failure": forgotten, "locked_until": forgotten}).Error)
+	assert.NoError(t, h.recordFailure(log, []string{"user:bob"}))
+
+	var reset db.Lockout
+	assert.NoError(t, h.db.Where("`key` = ?", "user:bob").First(&reset).Error)
+	assert.Equal(t, 1, reset.Failures)
+	assert.Equal(t, 0, reset.Lockouts)
+
+	until, err = h.lockedUntil([]string{"user:bob"})
+	assert.NoError(t, err)
+	assert.Nil(t, until)
+
+	// a threshold of 0 disables lockouts
+	h.config.LockoutThreshold = 0
+	until, err = h.lockedUntil([]string{"ip:203.0.113.10"})
+	assert.NoError(t, err)
+	assert.Nil(t, until)
+}
+
+func TestRecordFailureReplicas(t *testing.T) {
+	h := newTestHandlers(t)
+	h.config.LockoutThreshold = 100
+	h.config.LockoutDuration = time.Minute
+	h.config.LockoutMaxDuration = time.Hour
+
+	// api servers sharing the database count every failure
+	replicas := []*handlers{h, New(h.db, h.config)}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(r *handlers) {
+			defer wg.Done()
+			errs <- r.recordFailure(logrus.WithField("test", t.Name()), []string{"user:bob"})
+		}(replicas[i%2])
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+
+	var lockout db.Lockout
+	assert.NoError(t, h.db.Where("`key` = ?", "user:bob").First(&lockout).Error)
+	assert.Equal(t, 20, lockout.Failures)
+}
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ekristen/dockit/pkg/db"
+)
+
+const (
+	// UsernamePlaceholder is replaced by the username in the personal namespace template
+	UsernamePlaceholder = "{username}"
+	// GroupPlaceholder is replaced by the group name in the group namespace template
+	GroupPlaceholder = "{group}"
+)
+
+// ValidateNamespaceTemplate ensures a namespace template contains its placeholder exactly once, names are
+// lowercased when they are expanded so the rest of the template has to be lowercase to match them again
+func ValidateNamespaceTemplate(template, placeholder string) error {
+	if template == "" {
+		return nil
+	}
+
+	if strings.Count(template, placeholder) != 1 {
+		return fmt.Errorf("namespace template %s must contain %s exactly once", template, placeholder)
+	}
+
+	if strings.ToLower(template) != template {
+		return fmt.Errorf("namespace template %s must be lowercase", template)
+	}
+
+	return nil
+}
+
+// ValidateNamespaceTemplates validates the personal and group namespace templates and ensures no
+// namespace can expand from both, otherwise a user could push to the namespace of a group of the same name
+func ValidateNamespaceTemplates(personal, group string) error {
+	if err := ValidateNamespaceTemplate(personal, UsernamePlaceholder); err != nil {
+		return err
+	}
+
+	if err := ValidateNamespaceTemplate(group, GroupPlaceholder); err != nil {
+		return err
+	}
+
+	if personal == "" || group == "" {
+		return nil
+	}
+
+	if namespaceTemplatesOverlap(personal, UsernamePlaceholder, group, GroupPlaceholder) {
+		return fmt.Errorf("namespace templates %s and %s can expand to the same namespace", personal, group)
+	}
+
+	return nil
+}
+
+// namespaceTemplatesOverlap reports whether a namespace can match both templates. The prefix of one has to
+// start with the prefix of the other and the same for the suffixes, the rest becomes part of a name which
+// can not contain a slash.
+func namespaceTemplatesOverlap(a, aPlaceholder, b, bPlaceholder string) bool {
+	aParts := strings.SplitN(a, aPlaceholder, 2)
+	bParts := strings.SplitN(b, bPlaceholder, 2)
+
+	var prefixRest string
+	switch {
+	case strings.HasPrefix(aParts[0], bParts[0]):
+		prefixRest = strings.TrimPrefix(aParts[0], bParts[0])
+	case strings.HasPrefix(bParts[0], aParts[0]):
+		prefixRest = strings.TrimPrefix(bParts[0], aParts[0])
+	default:
+		return false
+	}
+
+	var suffixRest string
+	switch {
+	case strings.HasSuffix(aParts[1], bParts[1]):
+		suffixRest = strings.TrimSuffix(aParts[1], bParts[1])
+	case strings.HasSuffix(bParts[1], aParts[1]):
+		suffixRest = strings.TrimSuffix(bParts[1], aParts[1])
+	default:
+		return false
+	}
+
+	return !strings.Contains(prefixRest, "/") && !strings.Contains(suffixRest, "/")
+}
+
+// expandNamespace returns the namespace for a name from a template, the name is lowercased like
+// repository names have to be
+func expandNamespace(template, placeholder, name string) string {
+	return strings.Replace(template, placeholder, strings.ToLower(name), 1)
+}
+
+// matchNamespace returns the name a namespace was expanded from, if it matches the template
+func matchNamespace(template, placeholder, namespace string) (string, bool) {
+	parts := strings.SplitN(template, placeholder, 2)
+	if len(parts) != 2 {
+		return "", false
+	}
+
+	prefix, suffix := parts[0], parts[1]
+	if len(namespace) <= len(prefix)+len(suffix) || !strings.HasPrefix(namespace, prefix) || !strings.HasSuffix(namespace, suffix) {
+		return "", false
+	}
+
+	name := namespace[len(prefix) : len(namespace)-len(suffix)]
+	if strings.Contains(name, "/") {
+		return "", false
+	}
+
+	return name, true
+}
+
+// implicitPermissions returns the push permissions on the personal namespace of the user and the
+// namespaces of its groups, the resolved groups of the user must be loaded
+func (h *handlers) implicitPermissions(user *db.User) []db.Permission {
+	permissions := []db.Permission{}
+
+	if h.config.PersonalNamespace != "" && user.Username != "anonymous" {
+		permissions = append(permissions, db.Permission{
+			Type:     db.Namespace,
+			Name:     expandNamespace(h.config.PersonalNamespace, UsernamePlaceholder, user.Username),
+			Action:   db.Push,
+			EntityID: user.ID,
+			User:     user,
+		})
+	}
+
+	if h.config.GroupNamespace != "" {
+		for _, g := range user.Groups {
+			if !g.Active {
+				continue
+			}
+
+			permissions = append(permissions, db.Permission{
+				Type:     db.Namespace,
+				Name:     expandNamespace(h.config.GroupNamespace, GroupPlaceholder, g.Name),
+				Action:   db.Push,
+				EntityID: g.ID,
+				Group:    g,
+			})
+		}
+	}
+
+	return permissions
+}
+
+// namespaceOwners returns the implicit permissions of the users and groups whose namespace is one of the namespaces
+func (h *handlers) namespaceOwners(namespaces []string) ([]db.Permission, error) {
+	permissions := []db.Permission{}
+
+	for _, ns := range namespaces {
+		if username, ok := matchNamespace(h.config.PersonalNamespace, UsernamePlaceholder, ns); ok && username != "anonymous" {
+			var users []*db.User
+			if sql := h.db.Where("LOWER(username) = ? AND active = ?", username, true).Find(&users); sql.Error != nil {
+				return nil, sql.Error
+			}
+
+			for _, u := range users {
+				permissions = append(permissions, db.Permission{Type: db.Namespace, Name: ns, Action: db.Push, EntityID: u.ID, User: u})
+			}
+		}
+
+		if group, ok := matchNamespace(h.config.GroupNamespace, GroupPlaceholder, ns); ok {
+			var groups []*db.Group
+			if sql := h.db.Where("LOWER(name) = ? AND active = ?", group, true).Find(&groups); sql.Error != nil {
+				return nil, sql.Error
+			}
+
+			for _, g := range groups {
+				permissions = append(permissions, db.Permission{Type: db.Namespace, Name: ns, Action: db.Push, EntityID: g.ID, Group: g})
+			}
+		}
+	}
+
+	return permissions, nil
+}
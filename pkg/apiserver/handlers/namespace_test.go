@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ekristen/dockit/pkg/db"
+)
+
+func TestMatchNamespace(t *testing.T) {
+	cases := []struct {
+		Template  string
+		Namespace string
+		Name      string
+		Match     bool
+	}{
+		{Template: "{username}", Namespace: "alice", Name: "alice", Match: true},
+		{Template: "team-{group}", Namespace: "team-platform", Name: "platform", Match: true},
+		{Template: "team-{group}", Namespace: "platform", Match: false},
+		{Template: "team-{group}", Namespace: "team-", Match: false},
+		{Template: "users/{username}", Namespace: "users/bob", Name: "bob", Match: true},
+		{Template: "users/{username}", Namespace: "users/bob/x", Match: false},
+		{Template: "", Namespace: "alice", Match: false},
+	}
+
+	for _, c := range cases {
+		placeholder := UsernamePlaceholder
+		if c.Template == "team-{group}" {
+			placeholder = GroupPlaceholder
+		}
+
+		name, ok := matchNamespace(c.Template, placeholder, c.Namespace)
+		assert.Equal(t, c.Match, ok, c.Template+" "+c.Namespace)
+		assert.Equal(t, c.Name, name, c.Template+" "+c.Namespace)
+	}
+}
+
+func TestValidateNamespaceTemplates(t *testing.T) {
+	cases := []struct {
+		Personal string
+		Group    string
+		Valid    bool
+	}{
+		{Personal: "{username}", Group: "teams/{group}", Valid: true},
+		{Personal: "users/{username}", Group: "teams/{group}", Valid: true},
+		{Personal: "{username}", Group: "", Valid: true},
+		{Personal: "", Group: "team-{group}", Valid: true},
+		{Personal: "{username}-home", Group: "teams/{group}", Valid: true},
+		{Personal: "{username}", Group: "team-{group}", Valid: false},
+		{Personal: "team-{username}", Group: "team-{group}", Valid: false},
+		{Personal: "{username}", Group: "{group}", Valid: false},
+		{Personal: "u-{username}", Group: "{group}-g", Valid: false},
+		{Personal: "{username}", Group: "{group}/team", Valid: true},
+		{Personal: "{username}{username}", Group: "", Valid: false},
+		{Personal: "", Group: "teams", Valid: false},
+		{Personal: "Users/{username}", Group: "", Valid: false},
+	}
+
+	for _, c := range cases {
+		err := ValidateNamespaceTemplates(c.Personal, c.Group)
+		assert.Equal(t, c.Valid, err == nil, c.Personal+" "+c.Group)
+	}
+}
+
+func Test_ImplicitPermissions(t *testing.T) {
+	h := newTestHandlers(t)
+	h.config.PersonalNamespace = "users/{username}"
+	h.config.GroupNamespace = "teams/{group}"
+
+	alice := &db.User{Username: "Alice", Password: "alicepw", Active: true}
+	platform := &db.Group{Name: "Platform", Active: true}
+	disabled := &db.Group{Name: "disabled"}
+	alice.Groups = []*db.Group{platform, disabled}
+
+	permissions := h.implicitPermissions(alice)
+	assert.Len(t, permissions, 2)
+	assert.Equal(t, "users/alice", permissions[0].Name)
+	assert.Equal(t, db.Push, permissions[0].Action)
+	assert.Equal(t, alice, permissions[0].User)
+	assert.Equal(t, "teams/platform", permissions[1].Name)
+	assert.Equal(t, platform, permissions[1].Group)
+
+	assert.Empty(t, h.implicitPermissions(&db.User{Username: "anonymous"}))
+
+	h.config.PersonalNamespace = ""
+	h.config.GroupNamespace = ""
+	assert.Empty(t, h.implicitPermissions(alice))
+}
+
+func Test_NamespaceOwners(t *testing.T) {
+	h := newTestHandlers(t)
+	h.config.PersonalNamespace = "users/{username}"
+	h.config.GroupNamespace = "teams/{group}"
+
+	alice := &db.User{Username: "Alice", Password: "alicepw", Active: true}
+	assert.NoError(t, h.db.Create(alice).Error)
+	assert.NoError(t, h.db.Create(&db.User{Username: "anonymous", Password: "anonymous", Active: true}).Error)
+	platform := &db.Group{Name: "Platform", Active: true}
+	assert.NoError(t, h.db.Create(platform).Error)
+	assert.NoError(t, h.db.Create(&db.Group{Name: "disabled"}).Error)
+
+	permissions, err := h.namespaceOwners([]string{"users/alice", "teams/platform", "users/anonymous", "teams/disabled", "users/bob", "users"})
+	assert.NoError(t, err)
+	assert.Len(t, permissions, 2)
+
+	assert.Equal(t, "users/alice", permissions[0].Name)
+	assert.Equal(t, alice.ID, permissions[0].EntityID)
+	assert.Equal(t, "Alice", permissions[0].User.Username)
+
+	assert.Equal(t, "teams/platform", permissions[1].Name)
+	assert.Equal(t, platform.ID, permissions[1].EntityID)
+	assert.Equal(t, "Platform", permissions[1].Group.Name)
+}
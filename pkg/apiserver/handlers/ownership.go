@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/ekristen/dockit/pkg/common"
+	"github.com/ekristen/dockit/pkg/db"
+	"github.com/ekristen/dockit/pkg/docker"
+)
+
+// isSharedNamespace reports whether a repository is inside one of the shared namespaces
+func (h *handlers) isSharedNamespace(repository string) bool {
+	for _, ns := range h.config.SharedNamespaces {
+		if strings.HasPrefix(repository, strings.TrimSuffix(ns, "/")+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// newRepositoryPushes returns the push events that created a repository in a shared namespace, the first event
+// of a repository in the batch when no earlier event of it has been stored. It has to be called before the
+// events are stored.
+func (h *handlers) newRepositoryPushes(events []db.Event) ([]db.Event, error) {
+	first := map[string]*db.Event{}
+	repositories := []string{}
+	for i := range events {
+		e := &events[i]
+		if !h.isSharedNamespace(e.Repository) {
+			continue
+		}
+
+		if f, ok := first[e.Repository]; !ok {
+			repositories = append(repositories, e.Repository)
+			first[e.Repository] = e
+		} else if e.Timestamp.Before(*f.Timestamp) {
+			first[e.Repository] = e
+		}
+	}
+
+	if len(repositories) == 0 {
+		return nil, nil
+	}
+
+	var known []string
+	if sql := h.db.Model(&db.Event{}).Distinct("repository").Where("repository IN ?", repositories).Pluck("repository", &known); sql.Error != nil {
+		return nil, sql.Error
+	}
+
+	existing := map[string]bool{}
+	for _, r := range known {
+		existing[r] = true
+	}
+
+	pushes := []db.Event{}
+	for _, r := range repositories {
+		if e := first[r]; !existing[r] && e.Action == "push" && e.Actor != "" {
+			pushes = append(pushes, *e)
+		}
+	}
+
+	return pushes, nil
+}
+
+// claimOwnership makes the actor of the push that created a repository in a shared namespace its owner,
+// ownership is an admin permission on the repository. The claim is recorded first and only once per
+// repository, so of concurrent claims only one grants admin, and a repository that already has an admin
+// is not claimed.
+func (h *handlers) claimOwnership(log *logrus.Entry, user *db.User, push *db.Event) error {
+	if !h.isSharedNamespace(push.Repository) || user.Username == "anonymous" {
+		return nil
+	}
+
+	return h.db.Transaction(func(tx *gorm.DB) error {
+		var owners int64
+		sql := tx.Model(&db.Permission{}).
+			Where("type = ? AND name = ? AND action = ?", db.Repository, push.Repository, db.Admin).
+			Count(&owners)
+		if sql.Error != nil {
+			return sql.Error
+		}
+		if owners > 0 {
+			return nil
+		}
+
+		sql = tx.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "repository"}}, DoNothing: true}).Create(&db.RepositoryOwner{
+			Repository: push.Repository,
+			UserID:     user.ID,
+			EventID:    push.EventID,
+		})
+		if sql.Error != nil {
+			return sql.Error
+		}
+		if sql.RowsAffected == 0 {
+			return nil
+		}
+
+		if sql := tx.Create(&db.Permission{
+			Type:     db.Repository,
+			Name:     push.Repository,
+			Action:   db.Admin,
+			EntityID: user.ID,
+		}); sql.Error != nil {
+			return sql.Error
+		}
+
+		log.WithField("user", user.Username).WithField("repository", push.Repository).Info("repository owner recorded")
+
+		return nil
+	})
+}
+
+// canAdminRepository reports whether the user is a global admin or has been granted admin on the repository
+func (h *handlers) canAdminRepository(r *http.Request, user *db.User, repository string) (bool, error) {
+	if user.Admin {
+		return true, nil
+	}
+
+	groups, err := h.resolveGroups(user)
+	if err != nil {
+		return false, err
+	}
+	user.Groups = groups
+
+	ip, _ := r.Context().Value(common.ContextKeyRemoteAddr).(string)
+
+	granted, err := h.resolveAccess(h.entityIDs(user), h.implicitPermissions(user), nil, ip, []docker.Scope{
+		{Type: string(db.Repository), Name: repository, Actions: []string{string(db.Admin)}},
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return len(granted) > 0, nil
+}
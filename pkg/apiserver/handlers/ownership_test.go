@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ekristen/dockit/pkg/db"
+)
+
+// repositoryAdmins returns the ids of the entities with admin on the repository
+func repositoryAdmins(t *testing.T, h *handlers, repository string) []int64 {
+	var ids []int64
+	assert.NoError(t, h.db.Model(&db.Permission{}).
+		Where("type = ? AND name = ? AND action = ?", db.Repository, repository, db.Admin).
+		Order("entity_id").Pluck("entity_id", &ids).Error)
+
+	return ids
+}
+
+func Test_OwnershipFromEvents(t *testing.T) {
+	h := newTestHandlers(t)
+	h.config.EventsToken = "events-token"
+	h.config.SharedNamespaces = []string{"shared"}
+
+	alice := &db.User{Username: "alice", Password: "alicepw", Active: true}
+	assert.NoError(t, h.db.Create(alice).Error)
+	bob := &db.User{Username: "bob", Password: "bobpw", Active: true}
+	assert.NoError(t, h.db.Create(bob).Error)
+
+	start := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	bobPush := registryEvent("2", "push", "shared/app", "v2", start.Add(time.Minute))
+	bobPush.Actor.Name = "bob"
+
+	// the first push of a new repository makes its actor the owner, even when it is delivered later
+	assert.Equal(t, 200, postEvents(t, h, "events-token", bobPush, registryEvent("1", "push", "shared/app", "v1", start)))
+	assert.Equal(t, []int64{alice.ID}, repositoryAdmins(t, h, "shared/app"))
+
+	// pushes to a repository with earlier events do not, neither do retried deliveries
+	bobPush.ID = "3"
+	assert.Equal(t, 200, postEvents(t, h, "events-token", bobPush))
+	assert.Equal(t, 200, postEvents(t, h, "events-token", registryEvent("1", "push", "shared/app", "v1", start)))
+	assert.Equal(t, []int64{alice.ID}, repositoryAdmins(t, h, "shared/app"))
+
+	// a repository that existed before has events other than the push
+	pull := registryEvent("4", "pull", "shared/old", "v1", start)
+	pull.Actor.Name = "bob"
+	assert.Equal(t, 200, postEvents(t, h, "events-token", pull, registryEvent("5", "push", "shared/old", "v2", start.Add(time.Minute))))
+	assert.Empty(t, repositoryAdmins(t, h, "shared/old"))
+
+	// only repositories in shared namespaces are claimed
+	assert.Equal(t, 200, postEvents(t, h, "events-token", registryEvent("6", "push", "apps/web", "v1", start)))
+	assert.Empty(t, repositoryAdmins(t, h, "apps/web"))
+
+	// unknown actors do not claim a repository
+	unknown := registryEvent("7", "push", "shared/unknown", "v1", start)
+	unknown.Actor.Name = "mallory"
+	assert.Equal(t, 200, postEvents(t, h, "events-token", unknown))
+	assert.Empty(t, repositoryAdmins(t, h, "shared/unknown"))
+}
+
+func Test_ClaimOwnershipOnce(t *testing.T) {
+	h := newTestHandlers(t)
+	h.config.SharedNamespaces = []string{"shared"}
+
+	alice := &db.User{Username: "alice", Password: "alicepw", Active: true}
+	assert.NoError(t, h.db.Create(alice).Error)
+	bob := &db.User{Username: "bob", Password: "bobpw", Active: true}
+	assert.NoError(t, h.db.Create(bob).Error)
+
+	log := logrus.WithField("test", t.Name())
+	push := &db.Event{EventID: "1", Action: "push", Repository: "shared/app", Actor: "alice"}
+
+	assert.NoError(t, h.claimOwnership(log, alice, push))
+	assert.Equal(t, []int64{alice.ID}, repositoryAdmins(t, h, "shared/app"))
+
+	// a claim racing the first one does not see its permission yet, the recorded owner still refuses it
+	assert.NoError(t, h.db.Where("type = ? AND name = ?", db.Repository, "shared/app").Delete(&db.Permission{}).Error)
+	assert.NoError(t, h.claimOwnership(log, bob, &db.Event{EventID: "2", Action: "push", Repository: "shared/app", Actor: "bob"}))
+	assert.Empty(t, repositoryAdmins(t, h, "shared/app"))
+
+	// a repository with an admin is not claimed
+	carol := &db.User{Username: "carol", Password: "carolpw", Active: true}
+	assert.NoError(t, h.db.Create(carol).Error)
+	assert.NoError(t, h.db.Create(&db.Permission{Type: db.Repository, Name: "shared/web", Action: db.Admin, EntityID: carol.ID}).Error)
+	assert.NoError(t, h.claimOwnership(log, bob, &db.Event{EventID: "3", Action: "push", Repository: "shared/web", Actor: "bob"}))
+	assert.Equal(t, []int64{carol.ID}, repositoryAdmins(t, h, "shared/web"))
+
+	var owners int64
+	assert.NoError(t, h.db.Model(&db.RepositoryOwner{}).Count(&owners).Error)
+	assert.Equal(t, int64(1), owners)
+}
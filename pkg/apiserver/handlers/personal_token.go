@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+
+	"github.com/ekristen/dockit/pkg/apiserver/response"
+	"github.com/ekristen/dockit/pkg/common"
+	"github.com/ekristen/dockit/pkg/db"
+	"github.com/ekristen/dockit/pkg/docker"
+	"github.com/ekristen/dockit/pkg/httpauth"
+)
+
+const (
+	// AdminScopeType and AdminScopeName make up the dockit:admin:* scope of tokens for the admin api
+	AdminScopeType = "dockit"
+	AdminScopeName = "admin"
+
+	// PersonalTokenPrefix is the prefix of personal access tokens
+	PersonalTokenPrefix = "dkp_"
+
+	// DefaultPersonalTokenTTL is the lifetime of a personal access token without an explicit expiry
+	DefaultPersonalTokenTTL = 90 * 24 * time.Hour
+	// DefaultPersonalTokenMaxTTL is the longest lifetime of a personal access token
+	DefaultPersonalTokenMaxTTL = 365 * 24 * time.Hour
+)
+
+// PasswordRequiredError is returned when a personal access token is created with a bearer token, a token
+// could otherwise be used to create tokens that outlive it and its revocation
+var PasswordRequiredError = errors.New("personal access tokens can only be created with a password")
+
+// NewPersonalToken is the request to create a personal access token
+type NewPersonalToken struct {
+	Description string `json:"description"`
+	// ExpiresIn is a duration like 720h, the default is DefaultPersonalTokenTTL
+	ExpiresIn string `json:"expires_in"`
+}
+
+// PersonalToken is returned once when a personal access token is created
+type PersonalToken struct {
+	JTI       string    `json:"jti"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// adminScope returns the scope that allows a token to be used for the admin api
+func adminScope() docker.Scope {
+	return docker.Scope{Type: AdminScopeType, Name: AdminScopeName, Actions: []string{"*"}}
+}
+
+// hasAdminScope reports whether the admin api scope is one of the scopes
+func hasAdminScope(scopes []docker.Scope) bool {
+	for _, s := range scopes {
+		if s.Type == AdminScopeType && s.Name == AdminScopeName {
+			return true
+		}
+	}
+
+	return false
+}
+
+// bearerAuth authenticates the request of an active user with a personal access token or a dockit token
+// issued for the admin api, the token only replaces the password, what the user can do is still decided by
+// the handler
+func (h *handlers) bearerAuth(log *logrus.Entry, r *http.Request) (*db.User, error) {
+	raw := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	var userID int64
+	if strings.HasPrefix(raw, PersonalTokenPrefix) {
+		token, user, err := h.activeToken(db.PersonalTokenType, "hash = ?", hashToken(raw))
+		if err != nil {
+			log.WithError(err).Error("unable to query database")
+			return nil, DBError
+		}
+		if token == nil {
+			log.Debug("unknown or inactive personal access token")
+			return nil, UnauthorizedError
+		}
+
+		userID = user.ID
+	} else {
+		user, claims := h.bearerUser(log, r)
+		if user == nil {
+			return nil, UnauthorizedError
+		}
+		if claims.Audience != h.config.TokenIssuer || !hasAdminScope(claims.Access) {
+			log.WithField("jti", claims.Id).Debug("token was not issued for the admin api")
+			return nil, UnauthorizedError
+		}
+
+		userID = user.ID
+	}
+
+	var user db.User
+	sql := h.db.Preload("Groups").Where("id = ?", userID).First(&user)
+	if sql.Error != nil {
+		log.WithError(sql.Error).Error("unable to query database")
+		return nil, DBError
+	}
+
+	return &user, nil
+}
+
+// PersonalTokens creates a personal access token for the admin api, users can create their own tokens
+// and admins the tokens of any user. Only basic auth is accepted, no token can create another.
+func (h *handlers) PersonalTokens(w http.ResponseWriter, r *http.Request) {
+	reqID := r.Context().Value(common.ContextReqIDKey)
+	log := logrus.WithField("reqID", reqID)
+
+	res := response.New(w, r)
+
+	if strings.HasPrefix(r.Header.Get("Authorization"), httpauth.BEARER_SCHEMA) {
+		res.AddError(PasswordRequiredError).Send(403)
+		return
+	}
+
+	caller, err := h.basicAuth(log, w, r)
+	if err != nil {
+		sendAuthError(w, r, err)
+		return
+	}
+
+	username := mux.Vars(r)["rbac_entity"]
+	if !caller.Admin && caller.Username != username {
+		sendAuthError(w, r, ForbiddenError)
+		return
+	}
+
+	var user db.User
+	sql := h.db.Where("username = ? AND active = ?", username, true).First(&user)
+	if sql.Error != nil {
+		if sql.Error == gorm.ErrRecordNotFound {
+			res.AddError(fmt.Errorf("unknown user: %s", username)).Send(404)
+			return
+		}
+
+		log.WithError(sql.Error).Error("unable to query database")
+		res.AddError(DBError).Send(500)
+		return
+	}
+
+	var req NewPersonalToken
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		res.AddError(fmt.Errorf("invalid request: %s", err)).Send(400)
+		return
+	}
+
+	ttl := DefaultPersonalTokenTTL
+	if ttl > h.config.PersonalTokenMaxTTL {
+		ttl = h.config.PersonalTokenMaxTTL
+	}
+	if req.ExpiresIn != "" {
+		ttl, err = time.ParseDuration(req.ExpiresIn)
+		if err != nil || ttl <= 0 {
+			res.AddError(fmt.Errorf("invalid expires_in: %s", req.ExpiresIn)).Send(400)
+			return
+		}
+		if ttl > h.config.PersonalTokenMaxTTL {
+			res.AddError(fmt.Errorf("invalid expires_in: %s is longer than the maximum of %s", req.ExpiresIn, h.config.PersonalTokenMaxTTL)).Send(400)
+			return
+		}
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		log.WithError(err).Error("unable to generate token")
+		res.AddError(err).Send(500)
+		return
+	}
+
+	raw := PersonalTokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	now := time.Now().UTC()
+	expiresAt := now.Add(ttl)
+
+	token := &db.Token{
+		JTI:         uuid.NewString(),
+		Type:        db.PersonalTokenType,
+		Hash:        hashToken(raw),
+		UserID:      user.ID,
+		Service:     h.config.TokenIssuer,
+		Scope:       adminScope().String(),
+		Description: req.Description,
+		IssuedAt:    &now,
+		ExpiresAt:   &expiresAt,
+	}
+	if sql := h.db.Create(token); sql.Error != nil {
+		log.WithError(sql.Error).Error("unable to query database")
+		res.AddError(DBError).Send(500)
+		return
+	}
+
+	log.WithField("user", username).WithField("jti", token.JTI).Info("created personal access token")
+
+	res.AddData(PersonalToken{JTI: token.JTI, Token: raw, ExpiresAt: expiresAt}).Send(201)
+}
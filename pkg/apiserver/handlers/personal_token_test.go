@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ekristen/dockit/pkg/db"
+)
+
+// testScopedToken returns a dockit token for the service and scope
+func testScopedToken(t *testing.T, h *handlers, username, password, service, scope string) string {
+	req := httptest.NewRequest("GET", "/v2/token?service="+url.QueryEscape(service)+"&scope="+url.QueryEscape(scope), nil)
+	req.SetBasicAuth(username, password)
+
+	rec := httptest.NewRecorder()
+	h.Token(rec, req)
+	assert.Equal(t, 200, rec.Code)
+
+	var res TokenResponse
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+
+	return res.Token
+}
+
+// testPersonalToken creates a personal access token for the user with its password
+func testPersonalToken(t *testing.T, h *handlers, username, password string) PersonalToken {
+	var token PersonalToken
+	vars := map[string]string{"rbac_entity": username}
+	assert.Equal(t, 201, testRequest(t, h.PersonalTokens, username, password, "POST", "/v2/admin/user:"+username+"/tokens", vars, NewPersonalToken{}, &token))
+
+	return token
+}
+
+func Test_BearerAuth(t *testing.T) {
+	h := newTestHandlers(t)
+	log := logrus.WithField("test", t.Name())
+
+	alice := &db.User{Username: "alice", Password: "alicepw", Active: true}
+	assert.NoError(t, h.db.Create(alice).Error)
+
+	pat := testPersonalToken(t, h, "alice", "alicepw")
+	adminToken := testScopedToken(t, h, "alice", "alicepw", h.config.TokenIssuer, "dockit:admin:*")
+	registryToken := testScopedToken(t, h, "alice", "alicepw", "registry", "registry:catalog:*")
+
+	auth := func(token string) (*db.User, error) {
+		req := httptest.NewRequest("GET", "/v2/admin/users", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		return h.bearerAuth(log, req)
+	}
+
+	cases := []struct {
+		Name  string
+		Token string
+		Error error
+	}{
+		{"personal access token", pat.Token, nil},
+		{"admin token", adminToken, nil},
+		{"registry token", registryToken, UnauthorizedError},
+		{"unknown personal access token", PersonalTokenPrefix + "unknown", UnauthorizedError},
+		{"garbage", "garbage", UnauthorizedError},
+	}
+
+	for _, c := range cases {
+		user, err := auth(c.Token)
+		assert.Equal(t, c.Error, err, c.Name)
+		if c.Error == nil {
+			assert.Equal(t, alice.ID, user.ID, c.Name)
+		}
+	}
+
+	// revoked and expired tokens are refused
+	assert.NoError(t, h.db.Model(&db.Token{}).Where("jti = ?", tokenJTI(t, adminToken)).Update("revoked_at", time.Now().UTC()).Error)
+	_, err := auth(adminToken)
+	assert.Equal(t, UnauthorizedError, err)
+
+	assert.NoError(t, h.db.Model(&db.Token{}).Where("jti = ?", pat.JTI).Update("expires_at", time.Now().UTC().Add(-time.Second)).Error)
+	_, err = auth(pat.Token)
+	assert.Equal(t, UnauthorizedError, err)
+
+	// a disabled user can not use its tokens
+	pat = testPersonalToken(t, h, "alice", "alicepw")
+	assert.NoError(t, h.db.Model(alice).Update("active", false).Error)
+	_, err = auth(pat.Token)
+	assert.Equal(t, UnauthorizedError, err)
+}
+
+func Test_PersonalTokens(t *testing.T) {
+	h := newTestHandlers(t)
+	h.config.PersonalTokenMaxTTL = 30 * 24 * time.Hour
+	testAdmin(t, h)
+
+	assert.NoError(t, h.db.Create(&db.User{Username: "alice", Password: "alicepw", Active: true}).Error)
+	assert.NoError(t, h.db.Create(&db.User{Username: "bob", Password: "bobpw", Active: true}).Error)
+
+	create := func(username, password, target string, body NewPersonalToken) (int, PersonalToken) {
+		var token PersonalToken
+		vars := map[string]string{"rbac_entity": target}
+		code := testRequest(t, h.PersonalTokens, username, password, "POST", "/v2/admin/user:"+target+"/tokens", vars, body, &token)
+
+		return code, token
+	}
+
+	// the default lifetime is capped to the maximum
+	before := time.Now().UTC()
+	code, token := create("alice", "alicepw", "alice", NewPersonalToken{Description: "laptop"})
+	assert.Equal(t, 201, code)
+	assert.WithinDuration(t, before.Add(30*24*time.Hour), token.ExpiresAt, time.Minute)
+
+	var stored db.Token
+	assert.NoError(t, h.db.Where("jti = ?", token.JTI).First(&stored).Error)
+	assert.Equal(t, db.PersonalTokenType, stored.Type)
+	assert.Equal(t, "laptop", stored.Description)
+	assert.NotEqual(t, token.Token, stored.Hash)
+
+	code, _ = create("alice", "alicepw", "alice", NewPersonalToken{ExpiresIn: "24h"})
+	assert.Equal(t, 201, code)
+
+	cases := []struct {
+		Name     string
+		Username string
+		Password string
+		Target   string
+		Body     NewPersonalToken
+		Expected int
+	}{
+		{"longer than the maximum", "alice", "alicepw", "alice", NewPersonalToken{ExpiresIn: "8760h"}, 400},
+		{"invalid lifetime", "alice", "alicepw", "alice", NewPersonalToken{ExpiresIn: "-1h"}, 400},
+		{"token of another user", "alice", "alicepw", "bob", NewPersonalToken{}, 403},
+		{"admin for another user", "admin", "adminpw", "bob", NewPersonalToken{}, 201},
+		{"unknown user", "admin", "adminpw", "carol", NewPersonalToken{}, 404},
+		{"wrong password", "alice", "wrong", "alice", NewPersonalToken{}, 401},
+	}
+
+	for _, c := range cases {
+		code, _ := create(c.Username, c.Password, c.Target, c.Body)
+		assert.Equal(t, c.Expected, code, c.Name)
+	}
+
+	// neither a personal access token nor an admin token can create another token
+	adminToken := testScopedToken(t, h, "admin", "adminpw", h.config.TokenIssuer, "dockit:admin:*")
+	for _, bearer := range []string{token.Token, adminToken} {
+		req := httptest.NewRequest("POST", "/v2/admin/user:alice/tokens", nil)
+		req.Header.Set("Authorization", "Bearer "+bearer)
+
+		rec := httptest.NewRecorder()
+		h.PersonalTokens(rec, req)
+		assert.Equal(t, 403, rec.Code)
+		assert.Contains(t, rec.Body.String(), PasswordRequiredError.Error())
+	}
+}
@@ -2,26 +2,155 @@ package handlers
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
 	"net/http"
 	"time"
 
-	"github.com/ekristen/dockit/pkg/db"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+
+	"github.com/ekristen/dockit/pkg/common"
+	"github.com/ekristen/dockit/pkg/issuer"
+	"github.com/ekristen/dockit/pkg/utils"
 )
 
+// MaxCertsWait caps how long a request for the cert bundle waits for a change
+const MaxCertsWait = 5 * time.Minute
+
+// certsPollInterval is how often the cert bundle is checked for a change while a request waits
+var certsPollInterval = time.Second
+
+// certBundle returns the cert bundle in the format, pem or jwks, and its etag. The pem bundle has the roots
+// registries verify the x5c chain of a token with, the jwks the keys of the signing certificates. Renewed
+// certificates are included before they are activated so registries know them in time, replaced ones until
+// the tokens signed with them expired.
+func (h *handlers) certBundle(format string) ([]byte, string, error) {
+	var bundle []byte
+
+	retain, err := h.maxTokenTTL()
+	if err != nil {
+		return nil, "", err
+	}
+
+	if format == "jwks" {
+		pki, err := issuer.Published(h.db, true, retain)
+		if err != nil {
+			return nil, "", err
+		}
+
+		jwks := utils.JWKS{Keys: []*utils.JWK{}}
+
+		for _, p := range pki {
+			block, _ := pem.Decode([]byte(p.X509))
+			if block == nil {
+				return nil, "", fmt.Errorf("invalid certificate for pki %d", p.ID)
+			}
+
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, "", err
+			}
+
+			jwk, err := utils.NewJWK(cert)
+			if err != nil {
+				return nil, "", err
+			}
+
+			// rsa keys sign with pkcs1 or pss, the algorithm tells registries which one
+			if method, err := SigningMethod(&p); err == nil {
+				jwk.Alg = method.Alg()
+			}
+
+			jwks.Keys = append(jwks.Keys, jwk)
+		}
+
+		data, err := json.Marshal(jwks)
+		if err != nil {
+			return nil, "", err
+		}
+
+		bundle = data
+	} else {
+		certs, err := issuer.Bundle(h.db, retain)
+		if err != nil {
+			return nil, "", err
+		}
+
+		var resData [][]byte
+
+		for _, cert := range certs {
+			resData = append(resData, []byte(cert))
+		}
+
+		bundle = bytes.Join(resData, []byte("\n"))
+	}
+
+	sum := sha256.Sum256(bundle)
+
+	return bundle, fmt.Sprintf(`"%s"`, hex.EncodeToString(sum[:])), nil
+}
+
+// PKICerts returns the cert bundle as pem or as a json web key set. A request with If-None-Match receives a 304 when the bundle has not
+// changed, with a wait parameter like 30s the request is held until the bundle changes or the wait is over.
 func (h *handlers) PKICerts(w http.ResponseWriter, r *http.Request) {
-	var pki []db.PKI
-	sql := h.db.Model(&db.PKI{}).Where("active = ? AND expires_at > ?", true, time.Now().UTC()).Find(&pki)
-	if sql.Error != nil {
-		w.WriteHeader(500)
+	reqID := r.Context().Value(common.ContextReqIDKey)
+	log := logrus.WithField("reqID", reqID)
+
+	format := mux.Vars(r)["format"]
+	if format != "pem" && format != "jwks" {
+		w.WriteHeader(404)
 		return
 	}
 
-	var resData [][]byte
-
-	for _, p := range pki {
-		resData = append(resData, []byte(p.X509))
+	var wait time.Duration
+	if v := r.URL.Query().Get("wait"); v != "" {
+		var err error
+		wait, err = time.ParseDuration(v)
+		if err != nil || wait < 0 {
+			w.WriteHeader(400)
+			return
+		}
+		if wait > MaxCertsWait {
+			wait = MaxCertsWait
+		}
 	}
 
-	w.WriteHeader(200)
-	w.Write(bytes.Join(resData, []byte("\n")))
+	deadline := time.Now().Add(wait)
+	match := r.Header.Get("If-None-Match")
+
+	for {
+		bundle, etag, err := h.certBundle(format)
+		if err != nil {
+			log.WithError(err).Error("unable to query database")
+			w.WriteHeader(500)
+			return
+		}
+
+		w.Header().Set("ETag", etag)
+		if format == "jwks" {
+			w.Header().Set("Content-Type", "application/json")
+		}
+
+		if match != etag {
+			w.WriteHeader(200)
+			w.Write(bundle)
+			return
+		}
+
+		if !time.Now().Before(deadline) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(certsPollInterval):
+		}
+	}
 }
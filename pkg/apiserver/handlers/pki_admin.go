@@ -0,0 +1,507 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+
+	"github.com/ekristen/dockit/pkg/apiserver/response"
+	"github.com/ekristen/dockit/pkg/common"
+	"github.com/ekristen/dockit/pkg/db"
+	"github.com/ekristen/dockit/pkg/issuer"
+	"github.com/ekristen/dockit/pkg/signer"
+)
+
+var UnknownPKIError = errors.New("unknown pki")
+
+// the states of a pki
+const (
+	PKIActive   = "active"
+	PKIPending  = "pending"
+	PKIInactive = "inactive"
+	PKIExpired  = "expired"
+)
+
+// PKIInfo describes a pki without its private key, the certificate and chain are only included for a single pki
+type PKIInfo struct {
+	ID          int64      `json:"id"`
+	State       string     `json:"state"`
+	CA          bool       `json:"ca"`
+	Type        string     `json:"type"`
+	Bits        int        `json:"bits"`
+	Algorithm   string     `json:"algorithm,omitempty"`
+	Signer      string     `json:"signer"`
+	Subject     string     `json:"subject"`
+	Issuer      string     `json:"issuer"`
+	Fingerprint string     `json:"fingerprint"`
+	NotBefore   *time.Time `json:"not_before"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+	ActivateAt  *time.Time `json:"activate_at,omitempty"`
+	CreatedAt   *time.Time `json:"created_at"`
+	Certificate string     `json:"certificate,omitempty"`
+	Chain       string     `json:"chain,omitempty"`
+}
+
+// NewPKI is the request body to import or generate a pki. It is activated right away with Activate, otherwise it
+// is published with the certificates and activated at ActivateAt, by default after the prepublish time.
+type NewPKI struct {
+	// PEM has the certificate and private key of an imported pki followed by the chain of its ca
+	PEM        string     `json:"pem,omitempty"`
+	CA         bool       `json:"ca,omitempty"`
+	Activate   bool       `json:"activate,omitempty"`
+	ActivateAt *time.Time `json:"activate_at,omitempty"`
+}
+
+func newPKIInfo(p *db.PKI, detail bool) (*PKIInfo, error) {
+	cert, err := issuer.ParseCertificate(p.X509)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate for pki %d: %w", p.ID, err)
+	}
+
+	sum := sha256.Sum256(cert.Raw)
+
+	info := &PKIInfo{
+		ID:          p.ID,
+		State:       PKIInactive,
+		CA:          p.CA,
+		Type:        p.Type,
+		Bits:        p.Bits,
+		Signer:      p.Signer,
+		Subject:     cert.Subject.String(),
+		Issuer:      cert.Issuer.String(),
+		Fingerprint: hex.EncodeToString(sum[:]),
+		NotBefore:   p.NotBefore,
+		ExpiresAt:   p.ExpiresAt,
+		ActivateAt:  p.ActivateAt,
+		CreatedAt:   p.CreatedAt,
+	}
+	if info.Signer == "" {
+		info.Signer = signer.DB
+	}
+
+	switch {
+	case p.ExpiresAt != nil && !p.ExpiresAt.After(time.Now().UTC()):
+		info.State = PKIExpired
+	case p.Active:
+		info.State = PKIActive
+	case p.ActivateAt != nil:
+		info.State = PKIPending
+	}
+
+	if !p.CA {
+		if method, err := SigningMethod(p); err == nil {
+			info.Algorithm = method.Alg()
+		}
+	}
+
+	if detail {
+		info.Certificate = p.X509
+		info.Chain = p.Chain
+	}
+
+	return info, nil
+}
+
+// pkiNotGenerated is the error for requests that need dockit to hold the private keys of the signing pki
+func (h *handlers) pkiNotGenerated() error {
+	if h.config.Signer != nil {
+		return fmt.Errorf("the private keys are held by the %s signer, its certificate is imported with the pki-file", h.config.Signer.Name())
+	}
+	if h.config.Issuer == nil {
+		return errors.New("the pki is not managed by dockit")
+	}
+
+	return nil
+}
+
+// acceptedAlgorithm returns an error when the registries do not accept the algorithm of the signing pki
+func (h *handlers) acceptedAlgorithm(p *db.PKI) error {
+	if p.CA || len(h.config.Algorithms) == 0 {
+		return nil
+	}
+
+	method, err := SigningMethod(p)
+	if err != nil {
+		return err
+	}
+
+	for _, alg := range h.config.Algorithms {
+		if alg == method.Alg() {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("the registries do not accept the algorithm %s", method.Alg())
+}
+
+// sendPKI responds with the pki, the certificate is included
+func sendPKI(log *logrus.Entry, w http.ResponseWriter, r *http.Request, p *db.PKI) {
+	info, err := newPKIInfo(p, true)
+	if err != nil {
+		log.WithError(err).Error("unable to describe pki")
+		response.New(w, r).AddError(err).Send(500)
+		return
+	}
+
+	response.New(w, r).AddData(info).Send(200)
+}
+
+// PKIs lists the signing pki and cas
+func (h *handlers) PKIs(w http.ResponseWriter, r *http.Request) {
+	reqID := r.Context().Value(common.ContextReqIDKey)
+	log := logrus.WithField("reqID", reqID)
+
+	if _, err := h.adminAuth(log, w, r); err != nil {
+		sendAuthError(w, r, err)
+		return
+	}
+
+	var pki []db.PKI
+	sql := h.db.Order("ca DESC").Order("created_at").Find(&pki)
+	if sql.Error != nil {
+		log.WithError(sql.Error).Error("unable to query database")
+		response.New(w, r).AddError(DBError).Send(500)
+		return
+	}
+
+	infos := []*PKIInfo{}
+	for n := range pki {
+		info, err := newPKIInfo(&pki[n], false)
+		if err != nil {
+			log.WithError(err).Error("unable to describe pki")
+			response.New(w, r).AddError(err).Send(500)
+			return
+		}
+
+		infos = append(infos, info)
+	}
+
+	response.New(w, r).AddData(infos).Send(200)
+}
+
+// findPKI returns the pki of the id in the path, it responds with an error when there is none
+func (h *handlers) findPKI(log *logrus.Entry, w http.ResponseWriter, r *http.Request) *db.PKI {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		response.New(w, r).AddError(fmt.Errorf("%w: %s", UnknownPKIError, mux.Vars(r)["id"])).Send(404)
+		return nil
+	}
+
+	var pki db.PKI
+	sql := h.db.Where("id = ?", id).First(&pki)
+	if sql.Error == gorm.ErrRecordNotFound {
+		response.New(w, r).AddError(fmt.Errorf("%w: %d", UnknownPKIError, id)).Send(404)
+		return nil
+	} else if sql.Error != nil {
+		log.WithError(sql.Error).Error("unable to query database")
+		response.New(w, r).AddError(DBError).Send(500)
+		return nil
+	}
+
+	return &pki
+}
+
+// PKI shows a pki with its certificate and chain
+func (h *handlers) PKI(w http.ResponseWriter, r *http.Request) {
+	reqID := r.Context().Value(common.ContextReqIDKey)
+	log := logrus.WithField("reqID", reqID)
+
+	if _, err := h.adminAuth(log, w, r); err != nil {
+		sendAuthError(w, r, err)
+		return
+	}
+
+	pki := h.findPKI(log, w, r)
+	if pki == nil {
+		return
+	}
+
+	sendPKI(log, w, r, pki)
+}
+
+// decodeNewPKI decodes the request body and returns when the pki is activated, nil to activate it right away
+func (h *handlers) decodeNewPKI(r *http.Request) (*NewPKI, *time.Time, error) {
+	var newPKI NewPKI
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&newPKI); err != nil {
+			return nil, nil, fmt.Errorf("invalid pki: %s", err)
+		}
+	}
+
+	if newPKI.Activate {
+		return &newPKI, nil, nil
+	}
+
+	activateAt := time.Now().UTC().Add(h.config.PKIPrepublish)
+	if newPKI.ActivateAt != nil {
+		activateAt = newPKI.ActivateAt.UTC()
+	}
+
+	return &newPKI, &activateAt, nil
+}
+
+// PKIImport imports a signing pki or a ca
+func (h *handlers) PKIImport(w http.ResponseWriter, r *http.Request) {
+	reqID := r.Context().Value(common.ContextReqIDKey)
+	log := logrus.WithField("reqID", reqID)
+
+	res := response.New(w, r)
+
+	if _, err := h.adminAuth(log, w, r); err != nil {
+		sendAuthError(w, r, err)
+		return
+	}
+
+	if err := h.pkiNotGenerated(); err != nil {
+		res.AddError(err).Send(409)
+		return
+	}
+
+	newPKI, activateAt, err := h.decodeNewPKI(r)
+	if err != nil {
+		res.AddError(err).Send(400)
+		return
+	}
+
+	var pki *db.PKI
+	if newPKI.CA {
+		// a ca is always activated, signing certificates are issued from it on rotation
+		pki, err = h.config.Issuer.ImportCA(r.Context(), []byte(newPKI.PEM))
+	} else {
+		// the issuer rejects an algorithm the registries do not accept before the pki is stored
+		pending := time.Now().UTC()
+		if activateAt != nil {
+			pending = *activateAt
+		}
+		pki, err = h.config.Issuer.Import(r.Context(), []byte(newPKI.PEM), &pending)
+	}
+	if errors.Is(err, issuer.ErrExists) {
+		res.AddError(err).Send(409)
+		return
+	} else if err != nil {
+		res.AddError(fmt.Errorf("invalid pki: %w", err)).Send(400)
+		return
+	}
+
+	if !pki.CA && activateAt == nil {
+		if err := issuer.Activate(h.db, pki); err != nil {
+			log.WithError(err).Error("unable to query database")
+			res.AddError(DBError).Send(500)
+			return
+		}
+	}
+
+	log.WithField("id", pki.ID).WithField("ca", pki.CA).Info("pki imported")
+
+	sendPKI(log, w, r, pki)
+}
+
+// PKIGenerate generates a signing pki
+func (h *handlers) PKIGenerate(w http.ResponseWriter, r *http.Request) {
+	reqID := r.Context().Value(common.ContextReqIDKey)
+	log := logrus.WithField("reqID", reqID)
+
+	res := response.New(w, r)
+
+	if _, err := h.adminAuth(log, w, r); err != nil {
+		sendAuthError(w, r, err)
+		return
+	}
+
+	if err := h.pkiNotGenerated(); err != nil {
+		res.AddError(err).Send(409)
+		return
+	}
+
+	_, activateAt, err := h.decodeNewPKI(r)
+	if err != nil {
+		res.AddError(err).Send(400)
+		return
+	}
+
+	var pki *db.PKI
+	if activateAt == nil {
+		pki, err = h.config.Issuer.Generate(r.Context())
+	} else {
+		pki, err = h.config.Issuer.Renew(r.Context(), nil, *activateAt)
+	}
+	if errors.Is(err, issuer.ErrActivateAfterExpiry) {
+		res.AddError(err).Send(400)
+		return
+	} else if errors.Is(err, issuer.ErrNoActiveCA) {
+		res.AddError(err).Send(409)
+		return
+	} else if err != nil {
+		log.WithError(err).Error("unable to generate pki")
+		res.AddError(err).Send(500)
+		return
+	}
+
+	log.WithField("id", pki.ID).WithField("activate_at", pki.ActivateAt).Info("pki generated")
+
+	sendPKI(log, w, r, pki)
+}
+
+// PKIRotate activates the pending signing pki right away. Without one a new pki is generated, it is published
+// for the prepublish duration before it is activated unless the activation is forced or the bundle of the
+// registries does not change with it as it is issued by the internal ca.
+func (h *handlers) PKIRotate(w http.ResponseWriter, r *http.Request) {
+	reqID := r.Context().Value(common.ContextReqIDKey)
+	log := logrus.WithField("reqID", reqID)
+
+	res := response.New(w, r)
+
+	if _, err := h.adminAuth(log, w, r); err != nil {
+		sendAuthError(w, r, err)
+		return
+	}
+
+	if err := h.pkiNotGenerated(); err != nil {
+		res.AddError(err).Send(409)
+		return
+	}
+
+	newPKI, _, err := h.decodeNewPKI(r)
+	if err != nil {
+		res.AddError(err).Send(400)
+		return
+	}
+
+	var pending db.PKI
+	sql := h.db.Where("active = ? AND ca = ? AND activate_at IS NOT NULL AND expires_at > ?", false, false, time.Now().UTC()).Order("activate_at").Limit(1).Find(&pending)
+	if sql.Error != nil {
+		log.WithError(sql.Error).Error("unable to query database")
+		res.AddError(DBError).Send(500)
+		return
+	}
+
+	if sql.RowsAffected == 1 {
+		if err := issuer.Activate(h.db, &pending); err != nil {
+			log.WithError(err).Error("unable to query database")
+			res.AddError(DBError).Send(500)
+			return
+		}
+
+		log.WithField("id", pending.ID).Info("pki rotated to the pending pki")
+
+		sendPKI(log, w, r, &pending)
+		return
+	}
+
+	// registries that did not pick up the certificate yet would reject the tokens signed with it
+	if !newPKI.Activate && !h.config.Issuer.IssuedByCA() && h.config.PKIPrepublish > 0 {
+		pki, err := h.config.Issuer.Renew(r.Context(), nil, time.Now().UTC().Add(h.config.PKIPrepublish))
+		if err != nil {
+			log.WithError(err).Error("unable to generate pki")
+			res.AddError(err).Send(500)
+			return
+		}
+
+		log.WithField("id", pki.ID).WithField("activate_at", pki.ActivateAt).Info("pki generated for the rotation")
+
+		sendPKI(log, w, r, pki)
+		return
+	}
+
+	pki, err := h.config.Issuer.Generate(r.Context())
+	if errors.Is(err, issuer.ErrNoActiveCA) {
+		res.AddError(err).Send(409)
+		return
+	} else if err != nil {
+		log.WithError(err).Error("unable to generate pki")
+		res.AddError(err).Send(500)
+		return
+	}
+
+	log.WithField("id", pki.ID).Info("pki rotated to a generated pki")
+
+	sendPKI(log, w, r, pki)
+}
+
+// PKIAction activates or deactivates a pki
+func (h *handlers) PKIAction(w http.ResponseWriter, r *http.Request) {
+	reqID := r.Context().Value(common.ContextReqIDKey)
+	log := logrus.WithField("reqID", reqID)
+
+	res := response.New(w, r)
+
+	if _, err := h.adminAuth(log, w, r); err != nil {
+		sendAuthError(w, r, err)
+		return
+	}
+
+	if err := h.pkiNotGenerated(); err != nil {
+		res.AddError(err).Send(409)
+		return
+	}
+
+	pki := h.findPKI(log, w, r)
+	if pki == nil {
+		return
+	}
+
+	if pki.ExpiresAt != nil && !pki.ExpiresAt.After(time.Now().UTC()) {
+		res.AddError(fmt.Errorf("pki %d is expired", pki.ID)).Send(409)
+		return
+	}
+
+	switch mux.Vars(r)["action"] {
+	case "activate":
+		if err := h.acceptedAlgorithm(pki); err != nil {
+			res.AddError(err).Send(409)
+			return
+		}
+		if !pki.CA && pki.Signer != "" && pki.Signer != signer.DB && (h.config.Signer == nil || h.config.Signer.Name() != pki.Signer) {
+			res.AddError(fmt.Errorf("the private key of pki %d is held by the %s signer which is not configured", pki.ID, pki.Signer)).Send(409)
+			return
+		}
+
+		if err := issuer.Activate(h.db, pki); err != nil {
+			log.WithError(err).Error("unable to query database")
+			res.AddError(DBError).Send(500)
+			return
+		}
+	case "deactivate":
+		// tokens could not be signed anymore
+		if pki.Active && !pki.CA {
+			res.AddError(errors.New("the active signing pki can not be deactivated, activate another one or rotate first")).Send(409)
+			return
+		}
+
+		// signing certificates could not be issued anymore
+		if pki.Active && pki.CA {
+			var others int64
+			if sql := h.db.Model(&db.PKI{}).Where("ca = ? AND active = ? AND expires_at > ? AND id <> ?", true, true, time.Now().UTC(), pki.ID).Count(&others); sql.Error != nil {
+				log.WithError(sql.Error).Error("unable to query database")
+				res.AddError(DBError).Send(500)
+				return
+			}
+
+			if others == 0 {
+				res.AddError(errors.New("the active ca can not be deactivated, activate or import another one first")).Send(409)
+				return
+			}
+		}
+
+		pki.Active = false
+		pki.ActivateAt = nil
+		if sql := h.db.Model(pki).Select("active", "activate_at").Updates(pki); sql.Error != nil {
+			log.WithError(sql.Error).Error("unable to query database")
+			res.AddError(DBError).Send(500)
+			return
+		}
+	}
+
+	log.WithField("id", pki.ID).WithField("action", mux.Vars(r)["action"]).Info("pki updated")
+
+	sendPKI(log, w, r, pki)
+}
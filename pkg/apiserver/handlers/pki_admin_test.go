@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/snowflake"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ekristen/dockit/pkg/apiserver/response"
+	"github.com/ekristen/dockit/pkg/db"
+	"github.com/ekristen/dockit/pkg/issuer"
+	"github.com/ekristen/dockit/pkg/signer"
+	"github.com/ekristen/dockit/pkg/utils"
+)
+
+func pkiRequest(t *testing.T, handler http.HandlerFunc, method string, vars map[string]string, body interface{}) (int, *PKIInfo, []PKIInfo) {
+	var data []byte
+	if body != nil {
+		var err error
+		data, err = json.Marshal(body)
+		assert.NoError(t, err)
+	}
+
+	req := mux.SetURLVars(httptest.NewRequest(method, "/v2/admin/pki", bytes.NewReader(data)), vars)
+	req.SetBasicAuth("admin", "adminpw")
+
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	assert.NotContains(t, rec.Body.String(), "PRIVATE KEY")
+
+	res, err := response.ReadAllDecode(rec.Body)
+	assert.NoError(t, err)
+	if !res.Status {
+		return rec.Code, nil, nil
+	}
+
+	if _, ok := res.Data.([]interface{}); ok {
+		var list []PKIInfo
+		raw, _ := json.Marshal(res.Data)
+		assert.NoError(t, json.Unmarshal(raw, &list))
+		return rec.Code, nil, list
+	}
+
+	var info PKIInfo
+	raw, _ := json.Marshal(res.Data)
+	assert.NoError(t, json.Unmarshal(raw, &info))
+	return rec.Code, &info, nil
+}
+
+func Test_PKIAdmin(t *testing.T) {
+	h := newTestHandlers(t)
+	assert.NoError(t, h.db.Create(&db.User{Username: "admin", Password: "adminpw", Active: true, Admin: true}).Error)
+
+	// without an issuer the pki comes from the pki-file and is not managed with the admin api
+	code, _, _ := pkiRequest(t, h.PKIAction, "PUT", map[string]string{"id": "1", "action": "activate"}, nil)
+	assert.Equal(t, 409, code)
+	code, _, _ = pkiRequest(t, h.PKIRotate, "POST", nil, nil)
+	assert.Equal(t, 409, code)
+
+	node, err := snowflake.NewNode(1)
+	assert.NoError(t, err)
+	opts := issuer.Options{KeyType: "ec", ECKeySize: 256, Years: 1, Subject: pkix.Name{CommonName: "dockit"}, Algorithms: signer.DistributionAlgorithms}
+	h.config.Issuer = issuer.New(h.db, node, opts)
+	h.config.Algorithms = opts.Algorithms
+
+	code, _, list := pkiRequest(t, h.PKIs, "GET", nil, nil)
+	assert.Equal(t, 200, code)
+	assert.Len(t, list, 1)
+	assert.Equal(t, PKIActive, list[0].State)
+	assert.Equal(t, "ES256", list[0].Algorithm)
+	assert.Len(t, list[0].Fingerprint, 64)
+	assert.Empty(t, list[0].Certificate)
+
+	code, info, _ := pkiRequest(t, h.PKI, "GET", map[string]string{"id": "1"}, nil)
+	assert.Equal(t, 200, code)
+	assert.True(t, strings.HasPrefix(info.Certificate, "-----BEGIN CERTIFICATE-----"))
+
+	code, _, _ = pkiRequest(t, h.PKI, "GET", map[string]string{"id": "2"}, nil)
+	assert.Equal(t, 404, code)
+
+	// a pki can not be activated after its certificate expired
+	later := time.Now().UTC().Add(2 * 365 * 24 * time.Hour)
+	code, _, _ = pkiRequest(t, h.PKIGenerate, "POST", nil, NewPKI{ActivateAt: &later})
+	assert.Equal(t, 400, code)
+
+	// a generated pki is pending until it is activated
+	code, pending, _ := pkiRequest(t, h.PKIGenerate, "POST", nil, NewPKI{})
+	assert.Equal(t, 200, code)
+	assert.Equal(t, PKIPending, pending.State)
+
+	// the active pki can not be deactivated, tokens could not be signed anymore
+	code, _, _ = pkiRequest(t, h.PKIAction, "PUT", map[string]string{"id": "1", "action": "deactivate"}, nil)
+	assert.Equal(t, 409, code)
+
+	code, info, _ = pkiRequest(t, h.PKIRotate, "POST", nil, nil)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, pending.ID, info.ID)
+	assert.Equal(t, PKIActive, info.State)
+
+	code, info, _ = pkiRequest(t, h.PKIAction, "PUT", map[string]string{"id": "1", "action": "activate"}, nil)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, PKIActive, info.State)
+
+	var active []db.PKI
+	assert.NoError(t, h.db.Where("active = ?", true).Find(&active).Error)
+	assert.Len(t, active, 1)
+	assert.Equal(t, int64(1), active[0].ID)
+
+	// an imported pki with an algorithm the registries do not accept is rejected
+	_, keyPEM, err := utils.GenerateEd25519Key()
+	assert.NoError(t, err)
+	key, err := issuer.ParsePrivateKey(keyPEM)
+	assert.NoError(t, err)
+	_, certPEM, err := utils.GenerateCertificate(42, pkix.Name{CommonName: "dockit"}, key.Public(), key, 1, 0, 0)
+	assert.NoError(t, err)
+
+	code, _, _ = pkiRequest(t, h.PKIImport, "POST", nil, NewPKI{PEM: string(certPEM) + string(keyPEM), Activate: true})
+	assert.Equal(t, 400, code)
+
+	var count int64
+	assert.NoError(t, h.db.Model(&db.PKI{}).Where("serial = ?", "2a").Count(&count).Error)
+	assert.Equal(t, int64(0), count)
+
+	opts.Algorithms = append([]string{signer.EdDSA}, signer.DistributionAlgorithms...)
+	h.config.Issuer = issuer.New(h.db, node, opts)
+	h.config.Algorithms = opts.Algorithms
+
+	code, _, _ = pkiRequest(t, h.PKIImport, "POST", nil, NewPKI{PEM: string(certPEM) + string(keyPEM), ActivateAt: &later})
+	assert.Equal(t, 400, code)
+	assert.NoError(t, h.db.Model(&db.PKI{}).Where("serial = ?", "2a").Count(&count).Error)
+	assert.Equal(t, int64(0), count)
+
+	code, info, _ = pkiRequest(t, h.PKIImport, "POST", nil, NewPKI{PEM: string(certPEM) + string(keyPEM), Activate: true})
+	assert.Equal(t, 200, code)
+	assert.Equal(t, PKIActive, info.State)
+	assert.Equal(t, "EdDSA", info.Algorithm)
+
+	code, _, _ = pkiRequest(t, h.PKIImport, "POST", nil, NewPKI{PEM: string(certPEM) + string(keyPEM)})
+	assert.Equal(t, 409, code)
+
+	code, info, _ = pkiRequest(t, h.PKIAction, "PUT", map[string]string{"id": fmt.Sprint(pending.ID), "action": "deactivate"}, nil)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, PKIInactive, info.State)
+
+	// without a pending pki the rotation publishes a generated one first
+	h.config.PKIPrepublish = time.Hour
+	code, pending, _ = pkiRequest(t, h.PKIRotate, "POST", nil, nil)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, PKIPending, pending.State)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), *pending.ActivateAt, time.Minute)
+
+	code, info, _ = pkiRequest(t, h.PKIRotate, "POST", nil, nil)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, pending.ID, info.ID)
+	assert.Equal(t, PKIActive, info.State)
+
+	// unless the activation is forced
+	code, info, _ = pkiRequest(t, h.PKIRotate, "POST", nil, NewPKI{Activate: true})
+	assert.Equal(t, 200, code)
+	assert.NotEqual(t, pending.ID, info.ID)
+	assert.Equal(t, PKIActive, info.State)
+}
+
+func Test_PKIAdminCA(t *testing.T) {
+	h := newTestHandlers(t)
+	assert.NoError(t, h.db.Create(&db.User{Username: "admin", Password: "adminpw", Active: true, Admin: true}).Error)
+
+	node, err := snowflake.NewNode(1)
+	assert.NoError(t, err)
+	opts := issuer.Options{KeyType: "ec", ECKeySize: 256, Years: 1, Subject: pkix.Name{CommonName: "dockit"}, Algorithms: signer.DistributionAlgorithms,
+		CA: true, CASubject: pkix.Name{CommonName: "dockit ca"}, CAYears: 10}
+	h.config.Issuer = issuer.New(h.db, node, opts)
+	h.config.Algorithms = opts.Algorithms
+
+	_, _, err = h.config.Issuer.Ensure(context.Background())
+	assert.NoError(t, err)
+
+	ca, _, _, err := h.config.Issuer.CA(context.Background())
+	assert.NoError(t, err)
+
+	// the only active ca can not be deactivated, signing certificates could not be issued anymore
+	code, _, _ := pkiRequest(t, h.PKIAction, "PUT", map[string]string{"id": fmt.Sprint(ca.ID), "action": "deactivate"}, nil)
+	assert.Equal(t, 409, code)
+
+	code, info, _ := pkiRequest(t, h.PKI, "GET", map[string]string{"id": fmt.Sprint(ca.ID)}, nil)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, PKIActive, info.State)
+
+	code, _, _ = pkiRequest(t, h.PKIGenerate, "POST", nil, NewPKI{})
+	assert.Equal(t, 200, code)
+
+	// the bundle only has the ca, a generated pki is activated right away
+	h.config.PKIPrepublish = time.Hour
+	assert.NoError(t, h.db.Model(&db.PKI{}).Where("activate_at IS NOT NULL").Update("activate_at", nil).Error)
+	code, info, _ = pkiRequest(t, h.PKIRotate, "POST", nil, nil)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, PKIActive, info.State)
+}
@@ -0,0 +1,310 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+
+	"github.com/ekristen/dockit/pkg/common"
+	"github.com/ekristen/dockit/pkg/db"
+	"github.com/ekristen/dockit/pkg/docker"
+)
+
+// catalogPageSize is the number of repositories requested from the upstream per catalog page
+const catalogPageSize = 1000
+
+// accessBatchSize is the number of repositories the access of a user is resolved for at once
+const accessBatchSize = 200
+
+var tagsListPath = regexp.MustCompile(`^/v2/(.+)/tags/list$`)
+
+var linkNext = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// RegistryError is the error format of the distribution api
+type RegistryError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Catalog is the response of the distribution catalog api
+type Catalog struct {
+	Repositories []string `json:"repositories"`
+}
+
+type registryProxy struct {
+	h        *handlers
+	upstream *url.URL
+	proxy    *httputil.ReverseProxy
+	client   *http.Client
+}
+
+// RegistryProxy returns a reverse proxy to the upstream registry that only lists the repositories a user
+// can pull in the catalog and tag list responses, every other request is passed through as is
+func (h *handlers) RegistryProxy(upstream *url.URL) http.Handler {
+	return &registryProxy{
+		h:        h,
+		upstream: upstream,
+		proxy:    httputil.NewSingleHostReverseProxy(upstream),
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (p *registryProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	reqID := r.Context().Value(common.ContextReqIDKey)
+	log := logrus.WithField("reqID", reqID)
+
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		if r.URL.Path == "/v2/_catalog" {
+			p.catalog(log, w, r)
+			return
+		}
+
+		if m := tagsListPath.FindStringSubmatch(r.URL.Path); m != nil {
+			p.tagsList(log, w, r, m[1])
+			return
+		}
+	}
+
+	p.proxy.ServeHTTP(w, r)
+}
+
+func (p *registryProxy) catalog(log *logrus.Entry, w http.ResponseWriter, r *http.Request) {
+	user, audience, ok := p.authenticate(log, w, r, "registry:catalog:*")
+	if !ok {
+		return
+	}
+
+	repositories, err := p.upstreamCatalog(log, audience)
+	if err != nil {
+		log.WithError(err).Error("unable to fetch the upstream catalog")
+		sendRegistryError(w, 502, "UNAVAILABLE", "unable to fetch the catalog")
+		return
+	}
+
+	visible, err := p.pullable(r, user, audience, repositories)
+	if err != nil {
+		log.WithError(err).Error("unable to resolve access")
+		sendRegistryError(w, 500, "UNKNOWN", "unable to resolve access")
+		return
+	}
+
+	sort.Strings(visible)
+
+	// pagination is applied after filtering as the upstream pages include repositories the user cannot see
+	if last := r.URL.Query().Get("last"); last != "" {
+		i := sort.SearchStrings(visible, last)
+		if i < len(visible) && visible[i] == last {
+			i++
+		}
+		visible = visible[i:]
+	}
+
+	if n, err := strconv.Atoi(r.URL.Query().Get("n")); err == nil && n > 0 && n < len(visible) {
+		visible = visible[:n]
+		w.Header().Set("Link", fmt.Sprintf(`</v2/_catalog?last=%s&n=%d>; rel="next"`, url.QueryEscape(visible[n-1]), n))
+	}
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(200)
+	if r.Method == http.MethodHead {
+		return
+	}
+	if err := json.NewEncoder(w).Encode(Catalog{Repositories: visible}); err != nil {
+		log.WithError(err).Error("unable to encode json")
+	}
+}
+
+func (p *registryProxy) tagsList(log *logrus.Entry, w http.ResponseWriter, r *http.Request, name string) {
+	user, audience, ok := p.authenticate(log, w, r, fmt.Sprintf("repository:%s:pull", name))
+	if !ok {
+		return
+	}
+
+	visible, err := p.pullable(r, user, audience, []string{name})
+	if err != nil {
+		log.WithError(err).Error("unable to resolve access")
+		sendRegistryError(w, 500, "UNKNOWN", "unable to resolve access")
+		return
+	}
+
+	// repositories the user cannot pull are reported as unknown like they are in the catalog
+	if len(visible) == 0 {
+		sendRegistryError(w, 404, "NAME_UNKNOWN", "repository name not known to registry")
+		return
+	}
+
+	p.proxy.ServeHTTP(w, r)
+}
+
+// authenticate returns the user of the dockit token the request was made with and the service it was issued
+// for. Without a valid token the challenge of the upstream is returned when it requires authentication,
+// otherwise the request is made as the anonymous user.
+func (p *registryProxy) authenticate(log *logrus.Entry, w http.ResponseWriter, r *http.Request, scope string) (*db.User, string, bool) {
+	if user, claims := p.h.bearerUser(log, r); user != nil && !hasAdminScope(claims.Access) {
+		return user, claims.Audience, true
+	}
+
+	res, err := p.client.Get(p.upstream.ResolveReference(&url.URL{Path: "/v2/"}).String())
+	if err != nil {
+		log.WithError(err).Error("unable to reach the upstream registry")
+		sendRegistryError(w, 502, "UNAVAILABLE", "unable to reach the registry")
+		return nil, "", false
+	}
+	res.Body.Close()
+
+	if res.StatusCode == http.StatusUnauthorized {
+		challenge := res.Header.Get("WWW-Authenticate")
+		if challenge != "" {
+			challenge = fmt.Sprintf(`%s,scope="%s"`, challenge, scope)
+			w.Header().Set("WWW-Authenticate", challenge)
+		}
+		sendRegistryError(w, 401, "UNAUTHORIZED", "authentication required")
+		return nil, "", false
+	}
+
+	var users []db.User
+	if sql := p.h.db.Where("username = ? AND active = ?", "anonymous", true).Limit(1).Find(&users); sql.Error != nil {
+		log.WithError(sql.Error).Error("unable to query database")
+		sendRegistryError(w, 500, "UNKNOWN", "unable to query database")
+		return nil, "", false
+	}
+	if len(users) == 0 {
+		return &db.User{Username: "anonymous"}, "", true
+	}
+
+	return &users[0], "", true
+}
+
+// pullable returns the repositories the user can pull from the client ip with tokens for the service
+func (p *registryProxy) pullable(r *http.Request, user *db.User, audience string, repositories []string) ([]string, error) {
+	visible := []string{}
+	if user.ID == 0 {
+		return visible, nil
+	}
+
+	groups, err := p.h.resolveGroups(user)
+	if err != nil {
+		return nil, err
+	}
+	user.Groups = groups
+
+	service, err := p.h.lookupService(audience)
+	if err == UnknownServiceError {
+		return visible, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	ip, _ := r.Context().Value(common.ContextKeyRemoteAddr).(string)
+	implicit := p.h.implicitPermissions(user)
+
+	for i := 0; i < len(repositories); i += accessBatchSize {
+		end := i + accessBatchSize
+		if end > len(repositories) {
+			end = len(repositories)
+		}
+
+		scopes := []docker.Scope{}
+		for _, name := range repositories[i:end] {
+			scopes = append(scopes, docker.Scope{Type: string(db.Repository), Name: name, Actions: []string{string(db.Pull)}})
+		}
+
+		granted, err := p.h.resolveAccess(p.h.entityIDs(user), implicit, service, ip, scopes)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, s := range granted {
+			visible = append(visible, s.Name)
+		}
+	}
+
+	return visible, nil
+}
+
+// upstreamCatalog returns every repository of the upstream registry, it authenticates with a short lived
+// token for the catalog signed by dockit
+func (p *registryProxy) upstreamCatalog(log *logrus.Entry, audience string) ([]string, error) {
+	now := time.Now().UTC()
+	token, err := p.h.signToken(log, TokenClaims{
+		Access: []docker.Scope{{Type: string(db.Registry), Name: string(db.Catalog), Actions: []string{"*"}}},
+		StandardClaims: jwt.StandardClaims{
+			Id:        uuid.NewString(),
+			Audience:  audience,
+			Issuer:    p.h.config.TokenIssuer,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(time.Minute).Unix(),
+			NotBefore: now.Unix(),
+			Subject:   common.AppVersion.Name,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	repositories := []string{}
+	next := p.upstream.ResolveReference(&url.URL{Path: "/v2/_catalog", RawQuery: fmt.Sprintf("n=%d", catalogPageSize)})
+
+	for next != nil {
+		req, err := http.NewRequest(http.MethodGet, next.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		res, err := p.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		var page Catalog
+		err = decodeUpstream(res, &page)
+		if err != nil {
+			return nil, err
+		}
+
+		repositories = append(repositories, page.Repositories...)
+
+		next = nil
+		if m := linkNext.FindStringSubmatch(res.Header.Get("Link")); m != nil {
+			link, err := url.Parse(m[1])
+			if err != nil {
+				return nil, err
+			}
+			next = p.upstream.ResolveReference(link)
+		}
+	}
+
+	return repositories, nil
+}
+
+func decodeUpstream(res *http.Response, v interface{}) error {
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("upstream returned %d: %s", res.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+func sendRegistryError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string][]RegistryError{
+		"errors": {{Code: code, Message: message}},
+	})
+}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"context"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/snowflake"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ekristen/dockit/pkg/common"
+	"github.com/ekristen/dockit/pkg/db"
+	"github.com/ekristen/dockit/pkg/utils"
+)
+
+// standInRegistry serves a paginated catalog and tag lists and requires a bearer token like distribution does
+func standInRegistry(repositories []string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="http://dockit/v2/token",service="registry"`)
+			w.WriteHeader(401)
+			return
+		}
+
+		switch {
+		case r.URL.Path == "/v2/_catalog":
+			start := 0
+			if last := r.URL.Query().Get("last"); last != "" {
+				for i, name := range repositories {
+					if name == last {
+						start = i + 1
+					}
+				}
+			}
+
+			// pages of two to exercise pagination
+			end := start + 2
+			if end < len(repositories) {
+				w.Header().Set("Link", fmt.Sprintf(`</v2/_catalog?last=%s&n=2>; rel="next"`, repositories[end-1]))
+			} else {
+				end = len(repositories)
+			}
+
+			_ = json.NewEncoder(w).Encode(Catalog{Repositories: repositories[start:end]})
+		case strings.HasSuffix(r.URL.Path, "/tags/list"):
+			_, _ = w.Write([]byte(`{"name":"x","tags":["latest"]}`))
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+}
+
+func newTestHandlers(t *testing.T) *handlers {
+	node, err := snowflake.NewNode(1)
+	assert.NoError(t, err)
+
+	ctx := context.WithValue(context.Background(), common.ContextKeyNode, node)
+	database, err := db.New(ctx, "sqlite", fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name()), nil)
+	assert.NoError(t, err)
+
+	key, keyPEM, err := utils.GenerateECKey(256)
+	assert.NoError(t, err)
+	cert, certPEM, err := utils.GenerateCertificate(1, pkix.Name{CommonName: "dockit"}, &key.PublicKey, key, 1, 0, 0)
+	assert.NoError(t, err)
+
+	assert.NoError(t, database.Create(&db.PKI{
+		ID: 1, Type: "ECDSA", Bits: 256, Private: string(keyPEM), X509: string(certPEM),
+		NotBefore: &cert.NotBefore, ExpiresAt: &cert.NotAfter, Active: true,
+	}).Error)
+
+	return New(database, nil)
+}
+
+func testToken(t *testing.T, h *handlers, username, password string) string {
+	req := httptest.NewRequest("GET", "/v2/token?service=registry&scope=registry:catalog:*", nil)
+	req.SetBasicAuth(username, password)
+
+	rec := httptest.NewRecorder()
+	h.Token(rec, req)
+	assert.Equal(t, 200, rec.Code)
+
+	var res TokenResponse
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+
+	return res.Token
+}
+
+func Test_RegistryProxy(t *testing.T) {
+	h := newTestHandlers(t)
+
+	user := &db.User{Username: "bob", Password: "bobpw", Active: true}
+	assert.NoError(t, h.db.Create(user).Error)
+	assert.NoError(t, h.db.Create(&db.Permission{Type: db.Namespace, Name: "team", Action: db.Pull, EntityID: user.ID}).Error)
+	assert.NoError(t, h.db.Create(&db.Permission{Type: db.Repository, Name: "other/app", Action: db.Push, EntityID: user.ID}).Error)
+
+	registry := standInRegistry([]string{"other/app", "other/secret", "prod/api", "team/api", "team/web"})
+	defer registry.Close()
+
+	upstream, _ := url.Parse(registry.URL)
+	proxy := h.RegistryProxy(upstream)
+
+	catalog := func(query, token string) (*httptest.ResponseRecorder, Catalog) {
+		req := httptest.NewRequest("GET", "/v2/_catalog"+query, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		rec := httptest.NewRecorder()
+		proxy.ServeHTTP(rec, req)
+
+		var c Catalog
+		_ = json.NewDecoder(rec.Body).Decode(&c)
+
+		return rec, c
+	}
+
+	rec, _ := catalog("", "")
+	assert.Equal(t, 401, rec.Code)
+	assert.Contains(t, rec.Header().Get("WWW-Authenticate"), `scope="registry:catalog:*"`)
+
+	token := testToken(t, h, "bob", "bobpw")
+
+	rec, c := catalog("", token)
+	assert.Equal(t, 200, rec.Code)
+	assert.Equal(t, []string{"other/app", "team/api", "team/web"}, c.Repositories)
+
+	rec, c = catalog("?n=2", token)
+	assert.Equal(t, []string{"other/app", "team/api"}, c.Repositories)
+	assert.Equal(t, `</v2/_catalog?last=team%2Fapi&n=2>; rel="next"`, rec.Header().Get("Link"))
+
+	rec, c = catalog("?n=2&last=team%2Fapi", token)
+	assert.Equal(t, []string{"team/web"}, c.Repositories)
+	assert.Empty(t, rec.Header().Get("Link"))
+
+	tags := func(name string) int {
+		req := httptest.NewRequest("GET", "/v2/"+name+"/tags/list", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		rec := httptest.NewRecorder()
+		proxy.ServeHTTP(rec, req)
+
+		return rec.Code
+	}
+
+	assert.Equal(t, 200, tags("team/api"))
+	assert.Equal(t, 404, tags("prod/api"))
+}
@@ -2,24 +2,31 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 
 	"github.com/ekristen/dockit/pkg/apiserver/response"
 	"github.com/ekristen/dockit/pkg/common"
 	"github.com/ekristen/dockit/pkg/db"
-	"github.com/ekristen/dockit/pkg/httpauth"
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
 
+var PasswordLengthError = errors.New("password must be at least 4 characters")
+
 type Password struct {
 	Password string `json:"password"`
 }
 
+// NewUser is the request body to add a user
+type NewUser struct {
+	Password string `json:"password"`
+	Robot    bool   `json:"robot"`
+}
+
 type GroupAction struct {
 	Name string `json:"name"`
 }
@@ -30,41 +37,13 @@ func (h *handlers) Action(w http.ResponseWriter, r *http.Request) {
 
 	params := mux.Vars(r)
 
-	auth, err := httpauth.Parse(r)
-	if err != nil {
-		log.WithError(err).Error("unable to parse auth header")
-		w.WriteHeader(500)
-		return
-	}
+	res := response.New(w, r)
 
 	log.WithField("query", r.URL.Query()).Debug("url query")
 
-	log.Debug("basic authentication")
-	var user db.User
-
-	sql := h.db.Where("username = ? AND admin = ?", auth.Username(), true).First(&user)
-	if sql.Error != nil {
-		if sql.Error == gorm.ErrRecordNotFound {
-			w.WriteHeader(401)
-			return
-		}
-
-		log.WithError(sql.Error).Error("unable to query database")
-		w.WriteHeader(500)
-		return
-	}
-
-	if sql.RowsAffected == 0 {
-		log.Debug("unknown user")
-		w.WriteHeader(401)
-		w.Write([]byte("unknown user"))
-		return
-	}
-
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(auth.Password())); err != nil {
-		log.WithError(err).Debug("invalid password")
-		w.WriteHeader(401)
-		w.Write([]byte("invalid password"))
+	caller, err := h.userAuth(log, w, r)
+	if err != nil {
+		sendAuthError(w, r, err)
 		return
 	}
 
@@ -72,40 +51,49 @@ func (h *handlers) Action(w http.ResponseWriter, r *http.Request) {
 
 	rbac_type, ok := params["rbac_type"]
 	if !ok {
-		w.WriteHeader(400)
+		res.AddError(errors.New("missing rbac_type parameter")).Send(400)
 		return
 	}
 	rbac_entity, ok := params["rbac_entity"]
 	if !ok {
-		w.WriteHeader(400)
+		res.AddError(errors.New("missing rbac_entity parameter")).Send(400)
 		return
 	}
 
 	action, ok := params["action"]
 	if !ok {
-		w.WriteHeader(400)
+		res.AddError(errors.New("missing action parameter")).Send(400)
 		return
 	}
 
+	// namespace admins can only manage the members of groups, which is checked once the group is loaded
+	if !caller.Admin {
+		delegated := rbac_type == "group" && (action == "add-member" || action == "remove-member" || action == "permissions")
+		if !delegated {
+			sendAuthError(w, r, ForbiddenError)
+			return
+		}
+	}
+
 	switch rbac_type {
 	case "user":
 		if action == "add" {
-			var newPassword Password
+			var newUser NewUser
 
-			if err := json.NewDecoder(r.Body).Decode(&newPassword); err != nil {
+			if err := json.NewDecoder(r.Body).Decode(&newUser); err != nil {
 				logrus.WithError(err).Error("unable to decode json")
 			}
 
-			if len(newPassword.Password) < 4 {
+			if len(newUser.Password) < 4 {
 				log.WithField(rbac_type, rbac_entity).Info("password failed due to length")
-				w.WriteHeader(400)
+				res.AddError(PasswordLengthError).Send(400)
 				return
 			}
 
-			sql := h.db.Create(&db.User{Username: rbac_entity, Password: newPassword.Password, Active: true})
+			sql := h.db.Create(&db.User{Username: rbac_entity, Password: newUser.Password, Active: true, Robot: newUser.Robot})
 			if sql.Error != nil {
 				logrus.WithError(sql.Error).Error("unable to query database")
-				w.WriteHeader(500)
+				res.AddError(DBError).Send(500)
 				return
 			}
 		}
@@ -114,47 +102,41 @@ func (h *handlers) Action(w http.ResponseWriter, r *http.Request) {
 		sql := h.db.Where("username = ?", rbac_entity).First(&user)
 		if sql.Error != nil {
 			if sql.Error == gorm.ErrRecordNotFound {
-				sendErrorResponse(w, 500, fmt.Errorf("unknown user: %s", rbac_entity))
+				res.AddError(fmt.Errorf("unknown user: %s", rbac_entity)).Send(404)
 				return
 			}
 
 			logrus.WithError(sql.Error).Error("unable to query database")
-			w.WriteHeader(500)
-			return
-		}
-
-		if sql.RowsAffected == 0 {
-			logrus.Warn("no user found")
-			w.WriteHeader(400)
+			res.AddError(DBError).Send(500)
 			return
 		}
 
 		switch action {
 		case "add":
-			response.New(w, r).Success().Send(201)
+			res.Success().Send(201)
 			return
 		case "remove":
 			sql := h.db.Model(&db.User{}).Where("id = ?", user.ID).Delete(&user)
 			if sql.Error != nil {
 				logrus.WithError(sql.Error).Error("unable to query database")
-				w.WriteHeader(500)
+				res.AddError(DBError).Send(500)
 				return
 			}
 
-			response.New(w, r).Success().Send(201)
+			res.Success().Send(201)
 			return
 		case "enable":
 			sql := h.db.Model(&user).Update("active", true)
 			if sql.Error != nil {
 				logrus.WithError(sql.Error).Error("unable to query database")
-				w.WriteHeader(500)
+				res.AddError(DBError).Send(500)
 				return
 			}
 		case "disable":
 			sql := h.db.Model(&user).Update("active", false)
 			if sql.Error != nil {
 				logrus.WithError(sql.Error).Error("unable to query database")
-				w.WriteHeader(500)
+				res.AddError(DBError).Send(500)
 				return
 			}
 		case "change-password":
@@ -166,33 +148,44 @@ func (h *handlers) Action(w http.ResponseWriter, r *http.Request) {
 
 			if len(newPassword.Password) < 4 {
 				log.WithField(rbac_type, rbac_entity).Info("change password failed due to length")
-				w.WriteHeader(400)
+				res.AddError(PasswordLengthError).Send(400)
 				return
 			}
 
 			sql := h.db.Model(&user).Update("password", newPassword.Password)
 			if sql.Error != nil {
 				logrus.WithError(sql.Error).Error("unable to query database")
-				w.WriteHeader(500)
+				res.AddError(DBError).Send(500)
+				return
+			}
+
+			// a changed password has to lock out anyone still holding a refresh token
+			if _, err := h.revokeUserTokens(user.ID); err != nil {
+				logrus.WithError(err).Error("unable to revoke tokens")
+				res.AddError(DBError).Send(500)
 				return
 			}
 
 			log.WithField(rbac_type, rbac_entity).Info("change password successful")
+		case "token-policy":
+			if !h.setTokenPolicy(log, res, r, &user) {
+				return
+			}
 		case "permissions":
 			var permissions []db.Permission
 			sql := h.db.Preload(clause.Associations).Where("entity_id = ?", user.ID).Find(&permissions)
 			if sql.Error != nil {
 				logrus.WithError(sql.Error).Error("unable to find permissions")
-				response.New(w, r).AddError(sql.Error).Send(500)
+				res.AddError(DBError).Send(500)
 				return
 			}
 
-			response.New(w, r).AddData(permissions).Send(200)
+			res.AddData(permissions).Send(200)
 			return
 		default:
 			err := fmt.Errorf("unsupported action: %s", action)
 			log.WithError(err).Error("unsupported action")
-			response.New(w, r).AddError(err).Send(501)
+			res.AddError(err).Send(501)
 			return
 		}
 	case "group":
@@ -200,64 +193,73 @@ func (h *handlers) Action(w http.ResponseWriter, r *http.Request) {
 			sql := h.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&db.Group{Name: rbac_entity, Active: true})
 			if sql.Error != nil {
 				logrus.WithError(sql.Error).Error("unable to query database")
-				w.WriteHeader(500)
+				res.AddError(DBError).Send(500)
 				return
 			}
-
 		}
 
 		var group db.Group
 		sql := h.db.Where("name = ?", rbac_entity).First(&group)
 		if sql.Error != nil {
 			if sql.Error == gorm.ErrRecordNotFound {
-				sendErrorResponse(w, 404, fmt.Errorf("unknown group: %s", rbac_entity))
+				res.AddError(fmt.Errorf("unknown group: %s", rbac_entity)).Send(404)
 				return
 			}
 
 			logrus.WithError(sql.Error).Error("unable to query database")
-			w.WriteHeader(500)
+			res.AddError(DBError).Send(500)
 			return
 		}
 
-		if sql.RowsAffected == 0 {
-			logrus.Warn("no group found")
-			w.WriteHeader(400)
+		if allowed, err := h.canManageGroup(r, caller, &group); err != nil {
+			logrus.WithError(err).Error("unable to query database")
+			res.AddError(DBError).Send(500)
+			return
+		} else if !allowed {
+			sendAuthError(w, r, ForbiddenError)
 			return
 		}
 
 		switch action {
 		case "add":
 		case "remove":
-			sql := h.db.Model(&group).Delete(&group)
+			// nested group memberships would otherwise outlive the group
+			sql := h.db.Exec("DELETE FROM group_groups WHERE parent_id = ? OR child_id = ?", group.ID, group.ID)
 			if sql.Error != nil {
 				logrus.WithError(sql.Error).Error("unable to query database")
-				w.WriteHeader(500)
+				res.AddError(DBError).Send(500)
 				return
 			}
 
+			sql = h.db.Model(&group).Delete(&group)
+			if sql.Error != nil {
+				logrus.WithError(sql.Error).Error("unable to query database")
+				res.AddError(DBError).Send(500)
+				return
+			}
 		case "enable":
 			sql := h.db.Model(&group).Update("active", true)
 			if sql.Error != nil {
 				logrus.WithError(sql.Error).Error("unable to query database")
-				w.WriteHeader(500)
+				res.AddError(DBError).Send(500)
 				return
 			}
 		case "disable":
 			sql := h.db.Model(&group).Update("active", false)
 			if sql.Error != nil {
 				logrus.WithError(sql.Error).Error("unable to query database")
-				w.WriteHeader(500)
+				res.AddError(DBError).Send(500)
 				return
 			}
 		case "add-member", "remove-member":
 			rbac_type2, ok := params["rbac_type_2"]
 			if !ok {
-				w.WriteHeader(400)
+				res.AddError(errors.New("missing member rbac_type parameter")).Send(400)
 				return
 			}
 			rbac_entity2, ok := params["rbac_entity_2"]
 			if !ok {
-				w.WriteHeader(400)
+				res.AddError(errors.New("missing member rbac_entity parameter")).Send(400)
 				return
 			}
 
@@ -267,42 +269,65 @@ func (h *handlers) Action(w http.ResponseWriter, r *http.Request) {
 				sql := h.db.Model(&db.User{}).Where("username = ?", rbac_entity2).First(&user)
 				if sql.Error != nil {
 					if sql.Error == gorm.ErrRecordNotFound {
-						sendErrorResponse(w, 404, fmt.Errorf("unknown user: %s", rbac_entity2))
+						res.AddError(fmt.Errorf("unknown user: %s", rbac_entity2)).Send(404)
 						return
 					}
 
 					logrus.WithError(sql.Error).Error("unable to query database")
-					w.WriteHeader(500)
+					res.AddError(DBError).Send(500)
 					return
 				}
 
-				if r.Method == "PUT" {
-					if err := h.db.Model(&group).Association("Users").Append(&user); err != nil {
-						if sql.Error == gorm.ErrRecordNotFound {
-							logrus.WithError(sql.Error).Warnf("group not found: %s", rbac_entity)
-							sendErrorResponse(w, 404, fmt.Errorf("unknown group: %s", rbac_entity))
-							return
-						}
-						logrus.WithError(sql.Error).Error("unable to query database")
-						w.WriteHeader(500)
-						return
-					}
-				} else if r.Method == "DELETE" {
-					if err := h.db.Model(&group).Association("Users").Delete(&user); err != nil {
-						if sql.Error == gorm.ErrRecordNotFound {
-							logrus.WithError(sql.Error).Warnf("group not found: %s", rbac_entity)
-							sendErrorResponse(w, 404, fmt.Errorf("unknown group: %s", rbac_entity))
-							return
-						}
-
-						logrus.WithError(sql.Error).Error("unable to query database")
-						w.WriteHeader(500)
+				association := h.db.Model(&group).Association("Users")
+
+				var err error
+				if action == "add-member" {
+					err = association.Append(&user)
+				} else {
+					err = association.Delete(&user)
+				}
+				if err != nil {
+					logrus.WithError(err).Error("unable to query database")
+					res.AddError(DBError).Send(500)
+					return
+				}
+			case "group":
+				var child db.Group
+				sql := h.db.Model(&db.Group{}).Where("name = ?", rbac_entity2).First(&child)
+				if sql.Error != nil {
+					if sql.Error == gorm.ErrRecordNotFound {
+						res.AddError(fmt.Errorf("unknown group: %s", rbac_entity2)).Send(404)
 						return
 					}
+
+					logrus.WithError(sql.Error).Error("unable to query database")
+					res.AddError(DBError).Send(500)
+					return
+				}
+
+				var err error
+				if action == "add-member" {
+					err = h.addChildGroup(&group, &child)
+				} else {
+					err = h.db.Model(&group).Association("Children").Delete(&child)
+				}
+				if err == GroupCycleError {
+					res.AddError(fmt.Errorf("%w: %s is a member of %s", err, rbac_entity, rbac_entity2)).Send(400)
+					return
+				}
+				if err != nil {
+					logrus.WithError(err).Error("unable to query database")
+					res.AddError(DBError).Send(500)
+					return
 				}
 			default:
-				logrus.Error("unsupported rbac type: %s", rbac_type2)
-				w.WriteHeader(501)
+				err := fmt.Errorf("unsupported rbac type: %s", rbac_type2)
+				logrus.WithError(err).Error("unsupported rbac type")
+				res.AddError(err).Send(501)
+				return
+			}
+		case "token-policy":
+			if !h.setTokenPolicy(log, res, r, &group) {
 				return
 			}
 		case "permissions":
@@ -310,22 +335,53 @@ func (h *handlers) Action(w http.ResponseWriter, r *http.Request) {
 			sql := h.db.Preload(clause.Associations).Where("entity_id = ?", group.ID).Find(&permissions)
 			if sql.Error != nil {
 				logrus.WithError(sql.Error).Error("unable to find permissions")
-				response.New(w, r).AddError(sql.Error).Send(500)
+				res.AddError(DBError).Send(500)
 				return
 			}
 
-			response.New(w, r).AddData(permissions).Send(200)
+			res.AddData(permissions).Send(200)
 			return
 		default:
-			log.Errorf("unknown action: %s", action)
-			sendErrorResponse(w, 501, fmt.Errorf("unsupported action"))
+			err := fmt.Errorf("unsupported action: %s", action)
+			log.WithError(err).Error("unsupported action")
+			res.AddError(err).Send(501)
 			return
 		}
 	default:
-		log.Errorf("unknown rbac type: %s", rbac_type)
-		response.New(w, r).AddError(fmt.Errorf("unsupported rbac type")).Send(501)
+		err := fmt.Errorf("unsupported rbac type: %s", rbac_type)
+		log.WithError(err).Error("unsupported rbac type")
+		res.AddError(err).Send(501)
 		return
 	}
 
-	response.New(w, r).Success().Send(200)
+	res.Success().Send(200)
+}
+
+// setTokenPolicy updates the token ttl and claims of a user or group from the request body,
+// it returns false if a response has already been sent
+func (h *handlers) setTokenPolicy(log *logrus.Entry, res *response.Response, r *http.Request, model interface{}) bool {
+	var policy TokenPolicy
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		log.WithError(err).Debug("unable to decode json")
+		res.AddError(fmt.Errorf("invalid token policy: %s", err)).Send(400)
+		return false
+	}
+
+	ttl, claims, err := policy.Parse()
+	if err != nil {
+		res.AddError(err).Send(400)
+		return false
+	}
+
+	sql := h.db.Model(model).Updates(map[string]interface{}{
+		"token_ttl":    ttl,
+		"token_claims": claims,
+	})
+	if sql.Error != nil {
+		log.WithError(sql.Error).Error("unable to query database")
+		res.AddError(DBError).Send(500)
+		return false
+	}
+
+	return true
 }
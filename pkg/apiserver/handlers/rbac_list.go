@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+
+	"github.com/ekristen/dockit/pkg/apiserver/response"
+	"github.com/ekristen/dockit/pkg/common"
+	"github.com/ekristen/dockit/pkg/db"
+)
+
+// Member is a user or group that belongs to a group
+type Member struct {
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	Active bool   `json:"active"`
+}
+
+// Principal is a user or group that holds a permission, Via is set when the
+// permission is inherited through group membership
+type Principal struct {
+	Type       string `json:"type"`
+	Name       string `json:"name"`
+	Permission string `json:"permission"`
+	Via        string `json:"via,omitempty"`
+}
+
+func (h *handlers) Users(w http.ResponseWriter, r *http.Request) {
+	reqID := r.Context().Value(common.ContextReqIDKey)
+	log := logrus.WithField("reqID", reqID)
+
+	if _, err := h.adminAuth(log, w, r); err != nil {
+		sendAuthError(w, r, err)
+		return
+	}
+
+	var users []db.User
+	sql := h.db.Preload("Groups").Order("username").Find(&users)
+	if sql.Error != nil {
+		log.WithError(sql.Error).Error("unable to query database")
+		response.New(w, r).AddError(DBError).Send(500)
+		return
+	}
+
+	response.New(w, r).AddData(users).Send(200)
+}
+
+func (h *handlers) Groups(w http.ResponseWriter, r *http.Request) {
+	reqID := r.Context().Value(common.ContextReqIDKey)
+	log := logrus.WithField("reqID", reqID)
+
+	if _, err := h.adminAuth(log, w, r); err != nil {
+		sendAuthError(w, r, err)
+		return
+	}
+
+	var groups []db.Group
+	sql := h.db.Preload("Users").Preload("Children").Order("name").Find(&groups)
+	if sql.Error != nil {
+		log.WithError(sql.Error).Error("unable to query database")
+		response.New(w, r).AddError(DBError).Send(500)
+		return
+	}
+
+	response.New(w, r).AddData(groups).Send(200)
+}
+
+func (h *handlers) Members(w http.ResponseWriter, r *http.Request) {
+	reqID := r.Context().Value(common.ContextReqIDKey)
+	log := logrus.WithField("reqID", reqID)
+
+	caller, err := h.userAuth(log, w, r)
+	if err != nil {
+		sendAuthError(w, r, err)
+		return
+	}
+
+	name := mux.Vars(r)["rbac_entity"]
+
+	var group db.Group
+	sql := h.db.Preload("Users").Preload("Children").Where("name = ?", name).First(&group)
+	if sql.Error != nil {
+		if sql.Error == gorm.ErrRecordNotFound {
+			response.New(w, r).AddError(fmt.Errorf("unknown group: %s", name)).Send(404)
+			return
+		}
+
+		log.WithError(sql.Error).Error("unable to query database")
+		response.New(w, r).AddError(DBError).Send(500)
+		return
+	}
+
+	if allowed, err := h.canManageGroup(r, caller, &group); err != nil {
+		log.WithError(err).Error("unable to query database")
+		response.New(w, r).AddError(DBError).Send(500)
+		return
+	} else if !allowed {
+		sendAuthError(w, r, ForbiddenError)
+		return
+	}
+
+	members := []Member{}
+	for _, u := range group.Users {
+		members = append(members, Member{Type: "user", Name: u.Username, Active: u.Active})
+	}
+	for _, g := range group.Children {
+		members = append(members, Member{Type: "group", Name: g.Name, Active: g.Active})
+	}
+
+	response.New(w, r).AddData(members).Send(200)
+}
+
+func (h *handlers) WhoCan(w http.ResponseWriter, r *http.Request) {
+	reqID := r.Context().Value(common.ContextReqIDKey)
+	log := logrus.WithField("reqID", reqID)
+
+	if _, err := h.adminAuth(log, w, r); err != nil {
+		sendAuthError(w, r, err)
+		return
+	}
+
+	params := mux.Vars(r)
+
+	permType := params["type"]
+	name := strings.ReplaceAll(params["name"], "_", "/")
+
+	// every action that implies the requested one
+	actions := []string{params["action"]}
+	switch db.PermissionAction(params["action"]) {
+	case db.Pull:
+		actions = append(actions, string(db.Push), string(db.Admin))
+	case db.Push:
+		actions = append(actions, string(db.Admin))
+	}
+
+	namespaces := namespacePrefixes(name)
+	if permType == string(db.Namespace) {
+		namespaces = append(namespaces, name)
+	}
+
+	query := h.db.Where("type = ?", db.Namespace).Where("name IN ?", namespaces)
+	if permType == string(db.Repository) {
+		query = query.Or(h.db.Where("type = ?", db.Repository).Where("name = ?", name))
+	}
+
+	serviceIDs := []int64{0}
+	if serviceName := r.URL.Query().Get("service"); serviceName != "" {
+		var service db.Service
+		sql := h.db.Where("name = ?", serviceName).First(&service)
+		if sql.Error != nil {
+			if sql.Error == gorm.ErrRecordNotFound {
+				response.New(w, r).AddError(fmt.Errorf("%w: %s", UnknownServiceError, serviceName)).Send(404)
+				return
+			}
+
+			log.WithError(sql.Error).Error("unable to query database")
+			response.New(w, r).AddError(DBError).Send(500)
+			return
+		}
+
+		serviceIDs = append(serviceIDs, service.ID)
+	}
+
+	// without a service only the permissions that hold for any service apply
+	var permissions []db.Permission
+	sql := h.db.Preload("User").Preload("Group").Preload("Service").
+		Where("action IN ?", actions).
+		Where("expires_at IS NULL OR expires_at > ?", time.Now().UTC()).
+		Where("service_id IN ?", serviceIDs).
+		Where(query).
+		Find(&permissions)
+	if sql.Error != nil {
+		log.WithError(sql.Error).Error("unable to query database")
+		response.New(w, r).AddError(DBError).Send(500)
+		return
+	}
+
+	owners, err := h.namespaceOwners(namespaces)
+	if err != nil {
+		log.WithError(err).Error("unable to query database")
+		response.New(w, r).AddError(DBError).Send(500)
+		return
+	}
+	permissions = append(permissions, owners...)
+
+	principals := []Principal{}
+	for _, p := range permissions {
+		if p.User != nil {
+			principals = append(principals, Principal{Type: "user", Name: p.User.Username, Permission: p.String()})
+		}
+
+		if p.Group != nil {
+			principals = append(principals, Principal{Type: "group", Name: p.Group.Name, Permission: p.String()})
+
+			members, err := h.groupPrincipals(p.Group, p.String())
+			if err != nil {
+				log.WithError(err).Error("unable to query database")
+				response.New(w, r).AddError(DBError).Send(500)
+				return
+			}
+
+			principals = append(principals, members...)
+		}
+	}
+
+	response.New(w, r).AddData(principals).Send(200)
+}
+
+// namespacePrefixes returns every parent namespace of a repository name,
+// for example a/b/c returns a and a/b
+func namespacePrefixes(name string) []string {
+	parts := strings.Split(name, "/")
+
+	prefixes := []string{}
+	for i := 1; i < len(parts); i++ {
+		prefixes = append(prefixes, strings.Join(parts[0:i], "/"))
+	}
+
+	return prefixes
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ekristen/dockit/pkg/apiserver/response"
+	"github.com/ekristen/dockit/pkg/db"
+)
+
+// testAdmin creates the admin user adminRequest authenticates as
+func testAdmin(t *testing.T, h *handlers) *db.User {
+	admin := &db.User{Username: "admin", Password: "adminpw", Active: true, Admin: true}
+	assert.NoError(t, h.db.Create(admin).Error)
+
+	return admin
+}
+
+// testRequest calls the handler as the user and decodes the data of the response into v unless it is nil
+func testRequest(t *testing.T, handler http.HandlerFunc, username, password, method, target string, vars map[string]string, body interface{}, v interface{}) int {
+	var data []byte
+	if body != nil {
+		var err error
+		data, err = json.Marshal(body)
+		assert.NoError(t, err)
+	}
+
+	req := mux.SetURLVars(httptest.NewRequest(method, target, bytes.NewReader(data)), vars)
+	if username != "" {
+		req.SetBasicAuth(username, password)
+	}
+
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+
+	if v != nil {
+		res, err := response.ReadAllDecode(rec.Body)
+		assert.NoError(t, err)
+
+		raw, err := json.Marshal(res.Data)
+		assert.NoError(t, err)
+		assert.NoError(t, json.Unmarshal(raw, v))
+	}
+
+	return rec.Code
+}
+
+// adminRequest calls the handler as the admin created by testAdmin
+func adminRequest(t *testing.T, handler http.HandlerFunc, method, target string, vars map[string]string, body interface{}, v interface{}) int {
+	return testRequest(t, handler, "admin", "adminpw", method, target, vars, body, v)
+}
+
+func Test_WhoCanService(t *testing.T) {
+	h := newTestHandlers(t)
+	testAdmin(t, h)
+
+	service := &db.Service{Name: "registry-prod"}
+	assert.NoError(t, h.db.Create(service).Error)
+
+	alice := &db.User{Username: "alice", Password: "alicepw", Active: true}
+	assert.NoError(t, h.db.Create(alice).Error)
+	bob := &db.User{Username: "bob", Password: "bobpw", Active: true}
+	assert.NoError(t, h.db.Create(bob).Error)
+
+	assert.NoError(t, h.db.Create(&db.Permission{Type: db.Namespace, Name: "apps", Action: db.Pull, EntityID: alice.ID}).Error)
+	assert.NoError(t, h.db.Create(&db.Permission{Type: db.Namespace, Name: "apps", Action: db.Pull, EntityID: bob.ID, ServiceID: service.ID}).Error)
+
+	vars := map[string]string{"type": "repository", "name": "apps_web", "action": "pull"}
+
+	// permissions bound to a service only apply to it
+	var principals []Principal
+	assert.Equal(t, 200, adminRequest(t, h.WhoCan, "GET", "/v2/admin/who-can/repository:apps_web:pull", vars, nil, &principals))
+	assert.Equal(t, []Principal{{Type: "user", Name: "alice", Permission: "namespace:apps:pull"}}, principals)
+
+	principals = nil
+	assert.Equal(t, 200, adminRequest(t, h.WhoCan, "GET", "/v2/admin/who-can/repository:apps_web:pull?service=registry-prod", vars, nil, &principals))
+	assert.ElementsMatch(t, []Principal{
+		{Type: "user", Name: "alice", Permission: "namespace:apps:pull"},
+		{Type: "user", Name: "bob", Permission: "namespace:apps:pull@registry-prod"},
+	}, principals)
+
+	assert.Equal(t, 404, adminRequest(t, h.WhoCan, "GET", "/v2/admin/who-can/repository:apps_web:pull?service=unknown", vars, nil, nil))
+}
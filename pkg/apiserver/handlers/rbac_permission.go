@@ -1,22 +1,30 @@
 package handlers
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/ekristen/dockit/pkg/apiserver/response"
 	"github.com/ekristen/dockit/pkg/common"
 	"github.com/ekristen/dockit/pkg/db"
-	"github.com/ekristen/dockit/pkg/httpauth"
+	"github.com/ekristen/dockit/pkg/utils"
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
 
+// PermissionGrant is the optional request body of a grant, a grant without expires_at is permanent
+// and a grant without cidrs applies to requests from anywhere
+type PermissionGrant struct {
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	CIDRs     []string   `json:"cidrs,omitempty"`
+}
+
 func (h *handlers) Permission(w http.ResponseWriter, r *http.Request) {
 	reqID := r.Context().Value(common.ContextReqIDKey)
 	log := logrus.WithField("reqID", reqID)
@@ -25,45 +33,29 @@ func (h *handlers) Permission(w http.ResponseWriter, r *http.Request) {
 
 	res := response.New(w, r)
 
-	auth, err := httpauth.Parse(r)
+	log.WithField("query", r.URL.Query()).Debug("url query")
+
+	caller, err := h.userAuth(log, w, r)
 	if err != nil {
-		log.WithError(err).Error("unable to parse auth header")
-		res.AddError(err).Send(500)
+		sendAuthError(w, r, err)
 		return
 	}
 
-	log.WithField("query", r.URL.Query()).Debug("url query")
-
-	log.Debug("basic authentication")
-	var user db.User
+	// we've authenticated successfully ...
 
-	sql := h.db.Where("username = ? AND admin = ?", auth.Username(), true).First(&user)
-	if sql.Error != nil {
-		if sql.Error == gorm.ErrRecordNotFound {
-			err := fmt.Errorf("unauthorized")
-			res.AddError(err).Send(401)
-			return
-		}
+	name := strings.ReplaceAll(params["name"], "_", "/")
 
-		log.WithError(sql.Error).Error("unable to query database")
+	allowed, err := h.canManagePermission(r, caller, db.PermissionType(params["type"]), name)
+	if err != nil {
+		log.WithError(err).Error("unable to query database")
 		res.AddError(DBError).Send(500)
 		return
 	}
-
-	if sql.RowsAffected == 0 {
-		log.Debug("unknown user")
-		res.AddError(UnauthorizedError).Send(401)
+	if !allowed {
+		sendAuthError(w, r, ForbiddenError)
 		return
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(auth.Password())); err != nil {
-		log.WithError(err).Debug("invalid password")
-		res.AddError(UnauthorizedError).Send(401)
-		return
-	}
-
-	// we've authenticated successfully ...
-
 	var entityID int64
 
 	rbac_type, ok := params["rbac_type"]
@@ -84,51 +76,103 @@ func (h *handlers) Permission(w http.ResponseWriter, r *http.Request) {
 		var user db.User
 		sql := h.db.Where("username = ?", rbac_entity).First(&user)
 		if sql.Error != nil {
+			if sql.Error == gorm.ErrRecordNotFound {
+				res.AddError(fmt.Errorf("unknown user: %s", rbac_entity)).Send(404)
+				return
+			}
+
 			logrus.WithError(sql.Error).Error("unable to query database")
 			res.AddError(DBError).Send(500)
 			return
 		}
 
-		if sql.RowsAffected == 0 {
-			logrus.Warn("no user found")
-			w.WriteHeader(400)
-			return
-		}
 		entityID = user.ID
 	case "group":
 		var group db.Group
 		sql := h.db.Where("name = ?", rbac_entity).First(&group)
 		if sql.Error != nil {
-			logrus.WithError(sql.Error).Error("unable to query database")
-			w.WriteHeader(500)
-			return
-		}
+			if sql.Error == gorm.ErrRecordNotFound {
+				res.AddError(fmt.Errorf("unknown group: %s", rbac_entity)).Send(404)
+				return
+			}
 
-		if sql.RowsAffected == 0 {
-			logrus.Warn("no group found")
-			w.WriteHeader(400)
+			logrus.WithError(sql.Error).Error("unable to query database")
+			res.AddError(DBError).Send(500)
 			return
 		}
 
 		entityID = group.ID
 	default:
-		logrus.Errorf("unknown rbac type: %s", rbac_type)
-		w.WriteHeader(500)
+		err := fmt.Errorf("unsupported rbac type: %s", rbac_type)
+		logrus.WithError(err).Error("unsupported rbac type")
+		res.AddError(err).Send(501)
 		return
 	}
 
-	name := strings.ReplaceAll(params["name"], "_", "/")
+	// permissions without a service apply to tokens for every service
+	var serviceID int64
+	if serviceName := r.URL.Query().Get("service"); serviceName != "" {
+		var service db.Service
+		sql := h.db.Where("name = ?", serviceName).First(&service)
+		if sql.Error != nil {
+			if sql.Error == gorm.ErrRecordNotFound {
+				res.AddError(fmt.Errorf("%w: %s", UnknownServiceError, serviceName)).Send(404)
+				return
+			}
+
+			logrus.WithError(sql.Error).Error("unable to query database")
+			res.AddError(DBError).Send(500)
+			return
+		}
+
+		serviceID = service.ID
+	}
 
 	switch r.Method {
 	case "PUT":
-		sql = h.db.Clauses(clause.OnConflict{
-			Columns:   []clause.Column{{Name: "type"}, {Name: "name"}, {Name: "class"}},
-			DoUpdates: clause.AssignmentColumns([]string{"action"}),
+		var grant PermissionGrant
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&grant); err != nil {
+				res.AddError(fmt.Errorf("invalid grant: %s", err)).Send(400)
+				return
+			}
+		}
+
+		if grant.ExpiresAt != nil && !grant.ExpiresAt.After(time.Now()) {
+			res.AddError(errors.New("invalid grant: expires_at must be in the future")).Send(400)
+			return
+		}
+
+		// expiry is compared as stored, so grants in other zones would be pruned and ignored at the wrong time
+		if grant.ExpiresAt != nil {
+			expiresAt := grant.ExpiresAt.UTC()
+			grant.ExpiresAt = &expiresAt
+		}
+
+		nets, err := utils.ParseCIDRs(grant.CIDRs)
+		if err != nil {
+			res.AddError(fmt.Errorf("invalid grant: %s", err)).Send(400)
+			return
+		}
+
+		cidrs := make([]string, 0, len(nets))
+		for _, n := range nets {
+			cidrs = append(cidrs, n.String())
+		}
+
+		sql := h.db.Clauses(clause.OnConflict{
+			Columns: []clause.Column{
+				{Name: "type"}, {Name: "class"}, {Name: "name"}, {Name: "action"}, {Name: "entity_id"}, {Name: "service_id"},
+			},
+			DoUpdates: clause.AssignmentColumns([]string{"expires_at", "cidrs", "updated_at"}),
 		}).Create(&db.Permission{
-			Type:     db.PermissionType(params["type"]),
-			Name:     name,
-			Action:   db.PermissionAction(params["action"]),
-			EntityID: entityID,
+			Type:      db.PermissionType(params["type"]),
+			Name:      name,
+			Action:    db.PermissionAction(params["action"]),
+			EntityID:  entityID,
+			ServiceID: serviceID,
+			ExpiresAt: grant.ExpiresAt,
+			CIDRs:     strings.Join(cidrs, ","),
 		})
 		if sql.Error != nil {
 			logrus.WithError(sql.Error).Error("unable to query database")
@@ -136,11 +180,12 @@ func (h *handlers) Permission(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	case "DELETE":
-		sql = h.db.Model(&db.Permission{}).
+		sql := h.db.Model(&db.Permission{}).
 			Where("type = ?", params["type"]).
 			Where("name = ?", name).
 			Where("action = ?", params["action"]).
 			Where("entity_id = ?", entityID).
+			Where("service_id = ?", serviceID).
 			Delete(&db.Permission{})
 		if sql.Error != nil {
 			logrus.WithError(sql.Error).Error("unable to query database")
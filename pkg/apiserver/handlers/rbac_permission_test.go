@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ekristen/dockit/pkg/db"
+	"github.com/ekristen/dockit/pkg/janitor"
+)
+
+func Test_PermissionExpiresAtUTC(t *testing.T) {
+	h := newTestHandlers(t)
+	testAdmin(t, h)
+
+	alice := &db.User{Username: "alice", Password: "alicepw", Active: true}
+	assert.NoError(t, h.db.Create(alice).Error)
+
+	// an expiry ahead of utc sorts after later utc times when it is stored with its offset
+	expiresAt := time.Now().Add(time.Minute).In(time.FixedZone("AEST", 10*60*60))
+
+	vars := map[string]string{"type": "namespace", "name": "apps", "action": "pull", "rbac_type": "user", "rbac_entity": "alice"}
+	assert.Equal(t, 200, adminRequest(t, h.Permission, "PUT", "/v2/rbac/user/alice/permission/namespace/apps/pull", vars, PermissionGrant{ExpiresAt: &expiresAt}, nil))
+
+	var permission db.Permission
+	assert.NoError(t, h.db.Where("entity_id = ?", alice.ID).First(&permission).Error)
+	_, offset := permission.ExpiresAt.Zone()
+	assert.Equal(t, 0, offset)
+	assert.True(t, expiresAt.Equal(*permission.ExpiresAt))
+
+	pruned, err := janitor.Prune(h.db, time.Now().UTC().Add(time.Hour), time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), pruned.Permissions)
+}
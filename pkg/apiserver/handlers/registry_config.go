@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+
+	"github.com/ekristen/dockit/pkg/apiserver/response"
+	"github.com/ekristen/dockit/pkg/common"
+	"github.com/ekristen/dockit/pkg/db"
+)
+
+// DefaultRootCertBundle is where the init container writes the cert bundle in the examples
+const DefaultRootCertBundle = "/dockit/certs.pem"
+
+// DefaultService is the service of a registry when no service is registered
+const DefaultService = "registry"
+
+// ServiceRequiredError is returned when more than one service is registered and none was chosen
+var ServiceRequiredError = errors.New("a service is required")
+
+// RegistryConfig is the auth.token configuration of a distribution registry that trusts dockit
+type RegistryConfig struct {
+	Realm          string `json:"realm" yaml:"realm"`
+	Service        string `json:"service" yaml:"service"`
+	Issuer         string `json:"issuer" yaml:"issuer"`
+	RootCertBundle string `json:"rootcertbundle" yaml:"rootcertbundle"`
+	JWKS           string `json:"jwks,omitempty" yaml:"jwks,omitempty"`
+}
+
+// Env returns the configuration as the environment variables of the registry
+func (c *RegistryConfig) Env() string {
+	env := [][2]string{
+		{"REGISTRY_AUTH_TOKEN_REALM", c.Realm},
+		{"REGISTRY_AUTH_TOKEN_SERVICE", c.Service},
+		{"REGISTRY_AUTH_TOKEN_ISSUER", c.Issuer},
+		{"REGISTRY_AUTH_TOKEN_ROOTCERTBUNDLE", c.RootCertBundle},
+	}
+	if c.JWKS != "" {
+		env = append(env, [2]string{"REGISTRY_AUTH_TOKEN_JWKS", c.JWKS})
+	}
+
+	var b strings.Builder
+	for _, e := range env {
+		fmt.Fprintf(&b, "%s=%s\n", e[0], e[1])
+	}
+
+	return b.String()
+}
+
+// YAML returns the configuration as the auth section of the config.yml of the registry
+func (c *RegistryConfig) YAML() (string, error) {
+	var b strings.Builder
+
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+
+	if err := enc.Encode(map[string]interface{}{"auth": map[string]interface{}{"token": c}}); err != nil {
+		return "", err
+	}
+	if err := enc.Close(); err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
+
+// registryService returns the service for the registry config, without one the only registered service or
+// the default service when none is registered
+func (h *handlers) registryService(name string) (string, error) {
+	if name != "" {
+		if _, err := h.lookupService(name); err != nil {
+			return "", err
+		}
+
+		return name, nil
+	}
+
+	var services []db.Service
+	if sql := h.db.Find(&services); sql.Error != nil {
+		return "", sql.Error
+	}
+
+	switch len(services) {
+	case 0:
+		return DefaultService, nil
+	case 1:
+		return services[0].Name, nil
+	}
+
+	names := []string{}
+	for _, s := range services {
+		names = append(names, s.Name)
+	}
+	sort.Strings(names)
+
+	return "", fmt.Errorf("%w, one of: %s", ServiceRequiredError, strings.Join(names, ", "))
+}
+
+// requestBaseURL returns the url the api server was reached at
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+
+	return fmt.Sprintf("%s://%s/v2", scheme, r.Host)
+}
+
+// RegistryConfig returns the auth.token configuration for a registry, as json or with a format of env or
+// yaml as text. The realm defaults to the url the api server was reached at. Only admins can request it,
+// the registered services are not disclosed to anyone else.
+func (h *handlers) RegistryConfig(w http.ResponseWriter, r *http.Request) {
+	reqID := r.Context().Value(common.ContextReqIDKey)
+	log := logrus.WithField("reqID", reqID)
+
+	res := response.New(w, r)
+	query := r.URL.Query()
+
+	if _, err := h.adminAuth(log, w, r); err != nil {
+		sendAuthError(w, r, err)
+		return
+	}
+
+	service, err := h.registryService(query.Get("service"))
+	if err != nil {
+		if errors.Is(err, UnknownServiceError) {
+			res.AddError(fmt.Errorf("%w: %s", err, query.Get("service"))).Send(400)
+			return
+		}
+		if errors.Is(err, ServiceRequiredError) {
+			res.AddError(err).Send(400)
+			return
+		}
+
+		log.WithError(err).Error("unable to query database")
+		res.AddError(DBError).Send(500)
+		return
+	}
+
+	config := &RegistryConfig{
+		Realm:          query.Get("realm"),
+		Service:        service,
+		Issuer:         h.config.TokenIssuer,
+		RootCertBundle: query.Get("rootcertbundle"),
+		JWKS:           query.Get("jwks"),
+	}
+	if config.Realm == "" {
+		config.Realm = fmt.Sprintf("%s/token", requestBaseURL(r))
+	}
+	if config.RootCertBundle == "" {
+		config.RootCertBundle = DefaultRootCertBundle
+	}
+
+	switch query.Get("format") {
+	case "", "json":
+		res.AddData(config).Send(200)
+	case "env":
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(200)
+		w.Write([]byte(config.Env()))
+	case "yaml":
+		data, err := config.YAML()
+		if err != nil {
+			log.WithError(err).Error("unable to encode yaml")
+			res.AddError(errors.New("unable to encode yaml")).Send(500)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/yaml")
+		w.WriteHeader(200)
+		w.Write([]byte(data))
+	default:
+		res.AddError(fmt.Errorf("invalid format: %s", query.Get("format"))).Send(400)
+	}
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"crypto/x509/pkix"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ekristen/dockit/pkg/db"
+	"github.com/ekristen/dockit/pkg/utils"
+)
+
+func Test_RegistryConfig(t *testing.T) {
+	h := newTestHandlers(t)
+	testAdmin(t, h)
+	assert.NoError(t, h.db.Create(&db.User{Username: "alice", Password: "alicepw", Active: true}).Error)
+
+	// the service names are only listed to admins
+	req := httptest.NewRequest("GET", "/v2/registry-config", nil)
+	rec := httptest.NewRecorder()
+	h.RegistryConfig(rec, req)
+	assert.Equal(t, 401, rec.Code)
+
+	req = httptest.NewRequest("GET", "/v2/registry-config", nil)
+	req.SetBasicAuth("alice", "alicepw")
+	rec = httptest.NewRecorder()
+	h.RegistryConfig(rec, req)
+	assert.Equal(t, 403, rec.Code)
+
+	req = httptest.NewRequest("GET", "http://dockit.example.com/v2/registry-config?format=env&jwks=/dockit/jwks.json", nil)
+	req.SetBasicAuth("admin", "adminpw")
+	rec = httptest.NewRecorder()
+	h.RegistryConfig(rec, req)
+	assert.Equal(t, 200, rec.Code)
+	assert.Equal(t, "REGISTRY_AUTH_TOKEN_REALM=http://dockit.example.com/v2/token\n"+
+		"REGISTRY_AUTH_TOKEN_SERVICE=registry\n"+
+		"REGISTRY_AUTH_TOKEN_ISSUER=dockit\n"+
+		"REGISTRY_AUTH_TOKEN_ROOTCERTBUNDLE=/dockit/certs.pem\n"+
+		"REGISTRY_AUTH_TOKEN_JWKS=/dockit/jwks.json\n", rec.Body.String())
+
+	assert.NoError(t, h.db.Create(&db.Service{Name: "registry-prod"}).Error)
+
+	req = httptest.NewRequest("GET", "/v2/registry-config?format=yaml&realm=https://auth.example.com/v2/token", nil)
+	req.SetBasicAuth("admin", "adminpw")
+	rec = httptest.NewRecorder()
+	h.RegistryConfig(rec, req)
+	assert.Equal(t, 200, rec.Code)
+	assert.Contains(t, rec.Body.String(), "service: registry-prod")
+	assert.Contains(t, rec.Body.String(), "realm: https://auth.example.com/v2/token")
+
+	assert.NoError(t, h.db.Create(&db.Service{Name: "registry-dev"}).Error)
+
+	req = httptest.NewRequest("GET", "/v2/registry-config", nil)
+	req.SetBasicAuth("admin", "adminpw")
+	rec = httptest.NewRecorder()
+	h.RegistryConfig(rec, req)
+	assert.Equal(t, 400, rec.Code)
+
+	req = httptest.NewRequest("GET", "/v2/registry-config?service=unknown", nil)
+	req.SetBasicAuth("admin", "adminpw")
+	rec = httptest.NewRecorder()
+	h.RegistryConfig(rec, req)
+	assert.Equal(t, 400, rec.Code)
+}
+
+func Test_PKICertsJWKS(t *testing.T) {
+	h := newTestHandlers(t)
+
+	req := mux.SetURLVars(httptest.NewRequest("GET", "/v2/certs/jwks", nil), map[string]string{"format": "jwks"})
+	rec := httptest.NewRecorder()
+	h.PKICerts(rec, req)
+	assert.Equal(t, 200, rec.Code)
+
+	var jwks utils.JWKS
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&jwks))
+	assert.Len(t, jwks.Keys, 1)
+	assert.Equal(t, "EC", jwks.Keys[0].KeyType)
+	assert.Equal(t, "P-256", jwks.Keys[0].Curve)
+	assert.Len(t, jwks.Keys[0].X, 43)
+
+	kid, err := jwks.Keys[0].Thumbprint()
+	assert.NoError(t, err)
+	assert.Equal(t, kid, jwks.Keys[0].KeyID)
+
+	// a replaced key is published as long as tokens signed with it are valid
+	key, keyPEM, err := utils.GenerateECKey(256)
+	assert.NoError(t, err)
+	cert, certPEM, err := utils.GenerateCertificate(2, pkix.Name{CommonName: "dockit"}, &key.PublicKey, key, 1, 0, 0)
+	assert.NoError(t, err)
+	assert.NoError(t, h.db.Create(&db.PKI{ID: 2, Type: "ECDSA", Bits: 256, Private: string(keyPEM), X509: string(certPEM), NotBefore: &cert.NotBefore, ExpiresAt: &cert.NotAfter, Active: true}).Error)
+
+	for _, c := range []struct {
+		deactivated time.Time
+		keys        int
+	}{
+		{time.Now().UTC(), 2},
+		{time.Now().UTC().Add(-h.config.TokenTTL - time.Minute), 1},
+	} {
+		assert.NoError(t, h.db.Model(&db.PKI{}).Where("id = ?", 1).Update("deactivated_at", c.deactivated).Error)
+
+		rec = httptest.NewRecorder()
+		h.PKICerts(rec, req)
+		assert.Equal(t, 200, rec.Code)
+
+		jwks = utils.JWKS{}
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(&jwks))
+		assert.Len(t, jwks.Keys, c.keys)
+	}
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/ekristen/dockit/pkg/apiserver/response"
+	"github.com/ekristen/dockit/pkg/common"
+	"github.com/ekristen/dockit/pkg/db"
+)
+
+// NewService is the request body to register a service, the events token is only replaced when one is given
+type NewService struct {
+	Description string `json:"description"`
+	EventsToken string `json:"events_token,omitempty"`
+}
+
+func (h *handlers) Services(w http.ResponseWriter, r *http.Request) {
+	reqID := r.Context().Value(common.ContextReqIDKey)
+	log := logrus.WithField("reqID", reqID)
+
+	if _, err := h.adminAuth(log, w, r); err != nil {
+		sendAuthError(w, r, err)
+		return
+	}
+
+	var services []db.Service
+	sql := h.db.Order("name").Find(&services)
+	if sql.Error != nil {
+		log.WithError(sql.Error).Error("unable to query database")
+		response.New(w, r).AddError(DBError).Send(500)
+		return
+	}
+
+	response.New(w, r).AddData(services).Send(200)
+}
+
+func (h *handlers) Service(w http.ResponseWriter, r *http.Request) {
+	reqID := r.Context().Value(common.ContextReqIDKey)
+	log := logrus.WithField("reqID", reqID)
+
+	res := response.New(w, r)
+
+	if _, err := h.adminAuth(log, w, r); err != nil {
+		sendAuthError(w, r, err)
+		return
+	}
+
+	name := mux.Vars(r)["name"]
+
+	switch r.Method {
+	case "PUT":
+		var newService NewService
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&newService); err != nil {
+				res.AddError(fmt.Errorf("invalid service: %s", err)).Send(400)
+				return
+			}
+		}
+
+		service := &db.Service{Name: name, Description: newService.Description}
+		updates := []string{"description", "updated_at"}
+
+		if newService.EventsToken != "" {
+			if len(newService.EventsToken) < 16 {
+				res.AddError(errors.New("invalid service: events token must be at least 16 characters")).Send(400)
+				return
+			}
+
+			service.EventsToken = hashToken(newService.EventsToken)
+			updates = append(updates, "events_token")
+		}
+
+		sql := h.db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "name"}},
+			DoUpdates: clause.AssignmentColumns(updates),
+		}).Create(service)
+		if sql.Error != nil {
+			log.WithError(sql.Error).Error("unable to query database")
+			res.AddError(DBError).Send(500)
+			return
+		}
+
+		log.WithField("service", name).Info("service registered")
+	case "DELETE":
+		var service db.Service
+		sql := h.db.Where("name = ?", name).First(&service)
+		if sql.Error != nil {
+			if sql.Error == gorm.ErrRecordNotFound {
+				res.AddError(fmt.Errorf("%w: %s", UnknownServiceError, name)).Send(404)
+				return
+			}
+
+			log.WithError(sql.Error).Error("unable to query database")
+			res.AddError(DBError).Send(500)
+			return
+		}
+
+		// permissions bound to the service are removed with it
+		err := h.db.Transaction(func(tx *gorm.DB) error {
+			if sql := tx.Where("service_id = ?", service.ID).Delete(&db.Permission{}); sql.Error != nil {
+				return sql.Error
+			}
+
+			return tx.Delete(&service).Error
+		})
+		if err != nil {
+			log.WithError(err).Error("unable to query database")
+			res.AddError(DBError).Send(500)
+			return
+		}
+
+		log.WithField("service", name).Info("service removed")
+	}
+
+	res.Success().Send(200)
+}
@@ -1,7 +1,7 @@
 package handlers
 
 import (
-	"crypto"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -13,28 +13,102 @@ import (
 	"github.com/ekristen/dockit/pkg/db"
 	"github.com/ekristen/dockit/pkg/docker"
 	"github.com/ekristen/dockit/pkg/httpauth"
+	"github.com/ekristen/dockit/pkg/issuer"
+	"github.com/ekristen/dockit/pkg/signer"
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
-	"golang.org/x/crypto/bcrypt"
-	"gorm.io/gorm"
-	"gorm.io/gorm/clause"
 )
 
 type TokenResponse struct {
-	Token       string    `json:"token"`
-	AccessToken string    `json:"access_token"`
-	ExpiresIn   int       `json:"expires_in"`
-	IssuedAt    time.Time `json:"issued_at"`
+	Token        string    `json:"token"`
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	ExpiresIn    int       `json:"expires_in"`
+	IssuedAt     time.Time `json:"issued_at"`
 }
 
 type TokenClaims struct {
 	Access []docker.Scope `json:"access,omitempty"`
 	jwt.StandardClaims
+
+	// Extra are additional claims configured by token policies
+	Extra map[string]interface{} `json:"-"`
+}
+
+// MarshalJSON flattens the extra claims into the token claims
+func (c TokenClaims) MarshalJSON() ([]byte, error) {
+	type claims TokenClaims
+
+	b, err := json.Marshal(claims(c))
+	if err != nil || len(c.Extra) == 0 {
+		return b, err
+	}
+
+	merged := map[string]interface{}{}
+	for k, v := range c.Extra {
+		merged[k] = v
+	}
+	if err := json.Unmarshal(b, &merged); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(merged)
 }
 
+// tokenRequest holds the parameters common to the basic auth and oauth2 token flows
+type tokenRequest struct {
+	Service string
+	Scope   string
+	// Offline requests a refresh token along with the access token
+	Offline bool
+	// Refresh is the refresh token the request was authenticated with
+	Refresh *db.Token
+	// RefreshToken is the raw refresh token, it is returned as is to the client
+	RefreshToken string
+}
+
+// BearerToken implements the oauth2 token flow used by docker clients to exchange a password
+// or a refresh token for an access token
 func (h *handlers) BearerToken(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(501)
+	reqID := r.Context().Value(common.ContextReqIDKey)
+	log := logrus.WithField("reqID", reqID)
+
+	if err := r.ParseForm(); err != nil {
+		response.New(w, r).AddError(fmt.Errorf("invalid request: %s", err)).Send(400)
+		return
+	}
+
+	req := tokenRequest{
+		Service: r.PostForm.Get("service"),
+		Scope:   r.PostForm.Get("scope"),
+		Offline: r.PostForm.Get("access_type") == "offline",
+	}
+
+	var user *db.User
+	var err error
+
+	switch grantType := r.PostForm.Get("grant_type"); grantType {
+	case "password":
+		user, err = h.passwordAuth(log, w, r, r.PostForm.Get("username"), r.PostForm.Get("password"))
+		if err != nil {
+			sendAuthError(w, r, err)
+			return
+		}
+	case "refresh_token":
+		req.RefreshToken = r.PostForm.Get("refresh_token")
+
+		user, req.Refresh, err = h.refreshAuth(log, req.RefreshToken, req.Service)
+		if err != nil {
+			sendAuthError(w, r, err)
+			return
+		}
+	default:
+		response.New(w, r).AddError(fmt.Errorf("unsupported grant_type: %s", grantType)).Send(400)
+		return
+	}
+
+	h.issueToken(log, w, r, user, req)
 }
 
 func (h *handlers) Token(w http.ResponseWriter, r *http.Request) {
@@ -48,124 +122,96 @@ func (h *handlers) Token(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var audience string = ""
-	var subject string = ""
-	var scopes []docker.Scope
-
 	log.WithField("query", r.URL.Query()).Debug("url query")
 
 	log.Debug("basic authentication")
-	var user db.User
 
-	sql := h.db.Where("username = ?", auth.Username()).First(&user)
-	if sql.Error != nil {
-		if sql.Error == gorm.ErrRecordNotFound {
-			response.New(w, r).Failed().Send(401)
-			return
-		}
-
-		log.WithError(sql.Error).Error("unable to query database")
-		w.WriteHeader(500)
+	user, err := h.passwordAuth(log, w, r, auth.Username(), auth.Password())
+	if err != nil {
+		sendAuthError(w, r, err)
 		return
 	}
 
-	if sql.RowsAffected == 0 {
-		log.Debug("unknown user")
-		w.WriteHeader(401)
-		w.Write([]byte("unknown user"))
+	if account := r.URL.Query().Get("account"); account != "" && account != user.Username {
+		log.WithField("account", account).Debug("account does not match authenticated user")
+		response.New(w, r).AddError(UnauthorizedError).Send(401)
 		return
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(auth.Password())); err != nil {
-		log.WithError(err).Debug("invalid password")
-		w.WriteHeader(401)
-		w.Write([]byte("invalid password"))
+	h.issueToken(log, w, r, user, tokenRequest{
+		Service: r.URL.Query().Get("service"),
+		Scope:   strings.Join(r.URL.Query()["scope"], " "),
+		Offline: r.URL.Query().Get("offline_token") == "true",
+	})
+}
+
+// issueToken signs an access token for the user and records it, a refresh token is
+// created as well when one is requested
+func (h *handlers) issueToken(log *logrus.Entry, w http.ResponseWriter, r *http.Request, user *db.User, req tokenRequest) {
+	var audience string = ""
+	var subject string = ""
+	var scopes []docker.Scope
+	var err error
+
+	audience = req.Service
+	subject = user.Username
+
+	// groups inherited through nested groups are resolved once for the token policy and permissions
+	groups, err := h.resolveGroups(user)
+	if err != nil {
+		log.WithError(err).Error("unable to resolve groups")
+		response.New(w, r).AddError(DBError).Send(500)
 		return
 	}
+	user.Groups = groups
 
-	var pki db.PKI
-	sql = h.db.Model(&db.PKI{}).Where("active = ? AND expires_at > ?", true, time.Now().UTC()).Order("created_at DESC").First(&pki)
-	if sql.Error != nil {
-		log.WithError(sql.Error).Error("unable to query database")
-		w.WriteHeader(500)
+	ttl, extraClaims, err := h.tokenPolicy(user)
+	if err != nil {
+		log.WithError(err).Error("unable to resolve token policy")
+		response.New(w, r).AddError(err).Send(500)
 		return
 	}
 
-	audience = r.URL.Query().Get("service")
-	subject = r.URL.Query().Get("account")
-
-	var newScopes = []docker.Scope{}
-
-	scope := r.URL.Query().Get("scope")
-	if scope != "" {
-		scopes, _ = docker.ParseScope(scope)
+	if req.Scope != "" {
+		scopes, _ = docker.ParseScope(req.Scope)
+	}
 
-		var user db.User
-		var permissions []db.Permission
+	// tokens for the admin api are only valid for dockit itself and never carry registry access
+	adminToken := hasAdminScope(scopes)
+	if adminToken {
+		audience = h.config.TokenIssuer
+	}
 
-		// Grab the user that's auth
-		sql := h.db.Model(&db.User{}).Preload(clause.Associations).Where("username = ?", subject).First(&user)
-		if sql.Error != nil {
-			log.WithError(sql.Error).Error("unable to query database")
-			w.WriteHeader(500)
+	var service *db.Service
+	if !adminToken {
+		service, err = h.lookupService(audience)
+	}
+	if err != nil {
+		if err == UnknownServiceError {
+			log.WithField("service", audience).Debug("unknown service")
+			response.New(w, r).AddError(fmt.Errorf("%w: %s", err, audience)).Send(400)
 			return
 		}
 
-		// Bring all user ids and group ids into a single slice
-		var entityIds []int64 = make([]int64, 0)
-		entityIds = append(entityIds, user.ID)
-		for _, e := range user.Groups {
-			entityIds = append(entityIds, e.ID)
-		}
-
-		// Query for all permissions that match the entity ids
-		sql = h.db.Model(&db.Permission{}).Where(h.db.Where("entity_id IN ?", entityIds))
-		for _, scope := range scopes {
-			if len(scope.Actions) == 1 && scope.Actions[0] == "pull" {
-				scope.Actions = append(scope.Actions, "push")
-			}
+		log.WithError(err).Error("unable to query database")
+		response.New(w, r).AddError(DBError).Send(500)
+		return
+	}
 
-			namespaces := strings.Split(scope.Name, "/")
-			namespaces = namespaces[0 : len(namespaces)-1]
+	var newScopes = []docker.Scope{}
 
-			sql = sql.Where(h.db.Where("type = ?", "namespace").Where("name IN ?", namespaces).Where("action IN ?", scope.Actions).Or(
-				h.db.Where("type = ?", scope.Type).Where("name = ?", scope.Name).Where("action IN ?", scope.Actions),
-			))
-		}
+	if adminToken {
+		newScopes = append(newScopes, adminScope())
+	} else if len(scopes) > 0 {
+		ip, _ := r.Context().Value(common.ContextKeyRemoteAddr).(string)
 
-		sql = sql.Find(&permissions)
-		if sql.Error != nil {
-			log.WithError(sql.Error).Error("unable to query database")
+		newScopes, err = h.resolveAccess(h.entityIDs(user), h.implicitPermissions(user), service, ip, scopes)
+		if err != nil {
+			log.WithError(err).Error("unable to query database")
 			w.WriteHeader(500)
 			return
 		}
 
-		for _, perm := range permissions {
-			actions := []string{string(perm.Action)}
-
-			if perm.Action == "push" {
-				actions = append(actions, "pull")
-			}
-
-			if perm.Type == "namespace" {
-				for _, scope := range scopes {
-					if strings.HasPrefix(scope.Name, perm.Name) {
-						newScopes = append(newScopes, docker.Scope{
-							Type:    scope.Type,
-							Name:    scope.Name,
-							Actions: actions,
-						})
-					}
-				}
-			} else {
-				newScopes = append(newScopes, docker.Scope{
-					Type:    string(perm.Type),
-					Name:    perm.Name,
-					Actions: actions,
-				})
-			}
-		}
-
 		for _, s := range newScopes {
 			log.WithFields(logrus.Fields{
 				"type":    s.Type,
@@ -175,58 +221,23 @@ func (h *handlers) Token(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	x5c := strings.ReplaceAll(pki.X509, "-----BEGIN CERTIFICATE-----", "")
-	x5c = strings.ReplaceAll(x5c, "-----END CERTIFICATE-----", "")
-	x5c = strings.ReplaceAll(x5c, "\n", "")
+	now := time.Now().UTC()
+	expiresAt := now.Add(ttl)
+	jti := uuid.NewString()
 
-	signingMethod := ""
-	if pki.Type == "ECDSA" {
-		signingMethod = fmt.Sprintf("ES%d", pki.Bits)
-	} else if pki.Type == "RSA" {
-		signingMethod = fmt.Sprintf("RS%d", pki.Bits)
-	} else {
-		err := fmt.Errorf("invalid pki type: %s", pki.Type)
-		log.WithError(err).Error("unknown signing algorith")
-		response.New(w, r).AddError(err).Send(500)
-		return
-	}
-
-	log.Debugf("signing method: %s", signingMethod)
-
-	t := jwt.New(jwt.GetSigningMethod(signingMethod))
-	t.Claims = TokenClaims{
+	token, err := h.signToken(log, TokenClaims{
 		Access: newScopes,
 		StandardClaims: jwt.StandardClaims{
-			Id:        uuid.NewString(),
+			Id:        jti,
 			Audience:  audience,
-			Issuer:    common.AppVersion.Name,
-			IssuedAt:  time.Now().UTC().Unix(),
-			ExpiresAt: time.Now().UTC().Add(300 * time.Second).Unix(),
-			NotBefore: time.Now().UTC().Unix(),
+			Issuer:    h.config.TokenIssuer,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: expiresAt.Unix(),
+			NotBefore: now.Unix(),
 			Subject:   subject,
 		},
-	}
-	t.Header["x5c"] = []string{x5c}
-
-	var key crypto.PrivateKey
-
-	if pki.Type == "RSA" {
-		key, err = jwt.ParseRSAPrivateKeyFromPEM([]byte(pki.Private))
-		if err != nil {
-			log.WithError(err).Error("unable to parse pki private key")
-			w.WriteHeader(500)
-			return
-		}
-	} else if pki.Type == "ECDSA" {
-		key, err = jwt.ParseECPrivateKeyFromPEM([]byte(pki.Private))
-		if err != nil {
-			log.WithError(err).Error("unable to parse pki private key")
-			w.WriteHeader(500)
-			return
-		}
-	}
-
-	token, err := t.SignedString(key)
+		Extra: extraClaims,
+	})
 	if err != nil {
 		log.WithError(err).Error("unable to sign token")
 		w.WriteHeader(500)
@@ -235,13 +246,42 @@ func (h *handlers) Token(w http.ResponseWriter, r *http.Request) {
 
 	log.Trace(token)
 
+	record := &db.Token{
+		JTI:       jti,
+		Type:      db.AccessTokenType,
+		UserID:    user.ID,
+		Service:   audience,
+		Scope:     scopeString(newScopes),
+		IssuedAt:  &now,
+		ExpiresAt: &expiresAt,
+	}
+	if req.Refresh != nil {
+		record.RefreshID = req.Refresh.ID
+	}
+	if sql := h.db.Create(record); sql.Error != nil {
+		log.WithError(sql.Error).Error("unable to record token")
+		w.WriteHeader(500)
+		return
+	}
+
 	res := TokenResponse{
-		Token:       token,
-		AccessToken: token,
-		ExpiresIn:   300,
-		IssuedAt:    time.Now().UTC(),
+		Token:        token,
+		AccessToken:  token,
+		RefreshToken: req.RefreshToken,
+		ExpiresIn:    int(ttl.Seconds()),
+		IssuedAt:     now,
+	}
+
+	if req.Offline && req.Refresh == nil {
+		res.RefreshToken, err = h.createRefreshToken(user, audience, now)
+		if err != nil {
+			log.WithError(err).Error("unable to create refresh token")
+			w.WriteHeader(500)
+			return
+		}
 	}
 
+	w.Header().Set("content-type", "application/json")
 	w.WriteHeader(200)
 	if err := json.NewEncoder(w).Encode(res); err != nil {
 		w.WriteHeader(500)
@@ -249,3 +289,69 @@ func (h *handlers) Token(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+// SigningMethod returns the signing method of the tokens signed with the pki, derived from its key
+func SigningMethod(pki *db.PKI) (jwt.SigningMethod, error) {
+	alg, err := signer.Algorithm(pki.Type, pki.Bits)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pki %d: %w", pki.ID, err)
+	}
+
+	method := jwt.GetSigningMethod(alg)
+	if method == nil {
+		return nil, fmt.Errorf("unsupported signing method: %s", alg)
+	}
+
+	return method, nil
+}
+
+// pkiSigner returns the signer of the pki, either its private key from the database or the configured signer
+// that holds it
+func (h *handlers) pkiSigner(pki *db.PKI) (signer.Signer, error) {
+	if pki.Signer != "" && pki.Signer != signer.DB {
+		if h.config.Signer == nil || h.config.Signer.Name() != pki.Signer {
+			return nil, fmt.Errorf("the private key of pki %d is held by the %s signer which is not configured", pki.ID, pki.Signer)
+		}
+
+		return h.config.Signer, nil
+	}
+
+	keyPEM, err := h.config.Keyring.Decrypt(context.Background(), pki.ID, pki.Private)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := issuer.ParsePrivateKey(keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse pki private key: %w", err)
+	}
+
+	return signer.NewKeySigner(key), nil
+}
+
+// signToken signs the claims with the active pki, the certificate and its chain are included in the x5c header
+func (h *handlers) signToken(log *logrus.Entry, claims TokenClaims) (string, error) {
+	var pki db.PKI
+	sql := h.db.Model(&db.PKI{}).Where("active = ? AND ca = ? AND expires_at > ?", true, false, time.Now().UTC()).Order("created_at DESC").First(&pki)
+	if sql.Error != nil {
+		return "", sql.Error
+	}
+
+	method, err := SigningMethod(&pki)
+	if err != nil {
+		return "", err
+	}
+
+	log.Debugf("signing method: %s", method.Alg())
+
+	s, err := h.pkiSigner(&pki)
+	if err != nil {
+		return "", err
+	}
+
+	t := jwt.New(method)
+	t.Claims = claims
+	t.Header["x5c"] = issuer.X5C(&pki)
+
+	return signer.SignToken(context.Background(), s, t)
+}
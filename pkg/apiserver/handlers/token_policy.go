@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ekristen/dockit/pkg/db"
+)
+
+// reservedClaims cannot be overridden by the extra claims of a token policy
+var reservedClaims = []string{"iss", "sub", "aud", "exp", "nbf", "iat", "jti", "access"}
+
+// TokenPolicy is the request body to set the token ttl and extra claims of a user or group,
+// an empty ttl or no claims clears the override
+type TokenPolicy struct {
+	TTL    string                 `json:"ttl"`
+	Claims map[string]interface{} `json:"claims"`
+}
+
+// Parse validates the policy and returns the values as they are stored in the database
+func (p *TokenPolicy) Parse() (ttl int, claims string, err error) {
+	if p.TTL != "" {
+		d, err := time.ParseDuration(p.TTL)
+		if err != nil {
+			return 0, "", fmt.Errorf("invalid ttl: %s", err)
+		}
+		if d < time.Second {
+			return 0, "", fmt.Errorf("invalid ttl: must be at least 1s")
+		}
+
+		ttl = int(d.Seconds())
+	}
+
+	if len(p.Claims) > 0 {
+		for _, c := range reservedClaims {
+			if _, ok := p.Claims[c]; ok {
+				return 0, "", fmt.Errorf("invalid claims: %s is reserved", c)
+			}
+		}
+
+		b, err := json.Marshal(p.Claims)
+		if err != nil {
+			return 0, "", err
+		}
+
+		claims = string(b)
+	}
+
+	return ttl, claims, nil
+}
+
+// tokenPolicy resolves the ttl and extra claims of a token for a user, the groups of the user must be loaded.
+// The ttl of the user takes precedence, then the longest ttl of its groups, then the robot ttl and finally the
+// default, claims of the user override the claims of its groups.
+func (h *handlers) tokenPolicy(user *db.User) (time.Duration, map[string]interface{}, error) {
+	ttl := h.config.TokenTTL
+	claims := map[string]interface{}{}
+
+	groups := make([]*db.Group, 0, len(user.Groups))
+	for _, g := range user.Groups {
+		if g.Active {
+			groups = append(groups, g)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Name < groups[j].Name
+	})
+
+	groupTTL := 0
+	for _, g := range groups {
+		if g.TokenTTL > groupTTL {
+			groupTTL = g.TokenTTL
+		}
+
+		if err := mergeClaims(claims, g.TokenClaims); err != nil {
+			return 0, nil, fmt.Errorf("invalid claims for group %s: %w", g.Name, err)
+		}
+	}
+
+	if err := mergeClaims(claims, user.TokenClaims); err != nil {
+		return 0, nil, fmt.Errorf("invalid claims for user %s: %w", user.Username, err)
+	}
+
+	if user.TokenTTL > 0 {
+		ttl = time.Duration(user.TokenTTL) * time.Second
+	} else if groupTTL > 0 {
+		ttl = time.Duration(groupTTL) * time.Second
+	} else if user.Robot && h.config.RobotTokenTTL > 0 {
+		ttl = h.config.RobotTokenTTL
+	}
+
+	if h.config.TokenMaxTTL > 0 && ttl > h.config.TokenMaxTTL {
+		ttl = h.config.TokenMaxTTL
+	}
+
+	return ttl, claims, nil
+}
+
+func mergeClaims(claims map[string]interface{}, raw string) error {
+	if raw == "" {
+		return nil
+	}
+
+	var extra map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &extra); err != nil {
+		return err
+	}
+
+	for k, v := range extra {
+		claims[k] = v
+	}
+
+	return nil
+}
+
+// maxTokenTTL returns the longest lifetime a token can be issued with, the default, the robot ttl or the ttl of
+// a user or group, capped at the maximum ttl
+func (h *handlers) maxTokenTTL() (time.Duration, error) {
+	if h.config.TokenMaxTTL > 0 {
+		return h.config.TokenMaxTTL, nil
+	}
+
+	ttl := h.config.TokenTTL
+	if h.config.RobotTokenTTL > ttl {
+		ttl = h.config.RobotTokenTTL
+	}
+
+	for _, model := range []interface{}{&db.User{}, &db.Group{}} {
+		var seconds int64
+		if sql := h.db.Model(model).Select("COALESCE(MAX(token_ttl), 0)").Scan(&seconds); sql.Error != nil {
+			return 0, sql.Error
+		}
+		if d := time.Duration(seconds) * time.Second; d > ttl {
+			ttl = d
+		}
+	}
+
+	return ttl, nil
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ekristen/dockit/pkg/db"
+)
+
+func Test_TokenPolicy(t *testing.T) {
+	cases := []struct {
+		Name   string
+		Config Config
+		User   db.User
+		TTL    time.Duration
+		Claims map[string]interface{}
+		Error  bool
+	}{
+		{
+			Name:   "default",
+			User:   db.User{Username: "bob"},
+			TTL:    5 * time.Minute,
+			Claims: map[string]interface{}{},
+		},
+		{
+			Name: "user ttl wins over groups",
+			User: db.User{Username: "bob", TokenTTL: 60, Groups: []*db.Group{
+				{Name: "ci", Active: true, TokenTTL: 3600},
+			}},
+			TTL:    time.Minute,
+			Claims: map[string]interface{}{},
+		},
+		{
+			Name: "longest ttl of the active groups",
+			User: db.User{Username: "bob", Groups: []*db.Group{
+				{Name: "a", Active: true, TokenTTL: 600},
+				{Name: "b", Active: true, TokenTTL: 1200},
+				{Name: "c", Active: false, TokenTTL: 7200},
+			}},
+			TTL:    20 * time.Minute,
+			Claims: map[string]interface{}{},
+		},
+		{
+			Name:   "robot ttl",
+			Config: Config{RobotTokenTTL: time.Hour},
+			User:   db.User{Username: "ci", Robot: true},
+			TTL:    time.Hour,
+			Claims: map[string]interface{}{},
+		},
+		{
+			Name:   "robot ttl is overridden by groups",
+			Config: Config{RobotTokenTTL: time.Hour},
+			User:   db.User{Username: "ci", Robot: true, Groups: []*db.Group{{Name: "ci", Active: true, TokenTTL: 120}}},
+			TTL:    2 * time.Minute,
+			Claims: map[string]interface{}{},
+		},
+		{
+			Name:   "clamped to the max ttl",
+			Config: Config{TokenMaxTTL: 10 * time.Minute},
+			User:   db.User{Username: "bob", TokenTTL: 3600},
+			TTL:    10 * time.Minute,
+			Claims: map[string]interface{}{},
+		},
+		{
+			Name:   "robot ttl clamped to the max ttl",
+			Config: Config{TokenMaxTTL: 10 * time.Minute, RobotTokenTTL: time.Hour},
+			User:   db.User{Username: "ci", Robot: true},
+			TTL:    10 * time.Minute,
+			Claims: map[string]interface{}{},
+		},
+		{
+			Name: "claims merged in group name order, the user overrides",
+			User: db.User{Username: "bob", TokenClaims: `{"team":"web"}`, Groups: []*db.Group{
+				{Name: "b", Active: true, TokenClaims: `{"env":"prod","tier":"b"}`},
+				{Name: "a", Active: true, TokenClaims: `{"team":"ops","tier":"a"}`},
+				{Name: "c", Active: false, TokenClaims: `{"env":"dev"}`},
+			}},
+			TTL:    5 * time.Minute,
+			Claims: map[string]interface{}{"team": "web", "env": "prod", "tier": "b"},
+		},
+		{
+			Name:  "invalid group claims",
+			User:  db.User{Username: "bob", Groups: []*db.Group{{Name: "a", Active: true, TokenClaims: `{`}}},
+			Error: true,
+		},
+		{
+			Name:  "invalid user claims",
+			User:  db.User{Username: "bob", TokenClaims: `[]`},
+			Error: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			config := c.Config
+			h := New(nil, &config)
+
+			user := c.User
+			ttl, claims, err := h.tokenPolicy(&user)
+			if c.Error {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, c.TTL, ttl)
+			assert.Equal(t, c.Claims, claims)
+		})
+	}
+}
+
+func Test_TokenPolicyParse(t *testing.T) {
+	cases := []struct {
+		Policy TokenPolicy
+		TTL    int
+		Claims string
+		Error  bool
+	}{
+		{Policy: TokenPolicy{}},
+		{Policy: TokenPolicy{TTL: "1h"}, TTL: 3600},
+		{Policy: TokenPolicy{TTL: "500ms"}, Error: true},
+		{Policy: TokenPolicy{TTL: "soon"}, Error: true},
+		{Policy: TokenPolicy{Claims: map[string]interface{}{"team": "web"}}, Claims: `{"team":"web"}`},
+		{Policy: TokenPolicy{Claims: map[string]interface{}{"access": []string{}}}, Error: true},
+		{Policy: TokenPolicy{Claims: map[string]interface{}{"exp": 1}}, Error: true},
+	}
+
+	for _, c := range cases {
+		ttl, claims, err := c.Policy.Parse()
+		if c.Error {
+			assert.Error(t, err, c.Policy)
+			continue
+		}
+
+		assert.NoError(t, err, c.Policy)
+		assert.Equal(t, c.TTL, ttl)
+		assert.Equal(t, c.Claims, claims)
+	}
+}
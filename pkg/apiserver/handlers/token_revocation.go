@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+
+	"github.com/ekristen/dockit/pkg/apiserver/response"
+	"github.com/ekristen/dockit/pkg/common"
+	"github.com/ekristen/dockit/pkg/db"
+	"github.com/ekristen/dockit/pkg/docker"
+)
+
+// hashToken returns the hex encoded sha256 of a refresh token as it is stored in the database
+func hashToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// scopeString returns the scopes in the space separated format they are requested in
+func scopeString(scopes []docker.Scope) string {
+	parts := make([]string, 0, len(scopes))
+	for _, s := range scopes {
+		parts = append(parts, s.String())
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// createRefreshToken creates and records a new refresh token for the user bound to the service
+func (h *handlers) createRefreshToken(user *db.User, service string, now time.Time) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	raw := base64.RawURLEncoding.EncodeToString(b)
+	expiresAt := now.Add(h.config.RefreshTokenTTL)
+
+	sql := h.db.Create(&db.Token{
+		JTI:       uuid.NewString(),
+		Type:      db.RefreshTokenType,
+		Hash:      hashToken(raw),
+		UserID:    user.ID,
+		Service:   service,
+		IssuedAt:  &now,
+		ExpiresAt: &expiresAt,
+	})
+	if sql.Error != nil {
+		return "", sql.Error
+	}
+
+	return raw, nil
+}
+
+// refreshAuth authenticates a refresh token, it has to be active, belong to an active user and
+// be used for the service it was issued for
+func (h *handlers) refreshAuth(log *logrus.Entry, raw string, service string) (*db.User, *db.Token, error) {
+	if raw == "" {
+		return nil, nil, UnauthorizedError
+	}
+
+	var token db.Token
+	sql := h.db.Where("hash = ? AND type = ?", hashToken(raw), db.RefreshTokenType).First(&token)
+	if sql.Error != nil {
+		if sql.Error == gorm.ErrRecordNotFound {
+			log.Debug("unknown refresh token")
+			return nil, nil, UnauthorizedError
+		}
+
+		log.WithError(sql.Error).Error("unable to query database")
+		return nil, nil, DBError
+	}
+
+	if !token.Active() {
+		log.WithField("jti", token.JTI).Debug("refresh token expired or revoked")
+		return nil, nil, UnauthorizedError
+	}
+
+	if token.Service != "" && token.Service != service {
+		log.WithField("jti", token.JTI).WithField("service", service).Debug("refresh token issued for another service")
+		return nil, nil, UnauthorizedError
+	}
+
+	var user db.User
+	sql = h.db.Preload("Groups").Where("id = ? AND active = ?", token.UserID, true).First(&user)
+	if sql.Error != nil {
+		if sql.Error == gorm.ErrRecordNotFound {
+			log.WithField("jti", token.JTI).Debug("refresh token user is disabled or removed")
+			return nil, nil, UnauthorizedError
+		}
+
+		log.WithError(sql.Error).Error("unable to query database")
+		return nil, nil, DBError
+	}
+
+	return &user, &token, nil
+}
+
+// revokeUserTokens revokes every outstanding access and refresh token of a user
+func (h *handlers) revokeUserTokens(userID int64) (int64, error) {
+	now := time.Now().UTC()
+
+	sql := h.db.Model(&db.Token{}).
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, now).
+		Update("revoked_at", now)
+
+	return sql.RowsAffected, sql.Error
+}
+
+func (h *handlers) UserTokens(w http.ResponseWriter, r *http.Request) {
+	reqID := r.Context().Value(common.ContextReqIDKey)
+	log := logrus.WithField("reqID", reqID)
+
+	res := response.New(w, r)
+
+	if _, err := h.adminAuth(log, w, r); err != nil {
+		sendAuthError(w, r, err)
+		return
+	}
+
+	username := mux.Vars(r)["rbac_entity"]
+
+	var user db.User
+	sql := h.db.Where("username = ?", username).First(&user)
+	if sql.Error != nil {
+		if sql.Error == gorm.ErrRecordNotFound {
+			res.AddError(fmt.Errorf("unknown user: %s", username)).Send(404)
+			return
+		}
+
+		log.WithError(sql.Error).Error("unable to query database")
+		res.AddError(DBError).Send(500)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		var tokens []db.Token
+		sql := h.db.Where("user_id = ? AND expires_at > ?", user.ID, time.Now().UTC()).Order("issued_at DESC").Find(&tokens)
+		if sql.Error != nil {
+			log.WithError(sql.Error).Error("unable to query database")
+			res.AddError(DBError).Send(500)
+			return
+		}
+
+		res.AddData(tokens).Send(200)
+		return
+	case "DELETE":
+		count, err := h.revokeUserTokens(user.ID)
+		if err != nil {
+			log.WithError(err).Error("unable to query database")
+			res.AddError(DBError).Send(500)
+			return
+		}
+
+		log.WithField("user", username).WithField("count", count).Info("revoked tokens")
+	}
+
+	res.Success().Send(200)
+}
+
+func (h *handlers) RevokeToken(w http.ResponseWriter, r *http.Request) {
+	reqID := r.Context().Value(common.ContextReqIDKey)
+	log := logrus.WithField("reqID", reqID)
+
+	res := response.New(w, r)
+
+	if _, err := h.adminAuth(log, w, r); err != nil {
+		sendAuthError(w, r, err)
+		return
+	}
+
+	jti := mux.Vars(r)["jti"]
+
+	var token db.Token
+	sql := h.db.Where("jti = ?", jti).First(&token)
+	if sql.Error != nil {
+		if sql.Error == gorm.ErrRecordNotFound {
+			res.AddError(fmt.Errorf("unknown token: %s", jti)).Send(404)
+			return
+		}
+
+		log.WithError(sql.Error).Error("unable to query database")
+		res.AddError(DBError).Send(500)
+		return
+	}
+
+	// revoking a refresh token also revokes the access tokens that were issued with it
+	now := time.Now().UTC()
+	sql = h.db.Model(&db.Token{}).
+		Where("revoked_at IS NULL").
+		Where(h.db.Where("id = ?", token.ID).Or("refresh_id = ?", token.ID)).
+		Update("revoked_at", now)
+	if sql.Error != nil {
+		log.WithError(sql.Error).Error("unable to query database")
+		res.AddError(DBError).Send(500)
+		return
+	}
+
+	log.WithField("jti", jti).WithField("type", token.Type).Info("revoked token")
+
+	res.Success().Send(200)
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ekristen/dockit/pkg/db"
+)
+
+// testOfflineToken returns an access token and a refresh token for the user
+func testOfflineToken(t *testing.T, h *handlers, username, password string) TokenResponse {
+	req := httptest.NewRequest("GET", "/v2/token?service=registry&scope=registry:catalog:*&offline_token=true", nil)
+	req.SetBasicAuth(username, password)
+
+	rec := httptest.NewRecorder()
+	h.Token(rec, req)
+	assert.Equal(t, 200, rec.Code)
+
+	var res TokenResponse
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+	assert.NotEmpty(t, res.RefreshToken)
+
+	return res
+}
+
+// testRefresh exchanges the refresh token for an access token
+func testRefresh(t *testing.T, h *handlers, refreshToken string) (int, TokenResponse) {
+	form := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {refreshToken}, "service": {"registry"}}
+	req := httptest.NewRequest("POST", "/v2/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rec := httptest.NewRecorder()
+	h.BearerToken(rec, req)
+
+	var res TokenResponse
+	if rec.Code == 200 {
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+	}
+
+	return rec.Code, res
+}
+
+// testIntrospect introspects the token as the admin
+func testIntrospect(t *testing.T, h *handlers, token string) Introspection {
+	req := httptest.NewRequest("POST", "/v2/introspect", strings.NewReader(url.Values{"token": {token}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("admin", "adminpw")
+
+	rec := httptest.NewRecorder()
+	h.Introspect(rec, req)
+	assert.Equal(t, 200, rec.Code)
+
+	var res Introspection
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+
+	return res
+}
+
+func tokenJTI(t *testing.T, raw string) string {
+	claims := &TokenClaims{}
+	_, _, err := new(jwt.Parser).ParseUnverified(raw, claims)
+	assert.NoError(t, err)
+
+	return claims.Id
+}
+
+func Test_RevokedAccessToken(t *testing.T) {
+	h := newTestHandlers(t)
+	testAdmin(t, h)
+	assert.NoError(t, h.db.Create(&db.User{Username: "bob", Password: "bobpw", Active: true}).Error)
+
+	res := testOfflineToken(t, h, "bob", "bobpw")
+
+	introspection := testIntrospect(t, h, res.Token)
+	assert.True(t, introspection.Active)
+	assert.Equal(t, "bob", introspection.Username)
+	assert.Equal(t, "access_token", introspection.TokenType)
+
+	jti := tokenJTI(t, res.Token)
+	assert.Equal(t, 200, adminRequest(t, h.RevokeToken, "DELETE", "/v2/admin/tokens/"+jti, map[string]string{"jti": jti}, nil, nil))
+	assert.False(t, testIntrospect(t, h, res.Token).Active)
+
+	// revoking the access token leaves the refresh token usable
+	code, refreshed := testRefresh(t, h, res.RefreshToken)
+	assert.Equal(t, 200, code)
+	assert.True(t, testIntrospect(t, h, refreshed.Token).Active)
+
+	assert.Equal(t, 404, adminRequest(t, h.RevokeToken, "DELETE", "/v2/admin/tokens/unknown", map[string]string{"jti": "unknown"}, nil, nil))
+}
+
+func Test_RefreshAfterRevocation(t *testing.T) {
+	h := newTestHandlers(t)
+	testAdmin(t, h)
+	assert.NoError(t, h.db.Create(&db.User{Username: "bob", Password: "bobpw", Active: true}).Error)
+
+	res := testOfflineToken(t, h, "bob", "bobpw")
+
+	code, refreshed := testRefresh(t, h, res.RefreshToken)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, res.RefreshToken, refreshed.RefreshToken)
+
+	introspection := testIntrospect(t, h, res.RefreshToken)
+	assert.True(t, introspection.Active)
+	assert.Equal(t, "refresh_token", introspection.TokenType)
+
+	// revoking the refresh token revokes the access tokens issued with it as well
+	assert.Equal(t, 200, adminRequest(t, h.RevokeToken, "DELETE", "/v2/admin/tokens/"+introspection.JTI, map[string]string{"jti": introspection.JTI}, nil, nil))
+
+	code, _ = testRefresh(t, h, res.RefreshToken)
+	assert.Equal(t, 401, code)
+	assert.False(t, testIntrospect(t, h, res.RefreshToken).Active)
+	assert.False(t, testIntrospect(t, h, refreshed.Token).Active)
+
+	// the access token the refresh token was issued with was not issued with it
+	assert.True(t, testIntrospect(t, h, res.Token).Active)
+
+	// revoking every token of a user
+	res = testOfflineToken(t, h, "bob", "bobpw")
+	assert.Equal(t, 200, adminRequest(t, h.UserTokens, "DELETE", "/v2/admin/users/bob/tokens", map[string]string{"rbac_entity": "bob"}, nil, nil))
+
+	code, _ = testRefresh(t, h, res.RefreshToken)
+	assert.Equal(t, 401, code)
+	assert.False(t, testIntrospect(t, h, res.Token).Active)
+
+	// a disabled user can not refresh
+	res = testOfflineToken(t, h, "bob", "bobpw")
+	assert.NoError(t, h.db.Model(&db.User{}).Where("username = ?", "bob").Update("active", false).Error)
+
+	code, _ = testRefresh(t, h, res.RefreshToken)
+	assert.Equal(t, 401, code)
+	assert.False(t, testIntrospect(t, h, res.RefreshToken).Active)
+}
+
+func Test_IntrospectExpired(t *testing.T) {
+	h := newTestHandlers(t)
+	testAdmin(t, h)
+	bob := &db.User{Username: "bob", Password: "bobpw", Active: true}
+	assert.NoError(t, h.db.Create(bob).Error)
+
+	// an access token with a valid signature that has expired
+	issuedAt := time.Now().UTC().Add(-time.Hour)
+	expiresAt := issuedAt.Add(5 * time.Minute)
+	raw, err := h.signToken(logrus.WithField("test", t.Name()), TokenClaims{
+		StandardClaims: jwt.StandardClaims{
+			Id:        "expired",
+			Audience:  "registry",
+			Issuer:    h.config.TokenIssuer,
+			IssuedAt:  issuedAt.Unix(),
+			NotBefore: issuedAt.Unix(),
+			ExpiresAt: expiresAt.Unix(),
+			Subject:   "bob",
+		},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, h.db.Create(&db.Token{JTI: "expired", Type: db.AccessTokenType, UserID: bob.ID, Service: "registry", IssuedAt: &issuedAt, ExpiresAt: &expiresAt}).Error)
+
+	assert.Equal(t, Introspection{}, testIntrospect(t, h, raw))
+
+	// an expired refresh token is inactive and can not be used
+	res := testOfflineToken(t, h, "bob", "bobpw")
+	assert.NoError(t, h.db.Model(&db.Token{}).Where("type = ?", db.RefreshTokenType).Update("expires_at", expiresAt).Error)
+
+	assert.Equal(t, Introspection{}, testIntrospect(t, h, res.RefreshToken))
+
+	code, _ := testRefresh(t, h, res.RefreshToken)
+	assert.Equal(t, 401, code)
+
+	// tokens that were never issued are inactive
+	assert.Equal(t, Introspection{}, testIntrospect(t, h, "not-a-token"))
+}
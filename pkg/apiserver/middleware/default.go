@@ -2,20 +2,24 @@ package middleware
 
 import (
 	"context"
+	"net"
 	"net/http"
 
-	"github.com/google/uuid"
 	"github.com/ekristen/dockit/pkg/common"
+	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 )
 
 type middleware struct {
-	log *logrus.Entry
+	log            *logrus.Entry
+	trustedProxies []*net.IPNet
 }
 
-func NewToken(log *logrus.Entry) *middleware {
+// NewToken returns the default middleware, forwarded headers are only trusted from the trusted proxies
+func NewToken(log *logrus.Entry, trustedProxies []*net.IPNet) *middleware {
 	return &middleware{
-		log: log.WithField("component", "middleware"),
+		log:            log.WithField("component", "middleware"),
+		trustedProxies: trustedProxies,
 	}
 }
 
@@ -34,3 +38,11 @@ func (m *middleware) RequestID(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// RealIP stores the real ip of the client on the request context
+func (m *middleware) RealIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), common.ContextKeyRemoteAddr, realIP(r, m.trustedProxies))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -7,19 +7,37 @@ import (
 	"time"
 
 	"github.com/ekristen/dockit/pkg/common"
+	"github.com/ekristen/dockit/pkg/utils"
 	"github.com/sirupsen/logrus"
 )
 
-// realIP get the real IP from http request
-func realIP(req *http.Request) string {
-	ra := req.RemoteAddr
-	if ip := req.Header.Get("X-Forwarded-For"); ip != "" {
-		ra = strings.Split(ip, ", ")[0]
-	} else if ip := req.Header.Get("X-Real-IP"); ip != "" {
-		ra = ip
-	} else {
-		ra, _, _ = net.SplitHostPort(ra)
+// realIP returns the ip of the client. The X-Forwarded-For and X-Real-IP headers are only honoured when
+// the request comes from a trusted proxy, X-Forwarded-For is walked from the right skipping trusted proxies
+// so a client cannot choose its own address by sending the header itself.
+func realIP(req *http.Request, trustedProxies []*net.IPNet) string {
+	ra, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		ra = req.RemoteAddr
 	}
+
+	if !utils.ContainsIP(trustedProxies, ra) {
+		return ra
+	}
+
+	if xff := req.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := strings.TrimSpace(hops[i])
+			if i == 0 || !utils.ContainsIP(trustedProxies, ip) {
+				return ip
+			}
+		}
+	}
+
+	if ip := req.Header.Get("X-Real-IP"); ip != "" {
+		return strings.TrimSpace(ip)
+	}
+
 	return ra
 }
 
@@ -53,13 +71,15 @@ func (rw *responseWriter) WriteHeader(code int) {
 func LoggingMiddleware2(logger *logrus.Entry) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		fn := func(w http.ResponseWriter, r *http.Request) {
+			logger := logger
+
 			reqID := r.Context().Value(common.ContextReqIDKey)
 
 			if reqID != nil {
 				logger = logger.WithField("reqID", reqID)
 			}
 
-			if remoteAddr := realIP(r); remoteAddr != "" {
+			if remoteAddr, ok := r.Context().Value(common.ContextKeyRemoteAddr).(string); ok && remoteAddr != "" {
 				logger = logger.WithField("remoteAddr", remoteAddr)
 			}
 
@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ekristen/dockit/pkg/utils"
+)
+
+func TestRealIP(t *testing.T) {
+	trusted, err := utils.ParseCIDRs([]string{"10.0.0.0/8"})
+	assert.NoError(t, err)
+
+	cases := []struct {
+		RemoteAddr string
+		Forwarded  string
+		Expected   string
+	}{
+		{RemoteAddr: "192.0.2.1:1234", Expected: "192.0.2.1"},
+		{RemoteAddr: "192.0.2.1:1234", Forwarded: "198.51.100.7", Expected: "192.0.2.1"},
+		{RemoteAddr: "10.0.0.1:1234", Forwarded: "198.51.100.7", Expected: "198.51.100.7"},
+		{RemoteAddr: "10.0.0.1:1234", Forwarded: "203.0.113.9, 198.51.100.7, 10.0.0.2", Expected: "198.51.100.7"},
+		{RemoteAddr: "10.0.0.1:1234", Forwarded: "10.0.0.3, 10.0.0.2", Expected: "10.0.0.3"},
+	}
+
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = c.RemoteAddr
+		if c.Forwarded != "" {
+			r.Header.Set("X-Forwarded-For", c.Forwarded)
+		}
+
+		assert.Equal(t, c.Expected, realIP(r, trusted), c.Forwarded)
+	}
+}
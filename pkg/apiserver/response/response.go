@@ -2,7 +2,6 @@ package response
 
 import (
 	"encoding/json"
-	"fmt"
 	"io"
 	"net/http"
 )
@@ -43,8 +42,8 @@ func (r *Response) AddData(data interface{}) *Response {
 	return r
 }
 func (r *Response) Send(code int) {
+	r.w.Header().Set("content-type", "application/json")
 	r.w.WriteHeader(code)
-	r.w.Header().Add("content-type", "application/json")
 	if err := json.NewEncoder(r.w).Encode(r); err != nil {
 		r.w.WriteHeader(500)
 		r.w.Write([]byte(`{"success": false, "errors":[{"message":""}]}`))
@@ -53,8 +52,10 @@ func (r *Response) Send(code int) {
 
 func ReadAllDecode(in io.Reader) (r *Response, err error) {
 	r = &Response{}
-	if err = json.NewDecoder(in).Decode(r); err != nil {
-		fmt.Println("error", err)
+
+	dec := json.NewDecoder(in)
+	dec.UseNumber()
+	if err = dec.Decode(r); err != nil {
 		return nil, err
 	}
 
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"net/url"
 	"time"
 
 	ghandlers "github.com/gorilla/handlers"
@@ -16,28 +17,31 @@ import (
 )
 
 type apiServer struct {
-	ctx  context.Context
-	log  *logrus.Entry
-	db   *gorm.DB
-	port int
+	ctx    context.Context
+	log    *logrus.Entry
+	db     *gorm.DB
+	port   int
+	config *handlers.Config
 }
 
-func Register(ctx context.Context, log *logrus.Entry, db *gorm.DB, port int) *apiServer {
+func Register(ctx context.Context, log *logrus.Entry, db *gorm.DB, port int, config *handlers.Config) *apiServer {
 	return &apiServer{
-		ctx:  ctx,
-		log:  log,
-		db:   db,
-		port: port,
+		ctx:    ctx,
+		log:    log,
+		db:     db,
+		port:   port,
+		config: config,
 	}
 }
 
 func (a *apiServer) Start() error {
-	handlers := handlers.New(a.db)
-	defaultm := middleware.NewToken(a.log)
+	handlers := handlers.New(a.db, a.config)
+	defaultm := middleware.NewToken(a.log, a.config.TrustedProxies)
 
 	router := mux.NewRouter().StrictSlash(true)
 
 	router.Use(defaultm.RequestID)
+	router.Use(defaultm.RealIP)
 	router.Use(middleware.LoggingMiddleware2(a.log))
 
 	router.Path("/").HandlerFunc(handlers.Root)
@@ -51,9 +55,20 @@ func (a *apiServer) Start() error {
 	// Token with Bearer/OAuth2 Auth
 	api.Path("/token").Methods("POST").HandlerFunc(handlers.BearerToken)
 
+	// Registry notifications
+	api.Path("/events").Methods("POST").HandlerFunc(handlers.Events)
+
+	// Token introspection (RFC 7662)
+	api.Path("/introspect").Methods("POST").HandlerFunc(handlers.Introspect)
+
+	// List / Revoke Tokens
+	api.Path("/admin/user:{rbac_entity}/tokens").Methods("GET", "DELETE").HandlerFunc(handlers.UserTokens)
+	api.Path("/admin/user:{rbac_entity}/tokens").Methods("POST").HandlerFunc(handlers.PersonalTokens)
+	api.Path("/admin/tokens/{jti}").Methods("DELETE").HandlerFunc(handlers.RevokeToken)
+
 	// Grant / Revoke Permissions
-	api.Path("/admin/{rbac_type:user|group}:{rbac_entity}/{type:namespace|repository}:{name}:{action:push|pull}").Methods("PUT").HandlerFunc(handlers.Permission)
-	api.Path("/admin/{rbac_type:user|group}:{rbac_entity}/{type:namespace|repository}:{name}:{action:push|pull}").Methods("DELETE").HandlerFunc(handlers.Permission)
+	api.Path("/admin/{rbac_type:user|group}:{rbac_entity}/{type:namespace|repository}:{name}:{action:push|pull|admin}").Methods("PUT").HandlerFunc(handlers.Permission)
+	api.Path("/admin/{rbac_type:user|group}:{rbac_entity}/{type:namespace|repository}:{name}:{action:push|pull|admin}").Methods("DELETE").HandlerFunc(handlers.Permission)
 
 	// Create User / Group
 	api.Path("/admin/{rbac_type:user|group}:{rbac_entity}").Methods("PUT").HandlerFunc(handlers.Root)
@@ -63,8 +78,35 @@ func (a *apiServer) Start() error {
 
 	api.Path("/admin/{rbac_type:user|group}:{rbac_entity}/{rbac_type_2:user|group}:{rbac_entity_2}/{action}").Methods("PUT").HandlerFunc(handlers.Action)
 
-	api.Path("/admin/pki/generate").Methods("POST").HandlerFunc(handlers.Root)
-	api.Path("/admin/pki/rotate").Methods("POST").HandlerFunc(handlers.Root)
+	// List Users / Groups / Members
+	api.Path("/admin/users").Methods("GET").HandlerFunc(handlers.Users)
+	api.Path("/admin/groups").Methods("GET").HandlerFunc(handlers.Groups)
+	api.Path("/admin/group:{rbac_entity}/members").Methods("GET").HandlerFunc(handlers.Members)
+
+	// Who has access to a repository or namespace
+	api.Path("/admin/who-can/{type:namespace|repository}:{name}:{action:push|pull|admin}").Methods("GET").HandlerFunc(handlers.WhoCan)
+
+	// Registry services tokens can be issued for
+	api.Path("/admin/services").Methods("GET").HandlerFunc(handlers.Services)
+	api.Path("/admin/services/{name}").Methods("PUT", "DELETE").HandlerFunc(handlers.Service)
+
+	// Repository event history
+	api.Path("/admin/repositories/{name:.+}/history").Methods("GET").HandlerFunc(handlers.RepositoryHistory)
+
+	// Failed authentication lockouts
+	api.Path("/admin/lockouts").Methods("GET").HandlerFunc(handlers.Lockouts)
+	api.Path("/admin/lockouts/{type:user|ip}:{name}").Methods("DELETE").HandlerFunc(handlers.Unlock)
+
+	// Signing pki and cas
+	api.Path("/admin/pki").Methods("GET").HandlerFunc(handlers.PKIs)
+	api.Path("/admin/pki/import").Methods("POST").HandlerFunc(handlers.PKIImport)
+	api.Path("/admin/pki/generate").Methods("POST").HandlerFunc(handlers.PKIGenerate)
+	api.Path("/admin/pki/rotate").Methods("POST").HandlerFunc(handlers.PKIRotate)
+	api.Path("/admin/pki/{id:[0-9]+}").Methods("GET").HandlerFunc(handlers.PKI)
+	api.Path("/admin/pki/{id:[0-9]+}/{action:activate|deactivate}").Methods("PUT").HandlerFunc(handlers.PKIAction)
+
+	// Token authentication config of a registry
+	api.Path("/registry-config").Methods("GET").HandlerFunc(handlers.RegistryConfig)
 
 	// PKI Cert Bundle
 	api.Path("/certs/{format}").Methods("GET").HandlerFunc(handlers.PKICerts)
@@ -73,11 +115,30 @@ func (a *apiServer) Start() error {
 	// It **HAS** to be defined after all other paths are defined.
 	router.NotFoundHandler = router.NewRoute().HandlerFunc(http.NotFound).GetHandler()
 
-	// Below this point is where the server is started and graceful shutdown occurs.
+	return a.serve("api server", a.port, ghandlers.CORS()(router))
+}
+
+// StartProxy starts a reverse proxy to the upstream registry that filters the catalog and tag lists
+func (a *apiServer) StartProxy(port int, upstream *url.URL) error {
+	handlers := handlers.New(a.db, a.config)
+	defaultm := middleware.NewToken(a.log, a.config.TrustedProxies)
+
+	router := mux.NewRouter()
+
+	router.Use(defaultm.RequestID)
+	router.Use(defaultm.RealIP)
+	router.Use(middleware.LoggingMiddleware2(a.log))
+
+	router.PathPrefix("/").Handler(handlers.RegistryProxy(upstream))
+
+	return a.serve("registry proxy", port, router)
+}
 
+// serve listens on the port until the context is done and then shuts down gracefully
+func (a *apiServer) serve(name string, port int, handler http.Handler) error {
 	srv := &http.Server{
-		Addr:    fmt.Sprintf(":%d", a.port),
-		Handler: ghandlers.CORS()(router),
+		Addr:    fmt.Sprintf(":%d", port),
+		Handler: handler,
 	}
 
 	go func() {
@@ -85,11 +146,11 @@ func (a *apiServer) Start() error {
 			a.log.Fatalf("listen: %s\n", err)
 		}
 	}()
-	a.log.WithField("port", a.port).Info("starting api server")
+	a.log.WithField("port", port).Infof("starting %s", name)
 
 	<-a.ctx.Done()
 
-	a.log.Info("shutting down the api server gracefully")
+	a.log.Infof("shutting down the %s gracefully", name)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer func() {
@@ -97,7 +158,7 @@ func (a *apiServer) Start() error {
 	}()
 
 	if err := srv.Shutdown(ctx); err != nil {
-		a.log.WithError(err).Error("unable to shutdown the api server gracefully")
+		a.log.WithError(err).Errorf("unable to shutdown the %s gracefully", name)
 		return err
 	}
 
@@ -0,0 +1,182 @@
+// Package clientconfig stores the api servers the cli has logged in to as named contexts, the refresh
+// token of a context is kept in a docker credential helper or in the config file itself
+package clientconfig
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker-credential-helpers/client"
+	"github.com/docker/docker-credential-helpers/credentials"
+	"github.com/docker/docker/pkg/homedir"
+)
+
+// ErrNoContext is returned when no context is selected
+var ErrNoContext = errors.New("no context, use dockit login first")
+
+// Context is an api server the cli has logged in to
+type Context struct {
+	BaseURL  string `json:"base_url"`
+	Username string `json:"username"`
+	// Service is the audience of the admin tokens, it is required to use the refresh token
+	Service  string `json:"service"`
+	Insecure bool   `json:"insecure,omitempty"`
+	// CredentialsStore is the suffix of the docker-credential-<store> helper that holds the refresh token,
+	// the refresh token is stored in the config file without one
+	CredentialsStore string `json:"credentials_store,omitempty"`
+	RefreshToken     string `json:"refresh_token,omitempty"`
+}
+
+// Config holds the contexts and which one is used by default
+type Config struct {
+	CurrentContext string              `json:"current_context"`
+	Contexts       map[string]*Context `json:"contexts"`
+
+	path string
+}
+
+// DefaultPath returns DOCKIT_CONFIG or ~/.dockit/config.json
+func DefaultPath() string {
+	if path := os.Getenv("DOCKIT_CONFIG"); path != "" {
+		return path
+	}
+
+	return filepath.Join(homedir.Get(), ".dockit", "config.json")
+}
+
+// NormalizeBaseURL returns the url of the api with the /v2 prefix the rbac commands expect
+func NormalizeBaseURL(url string) string {
+	url = strings.TrimRight(url, "/")
+	if !strings.HasSuffix(url, "/v2") {
+		url += "/v2"
+	}
+
+	return url
+}
+
+// Load reads the config file, a missing file is an empty config
+func Load(path string) (*Config, error) {
+	cfg := &Config{Contexts: map[string]*Context{}, path: path}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cfg, nil
+		}
+
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	if cfg.Contexts == nil {
+		cfg.Contexts = map[string]*Context{}
+	}
+
+	return cfg, nil
+}
+
+// Save writes the config file, only the owner can read it as it may contain refresh tokens
+func (c *Config) Save() error {
+	if err := os.MkdirAll(filepath.Dir(c.path), 0700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := c.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, c.path)
+}
+
+// Names returns the names of the contexts in order
+func (c *Config) Names() []string {
+	names := make([]string, 0, len(c.Contexts))
+	for name := range c.Contexts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Context returns the named context, or the current context when the name is empty
+func (c *Config) Context(name string) (*Context, error) {
+	if name == "" {
+		name = c.CurrentContext
+	}
+	if name == "" {
+		return nil, ErrNoContext
+	}
+
+	ctx, ok := c.Contexts[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown context: %s", name)
+	}
+
+	return ctx, nil
+}
+
+func (ctx *Context) program() client.ProgramFunc {
+	return client.NewShellProgramFunc("docker-credential-" + ctx.CredentialsStore)
+}
+
+// SetRefreshToken stores the refresh token of the context
+func (ctx *Context) SetRefreshToken(token string) error {
+	if ctx.CredentialsStore == "" {
+		ctx.RefreshToken = token
+		return nil
+	}
+
+	return client.Store(ctx.program(), &credentials.Credentials{
+		ServerURL: ctx.BaseURL,
+		Username:  ctx.Username,
+		Secret:    token,
+	})
+}
+
+// GetRefreshToken returns the refresh token of the context
+func (ctx *Context) GetRefreshToken() (string, error) {
+	if ctx.CredentialsStore == "" {
+		return ctx.RefreshToken, nil
+	}
+
+	creds, err := client.Get(ctx.program(), ctx.BaseURL)
+	if err != nil {
+		if credentials.IsErrCredentialsNotFound(err) {
+			return "", nil
+		}
+
+		return "", err
+	}
+
+	return creds.Secret, nil
+}
+
+// EraseRefreshToken removes the refresh token of the context
+func (ctx *Context) EraseRefreshToken() error {
+	if ctx.CredentialsStore == "" {
+		ctx.RefreshToken = ""
+		return nil
+	}
+
+	err := client.Erase(ctx.program(), ctx.BaseURL)
+	if err != nil && credentials.IsErrCredentialsNotFound(err) {
+		return nil
+	}
+
+	return err
+}
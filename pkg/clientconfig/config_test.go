@@ -0,0 +1,50 @@
+package clientconfig
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_NormalizeBaseURL(t *testing.T) {
+	assert.Equal(t, "https://dockit.example.com/v2", NormalizeBaseURL("https://dockit.example.com"))
+	assert.Equal(t, "https://dockit.example.com/v2", NormalizeBaseURL("https://dockit.example.com/"))
+	assert.Equal(t, "https://dockit.example.com/v2", NormalizeBaseURL("https://dockit.example.com/v2/"))
+}
+
+func Test_LoadSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dockit", "config.json")
+
+	cfg, err := Load(path)
+	assert.NoError(t, err)
+
+	_, err = cfg.Context("")
+	assert.Equal(t, ErrNoContext, err)
+
+	ctx := &Context{BaseURL: "https://dockit.example.com/v2", Username: "alice", Service: "dockit"}
+	assert.NoError(t, ctx.SetRefreshToken("secret"))
+
+	cfg.Contexts["prod"] = ctx
+	cfg.CurrentContext = "prod"
+	assert.NoError(t, cfg.Save())
+
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	cfg, err = Load(path)
+	assert.NoError(t, err)
+
+	loaded, err := cfg.Context("")
+	assert.NoError(t, err)
+	assert.Equal(t, ctx, loaded)
+
+	token, err := loaded.GetRefreshToken()
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", token)
+
+	_, err = cfg.Context("staging")
+	assert.EqualError(t, err, "unknown context: staging")
+}
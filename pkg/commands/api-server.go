@@ -5,16 +5,25 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"math/rand"
+	"net/url"
 	"os"
 	"time"
 
 	"github.com/bwmarrin/snowflake"
 	"github.com/ekristen/dockit/pkg/apiserver"
+	"github.com/ekristen/dockit/pkg/apiserver/handlers"
 	"github.com/ekristen/dockit/pkg/commands/global"
 	"github.com/ekristen/dockit/pkg/common"
 	"github.com/ekristen/dockit/pkg/db"
+	"github.com/ekristen/dockit/pkg/envelope"
+	"github.com/ekristen/dockit/pkg/issuer"
+	"github.com/ekristen/dockit/pkg/janitor"
+	"github.com/ekristen/dockit/pkg/metrics"
+	"github.com/ekristen/dockit/pkg/renewer"
+	"github.com/ekristen/dockit/pkg/signer"
 	"github.com/ekristen/dockit/pkg/utils"
 	"github.com/pkg/errors"
 	"github.com/rancher/wrangler/pkg/signals"
@@ -27,8 +36,66 @@ import (
 type apiServerCommand struct{}
 
 func (s *apiServerCommand) Execute(c *cli.Context) error {
-	if c.String("pki-key-type") != "ec" && c.String("pki-key-type") != "rsa" {
-		return fmt.Errorf("invalid pki key type: %s", c.String("pki-key-type"))
+	if c.Duration("lockout-duration") > c.Duration("lockout-max-duration") {
+		return fmt.Errorf("lockout-duration must not be greater than lockout-max-duration")
+	}
+
+	if c.Duration("token-ttl") < time.Second {
+		return fmt.Errorf("token-ttl must be at least 1s")
+	}
+
+	if c.String("events-token") != "" && len(c.String("events-token")) < 16 {
+		return fmt.Errorf("events-token must be at least 16 characters")
+	}
+
+	if c.Int("group-max-depth") < 1 {
+		return fmt.Errorf("group-max-depth must be at least 1")
+	}
+
+	if err := handlers.ValidateNamespaceTemplates(c.String("personal-namespace"), c.String("group-namespace")); err != nil {
+		return err
+	}
+
+	trustedProxies, err := utils.ParseCIDRs(c.StringSlice("trusted-proxy"))
+	if err != nil {
+		return errors.Wrap(err, "invalid trusted-proxy")
+	}
+
+	var upstream *url.URL
+	if c.String("proxy-upstream") != "" {
+		upstream, err = url.Parse(c.String("proxy-upstream"))
+		if err != nil || upstream.Scheme == "" || upstream.Host == "" {
+			return fmt.Errorf("invalid proxy-upstream, must be an absolute url: %s", c.String("proxy-upstream"))
+		}
+	}
+
+	keyring, err := newKeyring(c)
+	if err != nil {
+		return err
+	}
+
+	issuerOpts, err := issuerOptions(c, keyring)
+	if err != nil {
+		return err
+	}
+	alg, err := issuerOpts.Algorithm()
+	if err != nil {
+		return err
+	}
+	if err := acceptedAlgorithm(c.StringSlice("registry-algorithms"), alg); err != nil {
+		return errors.Wrap(err, "invalid pki-key-type")
+	}
+	if c.Duration("pki-prepublish") >= c.Duration("pki-renew-before") {
+		return fmt.Errorf("pki-prepublish must be less than pki-renew-before")
+	}
+	if c.Duration("pki-renew-before") >= time.Duration(c.Int("pki-cert-years"))*365*24*time.Hour {
+		return fmt.Errorf("pki-renew-before must be less than pki-cert-years")
+	}
+	if issuerOpts.CA && c.String("pki-signer") != signer.DB {
+		return fmt.Errorf("pki-ca can not be used with the %s signer, its certificate has to be issued outside of dockit", c.String("pki-signer"))
+	}
+	if issuerOpts.CA && !c.Bool("pki-generate") {
+		return fmt.Errorf("pki-ca and pki-ca-file require pki-generate, add the chain of the ca to the pki-file instead")
 	}
 
 	if c.Int("node-id") < 1 || c.Int("node-id") > 1024 {
@@ -41,7 +108,7 @@ func (s *apiServerCommand) Execute(c *cli.Context) error {
 		nodeId = int64(rand.Intn(1023))
 	}
 
-	if !c.Bool("pki-generate") {
+	if !c.Bool("pki-generate") || c.String("pki-signer") != signer.DB {
 		if _, err := os.Stat(c.Path("pki-file")); err != nil {
 			return errors.Wrap(err, "unable to find specified pki-file")
 		}
@@ -53,6 +120,10 @@ func (s *apiServerCommand) Execute(c *cli.Context) error {
 
 	log.Infof("version: %s, node: %d", common.AppVersion.Summary, nodeId)
 
+	if c.Bool("lockout-ip") && len(trustedProxies) == 0 {
+		log.Warn("lockout-ip without a trusted-proxy, behind a reverse proxy every client shares its ip and can be locked out together")
+	}
+
 	node, err := snowflake.NewNode(nodeId)
 	if err != nil {
 		return err
@@ -79,11 +150,104 @@ func (s *apiServerCommand) Execute(c *cli.Context) error {
 		}
 	}
 
-	if err := initPKI(c, node, database, c.Bool("pki-generate"), c.Path("pki-file")); err != nil {
+	for _, name := range c.StringSlice("service") {
+		sql := database.Clauses(clause.OnConflict{DoNothing: true}).Create(&db.Service{Name: name})
+		if sql.Error != nil {
+			return errors.Wrapf(sql.Error, "unable to register service %s", name)
+		}
+	}
+
+	var services int64
+	if sql := database.Model(&db.Service{}).Count(&services); sql.Error != nil {
+		return sql.Error
+	}
+	if services == 0 {
+		log.Warn("no services registered, tokens will be issued for any service")
+	}
+
+	ext, err := newSigner(c)
+	if err != nil {
 		return err
 	}
+	// the pkcs11 signer logs out of the token and closes its session
+	if closer, ok := ext.(io.Closer); ok {
+		defer closer.Close()
+	}
 
-	apiServer := apiserver.Register(ctx, log, database, c.Int("port"))
+	iss := issuer.New(database, node, issuerOpts)
+
+	if err := initPKI(c, database, node, iss, keyring, ext, c.Bool("pki-generate"), c.Path("pki-file")); err != nil {
+		return err
+	}
+
+	if err := checkAlgorithms(database, c.StringSlice("registry-algorithms")); err != nil {
+		return err
+	}
+
+	if err := checkSigner(ctx, database, ext); err != nil {
+		return err
+	}
+
+	if err := checkPrivateKeys(ctx, log, database, keyring); err != nil {
+		return err
+	}
+
+	go func() {
+		if err := metrics.Serve(ctx, log, c.Int("metrics-port")); err != nil {
+			log.WithError(err).Error("unable to shutdown the metrics server gracefully")
+		}
+	}()
+
+	if c.Duration("pki-renew-interval") > 0 {
+		go renewer.Run(ctx, log.WithField("component", "renewer"), database, iss, renewer.Options{
+			Renew:       c.Bool("pki-renew") && c.Bool("pki-generate") && ext == nil,
+			RenewBefore: c.Duration("pki-renew-before"),
+			Prepublish:  c.Duration("pki-prepublish"),
+		}, c.Duration("pki-renew-interval"))
+	}
+
+	if c.Duration("prune-interval") > 0 {
+		go janitor.Run(ctx, log.WithField("component", "janitor"), database, c.Duration("prune-interval"), c.Duration("events-retention"), c.Duration("lockout-max-duration"))
+	}
+
+	// the pki is only managed with the admin api when dockit generates it, a key of the pki-file or a signer
+	// would be replaced by the next restart
+	var managed *issuer.Issuer
+	if c.Bool("pki-generate") && ext == nil {
+		managed = iss
+	}
+
+	apiServer := apiserver.Register(ctx, log, database, c.Int("port"), &handlers.Config{
+		LockoutThreshold:    c.Int("lockout-threshold"),
+		LockoutDuration:     c.Duration("lockout-duration"),
+		LockoutMaxDuration:  c.Duration("lockout-max-duration"),
+		LockoutIP:           c.Bool("lockout-ip"),
+		TokenIssuer:         c.String("token-issuer"),
+		TokenTTL:            c.Duration("token-ttl"),
+		TokenMaxTTL:         c.Duration("token-max-ttl"),
+		RobotTokenTTL:       c.Duration("token-robot-ttl"),
+		RefreshTokenTTL:     c.Duration("refresh-token-ttl"),
+		PersonalTokenMaxTTL: c.Duration("personal-token-max-ttl"),
+		GroupMaxDepth:       c.Int("group-max-depth"),
+		PersonalNamespace:   c.String("personal-namespace"),
+		GroupNamespace:      c.String("group-namespace"),
+		TrustedProxies:      trustedProxies,
+		EventsToken:         c.String("events-token"),
+		SharedNamespaces:    c.StringSlice("shared-namespace"),
+		Keyring:             keyring,
+		Signer:              ext,
+		Issuer:              managed,
+		PKIPrepublish:       c.Duration("pki-prepublish"),
+		Algorithms:          c.StringSlice("registry-algorithms"),
+	})
+
+	if upstream != nil {
+		go func() {
+			if err := apiServer.StartProxy(c.Int("proxy-port"), upstream); err != nil {
+				log.WithError(err).Error("unable to shutdown the registry proxy gracefully")
+			}
+		}()
+	}
 
 	if err := apiServer.Start(); err != nil {
 		return err
@@ -115,13 +279,19 @@ func init() {
 		},
 		&cli.StringFlag{
 			Name:    "pki-key-type",
-			Usage:   "Algorithm to use for PKI for Registry to Dockit authentication",
+			Usage:   "Type of the keys tokens are signed with, ec, rsa, rsa-pss or ed25519, the algorithm is derived from the key",
 			Value:   "ec",
 			EnvVars: []string{"DOCKIT_PKI_KEY_TYPE", "PKI_KEY_TYPE"},
 		},
+		&cli.StringSliceFlag{
+			Name:    "registry-algorithms",
+			Usage:   "Signing algorithm the registries accept, can be repeated, the api server refuses to start when tokens would be signed with another one",
+			EnvVars: []string{"DOCKIT_REGISTRY_ALGORITHMS", "REGISTRY_ALGORITHMS"},
+			Value:   cli.NewStringSlice(signer.DistributionAlgorithms...),
+		},
 		&cli.IntFlag{
 			Name:    "pki-ec-key-size",
-			Usage:   "Elliptic Curve Key Size",
+			Usage:   "Elliptic Curve Key Size, 256, 384 or 521",
 			Value:   256,
 			EnvVars: []string{"DOCKIT_PKI_EC_KEY_SIZE", "PKI_EC_KEY_SIZE"},
 		},
@@ -137,6 +307,58 @@ func init() {
 			Value:   2,
 			EnvVars: []string{"DOCKIT_PKI_CERT_YEARS", "PKI_CERT_YEARS"},
 		},
+		&cli.BoolFlag{
+			Name:    "pki-renew",
+			Usage:   "Generate the next signing certificate before the active one expires, only with --pki-generate",
+			EnvVars: []string{"DOCKIT_PKI_RENEW", "PKI_RENEW"},
+			Value:   true,
+		},
+		&cli.DurationFlag{
+			Name:    "pki-renew-before",
+			Usage:   "How long before the signing certificate expires the next one is generated, or a warning is logged when it is not renewed automatically",
+			EnvVars: []string{"DOCKIT_PKI_RENEW_BEFORE", "PKI_RENEW_BEFORE"},
+			Value:   30 * 24 * time.Hour,
+		},
+		&cli.DurationFlag{
+			Name:    "pki-prepublish",
+			Usage:   "How long the next signing certificate is published on /v2/certs before it is activated, the registries have to pick it up within this time",
+			EnvVars: []string{"DOCKIT_PKI_PREPUBLISH", "PKI_PREPUBLISH"},
+			Value:   7 * 24 * time.Hour,
+		},
+		&cli.DurationFlag{
+			Name:    "pki-renew-interval",
+			Usage:   "Interval at which the expiry of the signing certificate is checked (0 disables renewals and expiry warnings)",
+			EnvVars: []string{"DOCKIT_PKI_RENEW_INTERVAL", "PKI_RENEW_INTERVAL"},
+			Value:   time.Hour,
+		},
+		&cli.StringFlag{
+			Name:    "pki-subject",
+			Usage:   "Subject of the generated signing certificates, for example /C=US/O=example/CN=dockit",
+			EnvVars: []string{"DOCKIT_PKI_SUBJECT", "PKI_SUBJECT"},
+			Value:   utils.DefaultSubject,
+		},
+		&cli.BoolFlag{
+			Name:    "pki-ca",
+			Usage:   "Issue the generated signing certificates from an internal ca, registries only have to trust its root",
+			EnvVars: []string{"DOCKIT_PKI_CA", "PKI_CA"},
+		},
+		&cli.PathFlag{
+			Name:    "pki-ca-file",
+			Usage:   "File with the certificate and private key of a ca to issue the signing certificates from, followed by its chain for an intermediate ca, implies --pki-ca",
+			EnvVars: []string{"DOCKIT_PKI_CA_FILE", "PKI_CA_FILE"},
+		},
+		&cli.StringFlag{
+			Name:    "pki-ca-subject",
+			Usage:   "Subject of the internal ca",
+			EnvVars: []string{"DOCKIT_PKI_CA_SUBJECT", "PKI_CA_SUBJECT"},
+			Value:   utils.DefaultCASubject,
+		},
+		&cli.IntFlag{
+			Name:    "pki-ca-years",
+			Usage:   "The number of years that the internal ca is good for",
+			EnvVars: []string{"DOCKIT_PKI_CA_YEARS", "PKI_CA_YEARS"},
+			Value:   10,
+		},
 		&cli.IntFlag{
 			Name:    "port",
 			Usage:   "Port for the HTTP Server Port",
@@ -149,6 +371,17 @@ func init() {
 			EnvVars: []string{"METRICS_PORT", "DOCKIT_METRICS_PORT"},
 			Value:   4316,
 		},
+		&cli.StringFlag{
+			Name:    "proxy-upstream",
+			Usage:   "URL of a registry to reverse proxy, the catalog and tag lists of the proxy only include repositories the user can pull",
+			EnvVars: []string{"DOCKIT_PROXY_UPSTREAM", "PROXY_UPSTREAM"},
+		},
+		&cli.IntFlag{
+			Name:    "proxy-port",
+			Usage:   "Port for the registry proxy to listen on, only used with --proxy-upstream",
+			EnvVars: []string{"DOCKIT_PROXY_PORT", "PROXY_PORT"},
+			Value:   4317,
+		},
 		&cli.StringFlag{
 			Name:    "sql-dialect",
 			Usage:   "The type of sql to use, sqlite or mysql",
@@ -171,6 +404,112 @@ func init() {
 			Usage:   "Root Password",
 			EnvVars: []string{"DOCKIT_ROOT_PASSWORD", "ROOT_PASSWORD"},
 		},
+		&cli.StringSliceFlag{
+			Name:    "service",
+			Usage:   "Register a registry service (token audience) at startup, once any service is registered tokens for unknown services are refused",
+			EnvVars: []string{"DOCKIT_SERVICES", "SERVICES"},
+		},
+		&cli.StringFlag{
+			Name:    "token-issuer",
+			Usage:   "Issuer of the tokens, must match the issuer configured on the registry",
+			EnvVars: []string{"DOCKIT_TOKEN_ISSUER", "TOKEN_ISSUER"},
+			Value:   common.AppVersion.Name,
+		},
+		&cli.DurationFlag{
+			Name:    "token-ttl",
+			Usage:   "Default lifetime of a token",
+			EnvVars: []string{"DOCKIT_TOKEN_TTL", "TOKEN_TTL"},
+			Value:   300 * time.Second,
+		},
+		&cli.DurationFlag{
+			Name:    "token-max-ttl",
+			Usage:   "Maximum lifetime of a token including user and group overrides (0 for no maximum)",
+			EnvVars: []string{"DOCKIT_TOKEN_MAX_TTL", "TOKEN_MAX_TTL"},
+			Value:   24 * time.Hour,
+		},
+		&cli.DurationFlag{
+			Name:    "token-robot-ttl",
+			Usage:   "Lifetime of a token for robot users without an override (0 uses --token-ttl)",
+			EnvVars: []string{"DOCKIT_TOKEN_ROBOT_TTL", "TOKEN_ROBOT_TTL"},
+		},
+		&cli.DurationFlag{
+			Name:    "refresh-token-ttl",
+			Usage:   "Lifetime of a refresh token issued to clients requesting offline access",
+			EnvVars: []string{"DOCKIT_REFRESH_TOKEN_TTL", "REFRESH_TOKEN_TTL"},
+			Value:   30 * 24 * time.Hour,
+		},
+		&cli.DurationFlag{
+			Name:    "personal-token-max-ttl",
+			Usage:   "Maximum lifetime of a personal access token",
+			EnvVars: []string{"DOCKIT_PERSONAL_TOKEN_MAX_TTL", "PERSONAL_TOKEN_MAX_TTL"},
+			Value:   handlers.DefaultPersonalTokenMaxTTL,
+		},
+		&cli.StringFlag{
+			Name:    "personal-namespace",
+			Usage:   "Give every user push and pull on a namespace of its own, for example {username} (empty disables it)",
+			EnvVars: []string{"DOCKIT_PERSONAL_NAMESPACE", "PERSONAL_NAMESPACE"},
+		},
+		&cli.StringFlag{
+			Name:    "group-namespace",
+			Usage:   "Give the members of every group push and pull on a namespace of the group, for example teams/{group} (empty disables it)",
+			EnvVars: []string{"DOCKIT_GROUP_NAMESPACE", "GROUP_NAMESPACE"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "shared-namespace",
+			Usage:   "Namespace in which the first user to push a new repository becomes its owner with admin on it, requires registry notifications, can be repeated",
+			EnvVars: []string{"DOCKIT_SHARED_NAMESPACES", "SHARED_NAMESPACES"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "trusted-proxy",
+			Usage:   "IP or CIDR of a reverse proxy whose X-Forwarded-For and X-Real-IP headers are trusted, can be repeated",
+			EnvVars: []string{"DOCKIT_TRUSTED_PROXIES", "TRUSTED_PROXIES"},
+		},
+		&cli.IntFlag{
+			Name:    "group-max-depth",
+			Usage:   "Number of levels of nested groups that are resolved for permissions and token policies",
+			EnvVars: []string{"DOCKIT_GROUP_MAX_DEPTH", "GROUP_MAX_DEPTH"},
+			Value:   5,
+		},
+		&cli.IntFlag{
+			Name:    "lockout-threshold",
+			Usage:   "Number of failed authentication attempts for a username, or client ip with --lockout-ip, before it is locked out (0 disables lockouts)",
+			EnvVars: []string{"DOCKIT_LOCKOUT_THRESHOLD", "LOCKOUT_THRESHOLD"},
+			Value:   5,
+		},
+		&cli.DurationFlag{
+			Name:    "lockout-duration",
+			Usage:   "Duration of the first lockout, every subsequent lockout doubles the duration",
+			EnvVars: []string{"DOCKIT_LOCKOUT_DURATION", "LOCKOUT_DURATION"},
+			Value:   time.Minute,
+		},
+		&cli.DurationFlag{
+			Name:    "lockout-max-duration",
+			Usage:   "Maximum duration of a lockout, failures are also forgotten after this duration",
+			EnvVars: []string{"DOCKIT_LOCKOUT_MAX_DURATION", "LOCKOUT_MAX_DURATION"},
+			Value:   time.Hour,
+		},
+		&cli.BoolFlag{
+			Name:    "lockout-ip",
+			Usage:   "Also lock out client ips, behind a reverse proxy it has to be listed with --trusted-proxy or every client shares the ip of the proxy",
+			EnvVars: []string{"DOCKIT_LOCKOUT_IP", "LOCKOUT_IP"},
+		},
+		&cli.DurationFlag{
+			Name:    "prune-interval",
+			Usage:   "Interval at which expired permission grants and tokens are removed from the database (0 disables pruning)",
+			EnvVars: []string{"DOCKIT_PRUNE_INTERVAL", "PRUNE_INTERVAL"},
+			Value:   time.Minute,
+		},
+		&cli.StringFlag{
+			Name:    "events-token",
+			Usage:   "Bearer token any registry can post notifications to /v2/events with, prefer the events token of a service",
+			EnvVars: []string{"DOCKIT_EVENTS_TOKEN", "EVENTS_TOKEN"},
+		},
+		&cli.DurationFlag{
+			Name:    "events-retention",
+			Usage:   "How long registry events are kept (0 keeps them forever)",
+			EnvVars: []string{"DOCKIT_EVENTS_RETENTION", "EVENTS_RETENTION"},
+			Value:   90 * 24 * time.Hour,
+		},
 		&cli.BoolFlag{
 			Name:    "first-user-admin",
 			Usage:   "Indicates if the first user to login should be made an admin",
@@ -183,95 +522,132 @@ func init() {
 		Name:   "api-server",
 		Usage:  "dockit api server",
 		Action: cmd.Execute,
-		Flags:  append(flags, global.Flags()...),
+		Flags:  append(append(append(flags, keyringFlags()...), signerFlags()...), global.Flags()...),
 		Before: global.Before,
 	}
 
 	common.RegisterCommand(cliCmd)
 }
 
-func initPKI(c *cli.Context, node *snowflake.Node, database *gorm.DB, generate bool, file string) error {
-	if !generate {
-		pki, err := parsePKIFile(file)
-		if err != nil {
+// importPKI stores the pki of the file as the active pki, an rsa key signs with pss when pss is set. The pki
+// imported on a previous start is found by the serial number of its certificate and keeps its id.
+func importPKI(ctx context.Context, database *gorm.DB, node *snowflake.Node, keyring *envelope.Keyring, pki *PKIFile, signerName string, pss bool) error {
+	keyType, bits := issuer.KeyType(pki.Cert.PublicKey)
+	if pss && keyType == "RSA" {
+		keyType = "RSA-PSS"
+	}
+
+	var existing db.PKI
+	if sql := database.Where("serial = ? AND ca = ?", issuer.Serial(pki.Cert), false).Limit(1).Find(&existing); sql.Error != nil {
+		return sql.Error
+	}
+
+	id := existing.ID
+	if id == 0 {
+		id = node.Generate().Int64()
+	}
+
+	private := string(pki.KeyPEM)
+	if keyring != nil && len(pki.KeyPEM) > 0 {
+		var err error
+		if private, err = keyring.Encrypt(ctx, id, pki.KeyPEM); err != nil {
 			return err
 		}
+	}
 
-		sql := database.Clauses(clause.OnConflict{
-			Columns:   []clause.Column{{Name: "id"}},
-			DoUpdates: clause.AssignmentColumns([]string{"private", "x509"}),
-		}).Create(&db.PKI{
-			ID:        pki.Cert.SerialNumber.Int64(),
-			Type:      pki.Cert.PublicKeyAlgorithm.String(),
-			Private:   string(pki.KeyPEM),
-			X509:      string(pki.CertPEM),
-			NotBefore: &pki.Cert.NotBefore,
-			ExpiresAt: &pki.Cert.NotAfter,
-			Active:    true,
-		})
-		if sql.Error != nil {
+	sql := database.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"type", "private", "x509", "serial", "chain", "signer", "bits", "active", "activate_at", "deactivated_at"}),
+	}).Create(&db.PKI{
+		ID:        id,
+		Type:      keyType,
+		Bits:      bits,
+		Private:   private,
+		Signer:    signerName,
+		X509:      string(pki.CertPEM),
+		Serial:    issuer.Serial(pki.Cert),
+		Chain:     string(pki.ChainPEM),
+		NotBefore: &pki.Cert.NotBefore,
+		ExpiresAt: &pki.Cert.NotAfter,
+		Active:    true,
+	})
+
+	return sql.Error
+}
+
+// issuerOptions returns the options for the pki generated by dockit
+func issuerOptions(c *cli.Context, keyring *envelope.Keyring) (issuer.Options, error) {
+	subject, err := utils.ParseSubject(c.String("pki-subject"))
+	if err != nil {
+		return issuer.Options{}, errors.Wrap(err, "invalid pki-subject")
+	}
+
+	caSubject, err := utils.ParseSubject(c.String("pki-ca-subject"))
+	if err != nil {
+		return issuer.Options{}, errors.Wrap(err, "invalid pki-ca-subject")
+	}
+
+	return issuer.Options{
+		KeyType:    c.String("pki-key-type"),
+		ECKeySize:  c.Int("pki-ec-key-size"),
+		RSAKeySize: c.Int("pki-rsa-key-size"),
+		Years:      c.Int("pki-cert-years"),
+		Subject:    subject,
+		CA:         c.Bool("pki-ca") || c.Path("pki-ca-file") != "",
+		CASubject:  caSubject,
+		CAYears:    c.Int("pki-ca-years"),
+		Keyring:    keyring,
+		Prepublish: c.Duration("pki-prepublish"),
+		Algorithms: c.StringSlice("registry-algorithms"),
+	}, nil
+}
+
+func initPKI(c *cli.Context, database *gorm.DB, node *snowflake.Node, iss *issuer.Issuer, keyring *envelope.Keyring, ext signer.Signer, generate bool, file string) error {
+	if err := issuer.SetSerials(database); err != nil {
+		return errors.Wrap(err, "unable to set the serial numbers of the pki")
+	}
+
+	// the private key of an external signer never leaves it, only its certificate is imported
+	if ext != nil {
+		pki, err := parsePKIFile(file, false)
+		if err != nil {
 			return err
 		}
+		if len(pki.KeyPEM) > 0 {
+			return fmt.Errorf("pki-file must only contain the certificate with the %s signer", ext.Name())
+		}
 
-		return nil
+		return importPKI(c.Context, database, node, nil, pki, ext.Name(), c.String("pki-key-type") == "rsa-pss")
 	}
 
-	sql := database.Model(&db.PKI{}).Where("expires_at > ? AND active = 1", time.Now().UTC()).Find(nil)
-	if sql.Error != nil {
-		return sql.Error
-	}
-	if sql.RowsAffected == 0 {
-		logrus.Info("generating pki for signing tokens")
-		id := node.Generate().Int64()
+	if c.Path("pki-ca-file") != "" {
+		data, err := ioutil.ReadFile(c.Path("pki-ca-file"))
+		if err != nil {
+			return err
+		}
 
-		switch c.String("pki-key-type") {
-		case "ec":
-			key, keyPEM, err := utils.GenerateECKey(c.Int("pki-ec-key-size"))
-			if err != nil {
-				return err
-			}
-			cert, certPEM, err := utils.GenerateCertificate(id, &key.PublicKey, key, c.Int("pki-cert-years"), 0, 0)
-			if err != nil {
-				return err
-			}
+		if _, err := iss.ImportCA(c.Context, data); err != nil {
+			return errors.Wrap(err, "unable to import pki-ca-file")
+		}
+	}
 
-			sql := database.Create(&db.PKI{
-				ID:        id,
-				Type:      "ECDSA",
-				Private:   string(keyPEM),
-				X509:      string(certPEM),
-				Bits:      c.Int("pki-ec-key-size"),
-				NotBefore: &cert.NotBefore,
-				ExpiresAt: &cert.NotAfter,
-				Active:    true,
-			})
-			if sql.Error != nil {
-				return err
-			}
-		case "rsa":
-			key, keyPEM, err := utils.GenerateRSAKey(c.Int("pki-rsa-key-size"))
-			if err != nil {
-				return err
-			}
-			cert, certPEM, err := utils.GenerateCertificate(id, key.PublicKey, key, c.Int("pki-cert-years"), 0, 0)
-			if err != nil {
-				return err
-			}
-			sql := database.Create(&db.PKI{
-				ID:        id,
-				Type:      "ec",
-				Private:   string(keyPEM),
-				X509:      string(certPEM),
-				Bits:      c.Int("pki-rsa-key-size"),
-				NotBefore: &cert.NotBefore,
-				ExpiresAt: &cert.NotAfter,
-				Active:    true,
-			})
-			if sql.Error != nil {
-				return err
-			}
+	if !generate {
+		pki, err := parsePKIFile(file, true)
+		if err != nil {
+			return err
 		}
 
+		return importPKI(c.Context, database, node, keyring, pki, signer.DB, c.String("pki-key-type") == "rsa-pss")
+	}
+
+	pki, generated, err := iss.Ensure(c.Context)
+	if err != nil {
+		return err
+	}
+	if generated && pki.Active {
+		logrus.WithField("id", pki.ID).Info("generated pki for signing tokens")
+	} else if generated {
+		logrus.WithField("id", pki.ID).WithField("activate_at", pki.ActivateAt).Info("the pki options changed, generated the pki that replaces the active one once it is published")
 	}
 
 	return nil
@@ -281,9 +657,11 @@ type PKIFile struct {
 	CertPEM []byte
 	KeyPEM  []byte
 	Cert    *x509.Certificate
+	// ChainPEM are the certificates after the first, the chain of the issuing ca
+	ChainPEM []byte
 }
 
-func parsePKIFile(file string) (pki *PKIFile, err error) {
+func parsePKIFile(file string, requireKey bool) (pki *PKIFile, err error) {
 	pki = &PKIFile{}
 
 	var block *pem.Block
@@ -305,20 +683,25 @@ func parsePKIFile(file string) (pki *PKIFile, err error) {
 
 		switch block.Type {
 		case "CERTIFICATE":
+			if pki.Cert != nil {
+				pki.ChainPEM = append(pki.ChainPEM, pem.EncodeToMemory(block)...)
+				continue
+			}
+
 			pki.CertPEM = pem.EncodeToMemory(block)
 
 			pki.Cert, err = x509.ParseCertificate(block.Bytes)
 			if err != nil {
 				return nil, err
 			}
-		case "RSA PRIVATE KEY", "EC PRIVATE KEY":
+		case "RSA PRIVATE KEY", "EC PRIVATE KEY", "PRIVATE KEY":
 			pki.KeyPEM = pem.EncodeToMemory(block)
 		}
 	}
 
 	if len(pki.CertPEM) == 0 {
 		err = fmt.Errorf("unable to find certificate")
-	} else if len(pki.KeyPEM) == 0 {
+	} else if requireKey && len(pki.KeyPEM) == 0 {
 		err = fmt.Errorf("unable to find key")
 	}
 
@@ -0,0 +1,196 @@
+// Package apiclient makes authenticated requests against the admin api of the api server for the commands
+package apiclient
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+
+	"github.com/ekristen/dockit/pkg/apiserver/response"
+	"github.com/ekristen/dockit/pkg/clientconfig"
+	"github.com/ekristen/dockit/pkg/output"
+)
+
+// APIError is returned when the api server responds with a non-success response
+type APIError struct {
+	StatusCode int
+	Errors     []string
+}
+
+func (e *APIError) Error() string {
+	if len(e.Errors) == 0 {
+		return fmt.Sprintf("request failed with status %d", e.StatusCode)
+	}
+
+	return fmt.Sprintf("request failed with status %d: %s", e.StatusCode, strings.Join(e.Errors, ", "))
+}
+
+// Do performs an authenticated request against the admin api and returns the response as is
+func Do(c *cli.Context, method string, path string, data []byte) (*http.Response, error) {
+	target, err := ResolveTarget(c)
+	if err != nil {
+		return nil, err
+	}
+
+	url := fmt.Sprintf("%s/%s", target.BaseURL, path)
+	logrus.WithField("url", url).Debug("request url")
+
+	req, err := http.NewRequest(method, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", target.Authorization)
+
+	resp, err := NewClient(target.Insecure).Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	logrus.WithField("headers", resp.Header).Debug("response headers")
+	logrus.WithField("status", resp.StatusCode).Debug("response Status Code")
+
+	return resp, nil
+}
+
+// DoRequest performs an authenticated request against the admin api and decodes the response,
+// a non-success response is returned as an APIError
+func DoRequest(c *cli.Context, method string, path string, data []byte) (*response.Response, error) {
+	resp, err := Do(c, method, path, data)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	res, err := response.ReadAllDecode(resp.Body)
+	if err != nil {
+		logrus.WithError(err).Debug("unable to decode response")
+		return nil, &APIError{StatusCode: resp.StatusCode}
+	}
+
+	if !res.Status {
+		// machine-readable formats always get the response so scripts can inspect the errors
+		if c.String("output") != output.Table {
+			if err := output.Print(os.Stdout, c.String("output"), res, nil); err != nil {
+				return nil, err
+			}
+		}
+
+		return res, &APIError{StatusCode: resp.StatusCode, Errors: res.Errors}
+	}
+
+	return res, nil
+}
+
+// DoRaw performs an authenticated request against the admin api for a response that is not json and returns
+// its body, a non-success response is returned as an APIError
+func DoRaw(c *cli.Context, method string, path string, data []byte) ([]byte, error) {
+	resp, err := Do(c, method, path, data)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &APIError{StatusCode: resp.StatusCode}
+		if res, err := response.ReadAllDecode(bytes.NewReader(body)); err == nil {
+			apiErr.Errors = res.Errors
+		}
+
+		return nil, apiErr
+	}
+
+	return body, nil
+}
+
+// Target is the api server a request is made against and how it is authenticated
+type Target struct {
+	BaseURL       string
+	Authorization string
+	Insecure      bool
+}
+
+// ResolveTarget returns where and how to authenticate a request, in order of precedence with the --token,
+// with the --username and --password or the docker credentials of the --registry-url, or with the context
+// selected by dockit login. The --base-url and --insecure flags override the ones of the context.
+func ResolveTarget(c *cli.Context) (*Target, error) {
+	t := &Target{BaseURL: c.String("base-url"), Insecure: c.Bool("insecure")}
+
+	if token := c.String("token"); token != "" {
+		t.Authorization = fmt.Sprintf("Bearer %s", token)
+		return t, nil
+	}
+
+	if c.String("username") == "" && c.String("registry-url") == "" {
+		ctx, err := CurrentContext(c)
+		if err != nil && err != clientconfig.ErrNoContext {
+			return nil, err
+		}
+
+		if ctx != nil {
+			if !c.IsSet("base-url") {
+				t.BaseURL = ctx.BaseURL
+			}
+			if !c.IsSet("insecure") {
+				t.Insecure = ctx.Insecure
+			}
+
+			token, err := refreshAccessToken(t.BaseURL, t.Insecure, ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			t.Authorization = fmt.Sprintf("Bearer %s", token)
+			return t, nil
+		}
+	}
+
+	username, password, err := getCredentials(c)
+	if err != nil {
+		return nil, err
+	}
+
+	basicCreds := fmt.Sprintf("%s:%s", username, password)
+	t.Authorization = fmt.Sprintf("Basic %s", base64.StdEncoding.EncodeToString([]byte(basicCreds)))
+
+	return t, nil
+}
+
+// NewClient returns the http client for the api server
+func NewClient(insecure bool) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: insecure,
+		},
+	}}
+}
+
+// PrintResult prints the result of a command that does not return data
+func PrintResult(c *cli.Context, res *response.Response) error {
+	return output.Print(os.Stdout, c.String("output"), res, output.Rows{
+		{fmt.Sprintf("%s successful", c.Command.Name)},
+	})
+}
+
+// DecodeData converts the generic response data into the given type
+func DecodeData(res *response.Response, v interface{}) error {
+	data, err := json.Marshal(res.Data)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,257 @@
+package apiclient
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli/v2"
+
+	"github.com/ekristen/dockit/pkg/apiserver/handlers"
+	"github.com/ekristen/dockit/pkg/clientconfig"
+)
+
+// tokenServer stands in for the token endpoint of the api server, only the refresh token "valid" is accepted
+func tokenServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v2/token", r.URL.Path)
+		assert.NoError(t, r.ParseForm())
+		assert.Equal(t, "refresh_token", r.PostForm.Get("grant_type"))
+		assert.Equal(t, "dockit", r.PostForm.Get("service"))
+		assert.Equal(t, AdminScope, r.PostForm.Get("scope"))
+
+		if r.PostForm.Get("refresh_token") != "valid" {
+			w.WriteHeader(401)
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(handlers.TokenResponse{Token: "access-" + r.Host})
+	}))
+}
+
+// copyFlags returns copies of the flags, values from the environment are stored on the flags
+func copyFlags(t *testing.T) []cli.Flag {
+	flags := []cli.Flag{}
+	for _, f := range Flags {
+		switch f := f.(type) {
+		case *cli.StringFlag:
+			c := *f
+			flags = append(flags, &c)
+		case *cli.BoolFlag:
+			c := *f
+			flags = append(flags, &c)
+		default:
+			t.Fatalf("unexpected flag %T", f)
+		}
+	}
+
+	return flags
+}
+
+// runResolveTarget resolves the target of a command run with the args
+func runResolveTarget(t *testing.T, args ...string) (*Target, error) {
+	var resolved *Target
+	var err error
+
+	app := &cli.App{
+		Name:  "dockit",
+		Flags: copyFlags(t),
+		Action: func(c *cli.Context) error {
+			resolved, err = ResolveTarget(c)
+			return nil
+		},
+	}
+	assert.NoError(t, app.Run(append([]string{"dockit"}, args...)))
+
+	return resolved, err
+}
+
+func basic(username, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+}
+
+func Test_ResolveTarget(t *testing.T) {
+	for _, env := range []string{"DOCKIT_BASE_URL", "DOCKIT_REGISTRY_URL", "DOCKIT_INSECURE", "DOCKIT_USERNAME", "DOCKIT_PASSWORD", "DOCKIT_TOKEN", "DOCKIT_CONTEXT",
+		"DOCKIT_GRANT_BASE_URL", "DOCKIT_GRANT_REGISTRY_URL", "DOCKIT_GRANT_INSECURE", "DOCKIT_GRANT_USERNAME", "DOCKIT_GRANT_PASSWORD"} {
+		t.Setenv(env, "")
+		assert.NoError(t, os.Unsetenv(env))
+	}
+
+	server := tokenServer(t)
+	defer server.Close()
+	serverURL := server.URL + "/v2"
+
+	dir := t.TempDir()
+
+	// docker credentials of a registry
+	homeDir = dir
+	defer func() { homeDir = "" }()
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, ".docker"), 0700))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, ".docker", "config.json"), []byte(`{"auths":{"https://registry.example.com":{"auth":"`+
+		base64.StdEncoding.EncodeToString([]byte("docker:dockerpw"))+`"}}}`), 0600))
+
+	t.Setenv("DOCKIT_CONFIG", filepath.Join(dir, "config.json"))
+	cfg, err := clientconfig.Load(clientconfig.DefaultPath())
+	assert.NoError(t, err)
+	cfg.CurrentContext = "current"
+	cfg.Contexts["current"] = &clientconfig.Context{BaseURL: serverURL, Username: "alice", Service: "dockit", Insecure: false, RefreshToken: "valid"}
+	cfg.Contexts["other"] = &clientconfig.Context{BaseURL: "http://other.invalid/v2", Username: "bob", Service: "dockit", Insecure: true, RefreshToken: "valid"}
+	cfg.Contexts["revoked"] = &clientconfig.Context{BaseURL: serverURL, Username: "carol", Service: "dockit", RefreshToken: "revoked"}
+	cfg.Contexts["logged-out"] = &clientconfig.Context{BaseURL: serverURL, Username: "dave", Service: "dockit"}
+	assert.NoError(t, cfg.Save())
+
+	host := server.Listener.Addr().String()
+
+	cases := []struct {
+		Name     string
+		Args     []string
+		Env      map[string]string
+		Expected *Target
+		Error    string
+	}{
+		{
+			Name:     "token flag over the context",
+			Args:     []string{"--token", "dkp_flag"},
+			Expected: &Target{BaseURL: "http://localhost:4315/v2", Authorization: "Bearer dkp_flag", Insecure: true},
+		},
+		{
+			Name:     "token from the environment",
+			Env:      map[string]string{"DOCKIT_TOKEN": "dkp_env"},
+			Expected: &Target{BaseURL: "http://localhost:4315/v2", Authorization: "Bearer dkp_env", Insecure: true},
+		},
+		{
+			Name:     "token flag over the environment",
+			Args:     []string{"--token", "dkp_flag"},
+			Env:      map[string]string{"DOCKIT_TOKEN": "dkp_env"},
+			Expected: &Target{BaseURL: "http://localhost:4315/v2", Authorization: "Bearer dkp_flag", Insecure: true},
+		},
+		{
+			Name:     "username over the context",
+			Args:     []string{"--username", "admin", "--password", "adminpw"},
+			Expected: &Target{BaseURL: "http://localhost:4315/v2", Authorization: basic("admin", "adminpw"), Insecure: true},
+		},
+		{
+			Name:     "username from the environment",
+			Env:      map[string]string{"DOCKIT_USERNAME": "admin", "DOCKIT_PASSWORD": "adminpw"},
+			Expected: &Target{BaseURL: "http://localhost:4315/v2", Authorization: basic("admin", "adminpw"), Insecure: true},
+		},
+		{
+			Name:     "docker credentials of the registry over the context",
+			Args:     []string{"--registry-url", "https://registry.example.com"},
+			Expected: &Target{BaseURL: "http://localhost:4315/v2", Authorization: basic("docker", "dockerpw"), Insecure: true},
+		},
+		{
+			Name:     "docker credentials of the registry over http",
+			Args:     []string{"--registry-url", "http://registry.example.com"},
+			Expected: &Target{BaseURL: "http://localhost:4315/v2", Authorization: basic("docker", "dockerpw"), Insecure: true},
+		},
+		{
+			Name:  "unknown registry",
+			Args:  []string{"--registry-url", "https://unknown.example.com"},
+			Error: "credentials for registry not found: https://unknown.example.com",
+		},
+		{
+			Name:     "current context",
+			Expected: &Target{BaseURL: serverURL, Authorization: "Bearer access-" + host, Insecure: false},
+		},
+		{
+			Name:     "base url and insecure flags over the context",
+			Args:     []string{"--context", "other", "--base-url", serverURL, "--insecure=false"},
+			Expected: &Target{BaseURL: serverURL, Authorization: "Bearer access-" + host, Insecure: false},
+		},
+		{
+			Name:     "context from the environment",
+			Args:     []string{"--base-url", serverURL},
+			Env:      map[string]string{"DOCKIT_CONTEXT": "other"},
+			Expected: &Target{BaseURL: serverURL, Authorization: "Bearer access-" + host, Insecure: true},
+		},
+		{
+			Name:  "revoked refresh token",
+			Args:  []string{"--context", "revoked"},
+			Error: "the login to " + serverURL + " has expired or was revoked, use dockit login",
+		},
+		{
+			Name:  "logged out context",
+			Args:  []string{"--context", "logged-out"},
+			Error: "not logged in to " + serverURL + ", use dockit login",
+		},
+		{
+			Name:  "unknown context",
+			Args:  []string{"--context", "unknown"},
+			Error: "unknown context: unknown",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			for k, v := range c.Env {
+				t.Setenv(k, v)
+			}
+
+			resolved, err := runResolveTarget(t, c.Args...)
+			if c.Error != "" {
+				assert.EqualError(t, err, c.Error)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, c.Expected, resolved)
+		})
+	}
+
+	// without a context the docker credentials are required
+	t.Setenv("DOCKIT_CONFIG", filepath.Join(dir, "missing.json"))
+	_, err = runResolveTarget(t)
+	assert.EqualError(t, err, "credentials for registry not found: ")
+}
+
+func Test_DoRaw(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer dkp_token", r.Header.Get("Authorization"))
+
+		switch r.URL.Path {
+		case "/v2/ok":
+			_, _ = w.Write([]byte("REGISTRY_AUTH=token\n"))
+		case "/v2/denied":
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"success":false,"data":null,"errors":["admin required"]}`))
+		default:
+			w.WriteHeader(http.StatusBadGateway)
+			_, _ = w.Write([]byte("bad gateway"))
+		}
+	}))
+	defer server.Close()
+
+	run := func(path string) ([]byte, error) {
+		var body []byte
+		var err error
+
+		app := &cli.App{
+			Name:  "dockit",
+			Flags: copyFlags(t),
+			Action: func(c *cli.Context) error {
+				body, err = DoRaw(c, "GET", path, nil)
+				return nil
+			},
+		}
+		assert.NoError(t, app.Run([]string{"dockit", "--base-url", server.URL + "/v2", "--token", "dkp_token"}))
+
+		return body, err
+	}
+
+	body, err := run("ok")
+	assert.NoError(t, err)
+	assert.Equal(t, "REGISTRY_AUTH=token\n", string(body))
+
+	_, err = run("denied")
+	assert.Equal(t, &APIError{StatusCode: http.StatusForbidden, Errors: []string{"admin required"}}, err)
+
+	_, err = run("gateway")
+	assert.Equal(t, &APIError{StatusCode: http.StatusBadGateway}, err)
+}
@@ -1,4 +1,4 @@
-package rbac
+package apiclient
 
 import (
 	"fmt"
@@ -15,7 +15,8 @@ var (
 	homeDir       string
 	configFileDir = ".docker"
 
-	rbacFlags = []cli.Flag{
+	// Flags select the api server and how requests are authenticated, see ResolveTarget
+	Flags = []cli.Flag{
 		&cli.StringFlag{
 			Name:    "base-url",
 			Value:   "http://localhost:4315/v2",
@@ -40,6 +41,16 @@ var (
 			Usage:   "manually specify password, otherwise will attempt to retrieve from docker store",
 			EnvVars: []string{"DOCKIT_PASSWORD", "DOCKIT_GRANT_PASSWORD"},
 		},
+		&cli.StringFlag{
+			Name:    "token",
+			Usage:   "personal access token or dockit token with the dockit:admin:* scope, used instead of the username and password",
+			EnvVars: []string{"DOCKIT_TOKEN"},
+		},
+		&cli.StringFlag{
+			Name:    "context",
+			Usage:   "context created by dockit login to use instead of the current context",
+			EnvVars: []string{"DOCKIT_CONTEXT"},
+		},
 	}
 )
 
@@ -0,0 +1,84 @@
+package apiclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/ekristen/dockit/pkg/apiserver/handlers"
+	"github.com/ekristen/dockit/pkg/clientconfig"
+)
+
+// AdminScope is the scope of the tokens for the admin api
+var AdminScope = fmt.Sprintf("%s:%s:*", handlers.AdminScopeType, handlers.AdminScopeName)
+
+// CurrentContext returns the context of the --context flag or the current context
+func CurrentContext(c *cli.Context) (*clientconfig.Context, error) {
+	cfg, err := clientconfig.Load(clientconfig.DefaultPath())
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg.Context(c.String("context"))
+}
+
+// RequestToken performs a token request and returns the response, a non-success response is an error
+func RequestToken(req *http.Request, insecure bool) (*handlers.TokenResponse, error) {
+	resp, err := NewClient(insecure).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, &APIError{StatusCode: resp.StatusCode, Errors: []string{strings.TrimSpace(string(body))}}
+	}
+
+	var token handlers.TokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// refreshAccessToken exchanges the refresh token of the context for a token for the admin api
+func refreshAccessToken(baseURL string, insecure bool, ctx *clientconfig.Context) (string, error) {
+	refreshToken, err := ctx.GetRefreshToken()
+	if err != nil {
+		return "", err
+	}
+	if refreshToken == "" {
+		return "", fmt.Errorf("not logged in to %s, use dockit login", ctx.BaseURL)
+	}
+
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+		"service":       {ctx.Service},
+		"scope":         {AdminScope},
+	}
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/token", baseURL), strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	token, err := RequestToken(req, insecure)
+	if err != nil {
+		if apiErr, ok := err.(*APIError); ok && apiErr.StatusCode == http.StatusUnauthorized {
+			return "", fmt.Errorf("the login to %s has expired or was revoked, use dockit login", ctx.BaseURL)
+		}
+
+		return "", err
+	}
+
+	return token.Token, nil
+}
@@ -7,6 +7,8 @@ import (
 
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
+
+	"github.com/ekristen/dockit/pkg/output"
 )
 
 func Flags() []cli.Flag {
@@ -30,6 +32,13 @@ func Flags() []cli.Flag {
 			Name:  "log-full-timestamp",
 			Usage: "force log output to always show full timestamp",
 		},
+		&cli.StringFlag{
+			Name:    "output",
+			Usage:   "output format (table, json, yaml)",
+			Aliases: []string{"o"},
+			EnvVars: []string{"DOCKIT_OUTPUT"},
+			Value:   output.Table,
+		},
 	}
 
 	return globalFlags
@@ -50,6 +59,12 @@ func Before(c *cli.Context) error {
 
 	logrus.SetFormatter(formatter)
 
+	switch c.String("output") {
+	case output.Table, output.JSON, output.YAML:
+	default:
+		return fmt.Errorf("unsupported output format: %s", c.String("output"))
+	}
+
 	switch c.String("log-level") {
 	case "trace":
 		logrus.SetLevel(logrus.TraceLevel)
@@ -1,11 +1,15 @@
 package initcontainer
 
 import (
+	"bytes"
 	"context"
-	"crypto/tls"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/ekristen/dockit/pkg/common"
 	"github.com/rancher/wrangler/pkg/signals"
@@ -15,42 +19,290 @@ import (
 	"github.com/ekristen/dockit/pkg/commands/global"
 )
 
+// requestTimeout is how long a request for the cert bundle may take on top of the wait of a long-poll
+const requestTimeout = 30 * time.Second
+
+// maxBackoff caps the delay between retries
+const maxBackoff = 30 * time.Second
+
 type initContainerCommand struct{}
 
+// bundle is a fetched cert bundle, NotModified is set when the etag still matches
+type bundle struct {
+	Data        []byte
+	ETag        string
+	NotModified bool
+}
+
+// fetch returns the cert bundle, with an etag the request is conditional and with a wait it is held by
+// the api server until the bundle changes
+func fetch(ctx context.Context, client *http.Client, baseURL string, format string, etag string, wait time.Duration) (*bundle, error) {
+	u := fmt.Sprintf("%s/certs/%s", baseURL, format)
+	if wait > 0 {
+		u = fmt.Sprintf("%s?%s", u, url.Values{"wait": {wait.String()}}.Encode())
+	}
+
+	// a long-poll is held by the api server for up to the wait
+	ctx, cancel := context.WithTimeout(ctx, wait+requestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	logrus.WithField("headers", resp.Header).Debug("Response Headers")
+	logrus.WithField("status", resp.StatusCode).Debug("Response Status Code")
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return &bundle{ETag: etag, NotModified: true}, nil
+	default:
+		return nil, fmt.Errorf("unexpected status fetching cert bundle: %d", resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return &bundle{Data: body, ETag: resp.Header.Get("ETag")}, nil
+}
+
+// fetchWithRetry fetches and verifies the cert bundle, failures are retried with an exponential backoff
+// until the timeout so the init container can start before dockit is ready
+func fetchWithRetry(ctx context.Context, log *logrus.Entry, client *http.Client, c *cli.Context) (*bundle, error) {
+	deadline := time.Now().Add(c.Duration("retry-timeout"))
+	backoff := time.Second
+
+	for {
+		b, err := fetch(ctx, client, c.String("base-url"), c.String("format"), "", 0)
+		if err == nil {
+			err = verify(c.String("format"), b.Data, c.StringSlice("pin-sha256"), time.Now())
+			if err == nil {
+				return b, nil
+			}
+		}
+
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if !time.Now().Add(backoff).Before(deadline) {
+			return nil, err
+		}
+
+		log.WithError(err).WithField("retry-in", backoff).Warn("unable to fetch cert bundle")
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// writeBundle atomically replaces the file with the data when the content differs, it reports whether
+// the file was written and whether it existed before
+func writeBundle(path string, data []byte) (written bool, existed bool, err error) {
+	current, err := ioutil.ReadFile(path)
+	if err == nil {
+		existed = true
+		if bytes.Equal(current, data) {
+			return false, existed, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return false, false, err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return false, existed, err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return false, existed, err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return false, existed, err
+	}
+	if err := tmp.Close(); err != nil {
+		return false, existed, err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return false, existed, err
+	}
+
+	return true, existed, os.Rename(tmp.Name(), path)
+}
+
 func (s *initContainerCommand) Execute(c *cli.Context) (err error) {
 	if c.Args().Len() != 1 {
 		return fmt.Errorf("please specify path to write cert bundle to")
 	}
 
+	if c.String("format") != "pem" && c.String("format") != "jwks" {
+		return fmt.Errorf("invalid format: %s", c.String("format"))
+	}
+
+	reload, err := newReloader(c)
+	if err != nil {
+		return err
+	}
+
 	ctx := signals.SetupSignalHandler(context.Background())
+	log := logrus.WithField("command", "init-container")
+	path := c.Args().First()
 
-	url := fmt.Sprintf("%s/certs/pem", c.String("base-url"))
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	client, err := newHTTPClient(c.Path("ca-file"), c.Bool("insecure"))
 	if err != nil {
 		return err
 	}
 
-	client := &http.Client{Transport: &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true,
-		},
-	}}
-	resp, err := client.Do(req)
+	b, err := fetchWithRetry(ctx, log, client, c)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
 
-	logrus.WithField("headers", resp.Header).Debug("Response Headers")
-	logrus.WithField("status", resp.StatusCode).Debug("Response Status Code")
+	written, existed, err := writeBundle(path, b.Data)
+	if err != nil {
+		return err
+	}
+	log.WithField("path", path).WithField("written", written).Info("cert bundle fetched")
 
-	body, _ := ioutil.ReadAll(resp.Body)
+	if !c.Bool("watch") {
+		return nil
+	}
 
-	if err := ioutil.WriteFile(c.Args().First(), body, 0644); err != nil {
-		return err
+	// when the sidecar starts after the registry with a different bundle on disk the registry has
+	// to be reloaded as well
+	if written && existed && reload != nil {
+		if err := reload.Reload(); err != nil {
+			log.WithError(err).Error("unable to reload")
+		}
 	}
 
-	return nil
+	return watch(ctx, log, client, c, path, b.ETag, reload)
+}
+
+// watch polls for changes of the cert bundle until the context is done, with --long-poll the api server
+// holds the request until the bundle changes. A reload that failed is retried on every poll until it
+// succeeds, the bundle it was for is already written and would not trigger another one.
+func watch(ctx context.Context, log *logrus.Entry, client *http.Client, c *cli.Context, path string, etag string, reload reloader) error {
+	interval := c.Duration("interval")
+
+	pending := false
+	runReload := func() {
+		if err := reload.Reload(); err != nil {
+			log.WithError(err).Error("unable to reload, retrying on the next poll")
+			pending = true
+			return
+		}
+
+		if pending {
+			log.Info("reload succeeded after retrying")
+		}
+		pending = false
+	}
+
+	for {
+		wait := time.Duration(0)
+		if c.Bool("long-poll") && !pending {
+			wait = interval
+		} else {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(interval):
+			}
+		}
+
+		if pending {
+			runReload()
+		}
+
+		b, err := fetch(ctx, client, c.String("base-url"), c.String("format"), etag, wait)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			log.WithError(err).Warn("unable to fetch cert bundle")
+
+			// a failing long-poll returns immediately, wait before retrying
+			if c.Bool("long-poll") {
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-time.After(interval):
+				}
+			}
+			continue
+		}
+
+		if b.NotModified {
+			continue
+		}
+
+		// a bad bundle is never written, the next change is picked up again
+		if err := verify(c.String("format"), b.Data, c.StringSlice("pin-sha256"), time.Now()); err != nil {
+			log.WithError(err).Error("refusing to write cert bundle")
+			if !c.Bool("long-poll") {
+				continue
+			}
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(interval):
+			}
+			continue
+		}
+
+		// the etag is only kept once the bundle is on disk, a failed write is retried on the next poll
+		written, _, err := writeBundle(path, b.Data)
+		if err != nil {
+			log.WithError(err).Error("unable to write cert bundle")
+			if !c.Bool("long-poll") {
+				continue
+			}
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(interval):
+			}
+			continue
+		}
+		etag = b.ETag
+
+		if !written {
+			continue
+		}
+
+		log.WithField("path", path).Info("cert bundle changed")
+
+		if reload != nil {
+			runReload()
+		}
+	}
 }
 
 func init() {
@@ -62,6 +314,74 @@ func init() {
 			Value:   "http://localhost:4315/v2",
 			EnvVars: []string{"DOCKIT_BASE_URL", "DOCKIT_INITCONTAINER_BASE_URL"},
 		},
+		&cli.StringFlag{
+			Name:    "format",
+			Usage:   "pem for the cert bundle or jwks for a json web key set",
+			EnvVars: []string{"DOCKIT_INITCONTAINER_FORMAT"},
+			Value:   "pem",
+		},
+		&cli.PathFlag{
+			Name:    "ca-file",
+			Usage:   "pem file with additional certificate authorities to verify the api server with",
+			EnvVars: []string{"DOCKIT_INITCONTAINER_CA_FILE"},
+		},
+		&cli.BoolFlag{
+			Name:    "insecure",
+			Usage:   "skip the verification of the tls certificate of the api server",
+			EnvVars: []string{"DOCKIT_INITCONTAINER_INSECURE"},
+		},
+		&cli.DurationFlag{
+			Name:    "retry-timeout",
+			Usage:   "how long to retry fetching the cert bundle while dockit is not ready",
+			EnvVars: []string{"DOCKIT_INITCONTAINER_RETRY_TIMEOUT"},
+			Value:   5 * time.Minute,
+		},
+		&cli.StringSliceFlag{
+			Name:    "pin-sha256",
+			Usage:   "sha256 fingerprint of a trusted signing certificate, every certificate in the bundle has to match one or be signed by one, can be specified multiple times",
+			EnvVars: []string{"DOCKIT_INITCONTAINER_PIN_SHA256"},
+		},
+		&cli.BoolFlag{
+			Name:    "watch",
+			Usage:   "keep running as a sidecar and rewrite the cert bundle when it changes",
+			EnvVars: []string{"DOCKIT_INITCONTAINER_WATCH"},
+		},
+		&cli.DurationFlag{
+			Name:    "interval",
+			Usage:   "how often to check for changes, or how long a long-poll waits",
+			EnvVars: []string{"DOCKIT_INITCONTAINER_INTERVAL"},
+			Value:   30 * time.Second,
+		},
+		&cli.BoolFlag{
+			Name:    "long-poll",
+			Usage:   "hold each request on the api server until the cert bundle changes instead of polling",
+			EnvVars: []string{"DOCKIT_INITCONTAINER_LONG_POLL"},
+		},
+		&cli.StringFlag{
+			Name:    "reload-signal",
+			Usage:   "signal to send after the cert bundle changed, for example HUP or TERM, requires --reload-pid-file or --reload-process",
+			EnvVars: []string{"DOCKIT_INITCONTAINER_RELOAD_SIGNAL"},
+		},
+		&cli.PathFlag{
+			Name:    "reload-pid-file",
+			Usage:   "file with the pid of the process to signal",
+			EnvVars: []string{"DOCKIT_INITCONTAINER_RELOAD_PID_FILE"},
+		},
+		&cli.StringFlag{
+			Name:    "reload-process",
+			Usage:   "name of the process to signal, requires a shared process namespace",
+			EnvVars: []string{"DOCKIT_INITCONTAINER_RELOAD_PROCESS"},
+		},
+		&cli.StringFlag{
+			Name:    "reload-command",
+			Usage:   "command to run with sh -c after the cert bundle changed",
+			EnvVars: []string{"DOCKIT_INITCONTAINER_RELOAD_COMMAND"},
+		},
+		&cli.PathFlag{
+			Name:    "reload-touch",
+			Usage:   "file to touch after the cert bundle changed",
+			EnvVars: []string{"DOCKIT_INITCONTAINER_RELOAD_TOUCH"},
+		},
 	}
 
 	cliCmd := &cli.Command{
@@ -0,0 +1,288 @@
+package initcontainer
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli/v2"
+
+	"github.com/ekristen/dockit/pkg/utils"
+)
+
+func Test_WriteBundle(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "certs.pem")
+
+	written, existed, err := writeBundle(path, []byte("one"))
+	assert.NoError(t, err)
+	assert.True(t, written)
+	assert.False(t, existed)
+
+	written, existed, err = writeBundle(path, []byte("one"))
+	assert.NoError(t, err)
+	assert.False(t, written)
+	assert.True(t, existed)
+
+	written, _, err = writeBundle(path, []byte("two"))
+	assert.NoError(t, err)
+	assert.True(t, written)
+
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "two", string(data))
+
+	matches, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "*.tmp*"))
+	assert.Empty(t, matches)
+}
+
+func Test_Fetch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"abc"`)
+		if r.Header.Get("If-None-Match") == `"abc"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		_, _ = w.Write([]byte("bundle"))
+	}))
+	defer server.Close()
+
+	b, err := fetch(context.Background(), server.Client(), server.URL, "pem", "", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "bundle", string(b.Data))
+	assert.Equal(t, `"abc"`, b.ETag)
+
+	b, err = fetch(context.Background(), server.Client(), server.URL, "pem", b.ETag, 0)
+	assert.NoError(t, err)
+	assert.True(t, b.NotModified)
+}
+
+func testCert(t *testing.T, cn string, notAfter time.Time, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              notAfter,
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	assert.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+
+	return cert, key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func Test_VerifyBundle(t *testing.T) {
+	now := time.Now()
+
+	ca, caKey, caPEM := testCert(t, "ca", now.Add(time.Hour), nil, nil)
+	_, _, leafPEM := testCert(t, "leaf", now.Add(time.Hour), ca, caKey)
+	_, _, expiredPEM := testCert(t, "expired", now.Add(-time.Minute), nil, nil)
+	_, _, otherPEM := testCert(t, "other", now.Add(time.Hour), nil, nil)
+
+	certs, err := verifyBundle(append(caPEM, leafPEM...), nil, now)
+	assert.NoError(t, err)
+	assert.Len(t, certs, 2)
+
+	_, err = verifyBundle([]byte("\n"), nil, now)
+	assert.Equal(t, ErrEmptyBundle, err)
+
+	_, err = verifyBundle(append(caPEM, []byte("garbage")...), nil, now)
+	assert.Error(t, err)
+
+	_, err = verifyBundle(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("key")}), nil, now)
+	assert.Error(t, err)
+
+	_, err = verifyBundle(expiredPEM, nil, now)
+	assert.Error(t, err)
+
+	// an expired certificate next to a valid one is kept for tokens still in flight
+	_, err = verifyBundle(append(expiredPEM, caPEM...), nil, now)
+	assert.NoError(t, err)
+
+	pin := strings.ToUpper(fingerprint(ca))
+	_, err = verifyBundle(append(caPEM, leafPEM...), []string{pin}, now)
+	assert.NoError(t, err)
+
+	_, err = verifyBundle(append(caPEM, otherPEM...), []string{pin}, now)
+	assert.Error(t, err)
+
+	_, err = verifyBundle(leafPEM, []string{pin}, now)
+	assert.Error(t, err)
+}
+
+func Test_VerifyJWKS(t *testing.T) {
+	now := time.Now()
+
+	ca, _, _ := testCert(t, "ca", now.Add(time.Hour), nil, nil)
+
+	jwk, err := utils.NewJWK(ca)
+	assert.NoError(t, err)
+
+	data, err := json.Marshal(utils.JWKS{Keys: []*utils.JWK{jwk}})
+	assert.NoError(t, err)
+	assert.NoError(t, verify("jwks", data, []string{fingerprint(ca)}, now))
+
+	empty, _ := json.Marshal(utils.JWKS{})
+	assert.Equal(t, ErrEmptyBundle, verify("jwks", empty, nil, now))
+
+	jwk.X5C = nil
+	data, _ = json.Marshal(utils.JWKS{Keys: []*utils.JWK{jwk}})
+	assert.Error(t, verify("jwks", data, nil, now))
+
+}
+
+func Test_FetchUnexpectedStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	_, err := fetch(context.Background(), server.Client(), server.URL, "pem", "", 0)
+	assert.Error(t, err)
+}
+
+// failingReloader fails the first reloads
+type failingReloader struct {
+	failures int
+	calls    chan int
+	count    int
+}
+
+func (r *failingReloader) Reload() error {
+	r.count++
+	r.calls <- r.count
+	if r.count <= r.failures {
+		return errors.New("reload failed")
+	}
+
+	return nil
+}
+
+func Test_WatchRetriesReload(t *testing.T) {
+	_, _, certPEM := testCert(t, "signing", time.Now().Add(time.Hour), nil, nil)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v2"`)
+		if r.Header.Get("If-None-Match") == `"v2"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		_, _ = w.Write(certPEM)
+	}))
+	defer server.Close()
+
+	set := flag.NewFlagSet("watch", flag.ContinueOnError)
+	set.String("base-url", server.URL, "")
+	set.String("format", "pem", "")
+	set.Duration("interval", 10*time.Millisecond, "")
+	set.Bool("long-poll", false, "")
+	c := cli.NewContext(cli.NewApp(), set, nil)
+
+	path := filepath.Join(t.TempDir(), "certs.pem")
+	reload := &failingReloader{failures: 2, calls: make(chan int, 10)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- watch(ctx, logrus.WithField("test", t.Name()), server.Client(), c, path, `"v1"`, reload)
+	}()
+
+	// the bundle changes once, the reload is retried on the following polls until it succeeds
+	for i := 1; i <= 3; i++ {
+		select {
+		case n := <-reload.calls:
+			assert.Equal(t, i, n)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("reload %d was not retried", i)
+		}
+	}
+
+	// nothing is left to retry
+	select {
+	case <-reload.calls:
+		t.Fatal("reloaded after the reload succeeded")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	cancel()
+	assert.NoError(t, <-done)
+
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, certPEM, data)
+}
+
+func Test_WatchRetriesWrite(t *testing.T) {
+	_, _, certPEM := testCert(t, "signing", time.Now().Add(time.Hour), nil, nil)
+
+	// the directory of the bundle is missing, the first write fails
+	dir := filepath.Join(t.TempDir(), "certs")
+	path := filepath.Join(dir, "certs.pem")
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 2 {
+			assert.NoError(t, os.Mkdir(dir, 0755))
+		}
+
+		w.Header().Set("ETag", `"v2"`)
+		if r.Header.Get("If-None-Match") == `"v2"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		_, _ = w.Write(certPEM)
+	}))
+	defer server.Close()
+
+	set := flag.NewFlagSet("watch", flag.ContinueOnError)
+	set.String("base-url", server.URL, "")
+	set.String("format", "pem", "")
+	set.Duration("interval", 10*time.Millisecond, "")
+	set.Bool("long-poll", false, "")
+	c := cli.NewContext(cli.NewApp(), set, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- watch(ctx, logrus.WithField("test", t.Name()), server.Client(), c, path, `"v1"`, nil)
+	}()
+
+	assert.Eventually(t, func() bool {
+		data, err := ioutil.ReadFile(path)
+		return err == nil && bytes.Equal(certPEM, data)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	assert.NoError(t, <-done)
+}
@@ -0,0 +1,182 @@
+package initcontainer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+var reloadSignals = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"TERM": syscall.SIGTERM,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+	"KILL": syscall.SIGKILL,
+}
+
+// reloader is run after the cert bundle changed
+type reloader interface {
+	Reload() error
+}
+
+// reloaders runs every configured reload action
+type reloaders []reloader
+
+func (r reloaders) Reload() error {
+	for _, reload := range r {
+		if err := reload.Reload(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// signalReloader sends a signal to the process in a pid file or to every process with a name
+type signalReloader struct {
+	signal  syscall.Signal
+	pidFile string
+	process string
+}
+
+func (r *signalReloader) Reload() error {
+	var pids []int
+
+	if r.pidFile != "" {
+		data, err := ioutil.ReadFile(r.pidFile)
+		if err != nil {
+			return err
+		}
+
+		pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err != nil {
+			return fmt.Errorf("invalid pid file %s: %w", r.pidFile, err)
+		}
+
+		pids = append(pids, pid)
+	} else {
+		var err error
+		pids, err = findProcesses(r.process)
+		if err != nil {
+			return err
+		}
+		if len(pids) == 0 {
+			return fmt.Errorf("no process named %s", r.process)
+		}
+	}
+
+	for _, pid := range pids {
+		if err := syscall.Kill(pid, r.signal); err != nil {
+			return fmt.Errorf("unable to signal %d: %w", pid, err)
+		}
+	}
+
+	return nil
+}
+
+// findProcesses returns the pids of the processes with the name from /proc
+func findProcesses(name string) ([]int, error) {
+	matches, err := filepath.Glob("/proc/[0-9]*/comm")
+	if err != nil {
+		return nil, err
+	}
+
+	self := os.Getpid()
+
+	var pids []int
+	for _, m := range matches {
+		comm, err := ioutil.ReadFile(m)
+		if err != nil || strings.TrimSpace(string(comm)) != name {
+			continue
+		}
+
+		pid, err := strconv.Atoi(filepath.Base(filepath.Dir(m)))
+		if err != nil || pid == self {
+			continue
+		}
+
+		pids = append(pids, pid)
+	}
+
+	return pids, nil
+}
+
+// commandReloader runs a command with sh -c
+type commandReloader struct {
+	command string
+}
+
+func (r *commandReloader) Reload() error {
+	cmd := exec.Command("sh", "-c", r.command)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
+
+// touchReloader updates the modification time of a file, the file is created if it does not exist
+type touchReloader struct {
+	path string
+}
+
+func (r *touchReloader) Reload() error {
+	now := time.Now()
+	if err := os.Chtimes(r.path, now, now); err == nil || !os.IsNotExist(err) {
+		return err
+	}
+
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+// newReloader returns the reload actions configured with the flags, nil when there are none
+func newReloader(c *cli.Context) (reloader, error) {
+	var r reloaders
+
+	if name := c.String("reload-signal"); name != "" {
+		sig, ok := reloadSignals[strings.TrimPrefix(strings.ToUpper(name), "SIG")]
+		if !ok {
+			return nil, fmt.Errorf("unsupported reload-signal: %s", name)
+		}
+
+		if (c.Path("reload-pid-file") == "") == (c.String("reload-process") == "") {
+			return nil, fmt.Errorf("reload-signal requires either reload-pid-file or reload-process")
+		}
+
+		r = append(r, &signalReloader{signal: sig, pidFile: c.Path("reload-pid-file"), process: c.String("reload-process")})
+	} else if c.Path("reload-pid-file") != "" || c.String("reload-process") != "" {
+		return nil, fmt.Errorf("reload-pid-file and reload-process require reload-signal")
+	}
+
+	if command := c.String("reload-command"); command != "" {
+		r = append(r, &commandReloader{command: command})
+	}
+
+	if path := c.Path("reload-touch"); path != "" {
+		r = append(r, &touchReloader{path: path})
+	}
+
+	if len(r) == 0 {
+		return nil, nil
+	}
+
+	if !c.Bool("watch") {
+		return nil, fmt.Errorf("reload actions require --watch")
+	}
+
+	return r, nil
+}
@@ -0,0 +1,175 @@
+package initcontainer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ekristen/dockit/pkg/utils"
+)
+
+// ErrEmptyBundle is returned for a bundle without any certificate
+var ErrEmptyBundle = errors.New("cert bundle is empty")
+
+// newHTTPClient returns a client that verifies the api server with the system roots and the ca file
+func newHTTPClient(caFile string, insecure bool) (*http.Client, error) {
+	config := &tls.Config{InsecureSkipVerify: insecure}
+
+	if caFile != "" {
+		data, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in ca file %s", caFile)
+		}
+
+		config.RootCAs = pool
+	}
+
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: config}}, nil
+}
+
+// fingerprint returns the hex encoded sha256 of a certificate
+func fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// normalizeFingerprint lowercases a fingerprint and removes the colons of the openssl format
+func normalizeFingerprint(fp string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(fp), ":", ""))
+}
+
+// verifyBundle parses the bundle, it has to consist of pem certificates only and at least one of them has to
+// be valid now. With pins every certificate has to match a pin or be signed by a certificate in the bundle
+// that matches one.
+func verifyBundle(data []byte, pins []string, now time.Time) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+
+	rest := data
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("unexpected pem block in cert bundle: %s", block.Type)
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate in cert bundle: %w", err)
+		}
+
+		certs = append(certs, cert)
+	}
+
+	if len(bytes.TrimSpace(rest)) > 0 {
+		return nil, fmt.Errorf("cert bundle contains data that is not pem")
+	}
+	if len(certs) == 0 {
+		return nil, ErrEmptyBundle
+	}
+
+	valid := false
+	for _, cert := range certs {
+		if !now.Before(cert.NotBefore) && now.Before(cert.NotAfter) {
+			valid = true
+		}
+	}
+	if !valid {
+		return nil, fmt.Errorf("every certificate in the cert bundle is expired or not yet valid")
+	}
+
+	if len(pins) == 0 {
+		return certs, nil
+	}
+
+	pinned := map[string]bool{}
+	for _, pin := range pins {
+		pinned[normalizeFingerprint(pin)] = true
+	}
+
+	var signers []*x509.Certificate
+	for _, cert := range certs {
+		if pinned[fingerprint(cert)] {
+			signers = append(signers, cert)
+		}
+	}
+
+	for _, cert := range certs {
+		if pinned[fingerprint(cert)] {
+			continue
+		}
+
+		signed := false
+		for _, signer := range signers {
+			if cert.CheckSignatureFrom(signer) == nil {
+				signed = true
+				break
+			}
+		}
+		if !signed {
+			return nil, fmt.Errorf("certificate %s with fingerprint %s is not pinned", cert.Subject, fingerprint(cert))
+		}
+	}
+
+	return certs, nil
+}
+
+// jwksCertificates returns the x5c certificates of a json web key set as pem, every key has to have one
+func jwksCertificates(data []byte) ([]byte, error) {
+	var jwks utils.JWKS
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, fmt.Errorf("invalid json web key set: %w", err)
+	}
+
+	var buf bytes.Buffer
+	for _, key := range jwks.Keys {
+		if len(key.X5C) == 0 {
+			return nil, fmt.Errorf("key %s in json web key set has no certificate", key.KeyID)
+		}
+
+		der, err := base64.StdEncoding.DecodeString(key.X5C[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate of key %s: %w", key.KeyID, err)
+		}
+
+		if err := pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: der}); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// verify verifies a fetched bundle in the format, a json web key set is verified by its certificates
+func verify(format string, data []byte, pins []string, now time.Time) error {
+	if format == "jwks" {
+		var err error
+		if data, err = jwksCertificates(data); err != nil {
+			return err
+		}
+	}
+
+	_, err := verifyBundle(data, pins, now)
+	return err
+}
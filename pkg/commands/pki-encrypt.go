@@ -0,0 +1,214 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/bwmarrin/snowflake"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+	"gorm.io/gorm"
+
+	"github.com/ekristen/dockit/pkg/commands/global"
+	"github.com/ekristen/dockit/pkg/common"
+	"github.com/ekristen/dockit/pkg/db"
+	"github.com/ekristen/dockit/pkg/envelope"
+	"github.com/ekristen/dockit/pkg/output"
+)
+
+// keyringFlags are the flags for the key encryption key of the pki private keys
+func keyringFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "pki-kek",
+			Usage:   "base64 encoded 32 byte key to encrypt the pki private keys with (openssl rand -base64 32)",
+			EnvVars: []string{"DOCKIT_PKI_KEK", "PKI_KEK"},
+		},
+		&cli.PathFlag{
+			Name:    "pki-kek-file",
+			Usage:   "file with the base64 encoded 32 byte key to encrypt the pki private keys with",
+			EnvVars: []string{"DOCKIT_PKI_KEK_FILE", "PKI_KEK_FILE"},
+		},
+		&cli.StringFlag{
+			Name:    "pki-kms",
+			Usage:   "url of a kms to encrypt the pki private keys with instead of a local key",
+			EnvVars: []string{"DOCKIT_PKI_KMS", "PKI_KMS"},
+		},
+	}
+}
+
+// newKeyring returns the keyring configured with the flags, nil when private keys are not encrypted
+func newKeyring(c *cli.Context) (*envelope.Keyring, error) {
+	set := 0
+	for _, name := range []string{"pki-kek", "pki-kek-file", "pki-kms"} {
+		if c.String(name) != "" {
+			set++
+		}
+	}
+	if set > 1 {
+		return nil, fmt.Errorf("only one of pki-kek, pki-kek-file and pki-kms can be specified")
+	}
+
+	var kms envelope.KMS
+	var err error
+
+	switch {
+	case c.String("pki-kms") != "":
+		kms, err = envelope.NewKMS(c.String("pki-kms"))
+	case c.String("pki-kek") != "" || c.Path("pki-kek-file") != "":
+		data := c.String("pki-kek")
+		if c.Path("pki-kek-file") != "" {
+			raw, err := ioutil.ReadFile(c.Path("pki-kek-file"))
+			if err != nil {
+				return nil, err
+			}
+			data = string(raw)
+		}
+
+		key, err := envelope.ParseKey(data)
+		if err != nil {
+			return nil, err
+		}
+
+		kms, err = envelope.NewLocalKMS(key)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return envelope.New(kms), nil
+}
+
+// checkPrivateKeys makes sure every unexpired private key can be read with the keyring
+func checkPrivateKeys(ctx context.Context, log *logrus.Entry, database *gorm.DB, keyring *envelope.Keyring) error {
+	var pki []db.PKI
+	if sql := database.Where("expires_at > ?", time.Now().UTC()).Find(&pki); sql.Error != nil {
+		return sql.Error
+	}
+
+	plaintext, unbound := 0, 0
+	for _, p := range pki {
+		if p.Private == "" {
+			continue
+		}
+		if _, err := keyring.Decrypt(ctx, p.ID, p.Private); err != nil {
+			return fmt.Errorf("unable to read the private key of pki %d: %w", p.ID, err)
+		}
+		if !envelope.IsEncrypted(p.Private) {
+			plaintext++
+		} else if !envelope.IsBound(p.Private) {
+			unbound++
+		}
+	}
+
+	if keyring != nil && plaintext > 0 {
+		log.WithField("count", plaintext).Warn("pki private keys are stored unencrypted, run dockit pki-encrypt")
+	}
+	if unbound > 0 {
+		log.WithField("count", unbound).Warn("pki private keys are encrypted without their pki id, run dockit pki-encrypt")
+	}
+
+	return nil
+}
+
+type pkiEncryptCommand struct{}
+
+func (s *pkiEncryptCommand) Execute(c *cli.Context) error {
+	keyring, err := newKeyring(c)
+	if err != nil {
+		return err
+	}
+	if keyring == nil {
+		return fmt.Errorf("one of pki-kek, pki-kek-file or pki-kms is required")
+	}
+
+	node, err := snowflake.NewNode(1)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.WithValue(context.Background(), common.ContextKeyNode, node)
+
+	database, err := db.New(ctx, c.String("sql-dialect"), c.String("sql-dsn"), &gorm.Config{
+		Logger: db.NewLogger(c.String("log-level")),
+	})
+	if err != nil {
+		return err
+	}
+
+	var pki []db.PKI
+	if sql := database.Find(&pki); sql.Error != nil {
+		return sql.Error
+	}
+
+	encrypted := []int64{}
+	for _, p := range pki {
+		if p.Private == "" || envelope.IsBound(p.Private) {
+			continue
+		}
+
+		// keys encrypted before the pki id was authenticated are encrypted again with it
+		keyPEM, err := keyring.Decrypt(ctx, p.ID, p.Private)
+		if err != nil {
+			return fmt.Errorf("unable to read the private key of pki %d: %w", p.ID, err)
+		}
+
+		stored, err := keyring.Encrypt(ctx, p.ID, keyPEM)
+		if err != nil {
+			return err
+		}
+
+		// the update is conditional so a key changed in the meantime is not overwritten
+		sql := database.Model(&db.PKI{}).Where("id = ? AND private = ?", p.ID, p.Private).UpdateColumn("private", stored)
+		if sql.Error != nil {
+			return sql.Error
+		}
+		if sql.RowsAffected == 1 {
+			encrypted = append(encrypted, p.ID)
+		}
+	}
+
+	rows := output.Rows{{"ID"}}
+	for _, id := range encrypted {
+		rows = append(rows, []string{fmt.Sprintf("%d", id)})
+	}
+
+	return output.Print(os.Stdout, c.String("output"), map[string]interface{}{"encrypted": encrypted, "key_id": keyring.KeyID()}, rows)
+}
+
+func init() {
+	cmd := pkiEncryptCommand{}
+
+	flags := append([]cli.Flag{
+		&cli.StringFlag{
+			Name:    "sql-dialect",
+			Usage:   "The type of sql to use, sqlite or mysql",
+			EnvVars: []string{"DOCKIT_SQL_DIALECT", "SQL_DIALECT"},
+			Value:   "sqlite",
+		},
+		&cli.StringFlag{
+			Name:    "sql-dsn",
+			Usage:   "The DSN to use to connect to",
+			EnvVars: []string{"DOCKIT_SQL_DSN", "SQL_DSN"},
+			Value:   "file:dockit.sqlite",
+		},
+	}, keyringFlags()...)
+
+	cliCmd := &cli.Command{
+		Name:   "pki-encrypt",
+		Usage:  "encrypt the pki private keys stored in the database that are not encrypted yet or not bound to their pki id",
+		Action: cmd.Execute,
+		Flags:  append(flags, global.Flags()...),
+		Before: global.Before,
+	}
+
+	common.RegisterCommand(cliCmd)
+}
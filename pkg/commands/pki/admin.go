@@ -0,0 +1,247 @@
+package pki
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/ekristen/dockit/pkg/apiserver/handlers"
+	"github.com/ekristen/dockit/pkg/apiserver/response"
+	"github.com/ekristen/dockit/pkg/commands/apiclient"
+	"github.com/ekristen/dockit/pkg/commands/global"
+	"github.com/ekristen/dockit/pkg/common"
+	"github.com/ekristen/dockit/pkg/output"
+)
+
+type pkiAdminCommand struct{}
+
+func (s *pkiAdminCommand) Execute(c *cli.Context) (err error) {
+	switch c.Command.Name {
+	case "list":
+		res, err := apiclient.DoRequest(c, "GET", "admin/pki", nil)
+		if err != nil {
+			return err
+		}
+
+		var pki []handlers.PKIInfo
+		if err := apiclient.DecodeData(res, &pki); err != nil {
+			return err
+		}
+
+		rows := output.Rows{{"ID", "STATE", "ALGORITHM", "SUBJECT", "FINGERPRINT", "EXPIRES", "ACTIVATES"}}
+		for _, p := range pki {
+			algorithm := p.Algorithm
+			if p.CA {
+				algorithm = fmt.Sprintf("ca %s", p.Type)
+			}
+
+			rows = append(rows, []string{strconv.FormatInt(p.ID, 10), p.State, algorithm, p.Subject, p.Fingerprint, optionalTime(p.ExpiresAt), optionalTime(p.ActivateAt)})
+		}
+
+		return output.Print(os.Stdout, c.String("output"), res.Data, rows)
+	case "show":
+		if c.Args().Len() != 1 {
+			return fmt.Errorf("usage: %s <id>", c.Command.Name)
+		}
+
+		res, err := apiclient.DoRequest(c, "GET", fmt.Sprintf("admin/pki/%s", c.Args().First()), nil)
+		if err != nil {
+			return err
+		}
+
+		return printPKI(c, res)
+	case "import", "generate", "rotate":
+		newPKI := handlers.NewPKI{
+			CA:       c.Bool("ca"),
+			Activate: c.Bool("activate"),
+		}
+		if c.IsSet("activate-in") {
+			at := time.Now().UTC().Add(c.Duration("activate-in"))
+			newPKI.ActivateAt = &at
+		}
+
+		if c.Command.Name == "import" {
+			if c.Args().Len() != 1 {
+				return fmt.Errorf("usage: %s <file>", c.Command.Name)
+			}
+
+			data, err := ioutil.ReadFile(c.Args().First())
+			if err != nil {
+				return err
+			}
+			newPKI.PEM = string(data)
+		}
+
+		data, err := json.Marshal(newPKI)
+		if err != nil {
+			return err
+		}
+
+		res, err := apiclient.DoRequest(c, "POST", fmt.Sprintf("admin/pki/%s", c.Command.Name), data)
+		if err != nil {
+			return err
+		}
+
+		return printPKI(c, res)
+	case "activate", "deactivate":
+		if c.Args().Len() != 1 {
+			return fmt.Errorf("usage: %s <id>", c.Command.Name)
+		}
+
+		res, err := apiclient.DoRequest(c, "PUT", fmt.Sprintf("admin/pki/%s/%s", c.Args().First(), c.Command.Name), nil)
+		if err != nil {
+			return err
+		}
+
+		return printPKI(c, res)
+	}
+
+	return nil
+}
+
+// printPKI prints a single pki, with --pem followed by its certificate and chain
+func printPKI(c *cli.Context, res *response.Response) error {
+	var p handlers.PKIInfo
+	if err := apiclient.DecodeData(res, &p); err != nil {
+		return err
+	}
+
+	rows := output.Rows{
+		{"ID", strconv.FormatInt(p.ID, 10)},
+		{"STATE", p.State},
+		{"CA", strconv.FormatBool(p.CA)},
+		{"TYPE", fmt.Sprintf("%s %d", p.Type, p.Bits)},
+		{"ALGORITHM", p.Algorithm},
+		{"SIGNER", p.Signer},
+		{"SUBJECT", p.Subject},
+		{"ISSUER", p.Issuer},
+		{"FINGERPRINT", p.Fingerprint},
+		{"NOT BEFORE", optionalTime(p.NotBefore)},
+		{"EXPIRES", optionalTime(p.ExpiresAt)},
+		{"ACTIVATES", optionalTime(p.ActivateAt)},
+		{"CREATED", optionalTime(p.CreatedAt)},
+	}
+	if err := output.Print(os.Stdout, c.String("output"), res.Data, rows); err != nil {
+		return err
+	}
+
+	// the other formats always include the certificate and chain
+	if c.Bool("pem") && c.String("output") == output.Table {
+		fmt.Printf("\n%s%s", p.Certificate, p.Chain)
+	}
+
+	return nil
+}
+
+// optionalTime formats a time that is not always set
+func optionalTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+
+	return t.Local().Format(time.RFC3339)
+}
+
+func init() {
+	cmd := pkiAdminCommand{}
+
+	activateFlags := []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "activate",
+			Usage: "sign tokens with the pki right away, otherwise it is published first and activated after --activate-in",
+		},
+		&cli.DurationFlag{
+			Name:  "activate-in",
+			Usage: "how long the pki is published before it is activated, defaults to the pki-prepublish of the api server",
+		},
+	}
+	pemFlag := &cli.BoolFlag{
+		Name:  "pem",
+		Usage: "print the certificate and chain",
+	}
+
+	listCmd := &cli.Command{
+		Name:   "list",
+		Usage:  "list the signing pki and cas with their fingerprints and expiry dates",
+		Action: cmd.Execute,
+		Flags:  append(apiclient.Flags, global.Flags()...),
+		Before: global.Before,
+	}
+
+	showCmd := &cli.Command{
+		Name:      "show",
+		Usage:     "show a pki",
+		ArgsUsage: "<id>",
+		Action:    cmd.Execute,
+		Flags:     append([]cli.Flag{pemFlag}, append(apiclient.Flags, global.Flags()...)...),
+		Before:    global.Before,
+	}
+
+	importCmd := &cli.Command{
+		Name:      "import",
+		Usage:     "import a pem file with a certificate, its private key and the chain of its ca as signing pki or ca",
+		ArgsUsage: "<file>",
+		Action:    cmd.Execute,
+		Flags: append(append([]cli.Flag{
+			&cli.BoolFlag{
+				Name:  "ca",
+				Usage: "import the ca signing certificates are issued from on the next rotation",
+			},
+			pemFlag,
+		}, activateFlags...), append(apiclient.Flags, global.Flags()...)...),
+		Before: global.Before,
+	}
+
+	generateCmd := &cli.Command{
+		Name:   "generate",
+		Usage:  "generate a signing pki with the key type of the api server",
+		Action: cmd.Execute,
+		Flags:  append(append([]cli.Flag{pemFlag}, activateFlags...), append(apiclient.Flags, global.Flags()...)...),
+		Before: global.Before,
+	}
+
+	activateCmd := &cli.Command{
+		Name:      "activate",
+		Usage:     "sign tokens with a pki right away, the active one is deactivated",
+		ArgsUsage: "<id>",
+		Action:    cmd.Execute,
+		Flags:     append([]cli.Flag{pemFlag}, append(apiclient.Flags, global.Flags()...)...),
+		Before:    global.Before,
+	}
+
+	deactivateCmd := &cli.Command{
+		Name:      "deactivate",
+		Usage:     "deactivate a ca or cancel the activation of a pending pki",
+		ArgsUsage: "<id>",
+		Action:    cmd.Execute,
+		Flags:     append([]cli.Flag{pemFlag}, append(apiclient.Flags, global.Flags()...)...),
+		Before:    global.Before,
+	}
+
+	rotateCmd := &cli.Command{
+		Name:   "rotate",
+		Usage:  "activate the pending pki right away, or generate one when there is none that is activated after the prepublish duration of the api server",
+		Action: cmd.Execute,
+		Flags: append([]cli.Flag{
+			&cli.BoolFlag{
+				Name:  "activate",
+				Usage: "activate a generated pki right away, registries that did not pick up its certificate reject the tokens signed with it",
+			},
+			pemFlag,
+		}, append(apiclient.Flags, global.Flags()...)...),
+		Before: global.Before,
+	}
+
+	common.RegisterSubcommand("pki", listCmd)
+	common.RegisterSubcommand("pki", showCmd)
+	common.RegisterSubcommand("pki", importCmd)
+	common.RegisterSubcommand("pki", generateCmd)
+	common.RegisterSubcommand("pki", activateCmd)
+	common.RegisterSubcommand("pki", deactivateCmd)
+	common.RegisterSubcommand("pki", rotateCmd)
+}
@@ -19,15 +19,21 @@ func (s *pkiCommand) Execute(c *cli.Context) (err error) {
 	var pub crypto.PublicKey
 	var key crypto.PrivateKey
 
-	if c.String("key-type") == "ec" {
-		key1, pem1, err := utils.GenerateECKey(c.Int("key-size"))
+	switch c.String("key-type") {
+	case "ec":
+		size := c.Int("key-size")
+		if !c.IsSet("key-size") {
+			size = 256
+		}
+
+		key1, pem1, err := utils.GenerateECKey(size)
 		if err != nil {
 			return err
 		}
 		pem = pem1
 		pub = &key1.PublicKey
 		key = key1
-	} else if c.String("key-type") == "rsa" {
+	case "rsa":
 		key1, pem1, err := utils.GenerateRSAKey(c.Int("key-size"))
 		if err != nil {
 			return err
@@ -35,6 +41,21 @@ func (s *pkiCommand) Execute(c *cli.Context) (err error) {
 		pem = pem1
 		pub = &key1.PublicKey
 		key = key1
+	case "ed25519":
+		key1, pem1, err := utils.GenerateEd25519Key()
+		if err != nil {
+			return err
+		}
+		pem = pem1
+		pub = key1.Public()
+		key = key1
+	default:
+		return fmt.Errorf("invalid key-type: %s", c.String("key-type"))
+	}
+
+	subject, err := utils.ParseSubject(c.String("subject"))
+	if err != nil {
+		return err
 	}
 
 	node, err := snowflake.NewNode(1)
@@ -42,7 +63,7 @@ func (s *pkiCommand) Execute(c *cli.Context) (err error) {
 		return err
 	}
 
-	_, certPem, err := utils.GenerateCertificate(node.Generate().Int64(), pub, key, c.Int("years"), c.Int("months"), 0)
+	_, certPem, err := utils.GenerateCertificate(node.Generate().Int64(), subject, pub, key, c.Int("years"), c.Int("months"), 0)
 	if err != nil {
 		return err
 	}
@@ -60,8 +81,19 @@ func init() {
 	flags := []cli.Flag{
 		&cli.StringFlag{
 			Name:  "key-type",
+			Usage: "Type of the private key, ec, rsa or ed25519",
 			Value: "rsa",
 		},
+		&cli.IntFlag{
+			Name:  "key-size",
+			Usage: "Curve size of an ec key, 256, 384 or 521, or bits of an rsa key, 2048, 3072 or 4096, defaults to 256 for ec",
+			Value: 4096,
+		},
+		&cli.StringFlag{
+			Name:  "subject",
+			Usage: "Subject of the certificate, for example /C=US/O=example/CN=dockit",
+			Value: utils.DefaultSubject,
+		},
 		&cli.IntFlag{
 			Name:  "years",
 			Usage: "How long the certificate is good for",
@@ -69,18 +101,28 @@ func init() {
 		},
 		&cli.IntFlag{
 			Name:  "months",
-			Usage: "The API Key used to authenticate to the SMM API",
+			Usage: "How many months the certificate is good for, in addition to --years",
 			Value: 0,
 		},
 	}
 
 	cliCmd := &cli.Command{
 		Name:   "pki-generate",
-		Usage:  "generates an ecdsa private key and certificate",
+		Usage:  "generates a private key and self-signed certificate and prints them, without storing them",
 		Action: cmd.Execute,
 		Flags:  append(flags, global.Flags()...),
 		Before: global.Before,
 	}
 
 	common.RegisterCommand(cliCmd)
+
+	// the subcommands manage the pki of the api server through the admin api
+	pkiCmd := &cli.Command{
+		Name:   "pki",
+		Usage:  "manage the pki tokens are signed with through the admin api",
+		Flags:  global.Flags(),
+		Before: global.Before,
+	}
+
+	common.RegisterCommand(pkiCmd)
 }
@@ -1,20 +1,20 @@
 package rbac
 
 import (
-	"bytes"
-	"crypto/tls"
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"net/http"
+	"os"
 	"strings"
+	"time"
 
-	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
 
-	"github.com/ekristen/dockit/pkg/apiserver/response"
+	"github.com/ekristen/dockit/pkg/apiserver/handlers"
+	"github.com/ekristen/dockit/pkg/commands/apiclient"
 	"github.com/ekristen/dockit/pkg/commands/global"
 	"github.com/ekristen/dockit/pkg/common"
+	"github.com/ekristen/dockit/pkg/db"
+	"github.com/ekristen/dockit/pkg/output"
 )
 
 type actionCommand struct{}
@@ -23,13 +23,14 @@ func (s *actionCommand) Execute(c *cli.Context) (err error) {
 	switch c.Command.Name {
 	case "add-member", "remove-member":
 		if c.Args().Len() != 2 {
-			return fmt.Errorf("usage: %s group:<name> user:<username>", c.Command.Name)
+			return fmt.Errorf("usage: %s group:<name> (user|group):<name>", c.Command.Name)
 		}
-	case "add":
+	case "add", "change-password":
 		if strings.HasPrefix(c.Args().Get(0), "user:") {
 			if c.Args().Len() != 2 {
 				return fmt.Errorf("usage: %s user:<username> <password>", c.Command.Name)
 			}
+			break
 		}
 		fallthrough
 	default:
@@ -43,19 +44,14 @@ func (s *actionCommand) Execute(c *cli.Context) (err error) {
 		}
 	}
 
-	username, password, err := getCredentials(c)
-	if err != nil {
-		return err
-	}
-
 	var args []string = c.Args().Slice()
 	var data []byte = nil
 
-	if c.Command.Name == "change-password" || c.Command.Name == "add" {
-		p := struct {
-			Password string `json:"password"`
-		}{
+	switch c.Command.Name {
+	case "change-password", "add":
+		p := handlers.NewUser{
 			Password: c.Args().Get(1),
+			Robot:    c.Bool("robot"),
 		}
 
 		args = []string{c.Args().Get(0)}
@@ -64,59 +60,60 @@ func (s *actionCommand) Execute(c *cli.Context) (err error) {
 		if err != nil {
 			return err
 		}
-	}
-
-	url := fmt.Sprintf("%s/admin/%s/%s", c.String("base-url"), strings.Join(args, "/"), c.Command.Name)
-	logrus.WithField("url", url).Debug("request url")
+	case "token-policy":
+		p := handlers.TokenPolicy{
+			TTL:    c.String("ttl"),
+			Claims: map[string]interface{}{},
+		}
 
-	req, err := http.NewRequest("PUT", url, bytes.NewReader(data))
-	if err != nil {
-		return err
-	}
+		for _, claim := range c.StringSlice("claim") {
+			parts := strings.SplitN(claim, "=", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("invalid claim, format should be <name>=<value>: %s", claim)
+			}
 
-	basicCreds := fmt.Sprintf("%s:%s", username, password)
-	authHeader := fmt.Sprintf("Basic %s", base64.StdEncoding.EncodeToString([]byte(basicCreds)))
-	req.Header.Set("Authorization", authHeader)
+			p.Claims[parts[0]] = parts[1]
+		}
 
-	client := &http.Client{Transport: &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: c.Bool("insecure"),
-		},
-	}}
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
+		data, err = json.Marshal(p)
+		if err != nil {
+			return err
+		}
 	}
-	defer resp.Body.Close()
 
-	logrus.WithField("headers", resp.Header).Debug("response headers")
-	logrus.WithField("status", resp.StatusCode).Debug("response Status Code")
-
-	res, err := response.ReadAllDecode(resp.Body)
+	res, err := apiclient.DoRequest(c, "PUT", fmt.Sprintf("admin/%s/%s", strings.Join(args, "/"), c.Command.Name), data)
 	if err != nil {
 		return err
 	}
 
-	if !res.Status {
-		fmt.Printf("Error Command: %s\n", c.Command.Name)
-		for _, e := range res.Errors {
-			fmt.Printf(" - %s\n", e)
+	switch c.Command.Name {
+	case "permissions":
+		var permissions []db.Permission
+		if err := apiclient.DecodeData(res, &permissions); err != nil {
+			return err
 		}
-	} else {
-		switch c.Command.Name {
-		case "permissions":
-			fmt.Println("Permissions:")
-			if len(res.Data.([]interface{})) == 0 {
-				fmt.Println(" - NONE")
+
+		rows := output.Rows{{"ACTION", "TYPE", "NAME", "SERVICE", "EXPIRES", "CIDRS"}}
+		for _, p := range permissions {
+			service := ""
+			if p.Service != nil {
+				service = p.Service.Name
 			}
-			for _, e := range res.Data.([]interface{}) {
-				p := e.(map[string]interface{})
-				fmt.Printf("  %s -> %s:%s\n", p["action"], p["type"], p["name"])
+
+			expires := ""
+			if p.Expired() {
+				expires = "expired"
+			} else if p.ExpiresAt != nil {
+				expires = p.ExpiresAt.Local().Format(time.RFC3339)
 			}
+
+			rows = append(rows, []string{string(p.Action), string(p.Type), p.Name, service, expires, p.CIDRs})
 		}
+
+		return output.Print(os.Stdout, c.String("output"), res.Data, rows)
 	}
 
-	return nil
+	return apiclient.PrintResult(c, res)
 }
 
 func init() {
@@ -130,7 +127,7 @@ func init() {
 		Name:   "change-password",
 		Usage:  "change password of a user",
 		Action: cmd.Execute,
-		Flags:  append(rbacFlags, global.Flags()...),
+		Flags:  append(apiclient.Flags, global.Flags()...),
 		Before: global.Before,
 	}
 
@@ -138,7 +135,7 @@ func init() {
 		Name:   "disable",
 		Usage:  "disable a user or group",
 		Action: cmd.Execute,
-		Flags:  append(rbacFlags, global.Flags()...),
+		Flags:  append(apiclient.Flags, global.Flags()...),
 		Before: global.Before,
 	}
 
@@ -146,7 +143,7 @@ func init() {
 		Name:   "enable",
 		Usage:  "enable a user or group",
 		Action: cmd.Execute,
-		Flags:  append(rbacFlags, global.Flags()...),
+		Flags:  append(apiclient.Flags, global.Flags()...),
 		Before: global.Before,
 	}
 
@@ -154,7 +151,30 @@ func init() {
 		Name:   "add",
 		Usage:  "add a user or group",
 		Action: cmd.Execute,
-		Flags:  append(rbacFlags, global.Flags()...),
+		Flags: append(append([]cli.Flag{
+			&cli.BoolFlag{
+				Name:  "robot",
+				Usage: "the user is a robot (for example ci), robots can have a different token lifetime",
+			},
+		}, apiclient.Flags...), global.Flags()...),
+		Before: global.Before,
+	}
+
+	tokenPolicyCmd := &cli.Command{
+		Name:      "token-policy",
+		Usage:     "set the token lifetime and extra claims of a user or group, without flags the overrides are cleared",
+		ArgsUsage: "(user|group):<name>",
+		Action:    cmd.Execute,
+		Flags: append(append([]cli.Flag{
+			&cli.StringFlag{
+				Name:  "ttl",
+				Usage: "lifetime of the tokens, for example 4h",
+			},
+			&cli.StringSliceFlag{
+				Name:  "claim",
+				Usage: "extra claim to add to the tokens, format <name>=<value>, can be specified multiple times",
+			},
+		}, apiclient.Flags...), global.Flags()...),
 		Before: global.Before,
 	}
 
@@ -162,23 +182,23 @@ func init() {
 		Name:   "remove",
 		Usage:  "remove a user or group",
 		Action: cmd.Execute,
-		Flags:  append(rbacFlags, global.Flags()...),
+		Flags:  append(apiclient.Flags, global.Flags()...),
 		Before: global.Before,
 	}
 
 	addMemberCmd := &cli.Command{
 		Name:   "add-member",
-		Usage:  "add a user to a group",
+		Usage:  "add a user or group to a group, group:<name> (user|group):<name>",
 		Action: cmd.Execute,
-		Flags:  append(rbacFlags, global.Flags()...),
+		Flags:  append(apiclient.Flags, global.Flags()...),
 		Before: global.Before,
 	}
 
 	removeMemberCmd := &cli.Command{
 		Name:   "remove-member",
-		Usage:  "remove a user from a group",
+		Usage:  "remove a user or group from a group, group:<name> (user|group):<name>",
 		Action: cmd.Execute,
-		Flags:  append(rbacFlags, global.Flags()...),
+		Flags:  append(apiclient.Flags, global.Flags()...),
 		Before: global.Before,
 	}
 
@@ -186,7 +206,7 @@ func init() {
 		Name:   "permissions",
 		Usage:  "list permissions for a user or group",
 		Action: cmd.Execute,
-		Flags:  append(rbacFlags, global.Flags()...),
+		Flags:  append(apiclient.Flags, global.Flags()...),
 		Before: global.Before,
 	}
 
@@ -198,4 +218,5 @@ func init() {
 	common.RegisterSubcommand("rbac", addMemberCmd)
 	common.RegisterSubcommand("rbac", removeMemberCmd)
 	common.RegisterSubcommand("rbac", listPermissionsCmd)
+	common.RegisterSubcommand("rbac", tokenPolicyCmd)
 }
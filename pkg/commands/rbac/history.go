@@ -0,0 +1,100 @@
+package rbac
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/ekristen/dockit/pkg/apiserver/handlers"
+	"github.com/ekristen/dockit/pkg/commands/apiclient"
+	"github.com/ekristen/dockit/pkg/commands/global"
+	"github.com/ekristen/dockit/pkg/common"
+	"github.com/ekristen/dockit/pkg/output"
+)
+
+type historyCommand struct{}
+
+func (s *historyCommand) Execute(c *cli.Context) (err error) {
+	if c.Args().Len() != 1 {
+		return fmt.Errorf("usage: %s <repository>", c.Command.Name)
+	}
+
+	query := url.Values{}
+	query.Set("limit", strconv.Itoa(c.Int("limit")))
+	if c.String("tag") != "" {
+		query.Set("tag", c.String("tag"))
+	}
+	if c.String("action") != "" {
+		query.Set("action", c.String("action"))
+	}
+
+	res, err := apiclient.DoRequest(c, "GET", fmt.Sprintf("admin/repositories/%s/history?%s", c.Args().First(), query.Encode()), nil)
+	if err != nil {
+		return err
+	}
+
+	if c.String("output") != output.Table {
+		return output.Print(os.Stdout, c.String("output"), res.Data, nil)
+	}
+
+	var history handlers.RepositoryHistory
+	if err := apiclient.DecodeData(res, &history); err != nil {
+		return err
+	}
+
+	formatTime := func(t *time.Time) string {
+		if t == nil {
+			return "never"
+		}
+		return t.Local().Format(time.RFC3339)
+	}
+
+	summary := output.Rows{
+		{"REPOSITORY", "LAST PUSHED", "LAST PUSHED BY", "LAST PULLED"},
+		{history.Repository, formatTime(history.LastPushed), history.LastPushedBy, formatTime(history.LastPulled)},
+	}
+	if err := output.Print(os.Stdout, output.Table, nil, summary); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(os.Stdout)
+
+	rows := output.Rows{{"TIME", "ACTION", "TAG", "DIGEST", "ACTOR", "SERVICE"}}
+	for _, e := range history.Events {
+		rows = append(rows, []string{formatTime(e.Timestamp), e.Action, e.Tag, e.Digest, e.Actor, e.Service})
+	}
+
+	return output.Print(os.Stdout, output.Table, nil, rows)
+}
+
+func init() {
+	cmd := historyCommand{}
+
+	historyCmd := &cli.Command{
+		Name:   "history",
+		Usage:  "show who pushed, pulled and deleted a repository as reported by the registry, history <repository>",
+		Action: cmd.Execute,
+		Flags: append(append([]cli.Flag{
+			&cli.StringFlag{
+				Name:  "tag",
+				Usage: "only show events for a tag",
+			},
+			&cli.StringFlag{
+				Name:  "action",
+				Usage: "only show push, pull or delete events",
+			},
+			&cli.IntFlag{
+				Name:  "limit",
+				Usage: "maximum number of events to show",
+				Value: 50,
+			},
+		}, apiclient.Flags...), global.Flags()...),
+		Before: global.Before,
+	}
+
+	common.RegisterSubcommand("rbac", historyCmd)
+}
@@ -0,0 +1,151 @@
+package rbac
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/ekristen/dockit/pkg/apiserver/handlers"
+	"github.com/ekristen/dockit/pkg/commands/apiclient"
+	"github.com/ekristen/dockit/pkg/commands/global"
+	"github.com/ekristen/dockit/pkg/common"
+	"github.com/ekristen/dockit/pkg/db"
+	"github.com/ekristen/dockit/pkg/output"
+)
+
+type listCommand struct{}
+
+func (s *listCommand) Execute(c *cli.Context) (err error) {
+	var path string
+
+	switch c.Command.Name {
+	case "users", "groups":
+		if c.Args().Len() != 0 {
+			return fmt.Errorf("usage: %s", c.Command.Name)
+		}
+
+		path = fmt.Sprintf("admin/%s", c.Command.Name)
+	case "members":
+		if c.Args().Len() != 1 || !strings.HasPrefix(c.Args().First(), "group:") {
+			return fmt.Errorf("usage: %s group:<name>", c.Command.Name)
+		}
+
+		path = fmt.Sprintf("admin/%s/members", c.Args().First())
+	case "who-can":
+		if c.Args().Len() != 1 || len(strings.Split(c.Args().First(), ":")) != 3 {
+			return fmt.Errorf("usage: %s (repository|namespace):<name>:(pull|push|admin)", c.Command.Name)
+		}
+
+		path = fmt.Sprintf("admin/who-can/%s", strings.ReplaceAll(c.Args().First(), "/", "_"))
+		if c.String("service") != "" {
+			path = fmt.Sprintf("%s?service=%s", path, url.QueryEscape(c.String("service")))
+		}
+	}
+
+	res, err := apiclient.DoRequest(c, "GET", path, nil)
+	if err != nil {
+		return err
+	}
+
+	var rows output.Rows
+
+	switch c.Command.Name {
+	case "users":
+		var users []db.User
+		if err := apiclient.DecodeData(res, &users); err != nil {
+			return err
+		}
+
+		rows = append(rows, []string{"USERNAME", "ACTIVE", "ADMIN", "GROUPS"})
+		for _, u := range users {
+			var groups []string
+			for _, g := range u.Groups {
+				groups = append(groups, g.Name)
+			}
+
+			rows = append(rows, []string{u.Username, strconv.FormatBool(u.Active), strconv.FormatBool(u.Admin), strings.Join(groups, ",")})
+		}
+	case "groups":
+		var groups []db.Group
+		if err := apiclient.DecodeData(res, &groups); err != nil {
+			return err
+		}
+
+		rows = append(rows, []string{"NAME", "ACTIVE", "MEMBERS", "GROUPS"})
+		for _, g := range groups {
+			rows = append(rows, []string{g.Name, strconv.FormatBool(g.Active), strconv.Itoa(len(g.Users)), strconv.Itoa(len(g.Children))})
+		}
+	case "members":
+		var members []handlers.Member
+		if err := apiclient.DecodeData(res, &members); err != nil {
+			return err
+		}
+
+		rows = append(rows, []string{"TYPE", "NAME", "ACTIVE"})
+		for _, m := range members {
+			rows = append(rows, []string{m.Type, m.Name, strconv.FormatBool(m.Active)})
+		}
+	case "who-can":
+		var principals []handlers.Principal
+		if err := apiclient.DecodeData(res, &principals); err != nil {
+			return err
+		}
+
+		rows = append(rows, []string{"TYPE", "NAME", "PERMISSION", "VIA"})
+		for _, p := range principals {
+			rows = append(rows, []string{p.Type, p.Name, p.Permission, p.Via})
+		}
+	}
+
+	return output.Print(os.Stdout, c.String("output"), res.Data, rows)
+}
+
+func init() {
+	cmd := listCommand{}
+
+	usersCmd := &cli.Command{
+		Name:   "users",
+		Usage:  "list users",
+		Action: cmd.Execute,
+		Flags:  append(apiclient.Flags, global.Flags()...),
+		Before: global.Before,
+	}
+
+	groupsCmd := &cli.Command{
+		Name:   "groups",
+		Usage:  "list groups",
+		Action: cmd.Execute,
+		Flags:  append(apiclient.Flags, global.Flags()...),
+		Before: global.Before,
+	}
+
+	membersCmd := &cli.Command{
+		Name:   "members",
+		Usage:  "list members of a group",
+		Action: cmd.Execute,
+		Flags:  append(apiclient.Flags, global.Flags()...),
+		Before: global.Before,
+	}
+
+	whoCanCmd := &cli.Command{
+		Name:   "who-can",
+		Usage:  "list users and groups that can perform an action, (repository|namespace):<name>:(pull|push|admin)",
+		Action: cmd.Execute,
+		Flags: append(append([]cli.Flag{
+			&cli.StringFlag{
+				Name:  "service",
+				Usage: "include permissions bound to a registry service",
+			},
+		}, apiclient.Flags...), global.Flags()...),
+		Before: global.Before,
+	}
+
+	common.RegisterSubcommand("rbac", usersCmd)
+	common.RegisterSubcommand("rbac", groupsCmd)
+	common.RegisterSubcommand("rbac", membersCmd)
+	common.RegisterSubcommand("rbac", whoCanCmd)
+}
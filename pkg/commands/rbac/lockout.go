@@ -0,0 +1,82 @@
+package rbac
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/ekristen/dockit/pkg/commands/apiclient"
+	"github.com/ekristen/dockit/pkg/commands/global"
+	"github.com/ekristen/dockit/pkg/common"
+	"github.com/ekristen/dockit/pkg/db"
+	"github.com/ekristen/dockit/pkg/output"
+)
+
+type lockoutCommand struct{}
+
+func (s *lockoutCommand) Execute(c *cli.Context) (err error) {
+	switch c.Command.Name {
+	case "lockouts":
+		res, err := apiclient.DoRequest(c, "GET", "admin/lockouts", nil)
+		if err != nil {
+			return err
+		}
+
+		var lockouts []db.Lockout
+		if err := apiclient.DecodeData(res, &lockouts); err != nil {
+			return err
+		}
+
+		rows := output.Rows{{"KEY", "FAILURES", "LOCKOUTS", "LOCKED UNTIL"}}
+		for _, l := range lockouts {
+			until := ""
+			if l.LockedUntil != nil && l.LockedUntil.After(time.Now()) {
+				until = l.LockedUntil.Local().Format(time.RFC3339)
+			}
+
+			rows = append(rows, []string{l.Key, strconv.Itoa(l.Failures), strconv.Itoa(l.Lockouts), until})
+		}
+
+		return output.Print(os.Stdout, c.String("output"), res.Data, rows)
+	case "unlock":
+		if c.Args().Len() != 1 || (!strings.HasPrefix(c.Args().First(), "user:") && !strings.HasPrefix(c.Args().First(), "ip:")) {
+			return fmt.Errorf("usage: %s (user|ip):<name>", c.Command.Name)
+		}
+
+		res, err := apiclient.DoRequest(c, "DELETE", fmt.Sprintf("admin/lockouts/%s", c.Args().First()), nil)
+		if err != nil {
+			return err
+		}
+
+		return apiclient.PrintResult(c, res)
+	}
+
+	return nil
+}
+
+func init() {
+	cmd := lockoutCommand{}
+
+	lockoutsCmd := &cli.Command{
+		Name:   "lockouts",
+		Usage:  "list usernames and client ips with failed authentication attempts",
+		Action: cmd.Execute,
+		Flags:  append(apiclient.Flags, global.Flags()...),
+		Before: global.Before,
+	}
+
+	unlockCmd := &cli.Command{
+		Name:   "unlock",
+		Usage:  "remove the lockout of a username or client ip, (user|ip):<name>",
+		Action: cmd.Execute,
+		Flags:  append(apiclient.Flags, global.Flags()...),
+		Before: global.Before,
+	}
+
+	common.RegisterSubcommand("rbac", lockoutsCmd)
+	common.RegisterSubcommand("rbac", unlockCmd)
+}
@@ -0,0 +1,266 @@
+package rbac
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/urfave/cli/v2"
+
+	"github.com/ekristen/dockit/pkg/clientconfig"
+	"github.com/ekristen/dockit/pkg/commands/apiclient"
+	"github.com/ekristen/dockit/pkg/commands/global"
+	"github.com/ekristen/dockit/pkg/common"
+	"github.com/ekristen/dockit/pkg/output"
+)
+
+type loginCommand struct{}
+
+func (s *loginCommand) Login(c *cli.Context) error {
+	if c.Args().Len() != 1 {
+		return fmt.Errorf("usage: %s <base-url>", c.Command.Name)
+	}
+
+	baseURL := clientconfig.NormalizeBaseURL(c.Args().First())
+
+	u, err := url.Parse(baseURL)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid base url: %s", c.Args().First())
+	}
+
+	password := c.String("password")
+	if c.Bool("password-stdin") {
+		data, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		password = strings.TrimRight(string(data), "\r\n")
+	}
+	if c.String("username") == "" || password == "" {
+		return fmt.Errorf("a username and a password (--password or --password-stdin) are required")
+	}
+
+	name := c.String("context")
+	if name == "" {
+		name = u.Host
+	}
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/token", baseURL), nil)
+	if err != nil {
+		return err
+	}
+	req.URL.RawQuery = url.Values{"scope": {apiclient.AdminScope}, "offline_token": {"true"}}.Encode()
+	req.SetBasicAuth(c.String("username"), password)
+
+	token, err := apiclient.RequestToken(req, c.Bool("insecure"))
+	if err != nil {
+		return err
+	}
+	if token.RefreshToken == "" {
+		return fmt.Errorf("the api server did not return a refresh token")
+	}
+
+	// the audience of the token is needed to use the refresh token later on
+	claims := &jwt.StandardClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(token.Token, claims); err != nil {
+		return fmt.Errorf("unable to parse token: %w", err)
+	}
+
+	cfg, err := clientconfig.Load(clientconfig.DefaultPath())
+	if err != nil {
+		return err
+	}
+
+	// the refresh token of a previous login to the same context is replaced
+	if previous, ok := cfg.Contexts[name]; ok {
+		if err := previous.EraseRefreshToken(); err != nil {
+			return err
+		}
+	}
+
+	ctx := &clientconfig.Context{
+		BaseURL:          baseURL,
+		Username:         c.String("username"),
+		Service:          claims.Audience,
+		Insecure:         c.Bool("insecure"),
+		CredentialsStore: c.String("credentials-store"),
+	}
+	if err := ctx.SetRefreshToken(token.RefreshToken); err != nil {
+		return err
+	}
+
+	cfg.Contexts[name] = ctx
+	cfg.CurrentContext = name
+
+	if err := cfg.Save(); err != nil {
+		return err
+	}
+
+	return output.Print(os.Stdout, c.String("output"), map[string]string{"context": name}, output.Rows{
+		{fmt.Sprintf("login successful, context %s", name)},
+	})
+}
+
+func (s *loginCommand) Logout(c *cli.Context) error {
+	cfg, err := clientconfig.Load(clientconfig.DefaultPath())
+	if err != nil {
+		return err
+	}
+
+	name := c.Args().First()
+	if name == "" {
+		name = cfg.CurrentContext
+	}
+
+	ctx, err := cfg.Context(name)
+	if err != nil {
+		return err
+	}
+
+	if err := ctx.EraseRefreshToken(); err != nil {
+		return err
+	}
+
+	delete(cfg.Contexts, name)
+	if cfg.CurrentContext == name {
+		cfg.CurrentContext = ""
+	}
+
+	if err := cfg.Save(); err != nil {
+		return err
+	}
+
+	return output.Print(os.Stdout, c.String("output"), map[string]string{"context": name}, output.Rows{
+		{fmt.Sprintf("logout successful, context %s", name)},
+	})
+}
+
+func (s *loginCommand) List(c *cli.Context) error {
+	cfg, err := clientconfig.Load(clientconfig.DefaultPath())
+	if err != nil {
+		return err
+	}
+
+	// refresh tokens stored in the config file are never printed
+	data := clientconfig.Config{CurrentContext: cfg.CurrentContext, Contexts: map[string]*clientconfig.Context{}}
+
+	rows := output.Rows{{"CURRENT", "NAME", "BASE URL", "USERNAME", "CREDENTIALS STORE"}}
+	for _, name := range cfg.Names() {
+		ctx := *cfg.Contexts[name]
+		ctx.RefreshToken = ""
+		data.Contexts[name] = &ctx
+
+		current := ""
+		if name == cfg.CurrentContext {
+			current = "*"
+		}
+
+		store := ctx.CredentialsStore
+		if store == "" {
+			store = "file"
+		}
+
+		rows = append(rows, []string{current, name, ctx.BaseURL, ctx.Username, store})
+	}
+
+	return output.Print(os.Stdout, c.String("output"), data, rows)
+}
+
+func (s *loginCommand) Use(c *cli.Context) error {
+	if c.Args().Len() != 1 {
+		return fmt.Errorf("usage: %s <name>", c.Command.Name)
+	}
+
+	cfg, err := clientconfig.Load(clientconfig.DefaultPath())
+	if err != nil {
+		return err
+	}
+
+	if _, err := cfg.Context(c.Args().First()); err != nil {
+		return err
+	}
+
+	cfg.CurrentContext = c.Args().First()
+
+	if err := cfg.Save(); err != nil {
+		return err
+	}
+
+	return output.Print(os.Stdout, c.String("output"), map[string]string{"context": cfg.CurrentContext}, output.Rows{
+		{fmt.Sprintf("switched to context %s", cfg.CurrentContext)},
+	})
+}
+
+func init() {
+	cmd := loginCommand{}
+
+	loginCmd := &cli.Command{
+		Name:      "login",
+		Usage:     "log in to a dockit api server, the rbac commands use the current context",
+		ArgsUsage: "<base-url>",
+		Action:    cmd.Login,
+		Flags: append([]cli.Flag{
+			&cli.StringFlag{
+				Name:  "context",
+				Usage: "name of the context, defaults to the host of the base url",
+			},
+			&cli.StringFlag{
+				Name:    "username",
+				EnvVars: []string{"DOCKIT_USERNAME"},
+			},
+			&cli.StringFlag{
+				Name:    "password",
+				EnvVars: []string{"DOCKIT_PASSWORD"},
+			},
+			&cli.BoolFlag{
+				Name:  "password-stdin",
+				Usage: "read the password from stdin",
+			},
+			&cli.StringFlag{
+				Name:    "credentials-store",
+				Usage:   "docker credential helper to store the refresh token in, for example desktop, osxkeychain or pass, without one it is stored in the config file",
+				EnvVars: []string{"DOCKIT_CREDENTIALS_STORE"},
+			},
+			&cli.BoolFlag{
+				Name:  "insecure",
+				Usage: "skip the verification of the tls certificate",
+			},
+		}, global.Flags()...),
+		Before: global.Before,
+	}
+
+	logoutCmd := &cli.Command{
+		Name:      "logout",
+		Usage:     "remove a context and its refresh token, defaults to the current context",
+		ArgsUsage: "[name]",
+		Action:    cmd.Logout,
+		Flags:     global.Flags(),
+		Before:    global.Before,
+	}
+
+	contextCmd := &cli.Command{
+		Name:   "context",
+		Usage:  "list the contexts created by dockit login",
+		Action: cmd.List,
+		Flags:  global.Flags(),
+		Before: global.Before,
+	}
+
+	useCmd := &cli.Command{
+		Name:      "use",
+		Usage:     "change the current context",
+		ArgsUsage: "<name>",
+		Action:    cmd.Use,
+		Flags:     global.Flags(),
+		Before:    global.Before,
+	}
+
+	common.RegisterCommand(loginCmd)
+	common.RegisterCommand(logoutCmd)
+	common.RegisterCommand(contextCmd)
+	common.RegisterSubcommand("context", useCmd)
+}
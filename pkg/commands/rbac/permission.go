@@ -1,16 +1,16 @@
 package rbac
 
 import (
-	"crypto/tls"
-	"encoding/base64"
+	"encoding/json"
 	"fmt"
-	"net/http"
+	"net/url"
 	"strings"
+	"time"
 
-	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
 
-	"github.com/ekristen/dockit/pkg/apiserver/response"
+	"github.com/ekristen/dockit/pkg/apiserver/handlers"
+	"github.com/ekristen/dockit/pkg/commands/apiclient"
 	"github.com/ekristen/dockit/pkg/commands/global"
 	"github.com/ekristen/dockit/pkg/common"
 )
@@ -19,83 +19,104 @@ type permissionCommand struct{}
 
 func (s *permissionCommand) Execute(c *cli.Context) (err error) {
 	if c.Args().Len() != 2 {
-		return fmt.Errorf("incorrect usage")
-	}
-
-	username, password, err := getCredentials(c)
-	if err != nil {
-		return err
+		return fmt.Errorf("usage: %s (user|group):<name> (repository|namespace):<name>:(pull|push|admin)", c.Command.Name)
 	}
 
 	url1 := strings.Join(c.Args().Slice(), "|")
 	url2 := strings.ReplaceAll(url1, "/", "_")
 	url3 := strings.ReplaceAll(url2, "|", "/")
 
-	url := fmt.Sprintf("%s/admin/%s", c.String("base-url"), url3)
-	logrus.WithField("url", url).Debug("request url")
-
 	method := "PUT"
 	if c.Command.Name == "revoke" {
 		method = "DELETE"
 	}
 
-	req, err := http.NewRequest(method, url, nil)
-	if err != nil {
-		return err
+	path := fmt.Sprintf("admin/%s", url3)
+	if c.String("service") != "" {
+		path = fmt.Sprintf("%s?service=%s", path, url.QueryEscape(c.String("service")))
 	}
 
-	basicCreds := fmt.Sprintf("%s:%s", username, password)
-	authHeader := fmt.Sprintf("Basic %s", base64.StdEncoding.EncodeToString([]byte(basicCreds)))
-	req.Header.Set("Authorization", authHeader)
+	var data []byte
+	if c.Command.Name == "grant" {
+		var grant handlers.PermissionGrant
 
-	client := &http.Client{Transport: &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: c.Bool("insecure"),
-		},
-	}}
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
+		if c.IsSet("for") && c.IsSet("until") {
+			return fmt.Errorf("only one of --for or --until can be set")
+		}
 
-	logrus.WithField("headers", resp.Header).Debug("response headers")
-	logrus.WithField("status", resp.StatusCode).Debug("response Status Code")
+		if c.IsSet("for") {
+			if c.Duration("for") <= 0 {
+				return fmt.Errorf("--for must be a positive duration")
+			}
 
-	res, err := response.ReadAllDecode(resp.Body)
-	if err != nil {
-		return err
+			expiresAt := time.Now().Add(c.Duration("for")).UTC()
+			grant.ExpiresAt = &expiresAt
+		}
+
+		if c.IsSet("until") {
+			expiresAt, err := time.Parse(time.RFC3339, c.String("until"))
+			if err != nil {
+				return fmt.Errorf("invalid --until, expected an RFC 3339 time such as 2006-01-02T15:04:05Z: %s", err)
+			}
+
+			expiresAt = expiresAt.UTC()
+			grant.ExpiresAt = &expiresAt
+		}
+
+		grant.CIDRs = c.StringSlice("cidr")
+
+		data, err = json.Marshal(grant)
+		if err != nil {
+			return err
+		}
 	}
 
-	if res.Status {
-		fmt.Printf("%s successful\n", c.Command.Name)
-	} else {
-		fmt.Printf("%s failed\n", c.Command.Name)
+	res, err := apiclient.DoRequest(c, method, path, data)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	return apiclient.PrintResult(c, res)
 }
 
 func init() {
 	cmd := permissionCommand{}
 
-	// flags := []cli.Flag{}
+	flags := []cli.Flag{
+		&cli.StringFlag{
+			Name:  "service",
+			Usage: "bind the permission to a registry service, by default it applies to every service",
+		},
+	}
 
 	// grant user repository name action
 
 	grantCmd := &cli.Command{
 		Name:   "grant",
-		Usage:  "grant (user|group):<name> (repository|namespace):<name>:(pull|push)",
+		Usage:  "grant (user|group):<name> (repository|namespace):<name>:(pull|push|admin)",
 		Action: cmd.Execute,
-		Flags:  append(rbacFlags, global.Flags()...),
+		Flags: append(append([]cli.Flag{
+			&cli.DurationFlag{
+				Name:  "for",
+				Usage: "expire the grant after a duration, for example 4h",
+			},
+			&cli.StringFlag{
+				Name:  "until",
+				Usage: "expire the grant at an RFC 3339 time, for example 2006-01-02T15:04:05Z",
+			},
+			&cli.StringSliceFlag{
+				Name:  "cidr",
+				Usage: "only allow the grant from a client ip within the cidr, can be repeated",
+			},
+		}, flags...), append(apiclient.Flags, global.Flags()...)...),
 		Before: global.Before,
 	}
 
 	revokeCmd := &cli.Command{
 		Name:   "revoke",
-		Usage:  "revoke (user|group):<name> (repository|namespace):<name>:(pull|push)",
+		Usage:  "revoke (user|group):<name> (repository|namespace):<name>:(pull|push|admin)",
 		Action: cmd.Execute,
-		Flags:  append(rbacFlags, global.Flags()...),
+		Flags:  append(append(flags, apiclient.Flags...), global.Flags()...),
 		Before: global.Before,
 	}
 
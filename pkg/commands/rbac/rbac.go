@@ -33,16 +33,25 @@ func init() {
 			Usage:   "manually specify password, otherwise will attempt to retrieve from docker store",
 			EnvVars: []string{"DOCKIT_PASSWORD", "DOCKIT_GRANT_PASSWORD"},
 		},
+		&cli.StringFlag{
+			Name:    "token",
+			Usage:   "personal access token or dockit token with the dockit:admin:* scope, used instead of the username and password",
+			EnvVars: []string{"DOCKIT_TOKEN"},
+		},
+		&cli.StringFlag{
+			Name:    "context",
+			Usage:   "context created by dockit login to use instead of the current context",
+			EnvVars: []string{"DOCKIT_CONTEXT"},
+		},
 	}
 
 	// grant user repository name action
 
 	cliCmd := &cli.Command{
-		Name:        "rbac",
-		Usage:       "provides the ability to perform various RBAC related actions",
-		Flags:       append(flags, global.Flags()...),
-		Before:      global.Before,
-		Subcommands: common.GetSubcommands("rbac"),
+		Name:   "rbac",
+		Usage:  "provides the ability to perform various RBAC related actions",
+		Flags:  append(flags, global.Flags()...),
+		Before: global.Before,
 	}
 
 	common.RegisterCommand(cliCmd)
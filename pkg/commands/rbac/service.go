@@ -0,0 +1,108 @@
+package rbac
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/ekristen/dockit/pkg/apiserver/handlers"
+	"github.com/ekristen/dockit/pkg/commands/apiclient"
+	"github.com/ekristen/dockit/pkg/commands/global"
+	"github.com/ekristen/dockit/pkg/common"
+	"github.com/ekristen/dockit/pkg/db"
+	"github.com/ekristen/dockit/pkg/output"
+)
+
+type serviceCommand struct{}
+
+func (s *serviceCommand) Execute(c *cli.Context) (err error) {
+	switch c.Command.Name {
+	case "services":
+		res, err := apiclient.DoRequest(c, "GET", "admin/services", nil)
+		if err != nil {
+			return err
+		}
+
+		var services []db.Service
+		if err := apiclient.DecodeData(res, &services); err != nil {
+			return err
+		}
+
+		rows := output.Rows{{"NAME", "DESCRIPTION"}}
+		for _, s := range services {
+			rows = append(rows, []string{s.Name, s.Description})
+		}
+
+		return output.Print(os.Stdout, c.String("output"), res.Data, rows)
+	case "add-service", "remove-service":
+		if c.Args().Len() != 1 {
+			return fmt.Errorf("usage: %s <name>", c.Command.Name)
+		}
+
+		method := "PUT"
+		var data []byte
+		if c.Command.Name == "remove-service" {
+			method = "DELETE"
+		} else {
+			data, err = json.Marshal(handlers.NewService{
+				Description: c.String("description"),
+				EventsToken: c.String("events-token"),
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		res, err := apiclient.DoRequest(c, method, fmt.Sprintf("admin/services/%s", c.Args().First()), data)
+		if err != nil {
+			return err
+		}
+
+		return apiclient.PrintResult(c, res)
+	}
+
+	return nil
+}
+
+func init() {
+	cmd := serviceCommand{}
+
+	servicesCmd := &cli.Command{
+		Name:   "services",
+		Usage:  "list registry services tokens can be issued for",
+		Action: cmd.Execute,
+		Flags:  append(apiclient.Flags, global.Flags()...),
+		Before: global.Before,
+	}
+
+	addServiceCmd := &cli.Command{
+		Name:   "add-service",
+		Usage:  "register a registry service, add-service <name>",
+		Action: cmd.Execute,
+		Flags: append(append([]cli.Flag{
+			&cli.StringFlag{
+				Name:  "description",
+				Usage: "description of the service",
+			},
+			&cli.StringFlag{
+				Name:  "events-token",
+				Usage: "bearer token the registry sends its notifications to /v2/events with",
+			},
+		}, apiclient.Flags...), global.Flags()...),
+		Before: global.Before,
+	}
+
+	removeServiceCmd := &cli.Command{
+		Name:   "remove-service",
+		Usage:  "remove a registry service and the permissions bound to it, remove-service <name>",
+		Action: cmd.Execute,
+		Flags:  append(apiclient.Flags, global.Flags()...),
+		Before: global.Before,
+	}
+
+	common.RegisterSubcommand("rbac", servicesCmd)
+	common.RegisterSubcommand("rbac", addServiceCmd)
+	common.RegisterSubcommand("rbac", removeServiceCmd)
+}
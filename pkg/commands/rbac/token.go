@@ -0,0 +1,160 @@
+package rbac
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/ekristen/dockit/pkg/apiserver/handlers"
+	"github.com/ekristen/dockit/pkg/commands/apiclient"
+	"github.com/ekristen/dockit/pkg/commands/global"
+	"github.com/ekristen/dockit/pkg/common"
+	"github.com/ekristen/dockit/pkg/db"
+	"github.com/ekristen/dockit/pkg/output"
+)
+
+type tokenCommand struct{}
+
+func (s *tokenCommand) Execute(c *cli.Context) (err error) {
+	switch c.Command.Name {
+	case "tokens", "revoke-tokens":
+		if c.Args().Len() != 1 || !strings.HasPrefix(c.Args().First(), "user:") {
+			return fmt.Errorf("usage: %s user:<username>", c.Command.Name)
+		}
+
+		path := fmt.Sprintf("admin/%s/tokens", c.Args().First())
+
+		if c.Command.Name == "revoke-tokens" {
+			res, err := apiclient.DoRequest(c, "DELETE", path, nil)
+			if err != nil {
+				return err
+			}
+
+			return apiclient.PrintResult(c, res)
+		}
+
+		res, err := apiclient.DoRequest(c, "GET", path, nil)
+		if err != nil {
+			return err
+		}
+
+		var tokens []db.Token
+		if err := apiclient.DecodeData(res, &tokens); err != nil {
+			return err
+		}
+
+		rows := output.Rows{{"JTI", "TYPE", "SERVICE", "DESCRIPTION", "ISSUED", "EXPIRES", "REVOKED"}}
+		for _, t := range tokens {
+			rows = append(rows, []string{
+				t.JTI,
+				string(t.Type),
+				t.Service,
+				t.Description,
+				t.IssuedAt.Local().Format(time.RFC3339),
+				t.ExpiresAt.Local().Format(time.RFC3339),
+				strconv.FormatBool(t.RevokedAt != nil),
+			})
+		}
+
+		return output.Print(os.Stdout, c.String("output"), res.Data, rows)
+	case "create-token":
+		if c.Args().Len() != 1 || !strings.HasPrefix(c.Args().First(), "user:") {
+			return fmt.Errorf("usage: %s user:<username>", c.Command.Name)
+		}
+
+		newToken := handlers.NewPersonalToken{Description: c.String("description")}
+		if c.IsSet("expires") {
+			newToken.ExpiresIn = c.Duration("expires").String()
+		}
+
+		data, err := json.Marshal(newToken)
+		if err != nil {
+			return err
+		}
+
+		res, err := apiclient.DoRequest(c, "POST", fmt.Sprintf("admin/%s/tokens", c.Args().First()), data)
+		if err != nil {
+			return err
+		}
+
+		var token handlers.PersonalToken
+		if err := apiclient.DecodeData(res, &token); err != nil {
+			return err
+		}
+
+		return output.Print(os.Stdout, c.String("output"), res.Data, output.Rows{
+			{"JTI", "EXPIRES", "TOKEN"},
+			{token.JTI, token.ExpiresAt.Local().Format(time.RFC3339), token.Token},
+		})
+	case "revoke-token":
+		if c.Args().Len() != 1 {
+			return fmt.Errorf("usage: %s <jti>", c.Command.Name)
+		}
+
+		res, err := apiclient.DoRequest(c, "DELETE", fmt.Sprintf("admin/tokens/%s", c.Args().First()), nil)
+		if err != nil {
+			return err
+		}
+
+		return apiclient.PrintResult(c, res)
+	}
+
+	return nil
+}
+
+func init() {
+	cmd := tokenCommand{}
+
+	tokensCmd := &cli.Command{
+		Name:   "tokens",
+		Usage:  "list the unexpired access, refresh and personal access tokens of a user, user:<username>",
+		Action: cmd.Execute,
+		Flags:  append(apiclient.Flags, global.Flags()...),
+		Before: global.Before,
+	}
+
+	revokeTokensCmd := &cli.Command{
+		Name:   "revoke-tokens",
+		Usage:  "revoke every access and refresh token of a user, user:<username>",
+		Action: cmd.Execute,
+		Flags:  append(apiclient.Flags, global.Flags()...),
+		Before: global.Before,
+	}
+
+	revokeTokenCmd := &cli.Command{
+		Name:   "revoke-token",
+		Usage:  "revoke a single token by its jti, revoking a refresh token also revokes the access tokens issued with it",
+		Action: cmd.Execute,
+		Flags:  append(apiclient.Flags, global.Flags()...),
+		Before: global.Before,
+	}
+
+	createTokenCmd := &cli.Command{
+		Name:      "create-token",
+		Usage:     "create a personal access token for the admin api with your password, it is only shown once",
+		ArgsUsage: "user:<username>",
+		Action:    cmd.Execute,
+		Flags: append(append([]cli.Flag{
+			&cli.StringFlag{
+				Name:  "description",
+				Usage: "what the token is used for",
+			},
+			&cli.DurationFlag{
+				Name:  "expires",
+				Usage: "lifetime of the token, at most the personal-token-max-ttl of the api server",
+				Value: handlers.DefaultPersonalTokenTTL,
+			},
+		}, apiclient.Flags...), global.Flags()...),
+		Before: global.Before,
+	}
+
+	common.RegisterSubcommand("rbac", tokensCmd)
+	common.RegisterSubcommand("rbac", createTokenCmd)
+	common.RegisterSubcommand("rbac", revokeTokensCmd)
+	common.RegisterSubcommand("rbac", revokeTokenCmd)
+}
@@ -0,0 +1,97 @@
+package registryconfig
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/ekristen/dockit/pkg/apiserver/handlers"
+	"github.com/ekristen/dockit/pkg/commands/apiclient"
+	"github.com/ekristen/dockit/pkg/commands/global"
+	"github.com/ekristen/dockit/pkg/common"
+	"github.com/ekristen/dockit/pkg/output"
+)
+
+type registryConfigCommand struct{}
+
+func (s *registryConfigCommand) Execute(c *cli.Context) error {
+	format := c.String("format")
+	if format != "env" && format != "yaml" {
+		return fmt.Errorf("invalid format: %s", format)
+	}
+
+	query := url.Values{}
+	for _, name := range []string{"service", "realm", "rootcertbundle", "jwks"} {
+		if v := c.String(name); v != "" {
+			query.Set(name, v)
+		}
+	}
+
+	// --format is how the registry is configured, a json or yaml --output prints the configuration as data
+	if c.String("output") != output.Table {
+		query.Set("format", "json")
+
+		res, err := apiclient.DoRequest(c, "GET", fmt.Sprintf("registry-config?%s", query.Encode()), nil)
+		if err != nil {
+			return err
+		}
+
+		return output.Print(os.Stdout, c.String("output"), res.Data, nil)
+	}
+
+	query.Set("format", format)
+
+	body, err := apiclient.DoRaw(c, "GET", fmt.Sprintf("registry-config?%s", query.Encode()), nil)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.HasSuffix(body, []byte("\n")) {
+		body = append(body, '\n')
+	}
+
+	_, err = os.Stdout.Write(body)
+	return err
+}
+
+func init() {
+	cmd := registryConfigCommand{}
+
+	flags := []cli.Flag{
+		&cli.StringFlag{
+			Name:  "format",
+			Usage: "env for the environment of the registry or yaml for the auth section of its config.yml",
+			Value: "env",
+		},
+		&cli.StringFlag{
+			Name:  "service",
+			Usage: "service of the registry, defaults to the only registered service",
+		},
+		&cli.StringFlag{
+			Name:  "realm",
+			Usage: "url of the token endpoint as the clients of the registry reach it, defaults to the token endpoint below the base url",
+		},
+		&cli.StringFlag{
+			Name:  "rootcertbundle",
+			Usage: "path the init container writes the cert bundle to",
+			Value: handlers.DefaultRootCertBundle,
+		},
+		&cli.StringFlag{
+			Name:  "jwks",
+			Usage: "path the init container writes the json web key set to, for registries that support it",
+		},
+	}
+
+	cliCmd := &cli.Command{
+		Name:   "registry-config",
+		Usage:  "print the token authentication configuration of a distribution registry that trusts the api server, requires an admin",
+		Action: cmd.Execute,
+		Flags:  append(append(flags, apiclient.Flags...), global.Flags()...),
+		Before: global.Before,
+	}
+
+	common.RegisterCommand(cliCmd)
+}
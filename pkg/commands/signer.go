@@ -0,0 +1,171 @@
+package commands
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/urfave/cli/v2"
+	"gorm.io/gorm"
+
+	"github.com/ekristen/dockit/pkg/apiserver/handlers"
+	"github.com/ekristen/dockit/pkg/db"
+	"github.com/ekristen/dockit/pkg/signer"
+)
+
+// signerFlags are the flags for a signer that holds the private key outside of the database
+func signerFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "pki-signer",
+			Usage:   "where the private key tokens are signed with is held, db, pkcs11 or webhook, with pkcs11 or webhook --pki-file has to contain the certificate of the key",
+			EnvVars: []string{"DOCKIT_PKI_SIGNER", "PKI_SIGNER"},
+			Value:   signer.DB,
+		},
+		&cli.PathFlag{
+			Name:    "pkcs11-module",
+			Usage:   "path of the pkcs11 library, for SoftHSM /usr/lib/softhsm/libsofthsm2.so",
+			EnvVars: []string{"DOCKIT_PKCS11_MODULE", "PKCS11_MODULE"},
+		},
+		&cli.StringFlag{
+			Name:    "pkcs11-token-label",
+			Usage:   "label of the pkcs11 token",
+			EnvVars: []string{"DOCKIT_PKCS11_TOKEN_LABEL", "PKCS11_TOKEN_LABEL"},
+		},
+		&cli.StringFlag{
+			Name:    "pkcs11-pin",
+			Usage:   "user pin of the pkcs11 token",
+			EnvVars: []string{"DOCKIT_PKCS11_PIN", "PKCS11_PIN"},
+		},
+		&cli.StringFlag{
+			Name:    "pkcs11-key-label",
+			Usage:   "label of the private key on the pkcs11 token",
+			EnvVars: []string{"DOCKIT_PKCS11_KEY_LABEL", "PKCS11_KEY_LABEL"},
+		},
+		&cli.StringFlag{
+			Name:    "pki-signer-url",
+			Usage:   "url of the signing service the webhook signer posts the digests to",
+			EnvVars: []string{"DOCKIT_PKI_SIGNER_URL", "PKI_SIGNER_URL"},
+		},
+		&cli.StringFlag{
+			Name:    "pki-signer-token",
+			Usage:   "bearer token for the signing service",
+			EnvVars: []string{"DOCKIT_PKI_SIGNER_TOKEN", "PKI_SIGNER_TOKEN"},
+		},
+		&cli.StringFlag{
+			Name:    "pki-signer-key-id",
+			Usage:   "id of the key the signing service signs with, sent with every request",
+			EnvVars: []string{"DOCKIT_PKI_SIGNER_KEY_ID", "PKI_SIGNER_KEY_ID"},
+		},
+	}
+}
+
+// newSigner returns the signer configured with the flags, nil when the private keys are in the database
+func newSigner(c *cli.Context) (signer.Signer, error) {
+	switch c.String("pki-signer") {
+	case signer.DB:
+		return nil, nil
+	case signer.PKCS11:
+		config := signer.PKCS11Config{
+			Module:     c.Path("pkcs11-module"),
+			TokenLabel: c.String("pkcs11-token-label"),
+			PIN:        c.String("pkcs11-pin"),
+			KeyLabel:   c.String("pkcs11-key-label"),
+		}
+		if config.Module == "" || config.TokenLabel == "" || config.KeyLabel == "" {
+			return nil, fmt.Errorf("pkcs11 requires pkcs11-module, pkcs11-token-label and pkcs11-key-label")
+		}
+
+		return signer.NewPKCS11Signer(config)
+	case signer.Webhook:
+		if c.String("pki-signer-url") == "" {
+			return nil, fmt.Errorf("webhook requires pki-signer-url")
+		}
+
+		return signer.NewWebhookSigner(c.String("pki-signer-url"), c.String("pki-signer-token"), c.String("pki-signer-key-id")), nil
+	}
+
+	return nil, fmt.Errorf("invalid pki-signer: %s", c.String("pki-signer"))
+}
+
+// acceptedAlgorithm returns an error when the registries do not accept the algorithm
+func acceptedAlgorithm(accepted []string, alg string) error {
+	for _, a := range accepted {
+		if jwt.GetSigningMethod(a) == nil {
+			return fmt.Errorf("unknown algorithm in registry-algorithms: %s", a)
+		}
+		if a == alg {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("tokens would be signed with %s which is not one of the registry-algorithms %s", alg, strings.Join(accepted, ","))
+}
+
+// checkAlgorithms checks that the registries accept the algorithm of the active signing pki
+func checkAlgorithms(database *gorm.DB, accepted []string) error {
+	var pki []db.PKI
+	if sql := database.Where("active = ? AND ca = ? AND expires_at > ?", true, false, time.Now().UTC()).Find(&pki); sql.Error != nil {
+		return sql.Error
+	}
+
+	for _, p := range pki {
+		method, err := handlers.SigningMethod(&p)
+		if err != nil {
+			return err
+		}
+
+		if err := acceptedAlgorithm(accepted, method.Alg()); err != nil {
+			return fmt.Errorf("pki %d: %w", p.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// checkSigner signs with the signer and verifies the signature with the certificate of the active pki, so a
+// signer that does not hold the key of the certificate fails on startup rather than on the first token
+func checkSigner(ctx context.Context, database *gorm.DB, s signer.Signer) error {
+	var pki []db.PKI
+	if sql := database.Where("signer NOT IN ? AND active = ? AND expires_at > ?", []string{"", signer.DB}, true, time.Now().UTC()).Find(&pki); sql.Error != nil {
+		return sql.Error
+	}
+
+	for _, p := range pki {
+		if s == nil || s.Name() != p.Signer {
+			return fmt.Errorf("the private key of pki %d is held by the %s signer, use --pki-signer %s", p.ID, p.Signer, p.Signer)
+		}
+
+		block, _ := pem.Decode([]byte(p.X509))
+		if block == nil {
+			return fmt.Errorf("invalid certificate for pki %d", p.ID)
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return err
+		}
+
+		method, err := handlers.SigningMethod(&p)
+		if err != nil {
+			return err
+		}
+
+		input := []byte(fmt.Sprintf("dockit signer check %d", time.Now().UnixNano()))
+
+		sig, err := s.Sign(ctx, method.Alg(), input)
+		if err != nil {
+			return fmt.Errorf("unable to sign with the %s signer: %w", s.Name(), err)
+		}
+
+		if err := signer.Verify(method.Alg(), input, sig, cert.PublicKey); err != nil {
+			return fmt.Errorf("the %s signer does not hold the private key of pki %d: %w", s.Name(), p.ID, err)
+		}
+	}
+
+	return nil
+}
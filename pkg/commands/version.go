@@ -1,19 +1,22 @@
 package commands
 
 import (
-	"fmt"
+	"os"
 
-	"github.com/ekristen/dockit/pkg/common"
 	"github.com/urfave/cli/v2"
+
+	"github.com/ekristen/dockit/pkg/commands/global"
+	"github.com/ekristen/dockit/pkg/common"
+	"github.com/ekristen/dockit/pkg/output"
 )
 
 type versionCommand struct {
 }
 
 func (w *versionCommand) Execute(c *cli.Context) error {
-	fmt.Printf("%s\n", common.AppVersion.Summary)
-
-	return nil
+	return output.Print(os.Stdout, c.String("output"), common.AppVersion, output.Rows{
+		{common.AppVersion.Summary},
+	})
 }
 
 func init() {
@@ -23,6 +26,8 @@ func init() {
 		Name:   "version",
 		Usage:  "print version",
 		Action: cmd.Execute,
+		Flags:  global.Flags(),
+		Before: global.Before,
 	}
 
 	common.RegisterCommand(cliCmd)
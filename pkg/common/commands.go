@@ -25,8 +25,15 @@ func RegisterSubcommand(group string, command *cli.Command) {
 	subcommands[group] = append(subcommands[group], command)
 }
 
-// GetCommands --
+// GetCommands returns all registered commands with their registered subcommands attached,
+// this allows subcommands to be registered after the parent command
 func GetCommands() []*cli.Command {
+	for _, c := range commands {
+		if s, ok := subcommands[c.Name]; ok {
+			c.Subcommands = s
+		}
+	}
+
 	return commands
 }
 
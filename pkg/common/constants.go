@@ -5,6 +5,9 @@ type ContextKey string
 // Common Constants, mostly for annotations
 const ContextReqIDKey ContextKey = "reqid"
 
+// ContextKeyRemoteAddr is used to set context value for the real client ip
+const ContextKeyRemoteAddr ContextKey = "remoteAddr"
+
 // ContextKeyDb is used to set context value for db
 const ContextKeyDb ContextKey = "db"
 
@@ -11,11 +11,11 @@ var AppVersion AppVersionInfo
 
 // AppVersionInfo --
 type AppVersionInfo struct {
-	Name    string
-	Version string
-	Branch  string
-	Summary string
-	Commit  string
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Branch  string `json:"branch"`
+	Summary string `json:"summary"`
+	Commit  string `json:"commit"`
 }
 
 func init() {
@@ -33,12 +33,35 @@ func New(ctx context.Context, dialect string, dsn string, config *gorm.Config) (
 		return nil, err
 	}
 
+	// the original unique index did not include the entity, action or service which prevented
+	// more than one user or group from holding a permission on the same repository
+	if db.Migrator().HasIndex(&Permission{}, "idx_permissions_unique") {
+		if err := db.Migrator().DropIndex(&Permission{}, "idx_permissions_unique"); err != nil {
+			return nil, err
+		}
+	}
+
+	// the entity of a permission is a user or a group and ServiceID 0 grants for any service, mysql refuses
+	// permissions with the foreign keys that were created for them
+	for _, constraint := range []string{"fk_users_permissions", "fk_groups_permissions", "fk_permissions_service"} {
+		if db.Migrator().HasConstraint(&Permission{}, constraint) {
+			if err := db.Migrator().DropConstraint(&Permission{}, constraint); err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	if err := db.AutoMigrate(
 		&User{},
 		&Group{},
 		&Permission{},
 		&Token{},
 		&PKI{},
+		&Lockout{},
+		&Service{},
+		&Event{},
+		&RepositoryOwner{},
+		&Lease{},
 	); err != nil {
 		return nil, err
 	}
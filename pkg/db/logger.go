@@ -35,19 +35,19 @@ func (l *dlogger) LogMode(gormlogger.LogLevel) gormlogger.Interface {
 }
 
 func (l *dlogger) Info(ctx context.Context, s string, args ...interface{}) {
-	log.WithContext(ctx).Debugf(s, args)
+	log.WithContext(ctx).Debugf(s, args...)
 }
 
 func (l *dlogger) Warn(ctx context.Context, s string, args ...interface{}) {
-	log.WithContext(ctx).Warnf(s, args)
+	log.WithContext(ctx).Warnf(s, args...)
 }
 
 func (l *dlogger) Error(ctx context.Context, s string, args ...interface{}) {
-	log.WithContext(ctx).Errorf(s, args)
+	log.WithContext(ctx).Errorf(s, args...)
 }
 
 func (l *dlogger) Debug(ctx context.Context, s string, args ...interface{}) {
-	log.WithContext(ctx).Debugf(s, args)
+	log.WithContext(ctx).Debugf(s, args...)
 }
 
 func (l *dlogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
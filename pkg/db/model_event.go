@@ -0,0 +1,38 @@
+package db
+
+import (
+	"time"
+
+	"github.com/bwmarrin/snowflake"
+	"github.com/ekristen/dockit/pkg/common"
+	"gorm.io/gorm"
+)
+
+// Event is a manifest push, pull or delete reported by a registry, the actor is the subject of the token
+// the registry request was made with
+type Event struct {
+	ID         int64      `gorm:"primaryKey;autoIncrement:false" json:"id"`
+	EventID    string     `gorm:"uniqueIndex;size:64" json:"event_id"`
+	Service    string     `gorm:"size:255" json:"service,omitempty"`
+	Action     string     `gorm:"index:idx_events_repository;size:16" json:"action"`
+	Repository string     `gorm:"index:idx_events_repository;size:255" json:"repository"`
+	Tag        string     `gorm:"size:128" json:"tag,omitempty"`
+	Digest     string     `gorm:"size:128" json:"digest,omitempty"`
+	MediaType  string     `gorm:"size:255" json:"media_type,omitempty"`
+	Size       int64      `json:"size,omitempty"`
+	Actor      string     `gorm:"size:255" json:"actor,omitempty"`
+	ClientAddr string     `gorm:"size:255" json:"client_addr,omitempty"`
+	UserAgent  string     `json:"user_agent,omitempty"`
+	Timestamp  *time.Time `gorm:"index" json:"timestamp"`
+	CreatedAt  *time.Time `json:"created_at"`
+}
+
+// BeforeCreate --
+func (e *Event) BeforeCreate(tx *gorm.DB) error {
+	if e.ID == 0 {
+		node := tx.Statement.Context.Value(common.ContextKeyNode).(*snowflake.Node)
+		e.ID = node.Generate().Int64()
+	}
+
+	return nil
+}
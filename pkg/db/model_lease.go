@@ -0,0 +1,13 @@
+package db
+
+import (
+	"time"
+)
+
+// Lease is held by one api server at a time for work that must not run in every replica, like the renewal of
+// the signing pki, a lease that was not extended before it expired is taken over by another api server
+type Lease struct {
+	Name      string    `gorm:"primaryKey;size:64" json:"name"`
+	Holder    string    `gorm:"size:64" json:"holder"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
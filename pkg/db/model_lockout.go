@@ -0,0 +1,31 @@
+package db
+
+import (
+	"time"
+
+	"github.com/bwmarrin/snowflake"
+	"github.com/ekristen/dockit/pkg/common"
+	"gorm.io/gorm"
+)
+
+// Lockout tracks failed authentication attempts for a username or client ip
+type Lockout struct {
+	ID          int64      `gorm:"primaryKey;autoIncrement:false" json:"id"`
+	Key         string     `gorm:"uniqueIndex;size:255" json:"key"`
+	Failures    int        `json:"failures"`
+	Lockouts    int        `json:"lockouts"`
+	LastFailure *time.Time `json:"last_failure"`
+	LockedUntil *time.Time `json:"locked_until"`
+	CreatedAt   *time.Time `json:"created_at"`
+	UpdatedAt   *time.Time `json:"updated_at"`
+}
+
+// BeforeCreate --
+func (l *Lockout) BeforeCreate(tx *gorm.DB) error {
+	if l.ID == 0 {
+		node := tx.Statement.Context.Value(common.ContextKeyNode).(*snowflake.Node)
+		l.ID = node.Generate().Int64()
+	}
+
+	return nil
+}
@@ -0,0 +1,29 @@
+package db
+
+import (
+	"time"
+
+	"github.com/bwmarrin/snowflake"
+	"github.com/ekristen/dockit/pkg/common"
+	"gorm.io/gorm"
+)
+
+// RepositoryOwner records who first pushed a repository in a shared namespace, the repository is unique
+// so it can only be claimed once no matter how many api servers receive its events
+type RepositoryOwner struct {
+	ID         int64      `gorm:"primaryKey;autoIncrement:false" json:"id"`
+	Repository string     `gorm:"uniqueIndex;size:255" json:"repository"`
+	UserID     int64      `gorm:"index" json:"-"`
+	EventID    string     `gorm:"size:64" json:"event_id"`
+	CreatedAt  *time.Time `json:"created_at"`
+}
+
+// BeforeCreate --
+func (o *RepositoryOwner) BeforeCreate(tx *gorm.DB) error {
+	if o.ID == 0 {
+		node := tx.Statement.Context.Value(common.ContextKeyNode).(*snowflake.Node)
+		o.ID = node.Generate().Int64()
+	}
+
+	return nil
+}
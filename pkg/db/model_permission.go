@@ -1,6 +1,7 @@
 package db
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/bwmarrin/snowflake"
@@ -27,15 +28,20 @@ const (
 
 type Permission struct {
 	ID        int64            `gorm:"primaryKey;autoIncrement:false" json:"id"`
-	Type      PermissionType   `gorm:"index:idx_permissions_unique,unique" json:"type"`
-	Class     string           `gorm:"index:idx_permissions_unique,unique" json:"class,omitempty"`
-	Name      string           `gorm:"index:idx_permissions_unique,unique" json:"name"`
-	Action    PermissionAction `json:"action"`
-	EntityID  int64            `json:"-"`
+	Type      PermissionType   `gorm:"index:idx_permissions_entity,unique;size:64" json:"type"`
+	Class     string           `gorm:"index:idx_permissions_entity,unique;size:64" json:"class,omitempty"`
+	Name      string           `gorm:"index:idx_permissions_entity,unique;size:255" json:"name"`
+	Action    PermissionAction `gorm:"index:idx_permissions_entity,unique;size:64" json:"action"`
+	EntityID  int64            `gorm:"index:idx_permissions_entity,unique" json:"-"`
+	ServiceID int64            `gorm:"index:idx_permissions_entity,unique" json:"-"`
 	User      *User            `gorm:"foreignKey:EntityID" json:"user,omitempty"`
 	Group     *Group           `gorm:"foreignKey:EntityID" json:"group,omitempty"`
-	CreatedAt *time.Time       `json:"created_at"`
-	UpdatedAt *time.Time       `json:"updated_at"`
+	// ServiceID 0 grants for any service, so it can not reference a service
+	Service   *Service   `gorm:"foreignKey:ServiceID;constraint:-" json:"service,omitempty"`
+	ExpiresAt *time.Time `gorm:"index" json:"expires_at,omitempty"`
+	CIDRs     string     `gorm:"column:cidrs;size:1024" json:"cidrs,omitempty"`
+	CreatedAt *time.Time `json:"created_at"`
+	UpdatedAt *time.Time `json:"updated_at"`
 }
 
 func (p *Permission) BeforeCreate(tx *gorm.DB) error {
@@ -46,3 +52,17 @@ func (p *Permission) BeforeCreate(tx *gorm.DB) error {
 
 	return nil
 }
+
+// Expired reports whether a time-bound grant has expired
+func (p *Permission) Expired() bool {
+	return p.ExpiresAt != nil && !p.ExpiresAt.After(time.Now())
+}
+
+// String returns the permission in type:name:action format, suffixed by @service if it is bound to one
+func (p *Permission) String() string {
+	if p.Service != nil {
+		return fmt.Sprintf("%s:%s:%s@%s", p.Type, p.Name, p.Action, p.Service.Name)
+	}
+
+	return fmt.Sprintf("%s:%s:%s", p.Type, p.Name, p.Action)
+}
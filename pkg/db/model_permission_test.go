@@ -0,0 +1,75 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/bwmarrin/snowflake"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/ekristen/dockit/pkg/common"
+)
+
+// Test_PermissionAnyService creates the tables with foreign keys that are enforced like mysql does
+func Test_PermissionAnyService(t *testing.T) {
+	node, err := snowflake.NewNode(1)
+	assert.NoError(t, err)
+
+	database, err := gorm.Open(sqlite.Open(fmt.Sprintf("file:%s?mode=memory&cache=shared&_pragma=foreign_keys(1)", t.Name())), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	assert.NoError(t, err)
+	database = database.WithContext(context.WithValue(context.Background(), common.ContextKeyNode, node))
+
+	assert.NoError(t, database.AutoMigrate(&User{}, &Group{}, &Service{}, &Permission{}))
+
+	user := &User{Username: "bob", Password: "bobpw", Active: true}
+	assert.NoError(t, database.Create(user).Error)
+	service := &Service{Name: "registry-prod"}
+	assert.NoError(t, database.Create(service).Error)
+
+	assert.NoError(t, database.Create(&Permission{Type: Repository, Name: "team/app", Action: Pull, EntityID: user.ID}).Error)
+	assert.NoError(t, database.Create(&Permission{Type: Repository, Name: "team/app", Action: Push, EntityID: user.ID, ServiceID: service.ID}).Error)
+
+	group := &Group{Name: "team", Active: true}
+	assert.NoError(t, database.Create(group).Error)
+	assert.NoError(t, database.Create(&Permission{Type: Namespace, Name: "team", Action: Pull, EntityID: group.ID}).Error)
+
+	var permissions []Permission
+	assert.NoError(t, database.Preload("Service").Where("entity_id = ?", user.ID).Order("action").Find(&permissions).Error)
+	if assert.Len(t, permissions, 2) {
+		assert.Equal(t, "repository:team/app:pull", permissions[0].String())
+		assert.Equal(t, "repository:team/app:push@registry-prod", permissions[1].String())
+	}
+}
+
+// Test_PermissionForeignKeysDropped migrates a permissions table that was created with the foreign keys
+func Test_PermissionForeignKeysDropped(t *testing.T) {
+	node, err := snowflake.NewNode(1)
+	assert.NoError(t, err)
+
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared&_pragma=foreign_keys(1)", t.Name())
+
+	old, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	assert.NoError(t, err)
+	assert.NoError(t, old.Exec("CREATE TABLE `users` (`id` integer,PRIMARY KEY (`id`))").Error)
+	assert.NoError(t, old.Exec("CREATE TABLE `groups` (`id` integer,PRIMARY KEY (`id`))").Error)
+	assert.NoError(t, old.Exec("CREATE TABLE `services` (`id` integer,PRIMARY KEY (`id`))").Error)
+	assert.NoError(t, old.Exec("CREATE TABLE `permissions` (`id` integer,`entity_id` integer,`service_id` integer,PRIMARY KEY (`id`),"+
+		"CONSTRAINT `fk_users_permissions` FOREIGN KEY (`entity_id`) REFERENCES `users`(`id`),"+
+		"CONSTRAINT `fk_groups_permissions` FOREIGN KEY (`entity_id`) REFERENCES `groups`(`id`),"+
+		"CONSTRAINT `fk_permissions_service` FOREIGN KEY (`service_id`) REFERENCES `services`(`id`))").Error)
+
+	database, err := New(context.WithValue(context.Background(), common.ContextKeyNode, node), "sqlite", dsn, nil)
+	assert.NoError(t, err)
+
+	for _, constraint := range []string{"fk_users_permissions", "fk_groups_permissions", "fk_permissions_service"} {
+		assert.False(t, database.Migrator().HasConstraint(&Permission{}, constraint), constraint)
+	}
+
+	assert.NoError(t, database.Create(&Permission{Type: Repository, Name: "team/app", Action: Pull, EntityID: 1}).Error)
+}
@@ -7,29 +7,50 @@ import (
 )
 
 type PKI struct {
-	ID        int64 `gorm:"primaryKey;autoIncrement:false" json:"id"`
-	Type      string
-	Bits      int
-	Private   string
-	Public    string
-	X509      string
+	ID      int64 `gorm:"primaryKey;autoIncrement:false" json:"id"`
+	Type    string
+	Bits    int
+	Private string
+	// Signer holds the private key when it is not stored in Private, like pkcs11 or webhook
+	Signer string `gorm:"size:32"`
+	Public string
+	X509   string
+	// Serial is the serial number of the certificate in hex, it can exceed the id and is only unique per issuer
+	Serial string `gorm:"size:64;index"`
+	// Chain are the certificates of the issuing ca up to its root, empty for a self-signed certificate
+	Chain string
+	// CA issues signing certificates rather than signing tokens
+	CA        bool `gorm:"index;not null;default:false"`
 	NotBefore *time.Time
 	ExpiresAt *time.Time
 	Active    bool
-	CreatedAt *time.Time `json:"created_at"`
-	UpdatedAt *time.Time `json:"updated_at"`
+	// ActivateAt is when a renewed pki becomes active, it is published with the certificates until then
+	ActivateAt *time.Time
+	// DeactivatedAt is when another pki took over, the certificate stays published until the tokens signed
+	// with it expired
+	DeactivatedAt *time.Time
+	CreatedAt     *time.Time `json:"created_at"`
+	UpdatedAt     *time.Time `json:"updated_at"`
 }
 
 func (p *PKI) AfterCreate(tx *gorm.DB) (err error) {
 	if p.Active {
-		tx.Model(&PKI{}).Where("id != ?", p.ID).Update("active", false)
+		deactivateOthers(tx, p)
 	}
 	return
 }
 
 func (p *PKI) AfterUpdate(tx *gorm.DB) (err error) {
 	if p.Active {
-		tx.Model(&PKI{}).Where("id != ?", p.ID).Update("active", false)
+		deactivateOthers(tx, p)
 	}
 	return
 }
+
+// deactivateOthers deactivates the pki of the same kind that was active before the pki
+func deactivateOthers(tx *gorm.DB, p *PKI) {
+	tx.Model(&PKI{}).Where("id != ? AND ca = ? AND active = ?", p.ID, p.CA, true).Updates(map[string]interface{}{
+		"active":         false,
+		"deactivated_at": time.Now().UTC(),
+	})
+}
@@ -14,10 +14,13 @@ type Group struct {
 	ID          int64         `gorm:"primaryKey;autoIncrement:false" json:"id"`
 	Name        string        `json:"name"`
 	Active      bool          `json:"active"`
+	TokenTTL    int           `json:"token_ttl,omitempty"`
+	TokenClaims string        `json:"token_claims,omitempty"`
 	CreatedAt   *time.Time    `json:"created_at"`
 	UpdatedAt   *time.Time    `json:"updated_at"`
 	Users       []*User       `gorm:"many2many:user_groups" json:"users,omitempty"`
-	Permissions []*Permission `gorm:"foreignKey:EntityID" json:"permissions,omitempty"`
+	Children    []*Group      `gorm:"many2many:group_groups;joinForeignKey:ParentID;joinReferences:ChildID" json:"groups,omitempty"`
+	Permissions []*Permission `gorm:"foreignKey:EntityID;constraint:-" json:"permissions,omitempty"`
 }
 
 // BeforeCreate --
@@ -34,15 +37,18 @@ func (g *Group) BeforeCreate(tx *gorm.DB) error {
 type User struct {
 	ID          int64         `gorm:"primaryKey;autoIncrement:false" json:"id"`
 	Name        string        `json:"name"`
-	Username    string        `gorm:"uniqueIndex,size:255"`
+	Username    string        `gorm:"uniqueIndex,size:255" json:"username"`
 	Password    string        `json:"-"`
 	Admin       bool          `json:"admin"`
 	Active      bool          `json:"active"`
+	Robot       bool          `json:"robot"`
+	TokenTTL    int           `json:"token_ttl,omitempty"`
+	TokenClaims string        `json:"token_claims,omitempty"`
 	CreatedAt   *time.Time    `json:"created_at"`
 	UpdatedAt   *time.Time    `json:"updated_at"`
 	Groups      []*Group      `gorm:"many2many:user_groups" json:"groups,omitempty"`
-	Permissions []*Permission `gorm:"foreignKey:EntityID" json:"permissions,omitempty"`
-	Tokens      []*Token      `gorm:"foreignKey:UserID" json:"users,omitempty"`
+	Permissions []*Permission `gorm:"foreignKey:EntityID;constraint:-" json:"permissions,omitempty"`
+	Tokens      []*Token      `gorm:"foreignKey:UserID" json:"tokens,omitempty"`
 }
 
 // BeforeCreate --
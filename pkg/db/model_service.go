@@ -0,0 +1,30 @@
+package db
+
+import (
+	"time"
+
+	"github.com/bwmarrin/snowflake"
+	"github.com/ekristen/dockit/pkg/common"
+	"gorm.io/gorm"
+)
+
+// Service is a registry that trusts dockit, the name is the audience of the tokens issued for it.
+// EventsToken is the sha256 of the bearer token the registry sends its notifications with.
+type Service struct {
+	ID          int64      `gorm:"primaryKey;autoIncrement:false" json:"id"`
+	Name        string     `gorm:"uniqueIndex;size:255" json:"name"`
+	Description string     `json:"description,omitempty"`
+	EventsToken string     `gorm:"size:64" json:"-"`
+	CreatedAt   *time.Time `json:"created_at"`
+	UpdatedAt   *time.Time `json:"updated_at"`
+}
+
+// BeforeCreate --
+func (s *Service) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == 0 {
+		node := tx.Statement.Context.Value(common.ContextKeyNode).(*snowflake.Node)
+		s.ID = node.Generate().Int64()
+	}
+
+	return nil
+}
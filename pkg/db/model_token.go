@@ -1,15 +1,52 @@
 package db
 
-import "time"
+import (
+	"time"
 
+	"github.com/bwmarrin/snowflake"
+	"github.com/ekristen/dockit/pkg/common"
+	"gorm.io/gorm"
+)
+
+type TokenType string
+
+const (
+	AccessTokenType   TokenType = "access"
+	RefreshTokenType  TokenType = "refresh"
+	PersonalTokenType TokenType = "personal"
+)
+
+// Token records an issued access, refresh or personal access token so it can be introspected and revoked,
+// only the sha256 hash of a refresh or personal access token is stored
 type Token struct {
-	ID           int64 `gorm:"primaryKey;autoIncrement:false" json:"id"`
-	AccessToken  string
-	RefreshToken string
-	ExpiresIn    int
-	IssuedAt     *time.Time
-	ExpiresAt    *time.Time
-	UserID       int64
-	CreatedAt    *time.Time `json:"created_at"`
-	UpdatedAt    *time.Time `json:"updated_at"`
+	ID        int64     `gorm:"primaryKey;autoIncrement:false" json:"id"`
+	JTI       string    `gorm:"uniqueIndex;size:64" json:"jti"`
+	Type      TokenType `gorm:"size:16" json:"type"`
+	Hash      string    `gorm:"index;size:64" json:"-"`
+	UserID    int64     `gorm:"index" json:"-"`
+	RefreshID int64     `gorm:"index" json:"-"`
+	Service   string    `json:"service,omitempty"`
+	Scope     string    `json:"scope,omitempty"`
+	// Description is set by the user for personal access tokens
+	Description string     `json:"description,omitempty"`
+	IssuedAt    *time.Time `json:"issued_at"`
+	ExpiresAt   *time.Time `gorm:"index" json:"expires_at"`
+	RevokedAt   *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt   *time.Time `json:"created_at"`
+	UpdatedAt   *time.Time `json:"updated_at"`
+}
+
+// BeforeCreate --
+func (t *Token) BeforeCreate(tx *gorm.DB) error {
+	if t.ID == 0 {
+		node := tx.Statement.Context.Value(common.ContextKeyNode).(*snowflake.Node)
+		t.ID = node.Generate().Int64()
+	}
+
+	return nil
+}
+
+// Active reports whether the token has neither expired nor been revoked
+func (t *Token) Active() bool {
+	return t.RevokedAt == nil && t.ExpiresAt != nil && t.ExpiresAt.After(time.Now())
 }
@@ -53,3 +53,13 @@ func splitResourceClass(t string) (string, string) {
 	}
 	return matches[1], matches[2][1 : len(matches[2])-1]
 }
+
+// String returns the scope in the type[(class)]:name:actions format it is requested in
+func (s Scope) String() string {
+	t := s.Type
+	if s.Class != "" {
+		t = fmt.Sprintf("%s(%s)", s.Type, s.Class)
+	}
+
+	return fmt.Sprintf("%s:%s:%s", t, s.Name, strings.Join(s.Actions, ","))
+}
@@ -0,0 +1,129 @@
+package envelope
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// BlockType is the pem type of an encrypted private key
+const BlockType = "DOCKIT ENCRYPTED PRIVATE KEY"
+
+// ErrNoKey is returned when an encrypted private key is read without a key encryption key
+var ErrNoKey = errors.New("private key is encrypted, a key encryption key is required")
+
+// Keyring encrypts private keys with a random data key per key, the data key is wrapped by the KMS and
+// stored next to the ciphertext in a pem block
+type Keyring struct {
+	kms KMS
+}
+
+// New returns a keyring for the KMS
+func New(kms KMS) *Keyring {
+	return &Keyring{kms: kms}
+}
+
+// KeyID returns the id of the key encryption key
+func (k *Keyring) KeyID() string {
+	return k.kms.KeyID()
+}
+
+// IsEncrypted reports whether the stored private key is encrypted
+func IsEncrypted(stored string) bool {
+	block, _ := pem.Decode([]byte(stored))
+	return block != nil && block.Type == BlockType
+}
+
+// IsBound reports whether the stored private key is encrypted and bound to the id of its pki, keys encrypted
+// before the id was authenticated can be swapped between pki until they are encrypted again
+func IsBound(stored string) bool {
+	block, _ := pem.Decode([]byte(stored))
+	return block != nil && block.Type == BlockType && block.Headers["Pki-Id"] != ""
+}
+
+// Encrypt encrypts the private key pem of the pki with the id, the result is a pem block that is safe to store.
+// The id is authenticated with the ciphertext so it can only be decrypted for the same pki.
+func (k *Keyring) Encrypt(ctx context.Context, id int64, plaintext []byte) (string, error) {
+	dek := make([]byte, 32)
+	if _, err := rand.Read(dek); err != nil {
+		return "", err
+	}
+
+	aead, err := newAEAD(dek)
+	if err != nil {
+		return "", err
+	}
+
+	ciphertext, err := seal(aead, plaintext, []byte(strconv.FormatInt(id, 10)))
+	if err != nil {
+		return "", err
+	}
+
+	wrapped, err := k.kms.WrapKey(ctx, dek)
+	if err != nil {
+		return "", fmt.Errorf("unable to wrap data key: %w", err)
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{
+		Type: BlockType,
+		Headers: map[string]string{
+			"Key-Id":   k.kms.KeyID(),
+			"Pki-Id":   strconv.FormatInt(id, 10),
+			"Data-Key": base64.StdEncoding.EncodeToString(wrapped),
+		},
+		Bytes: ciphertext,
+	})), nil
+}
+
+// Decrypt returns the private key pem of the pki with the id, a private key that is not encrypted is returned as
+// is so keys stored before encryption was enabled keep working. The keyring may be nil when no key encryption
+// key is configured.
+func (k *Keyring) Decrypt(ctx context.Context, id int64, stored string) ([]byte, error) {
+	block, _ := pem.Decode([]byte(stored))
+	if block == nil || block.Type != BlockType {
+		return []byte(stored), nil
+	}
+
+	if k == nil {
+		return nil, ErrNoKey
+	}
+
+	if id := block.Headers["Key-Id"]; id != k.kms.KeyID() {
+		return nil, fmt.Errorf("private key is encrypted with key %s, configured is %s", id, k.kms.KeyID())
+	}
+
+	// keys encrypted before the id was authenticated have no pki id and no additional data
+	var aad []byte
+	if pkiID := block.Headers["Pki-Id"]; pkiID != "" {
+		aad = []byte(strconv.FormatInt(id, 10))
+		if pkiID != string(aad) {
+			return nil, fmt.Errorf("private key is encrypted for pki %s, not %d", pkiID, id)
+		}
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(block.Headers["Data-Key"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid data key: %w", err)
+	}
+
+	dek, err := k.kms.UnwrapKey(ctx, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("unable to unwrap data key: %w", err)
+	}
+
+	aead, err := newAEAD(dek)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := open(aead, block.Bytes, aad)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt private key: %w", err)
+	}
+
+	return plaintext, nil
+}
@@ -0,0 +1,142 @@
+package envelope
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// KMS wraps and unwraps the data keys with a key encryption key that never leaves it
+type KMS interface {
+	// KeyID identifies the key encryption key, it is stored with every wrapped data key
+	KeyID() string
+	WrapKey(ctx context.Context, dek []byte) ([]byte, error)
+	UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+// KMSFactory creates a KMS from the url given with --pki-kms
+type KMSFactory func(u *url.URL) (KMS, error)
+
+var kmsMu sync.Mutex
+var kmsFactories = map[string]KMSFactory{}
+
+// RegisterKMS makes a KMS available under the scheme of the --pki-kms url
+func RegisterKMS(scheme string, factory KMSFactory) {
+	kmsMu.Lock()
+	defer kmsMu.Unlock()
+
+	kmsFactories[scheme] = factory
+}
+
+// NewKMS creates the KMS registered for the scheme of the url
+func NewKMS(rawURL string) (KMS, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme == "" {
+		return nil, fmt.Errorf("invalid kms url: %s", rawURL)
+	}
+
+	kmsMu.Lock()
+	factory, ok := kmsFactories[u.Scheme]
+	kmsMu.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unsupported kms %s, one of: %s", u.Scheme, strings.Join(registeredKMS(), ", "))
+	}
+
+	return factory(u)
+}
+
+func registeredKMS() []string {
+	kmsMu.Lock()
+	defer kmsMu.Unlock()
+
+	names := []string{}
+	for name := range kmsFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// localKMS wraps the data keys with AES-256-GCM and a key encryption key held in memory
+type localKMS struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// NewLocalKMS returns a KMS for a 32 byte key encryption key
+func NewLocalKMS(kek []byte) (KMS, error) {
+	if len(kek) != 32 {
+		return nil, fmt.Errorf("key encryption key must be 32 bytes, got %d", len(kek))
+	}
+
+	aead, err := newAEAD(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(kek)
+
+	return &localKMS{id: "local:" + hex.EncodeToString(sum[:8]), aead: aead}, nil
+}
+
+// ParseKey decodes a base64 encoded key encryption key, as generated with openssl rand -base64 32
+func ParseKey(data string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(data))
+	if err != nil {
+		return nil, errors.New("key encryption key must be base64 encoded")
+	}
+
+	return key, nil
+}
+
+func (k *localKMS) KeyID() string {
+	return k.id
+}
+
+func (k *localKMS) WrapKey(_ context.Context, dek []byte) ([]byte, error) {
+	return seal(k.aead, dek, nil)
+}
+
+func (k *localKMS) UnwrapKey(_ context.Context, wrapped []byte) ([]byte, error) {
+	return open(k.aead, wrapped, nil)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts the plaintext and authenticates the additional data, the random nonce is prepended to the
+// ciphertext
+func seal(aead cipher.AEAD, plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(aead cipher.AEAD, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+
+	return aead.Open(nil, ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():], additionalData)
+}
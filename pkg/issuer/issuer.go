@@ -0,0 +1,782 @@
+package issuer
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/snowflake"
+	"gorm.io/gorm"
+
+	"github.com/ekristen/dockit/pkg/db"
+	"github.com/ekristen/dockit/pkg/envelope"
+	"github.com/ekristen/dockit/pkg/signer"
+	"github.com/ekristen/dockit/pkg/utils"
+)
+
+// ErrCAExpires is returned when a renewed signing certificate would not outlive the current one as the ca
+// expires first
+var ErrCAExpires = errors.New("the ca expires before the signing certificate could be renewed, the ca has to be renewed")
+
+// ErrActivateAfterExpiry is returned when a pki would be activated after its certificate expired
+var ErrActivateAfterExpiry = errors.New("the certificate expires before the pki would be activated")
+
+// ErrNoActiveCA is returned when a ca exists but none is active and valid, one has to be activated or imported
+var ErrNoActiveCA = errors.New("no active ca, activate or import one")
+
+// ErrExists is returned when a pki with the serial number of an imported certificate exists
+var ErrExists = errors.New("a pki with the serial number of the certificate exists")
+
+// Options configure the keys and certificates of new signing pki
+type Options struct {
+	// KeyType is ec, rsa, rsa-pss or ed25519
+	KeyType    string
+	ECKeySize  int
+	RSAKeySize int
+	// Years is the lifetime of a signing certificate
+	Years   int
+	Subject pkix.Name
+
+	// CA issues the signing certificates from the internal ca, one is generated when there is none
+	CA        bool
+	CASubject pkix.Name
+	CAYears   int
+
+	// Keyring encrypts the private keys, nil stores them unencrypted
+	Keyring *envelope.Keyring
+
+	// Prepublish is how long a pki that replaces the active one after a change of the options is published
+	// before it is activated
+	Prepublish time.Duration
+
+	// Algorithms the registries accept, an imported pki that signs with another is rejected, empty accepts any
+	Algorithms []string
+}
+
+// Issuer creates the pki tokens are signed with
+type Issuer struct {
+	db   *gorm.DB
+	node *snowflake.Node
+	opts Options
+}
+
+// New returns an issuer
+func New(database *gorm.DB, node *snowflake.Node, opts Options) *Issuer {
+	return &Issuer{db: database, node: node, opts: opts}
+}
+
+// keyType returns the type and size of the keys generated with the options as they are stored with the pki
+func (o Options) keyType() (string, int, error) {
+	switch o.KeyType {
+	case "ec":
+		return "ECDSA", o.ECKeySize, nil
+	case "rsa":
+		return "RSA", o.RSAKeySize, nil
+	case "rsa-pss":
+		return "RSA-PSS", o.RSAKeySize, nil
+	case "ed25519":
+		return "Ed25519", 256, nil
+	}
+
+	return "", 0, fmt.Errorf("invalid pki key type: %s", o.KeyType)
+}
+
+// Algorithm returns the jws algorithm tokens are signed with by the keys generated with the options
+func (o Options) Algorithm() (string, error) {
+	keyType, bits, err := o.keyType()
+	if err != nil {
+		return "", err
+	}
+
+	return signer.Algorithm(keyType, bits)
+}
+
+// accepted reports whether the registries accept the algorithm
+func (o Options) accepted(alg string) bool {
+	if len(o.Algorithms) == 0 {
+		return true
+	}
+
+	for _, a := range o.Algorithms {
+		if a == alg {
+			return true
+		}
+	}
+
+	return false
+}
+
+// generateKey returns a new private key and its pem for the options
+func (i *Issuer) generateKey() (crypto.Signer, []byte, string, int, error) {
+	keyType, bits, err := i.opts.keyType()
+	if err != nil {
+		return nil, nil, "", 0, err
+	}
+
+	var key crypto.Signer
+	var keyPEM []byte
+
+	switch keyType {
+	case "ECDSA":
+		key, keyPEM, err = utils.GenerateECKey(bits)
+	case "RSA", "RSA-PSS":
+		key, keyPEM, err = utils.GenerateRSAKey(bits)
+	case "Ed25519":
+		key, keyPEM, err = utils.GenerateEd25519Key()
+	}
+
+	return key, keyPEM, keyType, bits, err
+}
+
+// private returns the private key pem of the pki with the id as it is stored
+func (i *Issuer) private(ctx context.Context, id int64, keyPEM []byte) (string, error) {
+	if i.opts.Keyring == nil {
+		return string(keyPEM), nil
+	}
+
+	return i.opts.Keyring.Encrypt(ctx, id, keyPEM)
+}
+
+// IssuedByCA reports whether signing pki are issued by the internal ca, the bundle of the registries then only
+// has the ca and does not change with a new signing pki
+func (i *Issuer) IssuedByCA() bool {
+	return i.opts.CA
+}
+
+// Generate creates a new active signing pki, issued by the ca when the internal ca is enabled
+func (i *Issuer) Generate(ctx context.Context) (*db.PKI, error) {
+	pki, err := i.generate(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// a renewed pki that was not activated yet is replaced by the new one
+	sql := i.db.Model(&db.PKI{}).Where("ca = ? AND active = ? AND activate_at IS NOT NULL", false, false).Update("activate_at", nil)
+	if sql.Error != nil {
+		return nil, sql.Error
+	}
+
+	return pki, nil
+}
+
+// Renew creates the signing pki that follows the current one, it is published with the certificates right away
+// and becomes active at activateAt, current is nil when there is no pki it follows
+func (i *Issuer) Renew(ctx context.Context, current *db.PKI, activateAt time.Time) (*db.PKI, error) {
+	if i.opts.CA {
+		ca, _, _, err := i.CA(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if current != nil && current.ExpiresAt != nil && !ca.ExpiresAt.After(*current.ExpiresAt) {
+			return nil, ErrCAExpires
+		}
+	}
+
+	return i.generate(ctx, &activateAt)
+}
+
+// Activate makes the pki the active signing pki, the previous one is deactivated
+func Activate(database *gorm.DB, pki *db.PKI) error {
+	pki.Active = true
+	pki.ActivateAt = nil
+	pki.DeactivatedAt = nil
+
+	return database.Model(pki).Select("active", "activate_at", "deactivated_at").Updates(pki).Error
+}
+
+// generate creates a signing pki, it is active unless it is activated later at activateAt
+func (i *Issuer) generate(ctx context.Context, activateAt *time.Time) (*db.PKI, error) {
+	var ca *db.PKI
+	var caCert *x509.Certificate
+	var caKey crypto.Signer
+
+	if i.opts.CA {
+		var err error
+		ca, caCert, caKey, err = i.CA(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	key, keyPEM, keyType, bits, err := i.generateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	id := i.node.Generate().Int64()
+
+	var cert *x509.Certificate
+	var certPEM []byte
+	if ca != nil {
+		cert, certPEM, err = utils.IssueCertificate(id, i.opts.Subject, key.Public(), caCert, caKey, i.opts.Years, 0, 0)
+	} else {
+		cert, certPEM, err = utils.GenerateCertificate(id, i.opts.Subject, key.Public(), key, i.opts.Years, 0, 0)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if activateAt != nil && activateAt.After(cert.NotAfter) {
+		return nil, ErrActivateAfterExpiry
+	}
+
+	stored, err := i.private(ctx, id, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	pki := &db.PKI{
+		ID:         id,
+		Type:       keyType,
+		Bits:       bits,
+		Private:    stored,
+		Signer:     signer.DB,
+		X509:       string(certPEM),
+		Serial:     Serial(cert),
+		NotBefore:  &cert.NotBefore,
+		ExpiresAt:  &cert.NotAfter,
+		Active:     activateAt == nil,
+		ActivateAt: activateAt,
+	}
+	if ca != nil {
+		pki.Chain = ca.X509 + ca.Chain
+	}
+
+	if sql := i.db.Create(pki); sql.Error != nil {
+		return nil, sql.Error
+	}
+
+	return pki, nil
+}
+
+// Ensure returns the active signing pki, a new one is generated when there is none. When the key of the active
+// one is not of the configured type or, with the internal ca enabled, it was not issued by the active ca, the
+// pki that replaces it is renewed and activated after Prepublish so the registries can pick up its certificate,
+// the active one keeps signing until then. The generated pki is returned when one was generated.
+func (i *Issuer) Ensure(ctx context.Context) (*db.PKI, bool, error) {
+	var pki db.PKI
+	sql := i.db.Where("active = ? AND ca = ? AND expires_at > ?", true, false, time.Now().UTC()).Order("created_at DESC").Limit(1).Find(&pki)
+	if sql.Error != nil {
+		return nil, false, sql.Error
+	}
+	hasActive := sql.RowsAffected == 1
+
+	var pending db.PKI
+	sql = i.db.Where("active = ? AND ca = ? AND activate_at IS NOT NULL AND expires_at > ?", false, false, time.Now().UTC()).Order("activate_at").Limit(1).Find(&pending)
+	if sql.Error != nil {
+		return nil, false, sql.Error
+	}
+	hasPending := sql.RowsAffected == 1
+
+	// a renewed pki takes over when the active one expired while dockit was not running
+	if !hasActive && hasPending {
+		if err := Activate(i.db, &pending); err != nil {
+			return nil, false, err
+		}
+
+		pki, hasActive, hasPending = pending, true, false
+	}
+
+	if hasActive {
+		current, err := i.current(ctx, &pki)
+		if err != nil {
+			return nil, false, err
+		}
+		if current {
+			return &pki, false, nil
+		}
+	}
+
+	if !hasActive || i.opts.Prepublish <= 0 {
+		generated, err := i.Generate(ctx)
+		if err != nil {
+			return nil, false, err
+		}
+
+		return generated, true, nil
+	}
+
+	// the replacement was renewed on an earlier start and is not activated yet
+	if hasPending {
+		current, err := i.current(ctx, &pending)
+		if err != nil {
+			return nil, false, err
+		}
+		if current {
+			return &pki, false, nil
+		}
+	}
+
+	activateAt := time.Now().UTC().Add(i.opts.Prepublish)
+	if activateAt.After(*pki.ExpiresAt) {
+		activateAt = *pki.ExpiresAt
+	}
+
+	// the new pki replaces the active one rather than following it, it is not bound to expire before the ca
+	renewed, err := i.Renew(ctx, nil, activateAt)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// a pending pki with the previous options is replaced by the renewed one
+	sql = i.db.Model(&db.PKI{}).Where("ca = ? AND active = ? AND activate_at IS NOT NULL AND id <> ?", false, false, renewed.ID).Update("activate_at", nil)
+	if sql.Error != nil {
+		return nil, false, sql.Error
+	}
+
+	return renewed, true, nil
+}
+
+// current reports whether the signing pki has a key of the configured type and, with the internal ca enabled,
+// was issued by the active ca
+func (i *Issuer) current(ctx context.Context, pki *db.PKI) (bool, error) {
+	keyType, bits, err := i.opts.keyType()
+	if err != nil {
+		return false, err
+	}
+
+	if pki.Type != keyType || pki.Bits != bits {
+		return false, nil
+	}
+
+	if i.opts.CA {
+		ca, _, _, err := i.CA(ctx)
+		if err != nil {
+			return false, err
+		}
+
+		return strings.HasPrefix(pki.Chain, ca.X509), nil
+	}
+
+	return true, nil
+}
+
+// CA returns the active ca with its certificate and private key, a new ca is only generated when there never
+// was one
+func (i *Issuer) CA(ctx context.Context) (*db.PKI, *x509.Certificate, crypto.Signer, error) {
+	var ca db.PKI
+	sql := i.db.Where("ca = ? AND active = ? AND expires_at > ?", true, true, time.Now().UTC()).Order("expires_at DESC").First(&ca)
+	if sql.Error == gorm.ErrRecordNotFound {
+		// a deactivated or expired ca is not silently replaced
+		var count int64
+		if sql := i.db.Model(&db.PKI{}).Where("ca = ?", true).Count(&count); sql.Error != nil {
+			return nil, nil, nil, sql.Error
+		}
+		if count > 0 {
+			return nil, nil, nil, ErrNoActiveCA
+		}
+
+		generated, err := i.generateCA(ctx)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		ca = *generated
+	} else if sql.Error != nil {
+		return nil, nil, nil, sql.Error
+	}
+
+	cert, err := ParseCertificate(ca.X509)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	keyPEM, err := i.opts.Keyring.Decrypt(ctx, ca.ID, ca.Private)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	key, err := ParsePrivateKey(keyPEM)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return &ca, cert, key, nil
+}
+
+func (i *Issuer) generateCA(ctx context.Context) (*db.PKI, error) {
+	key, keyPEM, keyType, bits, err := i.generateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	id := i.node.Generate().Int64()
+
+	cert, certPEM, err := utils.GenerateCA(id, i.opts.CASubject, key.Public(), key, i.opts.CAYears)
+	if err != nil {
+		return nil, err
+	}
+
+	stored, err := i.private(ctx, id, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	ca := &db.PKI{
+		ID:        id,
+		Type:      keyType,
+		Bits:      bits,
+		Private:   stored,
+		Signer:    signer.DB,
+		X509:      string(certPEM),
+		Serial:    Serial(cert),
+		CA:        true,
+		NotBefore: &cert.NotBefore,
+		ExpiresAt: &cert.NotAfter,
+		Active:    true,
+	}
+
+	if sql := i.db.Create(ca); sql.Error != nil {
+		return nil, sql.Error
+	}
+
+	return ca, nil
+}
+
+// splitPEM returns the certificate of the private key in a pem file, the other certificates are its chain
+func splitPEM(data []byte) (*x509.Certificate, string, string, []byte, error) {
+	var certs []*x509.Certificate
+	var certPEMs [][]byte
+	var keyPEM []byte
+
+	rest := data
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+
+		switch block.Type {
+		case "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, "", "", nil, err
+			}
+			certs = append(certs, cert)
+			certPEMs = append(certPEMs, pem.EncodeToMemory(block))
+		case "RSA PRIVATE KEY", "EC PRIVATE KEY", "PRIVATE KEY":
+			keyPEM = pem.EncodeToMemory(block)
+		}
+	}
+
+	if keyPEM == nil {
+		return nil, "", "", nil, errors.New("unable to find the private key")
+	}
+
+	key, err := ParsePrivateKey(keyPEM)
+	if err != nil {
+		return nil, "", "", nil, err
+	}
+
+	var cert *x509.Certificate
+	var certPEM string
+	var chain strings.Builder
+	for n, c := range certs {
+		if cert == nil && publicKeyEqual(c.PublicKey, key.Public()) {
+			cert = c
+			certPEM = string(certPEMs[n])
+			continue
+		}
+		chain.Write(certPEMs[n])
+	}
+
+	if cert == nil {
+		return nil, "", "", nil, errors.New("unable to find the certificate of the private key")
+	}
+
+	return cert, certPEM, chain.String(), keyPEM, nil
+}
+
+// Import stores the signing pki of a pem file with the certificate and private key, further certificates are
+// the chain of the issuing ca. It is active unless it is activated later at activateAt.
+func (i *Issuer) Import(ctx context.Context, data []byte, activateAt *time.Time) (*db.PKI, error) {
+	cert, certPEM, chain, keyPEM, err := splitPEM(data)
+	if err != nil {
+		return nil, err
+	}
+	if cert.IsCA {
+		return nil, errors.New("the certificate is a ca, import it as the ca")
+	}
+
+	keyType, bits := KeyType(cert.PublicKey)
+	if keyType == "RSA" && i.opts.KeyType == "rsa-pss" {
+		keyType = "RSA-PSS"
+	}
+	alg, err := signer.Algorithm(keyType, bits)
+	if err != nil {
+		return nil, err
+	}
+	if !i.opts.accepted(alg) {
+		return nil, fmt.Errorf("tokens would be signed with %s which the registries do not accept", alg)
+	}
+	if activateAt != nil && activateAt.After(cert.NotAfter) {
+		return nil, ErrActivateAfterExpiry
+	}
+
+	var existing db.PKI
+	if sql := i.db.Where("serial = ? AND ca = ?", Serial(cert), false).Limit(1).Find(&existing); sql.Error != nil {
+		return nil, sql.Error
+	} else if sql.RowsAffected == 1 {
+		return nil, fmt.Errorf("%w: %d", ErrExists, existing.ID)
+	}
+
+	id := i.node.Generate().Int64()
+
+	stored, err := i.private(ctx, id, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	pki := &db.PKI{
+		ID:         id,
+		Type:       keyType,
+		Bits:       bits,
+		Private:    stored,
+		Signer:     signer.DB,
+		X509:       certPEM,
+		Serial:     Serial(cert),
+		Chain:      chain,
+		NotBefore:  &cert.NotBefore,
+		ExpiresAt:  &cert.NotAfter,
+		Active:     activateAt == nil,
+		ActivateAt: activateAt,
+	}
+
+	if sql := i.db.Create(pki); sql.Error != nil {
+		return nil, sql.Error
+	}
+
+	return pki, nil
+}
+
+// ImportCA stores the ca of a pem file as the active ca. The file has the certificate and private key of
+// the ca, further certificates are the chain of an intermediate ca up to its root.
+func (i *Issuer) ImportCA(ctx context.Context, data []byte) (*db.PKI, error) {
+	ca, caPEM, chain, keyPEM, err := splitPEM(data)
+	if err != nil {
+		return nil, err
+	}
+	if !ca.IsCA || ca.KeyUsage&x509.KeyUsageCertSign == 0 {
+		return nil, errors.New("the certificate is not a ca that can sign certificates")
+	}
+
+	keyType, bits := KeyType(ca.PublicKey)
+
+	// importing the same ca again keeps the stored key, it may be encrypted with another key by now
+	var existing db.PKI
+	if sql := i.db.Where("serial = ? AND ca = ?", Serial(ca), true).Limit(1).Find(&existing); sql.Error != nil {
+		return nil, sql.Error
+	} else if sql.RowsAffected == 1 {
+		if !existing.Active {
+			if sql := i.db.Model(&existing).Update("active", true); sql.Error != nil {
+				return nil, sql.Error
+			}
+		}
+
+		return &existing, nil
+	}
+
+	id := i.node.Generate().Int64()
+
+	stored, err := i.private(ctx, id, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	pki := &db.PKI{
+		ID:        id,
+		Type:      keyType,
+		Bits:      bits,
+		Private:   stored,
+		Signer:    signer.DB,
+		X509:      caPEM,
+		Serial:    Serial(ca),
+		Chain:     chain,
+		CA:        true,
+		NotBefore: &ca.NotBefore,
+		ExpiresAt: &ca.NotAfter,
+		Active:    true,
+	}
+
+	if sql := i.db.Create(pki); sql.Error != nil {
+		return nil, sql.Error
+	}
+
+	return pki, nil
+}
+
+func publicKeyEqual(a, b crypto.PublicKey) bool {
+	k, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && k.Equal(b)
+}
+
+// KeyType returns the type and size of a public key as stored with the pki, an rsa key that signs with pss
+// is stored as RSA-PSS
+func KeyType(pub crypto.PublicKey) (string, int) {
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		return "ECDSA", pub.Curve.Params().BitSize
+	case *rsa.PublicKey:
+		return "RSA", pub.N.BitLen()
+	case ed25519.PublicKey:
+		return "Ed25519", 256
+	}
+
+	return "", 0
+}
+
+// ParseCertificate parses the first certificate of a pem
+func ParseCertificate(data string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("invalid certificate")
+	}
+
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// Serial returns the serial number of a certificate in hex as it is stored with the pki
+func Serial(cert *x509.Certificate) string {
+	return cert.SerialNumber.Text(16)
+}
+
+// SetSerials stores the serial number of the pki that were stored before their serial number was, imported pki
+// are found by it
+func SetSerials(database *gorm.DB) error {
+	var pki []db.PKI
+	if sql := database.Select("id", "x509").Where("serial = ? OR serial IS NULL", "").Find(&pki); sql.Error != nil {
+		return sql.Error
+	}
+
+	for _, p := range pki {
+		cert, err := ParseCertificate(p.X509)
+		if err != nil {
+			return fmt.Errorf("invalid certificate for pki %d: %w", p.ID, err)
+		}
+
+		if sql := database.Model(&db.PKI{}).Where("id = ?", p.ID).Update("serial", Serial(cert)); sql.Error != nil {
+			return sql.Error
+		}
+	}
+
+	return nil
+}
+
+// ParsePrivateKey parses an ec, rsa or pkcs8 private key pem, ed25519 keys are pkcs8
+func ParsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("invalid private key")
+	}
+
+	var key interface{}
+	var err error
+
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported private key type: %s", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key: %T", key)
+	}
+
+	return s, nil
+}
+
+// Bundle returns the certificates registries have to trust, the roots of the cas and of the active and renewed
+// signing certificates. A signing certificate issued by a ca is verified with the chain in the token so a rotation
+// does not change the bundle.
+func Bundle(database *gorm.DB, retain time.Duration) ([]string, error) {
+	pki, err := Published(database, false, retain)
+	if err != nil {
+		return nil, err
+	}
+
+	certs := []string{}
+	seen := map[string]bool{}
+	for _, p := range pki {
+		root := Root(p.X509 + p.Chain)
+		if !seen[root] {
+			seen[root] = true
+			certs = append(certs, root)
+		}
+	}
+
+	return certs, nil
+}
+
+// Published returns the unexpired pki whose certificates are published, the active and renewed signing pki, the
+// cas unless signingOnly is set and the signing pki that were deactivated within retain, the longest lifetime
+// of a token, as tokens signed with them are still in use
+func Published(database *gorm.DB, signingOnly bool, retain time.Duration) ([]db.PKI, error) {
+	now := time.Now().UTC()
+
+	query := database.Where("expires_at > ?", now)
+	if signingOnly {
+		query = query.Where("ca = ?", false)
+	}
+
+	var pki []db.PKI
+	sql := query.Where("ca = ? OR active = ? OR activate_at IS NOT NULL OR deactivated_at > ?", true, true, now.Add(-retain)).Order("created_at").Find(&pki)
+	if sql.Error != nil {
+		return nil, sql.Error
+	}
+
+	return pki, nil
+}
+
+// Root returns the last certificate of a chain
+func Root(chain string) string {
+	var root []byte
+
+	rest := []byte(chain)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		root = pem.EncodeToMemory(block)
+	}
+
+	return string(root)
+}
+
+// X5C returns the base64 der of the certificate and its chain for the x5c header of a token
+func X5C(pki *db.PKI) []string {
+	var x5c []string
+
+	rest := []byte(pki.X509 + pki.Chain)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			x5c = append(x5c, base64.StdEncoding.EncodeToString(block.Bytes))
+		}
+	}
+
+	return x5c
+}
@@ -0,0 +1,263 @@
+package issuer
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/snowflake"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+
+	"github.com/ekristen/dockit/pkg/common"
+	"github.com/ekristen/dockit/pkg/db"
+	"github.com/ekristen/dockit/pkg/envelope"
+	"github.com/ekristen/dockit/pkg/utils"
+)
+
+func newTestIssuer(t *testing.T, ca bool) (*Issuer, *gorm.DB) {
+	node, err := snowflake.NewNode(1)
+	assert.NoError(t, err)
+
+	ctx := context.WithValue(context.Background(), common.ContextKeyNode, node)
+	database, err := db.New(ctx, "sqlite", fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name()), nil)
+	assert.NoError(t, err)
+
+	return New(database, node, Options{
+		KeyType:   "ec",
+		ECKeySize: 256,
+		Years:     1,
+		Subject:   pkix.Name{CommonName: "dockit"},
+		CA:        ca,
+		CASubject: pkix.Name{CommonName: "dockit ca"},
+		CAYears:   10,
+	}), database
+}
+
+func Test_GenerateCA(t *testing.T) {
+	iss, database := newTestIssuer(t, true)
+
+	pki, generated, err := iss.Ensure(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, generated)
+	assert.False(t, pki.CA)
+
+	ca, caCert, _, err := iss.CA(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, ca.X509, pki.Chain)
+
+	x5c := X5C(pki)
+	assert.Len(t, x5c, 2)
+	assert.Equal(t, base64.StdEncoding.EncodeToString(caCert.Raw), x5c[1])
+
+	// the bundle only has the root, it does not change with a new signing certificate
+	bundle, err := Bundle(database, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{ca.X509}, bundle)
+
+	_, err = iss.Generate(context.Background())
+	assert.NoError(t, err)
+
+	bundle, err = Bundle(database, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{ca.X509}, bundle)
+
+	current, generated, err := iss.Ensure(context.Background())
+	assert.NoError(t, err)
+	assert.False(t, generated)
+	assert.NotEqual(t, pki.ID, current.ID)
+}
+
+func Test_ImportCA(t *testing.T) {
+	rootKey, rootKeyPEM, err := utils.GenerateECKey(256)
+	assert.NoError(t, err)
+	root, _, err := utils.GenerateCA(100, pkix.Name{CommonName: "root"}, &rootKey.PublicKey, rootKey, 10)
+	assert.NoError(t, err)
+	// the root of an intermediate ca allows a path length of one
+	root.MaxPathLen, root.MaxPathLenZero = 1, false
+	der, err := x509.CreateCertificate(rand.Reader, root, root, &rootKey.PublicKey, rootKey)
+	assert.NoError(t, err)
+	root, err = x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	rootPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	// an intermediate ca issued by the root
+	key, keyPEM, err := utils.GenerateECKey(256)
+	assert.NoError(t, err)
+	template, _, err := utils.GenerateCA(101, pkix.Name{CommonName: "intermediate"}, &key.PublicKey, key, 5)
+	assert.NoError(t, err)
+	der, err = x509.CreateCertificate(rand.Reader, template, root, &key.PublicKey, rootKey)
+	assert.NoError(t, err)
+	intermediatePEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	iss, database := newTestIssuer(t, true)
+
+	_, err = iss.ImportCA(context.Background(), append(append([]byte{}, rootPEM...), keyPEM...))
+	assert.EqualError(t, err, "unable to find the certificate of the private key")
+
+	var data []byte
+	data = append(data, intermediatePEM...)
+	data = append(data, keyPEM...)
+	data = append(data, rootPEM...)
+
+	ca, err := iss.ImportCA(context.Background(), data)
+	assert.NoError(t, err)
+	assert.True(t, ca.CA)
+	assert.Equal(t, "65", ca.Serial)
+	assert.NotEqual(t, int64(101), ca.ID)
+	assert.Equal(t, string(rootPEM), ca.Chain)
+
+	pki, generated, err := iss.Ensure(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, generated)
+	assert.Len(t, X5C(pki), 3)
+
+	cert, err := ParseCertificate(pki.X509)
+	assert.NoError(t, err)
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	intermediates := x509.NewCertPool()
+	intermediates.AppendCertsFromPEM(intermediatePEM)
+	_, err = cert.Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}})
+	assert.NoError(t, err)
+
+	bundle, err := Bundle(database, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{string(rootPEM)}, bundle)
+
+	// a signing certificate of another ca is issued again by the imported ca
+	ca, err = iss.ImportCA(context.Background(), append(append([]byte{}, rootPEM...), rootKeyPEM...))
+	assert.NoError(t, err)
+	assert.Equal(t, "64", ca.Serial)
+
+	// importing it again keeps the stored ca
+	again, err := iss.ImportCA(context.Background(), append(append([]byte{}, rootPEM...), rootKeyPEM...))
+	assert.NoError(t, err)
+	assert.Equal(t, ca.ID, again.ID)
+
+	reissued, generated, err := iss.Ensure(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, generated)
+	assert.Equal(t, string(rootPEM), reissued.Chain)
+}
+
+func Test_EnsureOptionsChange(t *testing.T) {
+	iss, database := newTestIssuer(t, false)
+	iss.opts.Prepublish = time.Hour
+
+	active, generated, err := iss.Ensure(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, generated)
+	assert.True(t, active.Active)
+
+	// a new key type is published before it is activated, the active pki keeps signing
+	iss.opts.KeyType = "rsa"
+	iss.opts.RSAKeySize = 2048
+
+	next, generated, err := iss.Ensure(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, generated)
+	assert.False(t, next.Active)
+	assert.Equal(t, "RSA", next.Type)
+	if assert.NotNil(t, next.ActivateAt) {
+		assert.WithinDuration(t, time.Now().Add(time.Hour), *next.ActivateAt, time.Minute)
+	}
+
+	current, generated, err := iss.Ensure(context.Background())
+	assert.NoError(t, err)
+	assert.False(t, generated)
+	assert.Equal(t, active.ID, current.ID)
+
+	bundle, err := Bundle(database, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{active.X509, next.X509}, bundle)
+
+	// another change replaces the pending pki
+	iss.opts.RSAKeySize = 3072
+
+	replaced, generated, err := iss.Ensure(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, generated)
+	assert.NotEqual(t, next.ID, replaced.ID)
+
+	var pending int64
+	assert.NoError(t, database.Model(&db.PKI{}).Where("active = ? AND activate_at IS NOT NULL", false).Count(&pending).Error)
+	assert.Equal(t, int64(1), pending)
+}
+
+func Test_CANotReplaced(t *testing.T) {
+	iss, database := newTestIssuer(t, true)
+
+	_, _, err := iss.Ensure(context.Background())
+	assert.NoError(t, err)
+
+	ca, _, _, err := iss.CA(context.Background())
+	assert.NoError(t, err)
+
+	// a deactivated ca is not replaced by a generated one
+	assert.NoError(t, database.Model(&db.PKI{}).Where("id = ?", ca.ID).Update("active", false).Error)
+
+	_, _, _, err = iss.CA(context.Background())
+	assert.Equal(t, ErrNoActiveCA, err)
+
+	_, err = iss.Generate(context.Background())
+	assert.Equal(t, ErrNoActiveCA, err)
+
+	var count int64
+	assert.NoError(t, database.Model(&db.PKI{}).Where("ca = ?", true).Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+}
+
+func Test_ImportSerial(t *testing.T) {
+	iss, database := newTestIssuer(t, false)
+
+	kms, err := envelope.NewLocalKMS(make([]byte, 32))
+	assert.NoError(t, err)
+	iss.opts.Keyring = envelope.New(kms)
+
+	// serial numbers are up to 20 bytes, they do not fit the id
+	serial, ok := new(big.Int).SetString("7f3b9a0c5e2d41f8a6b0c3d9e1f2a4b5", 16)
+	assert.True(t, ok)
+
+	key, keyPEM, err := utils.GenerateECKey(256)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "dockit"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	data := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), keyPEM...)
+
+	pki, err := iss.Import(context.Background(), data, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "7f3b9a0c5e2d41f8a6b0c3d9e1f2a4b5", pki.Serial)
+	assert.NotEqual(t, serial.Int64(), pki.ID)
+
+	// the key is bound to the id of the pki
+	assert.True(t, envelope.IsBound(pki.Private))
+	_, err = iss.opts.Keyring.Decrypt(context.Background(), pki.ID, pki.Private)
+	assert.NoError(t, err)
+
+	_, err = iss.Import(context.Background(), data, nil)
+	assert.True(t, errors.Is(err, ErrExists))
+
+	// pki stored before their serial number get it set
+	assert.NoError(t, database.Model(&db.PKI{}).Where("id = ?", pki.ID).Update("serial", "").Error)
+	assert.NoError(t, SetSerials(database))
+
+	var stored db.PKI
+	assert.NoError(t, database.First(&stored, pki.ID).Error)
+	assert.Equal(t, pki.Serial, stored.Serial)
+}
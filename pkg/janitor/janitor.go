@@ -0,0 +1,95 @@
+package janitor
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+
+	"github.com/ekristen/dockit/pkg/db"
+	"github.com/ekristen/dockit/pkg/metrics"
+)
+
+// Pruned are the number of records removed by Prune
+type Pruned struct {
+	Permissions int64
+	Tokens      int64
+	Lockouts    int64
+}
+
+// Prune removes expired permission grants and tokens and lockouts whose failures are forgotten after the
+// lockout retention, expired rows are already ignored everywhere else so this only keeps the tables from growing
+func Prune(database *gorm.DB, now time.Time, lockoutRetention time.Duration) (Pruned, error) {
+	var pruned Pruned
+
+	sql := database.Where("expires_at IS NOT NULL AND expires_at <= ?", now).Delete(&db.Permission{})
+	if sql.Error != nil {
+		return pruned, sql.Error
+	}
+	pruned.Permissions = sql.RowsAffected
+
+	sql = database.Where("expires_at <= ?", now).Delete(&db.Token{})
+	if sql.Error != nil {
+		return pruned, sql.Error
+	}
+	pruned.Tokens = sql.RowsAffected
+
+	sql = database.Where("(locked_until IS NULL OR locked_until <= ?) AND (last_failure IS NULL OR last_failure <= ?)", now, now.Add(-lockoutRetention)).Delete(&db.Lockout{})
+	if sql.Error != nil {
+		return pruned, sql.Error
+	}
+	pruned.Lockouts = sql.RowsAffected
+
+	return pruned, nil
+}
+
+// PruneEvents removes registry events older than the retention
+func PruneEvents(database *gorm.DB, before time.Time) (int64, error) {
+	sql := database.Where("timestamp < ?", before).Delete(&db.Event{})
+	return sql.RowsAffected, sql.Error
+}
+
+// Run prunes on every interval until the context is done, events are kept for the retention, 0 keeps them forever,
+// lockouts are kept for the lockout retention after the last failure
+func Run(ctx context.Context, log *logrus.Entry, database *gorm.DB, interval time.Duration, eventRetention time.Duration, lockoutRetention time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	log.WithField("interval", interval).Info("starting janitor")
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			pruned, err := Prune(database, time.Now().UTC(), lockoutRetention)
+			if err != nil {
+				log.WithError(err).Error("unable to prune expired records")
+				continue
+			}
+
+			metrics.PrunedPermissions.Add(pruned.Permissions)
+			metrics.PrunedTokens.Add(pruned.Tokens)
+			metrics.PrunedLockouts.Add(pruned.Lockouts)
+
+			if pruned.Permissions > 0 || pruned.Tokens > 0 || pruned.Lockouts > 0 {
+				log.WithField("permissions", pruned.Permissions).WithField("tokens", pruned.Tokens).WithField("lockouts", pruned.Lockouts).Info("pruned expired records")
+			}
+
+			if eventRetention > 0 {
+				events, err := PruneEvents(database, time.Now().UTC().Add(-eventRetention))
+				if err != nil {
+					log.WithError(err).Error("unable to prune events")
+					continue
+				}
+
+				metrics.PrunedEvents.Add(events)
+
+				if events > 0 {
+					log.WithField("events", events).Info("pruned events")
+				}
+			}
+		}
+	}
+}
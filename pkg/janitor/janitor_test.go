@@ -0,0 +1,92 @@
+package janitor
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/snowflake"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+
+	"github.com/ekristen/dockit/pkg/common"
+	"github.com/ekristen/dockit/pkg/db"
+)
+
+func newTestDB(t *testing.T) *gorm.DB {
+	node, err := snowflake.NewNode(1)
+	assert.NoError(t, err)
+
+	ctx := context.WithValue(context.Background(), common.ContextKeyNode, node)
+	database, err := db.New(ctx, "sqlite", fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name()), nil)
+	assert.NoError(t, err)
+
+	return database
+}
+
+func Test_PruneLockouts(t *testing.T) {
+	database := newTestDB(t)
+
+	now := time.Now().UTC()
+	recent := now.Add(-time.Minute)
+	old := now.Add(-2 * time.Hour)
+	locked := now.Add(time.Minute)
+
+	assert.NoError(t, database.Create(&db.Lockout{Key: "user:recent", Failures: 1, LastFailure: &recent}).Error)
+	assert.NoError(t, database.Create(&db.Lockout{Key: "user:old", Failures: 1, LastFailure: &old}).Error)
+	assert.NoError(t, database.Create(&db.Lockout{Key: "user:locked", Lockouts: 5, LastFailure: &old, LockedUntil: &locked}).Error)
+	assert.NoError(t, database.Create(&db.Lockout{Key: "user:expired", Lockouts: 1, LastFailure: &old, LockedUntil: &recent}).Error)
+
+	pruned, err := Prune(database, now, time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), pruned.Lockouts)
+
+	var keys []string
+	assert.NoError(t, database.Model(&db.Lockout{}).Order("`key`").Pluck("key", &keys).Error)
+	assert.Equal(t, []string{"user:locked", "user:recent"}, keys)
+}
+
+func Test_Prune(t *testing.T) {
+	database := newTestDB(t)
+
+	now := time.Now().UTC()
+	expired := now.Add(-time.Minute)
+	valid := now.Add(time.Minute)
+
+	assert.NoError(t, database.Create(&db.Permission{Type: db.Namespace, Name: "expired", Action: db.Pull, ExpiresAt: &expired}).Error)
+	assert.NoError(t, database.Create(&db.Permission{Type: db.Namespace, Name: "valid", Action: db.Pull, ExpiresAt: &valid}).Error)
+	assert.NoError(t, database.Create(&db.Permission{Type: db.Namespace, Name: "permanent", Action: db.Pull}).Error)
+
+	assert.NoError(t, database.Create(&db.Token{JTI: "expired", Type: db.AccessTokenType, ExpiresAt: &expired}).Error)
+	assert.NoError(t, database.Create(&db.Token{JTI: "valid", Type: db.AccessTokenType, ExpiresAt: &valid}).Error)
+
+	old := now.Add(-48 * time.Hour)
+	assert.NoError(t, database.Create(&db.Event{EventID: "old", Action: "push", Repository: "apps/web", Timestamp: &old}).Error)
+	assert.NoError(t, database.Create(&db.Event{EventID: "recent", Action: "push", Repository: "apps/web", Timestamp: &expired}).Error)
+
+	pruned, err := Prune(database, now, time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, Pruned{Permissions: 1, Tokens: 1}, pruned)
+
+	var names []string
+	assert.NoError(t, database.Model(&db.Permission{}).Order("name").Pluck("name", &names).Error)
+	assert.Equal(t, []string{"permanent", "valid"}, names)
+
+	var jtis []string
+	assert.NoError(t, database.Model(&db.Token{}).Pluck("jti", &jtis).Error)
+	assert.Equal(t, []string{"valid"}, jtis)
+
+	events, err := PruneEvents(database, now.Add(-24*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), events)
+
+	var eventIDs []string
+	assert.NoError(t, database.Model(&db.Event{}).Pluck("event_id", &eventIDs).Error)
+	assert.Equal(t, []string{"recent"}, eventIDs)
+
+	// nothing is left to prune
+	pruned, err = Prune(database, now, time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, Pruned{}, pruned)
+}
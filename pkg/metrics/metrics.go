@@ -0,0 +1,95 @@
+package metrics
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Prefix is used to select which expvars are exposed in the prometheus format
+const Prefix = "dockit_"
+
+var (
+	// AuthFailures counts failed authentication attempts
+	AuthFailures = expvar.NewInt("dockit_auth_failures_total")
+	// Lockouts counts the number of times a username or client ip has been locked out
+	Lockouts = expvar.NewInt("dockit_lockouts_total")
+	// LockedRequests counts authentication attempts rejected due to a lockout
+	LockedRequests = expvar.NewInt("dockit_locked_requests_total")
+	// EventsReceived counts the registry events stored by the events receiver
+	EventsReceived = expvar.NewInt("dockit_events_received_total")
+	// PrunedPermissions counts expired permission grants removed by the janitor
+	PrunedPermissions = expvar.NewInt("dockit_pruned_permissions_total")
+	// PrunedTokens counts expired tokens removed by the janitor
+	PrunedTokens = expvar.NewInt("dockit_pruned_tokens_total")
+	// PrunedEvents counts registry events removed by the janitor after the retention period
+	PrunedEvents = expvar.NewInt("dockit_pruned_events_total")
+	// PrunedLockouts counts lockouts removed by the janitor after their failures were forgotten
+	PrunedLockouts = expvar.NewInt("dockit_pruned_lockouts_total")
+	// PKIExpiry is the unix time the active signing certificate expires at
+	PKIExpiry = expvar.NewInt("dockit_pki_expiry_timestamp_seconds")
+	// PKINextActivation is the unix time the renewed signing certificate is activated at, 0 when there is none
+	PKINextActivation = expvar.NewInt("dockit_pki_next_activation_timestamp_seconds")
+	// CAExpiry is the unix time the active ca expires at, 0 without a ca
+	CAExpiry = expvar.NewInt("dockit_pki_ca_expiry_timestamp_seconds")
+	// PKIRenewals counts the signing certificates created by the renewer
+	PKIRenewals = expvar.NewInt("dockit_pki_renewals_total")
+	// PKIRenewalFailures counts the failed attempts of the renewer to create a signing certificate
+	PKIRenewalFailures = expvar.NewInt("dockit_pki_renewal_failures_total")
+	// PKIActivations counts the renewed signing certificates activated by the renewer
+	PKIActivations = expvar.NewInt("dockit_pki_activations_total")
+)
+
+// Handler writes all dockit expvars in the prometheus text format
+func Handler(w http.ResponseWriter, r *http.Request) {
+	var lines []string
+
+	expvar.Do(func(kv expvar.KeyValue) {
+		if !strings.HasPrefix(kv.Key, Prefix) {
+			return
+		}
+
+		switch v := kv.Value.(type) {
+		case *expvar.Int, *expvar.Float:
+			lines = append(lines, fmt.Sprintf("%s %s", kv.Key, v.String()))
+		}
+	})
+
+	sort.Strings(lines)
+
+	w.Header().Set("content-type", "text/plain; version=0.0.4")
+	w.WriteHeader(200)
+	w.Write([]byte(strings.Join(lines, "\n") + "\n"))
+}
+
+// Serve starts the metrics and debug http server, it blocks until the context is done
+func Serve(ctx context.Context, log *logrus.Entry, port int) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", Handler)
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(":%d", port),
+		Handler: mux,
+	}
+
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.WithError(err).Error("unable to start metrics server")
+		}
+	}()
+	log.WithField("port", port).Info("starting metrics server")
+
+	<-ctx.Done()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return srv.Shutdown(shutdownCtx)
+}
@@ -0,0 +1,59 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	Table = "table"
+	JSON  = "json"
+	YAML  = "yaml"
+)
+
+// Formats are the supported output formats
+var Formats = []string{Table, JSON, YAML}
+
+// Rows is the tabular representation of data, the first row is the header
+type Rows [][]string
+
+// Print writes data to w in the requested format, rows is only used for the table format
+func Print(w io.Writer, format string, data interface{}, rows Rows) error {
+	switch format {
+	case "", Table:
+		tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+		for _, row := range rows {
+			fmt.Fprintln(tw, strings.Join(row, "\t"))
+		}
+		return tw.Flush()
+	case JSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(data)
+	case YAML:
+		// round trip through json so json tags are honored
+		b, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+
+		var v interface{}
+		if err := yaml.Unmarshal(b, &v); err != nil {
+			return err
+		}
+
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+		return enc.Close()
+	default:
+		return fmt.Errorf("unsupported output format: %s, must be one of %s", format, strings.Join(Formats, ", "))
+	}
+}
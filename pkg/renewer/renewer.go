@@ -0,0 +1,190 @@
+package renewer
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/ekristen/dockit/pkg/db"
+	"github.com/ekristen/dockit/pkg/issuer"
+	"github.com/ekristen/dockit/pkg/metrics"
+	"github.com/ekristen/dockit/pkg/signer"
+)
+
+// Options configure when signing certificates are renewed
+type Options struct {
+	// Renew creates the next signing pki, without it expiring certificates are only reported
+	Renew bool
+	// RenewBefore is how long before the active signing certificate expires the next one is created
+	RenewBefore time.Duration
+	// Prepublish is how long the next signing certificate is published before it is activated
+	Prepublish time.Duration
+}
+
+// Check activates a renewed signing pki when it is due, renews the active one when it expires within
+// RenewBefore and reports the expiry of the signing certificate and the ca
+func Check(ctx context.Context, log *logrus.Entry, database *gorm.DB, iss *issuer.Issuer, opts Options, now time.Time) error {
+	var active db.PKI
+	sql := database.Where("active = ? AND ca = ? AND expires_at > ?", true, false, now).Order("created_at DESC").Limit(1).Find(&active)
+	if sql.Error != nil {
+		return sql.Error
+	}
+	hasActive := sql.RowsAffected == 1
+
+	var pending db.PKI
+	sql = database.Where("active = ? AND ca = ? AND activate_at IS NOT NULL AND expires_at > ?", false, false, now).Order("activate_at").Limit(1).Find(&pending)
+	if sql.Error != nil {
+		return sql.Error
+	}
+	hasPending := sql.RowsAffected == 1
+
+	if hasPending && (!hasActive || !now.Before(*pending.ActivateAt)) {
+		if err := issuer.Activate(database, &pending); err != nil {
+			return err
+		}
+
+		metrics.PKIActivations.Add(1)
+		log.WithField("id", pending.ID).WithField("expires_at", pending.ExpiresAt).Info("activated the renewed signing pki")
+
+		active, hasActive, hasPending = pending, true, false
+	}
+
+	if !hasActive {
+		metrics.PKIExpiry.Set(0)
+
+		if !opts.Renew {
+			log.Error("there is no unexpired signing pki, tokens can not be signed")
+			return nil
+		}
+
+		generated, _, err := iss.Ensure(ctx)
+		if err != nil {
+			metrics.PKIRenewalFailures.Add(1)
+			return err
+		}
+
+		metrics.PKIRenewals.Add(1)
+		log.WithField("id", generated.ID).Warn("there was no unexpired signing pki, generated one")
+
+		active = *generated
+	}
+
+	metrics.PKIExpiry.Set(active.ExpiresAt.Unix())
+	metrics.PKINextActivation.Set(0)
+	if hasPending {
+		metrics.PKINextActivation.Set(pending.ActivateAt.Unix())
+	}
+
+	entry := log.WithField("id", active.ID).WithField("expires_at", active.ExpiresAt)
+
+	if !hasPending && !now.Before(active.ExpiresAt.Add(-opts.RenewBefore)) {
+		if opts.Renew && (active.Signer == "" || active.Signer == signer.DB) {
+			// the next pki is activated before the current one expires even when the renewal is late
+			activateAt := now.Add(opts.Prepublish)
+			if activateAt.After(*active.ExpiresAt) {
+				activateAt = *active.ExpiresAt
+			}
+
+			next, err := iss.Renew(ctx, &active, activateAt)
+			if err != nil {
+				metrics.PKIRenewalFailures.Add(1)
+				entry.WithError(err).Error("unable to renew the signing pki")
+			} else {
+				metrics.PKIRenewals.Add(1)
+				metrics.PKINextActivation.Set(activateAt.Unix())
+				entry.WithField("next", next.ID).WithField("activate_at", activateAt).Info("renewed the signing pki, the next one is published until it is activated")
+			}
+		} else {
+			entry.Warn("the signing pki expires soon and is not renewed automatically, import a new certificate")
+		}
+	}
+
+	var ca db.PKI
+	sql = database.Where("active = ? AND ca = ?", true, true).Order("expires_at DESC").Limit(1).Find(&ca)
+	if sql.Error != nil {
+		return sql.Error
+	}
+
+	metrics.CAExpiry.Set(0)
+	if sql.RowsAffected == 1 {
+		metrics.CAExpiry.Set(ca.ExpiresAt.Unix())
+
+		if !now.Before(ca.ExpiresAt.Add(-opts.RenewBefore)) {
+			log.WithField("id", ca.ID).WithField("expires_at", ca.ExpiresAt).Warn("the ca expires soon, signing certificates issued by it do not outlive it, import or generate a new ca")
+		}
+	}
+
+	return nil
+}
+
+// leaseName is the lease the api server that checks the signing pki holds
+const leaseName = "pki-renewer"
+
+// acquire takes the lease for the holder until now + ttl, or extends it when the holder has it already, it
+// reports whether the holder has the lease. The row is inserted once and only taken over conditionally, so
+// of the api servers checking at the same time only one gets it.
+func acquire(database *gorm.DB, holder string, now time.Time, ttl time.Duration) (bool, error) {
+	lease := db.Lease{Name: leaseName, Holder: holder, ExpiresAt: now.Add(ttl)}
+
+	sql := database.Clauses(clause.OnConflict{DoNothing: true}).Create(&lease)
+	if sql.Error != nil {
+		return false, sql.Error
+	}
+	if sql.RowsAffected == 1 {
+		return true, nil
+	}
+
+	sql = database.Model(&db.Lease{}).Where("name = ? AND (holder = ? OR expires_at <= ?)", leaseName, holder, now).Updates(map[string]interface{}{
+		"holder":     holder,
+		"expires_at": now.Add(ttl),
+	})
+	if sql.Error != nil {
+		return false, sql.Error
+	}
+
+	return sql.RowsAffected == 1, nil
+}
+
+// Run checks the signing pki on every interval until the context is done. Only the api server that holds the
+// lease checks, so a renewal happens once no matter how many replicas run, another one takes over when it
+// stops.
+func Run(ctx context.Context, log *logrus.Entry, database *gorm.DB, iss *issuer.Issuer, opts Options, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	holder := uuid.New().String()
+	held := false
+
+	log.WithField("interval", interval).WithField("renew_before", opts.RenewBefore).Info("starting pki renewer")
+
+	for {
+		now := time.Now().UTC()
+
+		// the lease outlives a late check, it is extended on every one
+		ok, err := acquire(database, holder, now, 2*interval)
+		if err != nil {
+			log.WithError(err).Error("unable to acquire the pki renewer lease")
+		} else if ok && !held {
+			log.Info("acquired the pki renewer lease, this api server checks the signing pki")
+		} else if !ok && held {
+			log.Info("another api server took over the pki renewer lease")
+		}
+		held = ok && err == nil
+
+		if ok {
+			if err := Check(ctx, log, database, iss, opts, now); err != nil {
+				log.WithError(err).Error("unable to check the signing pki")
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package renewer
+
+import (
+	"context"
+	"crypto/x509/pkix"
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/snowflake"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ekristen/dockit/pkg/common"
+	"github.com/ekristen/dockit/pkg/db"
+	"github.com/ekristen/dockit/pkg/issuer"
+	"github.com/ekristen/dockit/pkg/metrics"
+)
+
+func Test_Check(t *testing.T) {
+	node, err := snowflake.NewNode(1)
+	assert.NoError(t, err)
+
+	ctx := context.WithValue(context.Background(), common.ContextKeyNode, node)
+	database, err := db.New(ctx, "sqlite", fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name()), nil)
+	assert.NoError(t, err)
+
+	iss := issuer.New(database, node, issuer.Options{KeyType: "ec", ECKeySize: 256, Years: 1, Subject: pkix.Name{CommonName: "dockit"}})
+	current, _, err := iss.Ensure(context.Background())
+	assert.NoError(t, err)
+
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	log := logrus.NewEntry(logger)
+
+	opts := Options{Renew: true, RenewBefore: 30 * 24 * time.Hour, Prepublish: 7 * 24 * time.Hour}
+
+	// nothing happens until the certificate expires within RenewBefore
+	assert.NoError(t, Check(context.Background(), log, database, iss, opts, time.Now().UTC()))
+	bundle, err := issuer.Bundle(database, 0)
+	assert.NoError(t, err)
+	assert.Len(t, bundle, 1)
+	assert.Equal(t, current.ExpiresAt.Unix(), metrics.PKIExpiry.Value())
+
+	// the certificate expires within RenewBefore
+	now := time.Now().UTC()
+	expiresAt := now.Add(20 * 24 * time.Hour)
+	assert.NoError(t, database.Model(current).Update("expires_at", expiresAt).Error)
+
+	assert.NoError(t, Check(context.Background(), log, database, iss, opts, now))
+
+	// the next certificate is published but not active
+	bundle, err = issuer.Bundle(database, 0)
+	assert.NoError(t, err)
+	assert.Len(t, bundle, 2)
+	assert.Equal(t, now.Add(opts.Prepublish).Unix(), metrics.PKINextActivation.Value())
+
+	var active db.PKI
+	assert.NoError(t, database.Where("active = ?", true).First(&active).Error)
+	assert.Equal(t, current.ID, active.ID)
+
+	// a second check does not renew again
+	renewals := metrics.PKIRenewals.Value()
+	assert.NoError(t, Check(context.Background(), log, database, iss, opts, now.Add(time.Hour)))
+	assert.Equal(t, renewals, metrics.PKIRenewals.Value())
+
+	assert.NoError(t, Check(context.Background(), log, database, iss, opts, now.Add(opts.Prepublish)))
+
+	var next db.PKI
+	assert.NoError(t, database.Where("active = ?", true).First(&next).Error)
+	assert.NotEqual(t, current.ID, next.ID)
+	assert.Nil(t, next.ActivateAt)
+	assert.Equal(t, int64(0), metrics.PKINextActivation.Value())
+	assert.Equal(t, renewals, metrics.PKIRenewals.Value())
+
+	// the replaced certificate stays published until the tokens signed with it expired
+	bundle, err = issuer.Bundle(database, time.Hour)
+	assert.NoError(t, err)
+	assert.Len(t, bundle, 2)
+	bundle, err = issuer.Bundle(database, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{next.X509}, bundle)
+}
+
+func Test_CheckCAExpires(t *testing.T) {
+	node, err := snowflake.NewNode(1)
+	assert.NoError(t, err)
+
+	ctx := context.WithValue(context.Background(), common.ContextKeyNode, node)
+	database, err := db.New(ctx, "sqlite", fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name()), nil)
+	assert.NoError(t, err)
+
+	// the signing certificate is capped to the lifetime of the ca
+	iss := issuer.New(database, node, issuer.Options{
+		KeyType: "ec", ECKeySize: 256, Years: 2, Subject: pkix.Name{CommonName: "dockit"},
+		CA: true, CASubject: pkix.Name{CommonName: "dockit ca"}, CAYears: 1,
+	})
+	current, _, err := iss.Ensure(context.Background())
+	assert.NoError(t, err)
+
+	_, err = iss.Renew(context.Background(), current, time.Now())
+	assert.Equal(t, issuer.ErrCAExpires, err)
+}
+
+func Test_Acquire(t *testing.T) {
+	node, err := snowflake.NewNode(1)
+	assert.NoError(t, err)
+
+	ctx := context.WithValue(context.Background(), common.ContextKeyNode, node)
+	database, err := db.New(ctx, "sqlite", fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name()), nil)
+	assert.NoError(t, err)
+
+	now := time.Now().UTC()
+
+	cases := []struct {
+		holder string
+		at     time.Duration
+		held   bool
+	}{
+		{"a", 0, true},
+		{"b", 0, false},
+		// the holder extends its lease
+		{"a", time.Hour, true},
+		{"b", 2 * time.Hour, false},
+		// the lease expired, another api server takes over
+		{"b", 4 * time.Hour, true},
+		{"a", 4 * time.Hour, false},
+	}
+
+	for _, c := range cases {
+		held, err := acquire(database, c.holder, now.Add(c.at), 2*time.Hour)
+		assert.NoError(t, err)
+		assert.Equal(t, c.held, held, "%s at %s", c.holder, c.at)
+	}
+}
@@ -0,0 +1,244 @@
+//go:build cgo
+// +build cgo
+
+package signer
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+)
+
+// digestInfoPrefix is the der prefix of the DigestInfo of a hash for CKM_RSA_PKCS
+var digestInfoPrefix = map[crypto.Hash][]byte{
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA384: {0x30, 0x41, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x02, 0x05, 0x00, 0x04, 0x30},
+	crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+// pssParams are the hash and mask generation function mechanisms of a hash for CKM_RSA_PKCS_PSS
+var pssParams = map[crypto.Hash][2]uint{
+	crypto.SHA256: {pkcs11.CKM_SHA256, pkcs11.CKG_MGF1_SHA256},
+	crypto.SHA384: {pkcs11.CKM_SHA384, pkcs11.CKG_MGF1_SHA384},
+	crypto.SHA512: {pkcs11.CKM_SHA512, pkcs11.CKG_MGF1_SHA512},
+}
+
+type pkcs11Signer struct {
+	// a session must not be used concurrently
+	mu      sync.Mutex
+	ctx     *pkcs11.Ctx
+	config  PKCS11Config
+	session pkcs11.SessionHandle
+	key     pkcs11.ObjectHandle
+	keyType uint
+}
+
+// NewPKCS11Signer opens a session on the token with the label, logs in with the pin and finds the private
+// key with the label
+func NewPKCS11Signer(config PKCS11Config) (Signer, error) {
+	p := pkcs11.New(config.Module)
+	if p == nil {
+		return nil, fmt.Errorf("unable to load pkcs11 module %s", config.Module)
+	}
+
+	if err := p.Initialize(); err != nil {
+		p.Destroy()
+		return nil, fmt.Errorf("unable to initialize pkcs11 module: %w", err)
+	}
+
+	s := &pkcs11Signer{ctx: p, config: config}
+
+	if err := s.open(); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *pkcs11Signer) open() error {
+	config := s.config
+
+	slots, err := s.ctx.GetSlotList(true)
+	if err != nil {
+		return err
+	}
+
+	var slot *uint
+	for _, id := range slots {
+		info, err := s.ctx.GetTokenInfo(id)
+		if err != nil {
+			return err
+		}
+
+		if info.Label == config.TokenLabel {
+			id := id
+			slot = &id
+			break
+		}
+	}
+	if slot == nil {
+		return fmt.Errorf("no pkcs11 token with label %s", config.TokenLabel)
+	}
+
+	s.session, err = s.ctx.OpenSession(*slot, pkcs11.CKF_SERIAL_SESSION)
+	if err != nil {
+		return fmt.Errorf("unable to open pkcs11 session: %w", err)
+	}
+
+	if err := s.ctx.Login(s.session, pkcs11.CKU_USER, config.PIN); err != nil {
+		return fmt.Errorf("unable to log in to pkcs11 token: %w", err)
+	}
+
+	if err := s.ctx.FindObjectsInit(s.session, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, config.KeyLabel),
+	}); err != nil {
+		return err
+	}
+	keys, _, err := s.ctx.FindObjects(s.session, 2)
+	if err != nil {
+		return err
+	}
+	if err := s.ctx.FindObjectsFinal(s.session); err != nil {
+		return err
+	}
+
+	switch len(keys) {
+	case 0:
+		return fmt.Errorf("no private key with label %s", config.KeyLabel)
+	case 1:
+	default:
+		return fmt.Errorf("more than one private key with label %s", config.KeyLabel)
+	}
+	s.key = keys[0]
+
+	attrs, err := s.ctx.GetAttributeValue(s.session, s.key, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, nil),
+	})
+	if err != nil {
+		return err
+	}
+
+	s.keyType = uint(pkcs11BytesToUint(attrs[0].Value))
+	if s.keyType != pkcs11.CKK_EC && s.keyType != pkcs11.CKK_RSA {
+		return fmt.Errorf("unsupported pkcs11 key type: %d", s.keyType)
+	}
+
+	return nil
+}
+
+func pkcs11BytesToUint(b []byte) uint64 {
+	var n uint64
+	for i := len(b) - 1; i >= 0; i-- {
+		n = n<<8 | uint64(b[i])
+	}
+
+	return n
+}
+
+func (s *pkcs11Signer) Name() string {
+	return PKCS11
+}
+
+func (s *pkcs11Signer) Sign(_ context.Context, alg string, signingInput []byte) ([]byte, error) {
+	hash, digest, err := Digest(alg, signingInput)
+	if err != nil {
+		return nil, err
+	}
+
+	var mechanism *pkcs11.Mechanism
+	var data []byte
+
+	switch {
+	case alg[:2] == "ES" && s.keyType == pkcs11.CKK_EC:
+		// the token returns the fixed size r || s of jws
+		mechanism = pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil)
+		data = digest
+	case alg[:2] == "RS" && s.keyType == pkcs11.CKK_RSA:
+		mechanism = pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS, nil)
+		data = append(append([]byte{}, digestInfoPrefix[hash]...), digest...)
+	case alg[:2] == "PS" && s.keyType == pkcs11.CKK_RSA:
+		// the salt of jws is as long as the hash
+		params := pssParams[hash]
+		mechanism = pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_PSS, pkcs11.NewPSSParams(params[0], params[1], uint(hash.Size())))
+		data = digest
+	default:
+		return nil, fmt.Errorf("algorithm %s is not supported by the pkcs11 key", alg)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sig, err := s.sign(mechanism, data)
+	if sessionLost(err) {
+		// the session is gone when the token was reset or the hsm restarted, it is opened once more
+		if err := s.reopen(); err != nil {
+			return nil, fmt.Errorf("unable to reopen pkcs11 session: %w", err)
+		}
+
+		sig, err = s.sign(mechanism, data)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkSignatureSize(alg, sig); err != nil {
+		return nil, err
+	}
+
+	return sig, nil
+}
+
+func (s *pkcs11Signer) sign(mechanism *pkcs11.Mechanism, data []byte) ([]byte, error) {
+	if err := s.ctx.SignInit(s.session, []*pkcs11.Mechanism{mechanism}, s.key); err != nil {
+		return nil, err
+	}
+
+	return s.ctx.Sign(s.session, data)
+}
+
+// reopen closes the session, opens a new one and logs in again, the handle of the key is looked up again as
+// it belongs to the session
+func (s *pkcs11Signer) reopen() error {
+	if s.session != 0 {
+		_ = s.ctx.CloseSession(s.session)
+		s.session = 0
+	}
+
+	return s.open()
+}
+
+// sessionLost reports whether the error means the session has to be opened again
+func sessionLost(err error) bool {
+	var e pkcs11.Error
+	if !errors.As(err, &e) {
+		return false
+	}
+
+	switch uint(e) {
+	case pkcs11.CKR_SESSION_HANDLE_INVALID, pkcs11.CKR_SESSION_CLOSED, pkcs11.CKR_USER_NOT_LOGGED_IN, pkcs11.CKR_OBJECT_HANDLE_INVALID, pkcs11.CKR_KEY_HANDLE_INVALID:
+		return true
+	}
+
+	return false
+}
+
+// Close logs out and closes the session
+func (s *pkcs11Signer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.session != 0 {
+		_ = s.ctx.Logout(s.session)
+		_ = s.ctx.CloseSession(s.session)
+	}
+	_ = s.ctx.Finalize()
+	s.ctx.Destroy()
+
+	return nil
+}
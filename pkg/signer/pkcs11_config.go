@@ -0,0 +1,13 @@
+package signer
+
+// PKCS11 is the signer of a pki whose private key is held by a pkcs11 token, like a hsm
+const PKCS11 = "pkcs11"
+
+// PKCS11Config selects the private key on a pkcs11 token
+type PKCS11Config struct {
+	// Module is the path of the pkcs11 library, for SoftHSM /usr/lib/softhsm/libsofthsm2.so
+	Module     string
+	TokenLabel string
+	PIN        string
+	KeyLabel   string
+}
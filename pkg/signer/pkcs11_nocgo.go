@@ -0,0 +1,11 @@
+//go:build !cgo
+// +build !cgo
+
+package signer
+
+import "errors"
+
+// NewPKCS11Signer is not available without cgo
+func NewPKCS11Signer(config PKCS11Config) (Signer, error) {
+	return nil, errors.New("pkcs11 requires a build with cgo enabled")
+}
@@ -0,0 +1,35 @@
+//go:build cgo
+// +build cgo
+
+package signer
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test_PKCS11Signer runs against a token prepared with SoftHSM, for example:
+//
+//	softhsm2-util --init-token --free --label dockit --pin 1234 --so-pin 1234
+//	pkcs11-tool --module /usr/lib/softhsm/libsofthsm2.so --token-label dockit --login --pin 1234 \
+//	  --keypairgen --key-type EC:prime256v1 --label signing
+//	DOCKIT_TEST_PKCS11_MODULE=/usr/lib/softhsm/libsofthsm2.so go test ./pkg/signer/
+func Test_PKCS11Signer(t *testing.T) {
+	module := os.Getenv("DOCKIT_TEST_PKCS11_MODULE")
+	if module == "" {
+		t.Skip("DOCKIT_TEST_PKCS11_MODULE is not set")
+	}
+
+	s, err := NewPKCS11Signer(PKCS11Config{Module: module, TokenLabel: "dockit", PIN: "1234", KeyLabel: "signing"})
+	assert.NoError(t, err)
+
+	sig, err := s.Sign(context.Background(), "ES256", []byte("header.claims"))
+	assert.NoError(t, err)
+	assert.Len(t, sig, 64)
+
+	_, err = NewPKCS11Signer(PKCS11Config{Module: module, TokenLabel: "dockit", PIN: "1234", KeyLabel: "missing"})
+	assert.Error(t, err)
+}
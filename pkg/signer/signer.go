@@ -0,0 +1,242 @@
+package signer
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// DB is the signer of a pki whose private key is stored in the database
+const DB = "db"
+
+// Signer signs tokens with a private key that may never leave it, like a key in a hsm
+type Signer interface {
+	// Name is stored with the pki whose key the signer holds
+	Name() string
+	// Sign returns the jws signature of the signing input for the algorithm, like ES256
+	Sign(ctx context.Context, alg string, signingInput []byte) ([]byte, error)
+}
+
+// EdDSA is the algorithm of ed25519 keys, it signs the signing input rather than a digest of it
+const EdDSA = "EdDSA"
+
+// DistributionAlgorithms are the algorithms the token authentication of the docker distribution registry
+// accepts
+var DistributionAlgorithms = []string{"ES256", "ES384", "ES512", "RS256", "RS384", "RS512"}
+
+// Algorithm returns the jws algorithm tokens are signed with by a key of the type and size, the type as it
+// is stored with the pki. The hash of an ecdsa key is the one of its curve, the hash of an rsa key grows
+// with its size.
+func Algorithm(keyType string, bits int) (string, error) {
+	switch keyType {
+	case "ECDSA":
+		switch bits {
+		case 256:
+			return "ES256", nil
+		case 384:
+			return "ES384", nil
+		case 521:
+			return "ES512", nil
+		}
+
+		return "", fmt.Errorf("unsupported ecdsa key size: %d", bits)
+	case "RSA", "RSA-PSS":
+		prefix := "RS"
+		if keyType == "RSA-PSS" {
+			prefix = "PS"
+		}
+
+		switch {
+		case bits < 2048:
+			return "", fmt.Errorf("unsupported rsa key size: %d", bits)
+		case bits < 3072:
+			return prefix + "256", nil
+		case bits < 4096:
+			return prefix + "384", nil
+		}
+
+		return prefix + "512", nil
+	case "Ed25519":
+		return EdDSA, nil
+	}
+
+	return "", fmt.Errorf("unsupported key type: %s", keyType)
+}
+
+// Hash returns the hash of a jws algorithm
+func Hash(alg string) (crypto.Hash, error) {
+	if len(alg) != 5 || (alg[:2] != "ES" && alg[:2] != "RS" && alg[:2] != "PS") {
+		return 0, fmt.Errorf("unsupported algorithm: %s", alg)
+	}
+
+	switch alg[2:] {
+	case "256":
+		return crypto.SHA256, nil
+	case "384":
+		return crypto.SHA384, nil
+	case "512":
+		return crypto.SHA512, nil
+	}
+
+	return 0, fmt.Errorf("unsupported algorithm: %s", alg)
+}
+
+// Digest returns the hash of the signing input for the algorithm
+func Digest(alg string, signingInput []byte) (crypto.Hash, []byte, error) {
+	if alg == EdDSA {
+		return 0, nil, fmt.Errorf("algorithm %s signs the signing input rather than a digest", alg)
+	}
+
+	hash, err := Hash(alg)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	h := hash.New()
+	h.Write(signingInput)
+
+	return hash, h.Sum(nil), nil
+}
+
+// checkSignatureSize returns an error when the signature is not as long as one of the algorithm, the fixed
+// size r || s of ecdsa, 64 bytes of ed25519 or the modulus of an rsa key of at least 2048 bits
+func checkSignatureSize(alg string, sig []byte) error {
+	size := 0
+	switch alg {
+	case "ES256":
+		size = 64
+	case "ES384":
+		size = 96
+	case "ES512":
+		size = 132
+	case EdDSA:
+		size = ed25519.SignatureSize
+	default:
+		if _, err := Hash(alg); err != nil {
+			return err
+		}
+
+		// an rsa signature is as long as the modulus, which the signer does not know
+		if len(sig) < 256 || len(sig) > 1024 {
+			return fmt.Errorf("invalid %s signature size: %d bytes", alg, len(sig))
+		}
+
+		return nil
+	}
+
+	if len(sig) != size {
+		return fmt.Errorf("invalid %s signature size: %d bytes, expected %d", alg, len(sig), size)
+	}
+
+	return nil
+}
+
+// SignToken signs the token with the signer, the result is the compact serialization
+func SignToken(ctx context.Context, s Signer, t *jwt.Token) (string, error) {
+	signingString, err := t.SigningString()
+	if err != nil {
+		return "", err
+	}
+
+	sig, err := s.Sign(ctx, t.Method.Alg(), []byte(signingString))
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Join([]string{signingString, base64.RawURLEncoding.EncodeToString(sig)}, "."), nil
+}
+
+// Verify checks the signature of the signing input with the public key, the signer can be checked against
+// the certificate with it before it is used
+func Verify(alg string, signingInput []byte, sig []byte, pub crypto.PublicKey) error {
+	method := jwt.GetSigningMethod(alg)
+	if method == nil {
+		return fmt.Errorf("unsupported algorithm: %s", alg)
+	}
+
+	return method.Verify(string(signingInput), base64.RawURLEncoding.EncodeToString(sig), pub)
+}
+
+// keySigner signs with a crypto.Signer held in memory
+type keySigner struct {
+	key crypto.Signer
+}
+
+// NewKeySigner returns a signer for a private key
+func NewKeySigner(key crypto.Signer) Signer {
+	return &keySigner{key: key}
+}
+
+func (s *keySigner) Name() string {
+	return DB
+}
+
+func (s *keySigner) Sign(_ context.Context, alg string, signingInput []byte) ([]byte, error) {
+	if alg == EdDSA {
+		if _, ok := s.key.Public().(ed25519.PublicKey); !ok {
+			return nil, fmt.Errorf("algorithm %s requires an ed25519 key", alg)
+		}
+
+		return s.key.Sign(rand.Reader, signingInput, crypto.Hash(0))
+	}
+
+	hash, digest, err := Digest(alg, signingInput)
+	if err != nil {
+		return nil, err
+	}
+
+	switch alg[:2] {
+	case "ES":
+		pub, ok := s.key.Public().(*ecdsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("algorithm %s requires an ecdsa key", alg)
+		}
+
+		der, err := s.key.Sign(rand.Reader, digest, hash)
+		if err != nil {
+			return nil, err
+		}
+
+		return ECDSAFromASN1(der, pub.Curve.Params().BitSize)
+	case "RS", "PS":
+		if _, ok := s.key.Public().(*rsa.PublicKey); !ok {
+			return nil, fmt.Errorf("algorithm %s requires an rsa key", alg)
+		}
+
+		if alg[:2] == "PS" {
+			return s.key.Sign(rand.Reader, digest, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: hash})
+		}
+
+		return s.key.Sign(rand.Reader, digest, hash)
+	}
+
+	return nil, fmt.Errorf("unsupported algorithm: %s", alg)
+}
+
+// ECDSAFromASN1 converts an asn.1 ecdsa signature to the fixed size r || s of jws
+func ECDSAFromASN1(der []byte, bitSize int) ([]byte, error) {
+	var sig struct {
+		R, S *big.Int
+	}
+	if _, err := asn1.Unmarshal(der, &sig); err != nil {
+		return nil, errors.New("invalid ecdsa signature")
+	}
+
+	size := (bitSize + 7) / 8
+	out := make([]byte, 2*size)
+	sig.R.FillBytes(out[:size])
+	sig.S.FillBytes(out[size:])
+
+	return out, nil
+}
@@ -0,0 +1,168 @@
+package signer
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_KeySigner(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	assert.NoError(t, err)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	cases := []struct {
+		alg string
+		s   Signer
+		pub interface{}
+	}{
+		{"ES384", NewKeySigner(ecKey), &ecKey.PublicKey},
+		{"RS256", NewKeySigner(rsaKey), &rsaKey.PublicKey},
+		{"PS256", NewKeySigner(rsaKey), &rsaKey.PublicKey},
+		{"PS512", NewKeySigner(rsaKey), &rsaKey.PublicKey},
+		{"EdDSA", NewKeySigner(edKey), edPub},
+	}
+
+	for _, c := range cases {
+		token, err := SignToken(context.Background(), c.s, jwt.NewWithClaims(jwt.GetSigningMethod(c.alg), jwt.MapClaims{"sub": "bob"}))
+		assert.NoError(t, err, c.alg)
+
+		parsed, err := jwt.Parse(token, func(*jwt.Token) (interface{}, error) { return c.pub, nil })
+		assert.NoError(t, err, c.alg)
+		assert.True(t, parsed.Valid, c.alg)
+	}
+
+	// the algorithm has to match the key
+	_, err = NewKeySigner(rsaKey).Sign(context.Background(), "ES256", []byte("input"))
+	assert.Error(t, err)
+	_, err = NewKeySigner(ecKey).Sign(context.Background(), "EdDSA", []byte("input"))
+	assert.Error(t, err)
+}
+
+func Test_Algorithm(t *testing.T) {
+	cases := []struct {
+		keyType string
+		bits    int
+		alg     string
+	}{
+		{"ECDSA", 256, "ES256"},
+		{"ECDSA", 384, "ES384"},
+		{"ECDSA", 521, "ES512"},
+		{"RSA", 2048, "RS256"},
+		{"RSA", 3072, "RS384"},
+		{"RSA", 4096, "RS512"},
+		{"RSA-PSS", 2048, "PS256"},
+		{"RSA-PSS", 4096, "PS512"},
+		{"Ed25519", 256, "EdDSA"},
+	}
+
+	for _, c := range cases {
+		alg, err := Algorithm(c.keyType, c.bits)
+		assert.NoError(t, err, c.alg)
+		assert.Equal(t, c.alg, alg)
+	}
+
+	_, err := Algorithm("ECDSA", 224)
+	assert.Error(t, err)
+	_, err = Algorithm("RSA", 1024)
+	assert.Error(t, err)
+	_, err = Algorithm("ec", 256)
+	assert.Error(t, err)
+}
+
+func Test_WebhookSigner(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var req WebhookRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, "ES256", req.Algorithm)
+		assert.Equal(t, "key-1", req.KeyID)
+
+		digest, err := base64.RawURLEncoding.DecodeString(req.Digest)
+		assert.NoError(t, err)
+
+		der, err := key.Sign(rand.Reader, digest, nil)
+		assert.NoError(t, err)
+		sig, err := ECDSAFromASN1(der, 256)
+		assert.NoError(t, err)
+
+		_ = json.NewEncoder(w).Encode(WebhookResponse{Signature: base64.RawURLEncoding.EncodeToString(sig)})
+	}))
+	defer server.Close()
+
+	input := []byte("header.claims")
+
+	sig, err := NewWebhookSigner(server.URL, "secret", "key-1").Sign(context.Background(), "ES256", input)
+	assert.NoError(t, err)
+	assert.NoError(t, Verify("ES256", input, sig, &key.PublicKey))
+
+	_, err = NewWebhookSigner(server.URL, "wrong", "key-1").Sign(context.Background(), "ES256", input)
+	assert.Error(t, err)
+
+	// a signature of the wrong size is rejected rather than put into a token
+	short := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(WebhookResponse{Signature: base64.RawURLEncoding.EncodeToString(make([]byte, 32))})
+	}))
+	defer short.Close()
+
+	_, err = NewWebhookSigner(short.URL, "secret", "key-1").Sign(context.Background(), "ES256", input)
+	assert.Error(t, err)
+
+	// only the start of a large error response ends up in the error
+	large := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(strings.Repeat("x", 1<<20)))
+	}))
+	defer large.Close()
+
+	_, err = NewWebhookSigner(large.URL, "secret", "key-1").Sign(context.Background(), "ES256", input)
+	assert.Error(t, err)
+	assert.Less(t, len(err.Error()), 2*maxErrorBody)
+}
+
+func Test_CheckSignatureSize(t *testing.T) {
+	cases := []struct {
+		alg  string
+		size int
+		ok   bool
+	}{
+		{"ES256", 64, true},
+		{"ES256", 63, false},
+		{"ES384", 96, true},
+		{"ES384", 64, false},
+		{"ES512", 132, true},
+		{"EdDSA", 64, true},
+		{"EdDSA", 65, false},
+		{"RS256", 256, true},
+		{"PS512", 512, true},
+		{"RS256", 128, false},
+		{"HS256", 32, false},
+	}
+
+	for _, c := range cases {
+		err := checkSignatureSize(c.alg, make([]byte, c.size))
+		assert.Equal(t, c.ok, err == nil, "%s %d", c.alg, c.size)
+	}
+}
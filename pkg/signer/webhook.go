@@ -0,0 +1,113 @@
+package signer
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Webhook is the signer of a pki whose private key is held by a remote signing service
+const Webhook = "webhook"
+
+// WebhookRequest is posted to the signing service, the digest is the base64url encoded hash of the
+// signing input for the algorithm, with EdDSA the base64url encoded signing input itself is sent as input
+type WebhookRequest struct {
+	KeyID     string `json:"key_id,omitempty"`
+	Algorithm string `json:"algorithm"`
+	Digest    string `json:"digest,omitempty"`
+	Input     string `json:"input,omitempty"`
+}
+
+// WebhookResponse is the response of the signing service, the signature is the base64url encoded jws
+// signature, for ecdsa the fixed size r || s
+type WebhookResponse struct {
+	Signature string `json:"signature"`
+}
+
+// maxErrorBody is how much of the response of a failed request is read for the error
+const maxErrorBody = 4096
+
+type webhookSigner struct {
+	url    string
+	token  string
+	keyID  string
+	client *http.Client
+}
+
+// NewWebhookSigner returns a signer that posts the digests to the url, the token is sent as a bearer token
+func NewWebhookSigner(url string, token string, keyID string) Signer {
+	return &webhookSigner{
+		url:    url,
+		token:  token,
+		keyID:  keyID,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *webhookSigner) Name() string {
+	return Webhook
+}
+
+func (s *webhookSigner) Sign(ctx context.Context, alg string, signingInput []byte) ([]byte, error) {
+	body := WebhookRequest{
+		KeyID:     s.keyID,
+		Algorithm: alg,
+	}
+
+	if alg == EdDSA {
+		body.Input = base64.RawURLEncoding.EncodeToString(signingInput)
+	} else {
+		_, digest, err := Digest(alg, signingInput)
+		if err != nil {
+			return nil, err
+		}
+		body.Digest = base64.RawURLEncoding.EncodeToString(digest)
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", s.url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.token != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.token))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return nil, fmt.Errorf("signing service responded with %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var res WebhookResponse
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, fmt.Errorf("invalid response of signing service: %w", err)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(res.Signature, "="))
+	if err != nil || len(sig) == 0 {
+		return nil, fmt.Errorf("invalid signature from signing service")
+	}
+	if err := checkSignatureSize(alg, sig); err != nil {
+		return nil, fmt.Errorf("invalid signature from signing service: %w", err)
+	}
+
+	return sig, nil
+}
@@ -0,0 +1,22 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ParseCIDRs(t *testing.T) {
+	nets, err := ParseCIDRs([]string{"10.0.0.0/8", "192.168.1.10", "fd00::/8"})
+	assert.NoError(t, err)
+	assert.Len(t, nets, 3)
+
+	assert.True(t, ContainsIP(nets, "10.1.2.3"))
+	assert.True(t, ContainsIP(nets, "192.168.1.10"))
+	assert.False(t, ContainsIP(nets, "192.168.1.11"))
+	assert.True(t, ContainsIP(nets, "fd00::1"))
+	assert.False(t, ContainsIP(nets, "not-an-ip"))
+
+	_, err = ParseCIDRs([]string{"10.0.0.0/33"})
+	assert.Error(t, err)
+}
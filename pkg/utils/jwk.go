@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+)
+
+// JWK is the public key of a signing certificate as a json web key (RFC 7517)
+type JWK struct {
+	KeyType string   `json:"kty"`
+	KeyID   string   `json:"kid"`
+	Use     string   `json:"use"`
+	Alg     string   `json:"alg,omitempty"`
+	Curve   string   `json:"crv,omitempty"`
+	X       string   `json:"x,omitempty"`
+	Y       string   `json:"y,omitempty"`
+	N       string   `json:"n,omitempty"`
+	E       string   `json:"e,omitempty"`
+	X5C     []string `json:"x5c,omitempty"`
+}
+
+// JWKS is a json web key set
+type JWKS struct {
+	Keys []*JWK `json:"keys"`
+}
+
+func encodeInt(i *big.Int, size int) string {
+	b := i.Bytes()
+	if len(b) < size {
+		b = append(make([]byte, size-len(b)), b...)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// NewJWK returns the public key of the certificate as a json web key, the key id is the RFC 7638 thumbprint
+func NewJWK(cert *x509.Certificate) (*JWK, error) {
+	jwk := &JWK{Use: "sig", X5C: []string{base64.StdEncoding.EncodeToString(cert.Raw)}}
+
+	switch pub := cert.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+
+		jwk.KeyType = "EC"
+		jwk.Curve = pub.Curve.Params().Name
+		jwk.X = encodeInt(pub.X, size)
+		jwk.Y = encodeInt(pub.Y, size)
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = encodeInt(pub.N, 0)
+		jwk.E = encodeInt(big.NewInt(int64(pub.E)), 0)
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+	default:
+		return nil, fmt.Errorf("unsupported public key type: %T", cert.PublicKey)
+	}
+
+	thumbprint, err := jwk.Thumbprint()
+	if err != nil {
+		return nil, err
+	}
+	jwk.KeyID = thumbprint
+
+	return jwk, nil
+}
+
+// Thumbprint returns the base64url encoded sha256 of the required members of the key (RFC 7638)
+func (k *JWK) Thumbprint() (string, error) {
+	var members interface{}
+
+	// encoding/json sorts the keys of a map which is the order RFC 7638 requires
+	switch k.KeyType {
+	case "EC":
+		members = map[string]string{"crv": k.Curve, "kty": k.KeyType, "x": k.X, "y": k.Y}
+	case "RSA":
+		members = map[string]string{"e": k.E, "kty": k.KeyType, "n": k.N}
+	case "OKP":
+		members = map[string]string{"crv": k.Curve, "kty": k.KeyType, "x": k.X}
+	default:
+		return "", fmt.Errorf("unsupported key type: %s", k.KeyType)
+	}
+
+	data, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
@@ -3,6 +3,7 @@ package utils
 import (
 	"bytes"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
@@ -11,18 +12,82 @@ import (
 	"encoding/pem"
 	"fmt"
 	"math/big"
+	"strings"
 	"time"
 )
 
-func GenerateCertificate(id int64, pub, priv interface{}, years, months, days int) (cert *x509.Certificate, certPem []byte, err error) {
+// DefaultSubject is the subject of the signing certificates when none is configured
+const DefaultSubject = "/C=US/ST=dev/O=ekristen/OU=dockit"
+
+// DefaultCASubject is the subject of the internal ca when none is configured
+const DefaultCASubject = "/C=US/ST=dev/O=ekristen/OU=dockit/CN=dockit ca"
+
+// ParseSubject parses a subject in the format of openssl, like /C=US/O=example/CN=dockit. An attribute can
+// be repeated for more than one value, a slash within a value is escaped with a backslash.
+func ParseSubject(subject string) (pkix.Name, error) {
+	var name pkix.Name
+
+	if !strings.HasPrefix(subject, "/") {
+		return name, fmt.Errorf("invalid subject, must start with a /: %s", subject)
+	}
+
+	var parts []string
+	var current strings.Builder
+	for i := 1; i < len(subject); i++ {
+		switch {
+		case subject[i] == '\\' && i+1 < len(subject):
+			i++
+			current.WriteByte(subject[i])
+		case subject[i] == '/':
+			parts = append(parts, current.String())
+			current.Reset()
+		default:
+			current.WriteByte(subject[i])
+		}
+	}
+	parts = append(parts, current.String())
+
+	for _, part := range parts {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return name, fmt.Errorf("invalid subject attribute: %s", part)
+		}
+
+		switch strings.ToUpper(kv[0]) {
+		case "C":
+			name.Country = append(name.Country, kv[1])
+		case "ST":
+			name.Province = append(name.Province, kv[1])
+		case "L":
+			name.Locality = append(name.Locality, kv[1])
+		case "O":
+			name.Organization = append(name.Organization, kv[1])
+		case "OU":
+			name.OrganizationalUnit = append(name.OrganizationalUnit, kv[1])
+		case "CN":
+			if name.CommonName != "" {
+				return name, fmt.Errorf("invalid subject, more than one CN")
+			}
+			name.CommonName = kv[1]
+		default:
+			return name, fmt.Errorf("unsupported subject attribute: %s", kv[0])
+		}
+	}
+
+	return name, nil
+}
+
+// GenerateCertificate returns a self-signed signing certificate
+func GenerateCertificate(id int64, subject pkix.Name, pub, priv interface{}, years, months, days int) (cert *x509.Certificate, certPem []byte, err error) {
+	return IssueCertificate(id, subject, pub, nil, priv, years, months, days)
+}
+
+// IssueCertificate returns a signing certificate issued by the ca, without a ca it is self-signed with priv.
+// A certificate issued by a ca does not outlive it.
+func IssueCertificate(id int64, subject pkix.Name, pub interface{}, ca *x509.Certificate, priv interface{}, years, months, days int) (cert *x509.Certificate, certPem []byte, err error) {
 	template := &x509.Certificate{
-		SerialNumber: big.NewInt(id),
-		Subject: pkix.Name{
-			OrganizationalUnit: []string{"dockit"},
-			Organization:       []string{"ekristen"},
-			Country:            []string{"US"},
-			Province:           []string{"dev"},
-		},
+		SerialNumber:          big.NewInt(id),
+		Subject:               subject,
 		NotBefore:             time.Now(),
 		NotAfter:              time.Now().AddDate(years, months, days),
 		IsCA:                  false,
@@ -31,13 +96,48 @@ func GenerateCertificate(id int64, pub, priv interface{}, years, months, days in
 		BasicConstraintsValid: true,
 	}
 
+	parent := template
+	if ca != nil {
+		parent = ca
+		template.KeyUsage = x509.KeyUsageDigitalSignature
+		if template.NotAfter.After(ca.NotAfter) {
+			template.NotAfter = ca.NotAfter
+		}
+	}
+
+	return createCertificate(template, parent, pub, priv)
+}
+
+// GenerateCA returns a self-signed ca certificate that can only issue signing certificates
+func GenerateCA(id int64, subject pkix.Name, pub, priv interface{}, years int) (cert *x509.Certificate, certPem []byte, err error) {
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(id),
+		Subject:               subject,
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().AddDate(years, 0, 0),
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+	}
+
+	return createCertificate(template, template, pub, priv)
+}
+
+func createCertificate(template, parent *x509.Certificate, pub, priv interface{}) (cert *x509.Certificate, certPem []byte, err error) {
 	certDer, err := x509.CreateCertificate(
-		rand.Reader, template, template, pub, priv,
+		rand.Reader, template, parent, pub, priv,
 	)
 	if err != nil {
 		return nil, nil, err
 	}
 
+	// the parsed certificate has the key ids that are filled in on creation
+	cert, err = x509.ParseCertificate(certDer)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	certBlock := pem.Block{
 		Type:  "CERTIFICATE",
 		Bytes: certDer,
@@ -49,7 +149,7 @@ func GenerateCertificate(id int64, pub, priv interface{}, years, months, days in
 		return nil, nil, err
 	}
 
-	return template, buf.Bytes(), err
+	return cert, buf.Bytes(), err
 }
 
 func GenerateECKey(curveSize int) (key *ecdsa.PrivateKey, pemData []byte, err error) {
@@ -62,6 +162,8 @@ func GenerateECKey(curveSize int) (key *ecdsa.PrivateKey, pemData []byte, err er
 		curve = elliptic.P256()
 	case 384:
 		curve = elliptic.P384()
+	case 521:
+		curve = elliptic.P521()
 	default:
 		return nil, nil, fmt.Errorf("unsupported curve size: %d", curveSize)
 	}
@@ -115,3 +217,28 @@ func GenerateRSAKey(bits int) (key *rsa.PrivateKey, pemData []byte, err error) {
 
 	return key, buf.Bytes(), nil
 }
+
+func GenerateEd25519Key() (key ed25519.PrivateKey, pemData []byte, err error) {
+	_, key, err = ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyBlock := pem.Block{
+		Type:  "PRIVATE KEY",
+		Bytes: keyDer,
+	}
+
+	buf := bytes.NewBuffer(pemData)
+
+	if err := pem.Encode(buf, &keyBlock); err != nil {
+		return nil, nil, err
+	}
+
+	return key, buf.Bytes(), nil
+}
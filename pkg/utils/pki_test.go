@@ -2,6 +2,7 @@ package utils
 
 import (
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/pem"
 	"testing"
 
@@ -23,7 +24,10 @@ func Test_GenerateCertificate(t *testing.T) {
 	key, _, err := GenerateECKey(256)
 	assert.NoError(t, err)
 
-	_, certPem, err := GenerateCertificate(1, &key.PublicKey, key, 1, 0, 0)
+	subject, err := ParseSubject("/C=US/O=SANS Institute/CN=dockit")
+	assert.NoError(t, err)
+
+	_, certPem, err := GenerateCertificate(1, subject, &key.PublicKey, key, 1, 0, 0)
 	assert.NoError(t, err)
 
 	assert.Contains(t, string(certPem), "BEGIN CERTIFICATE")
@@ -42,3 +46,40 @@ func Test_GenerateCertificate(t *testing.T) {
 	assert.Equal(t, x509.KeyUsageDigitalSignature|x509.KeyUsageCertSign, cert.KeyUsage)
 	assert.Equal(t, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth}, cert.ExtKeyUsage)
 }
+
+func Test_ParseSubject(t *testing.T) {
+	name, err := ParseSubject(`/C=US/ST=dev/O=ekristen/OU=dockit/OU=ops\/infra/CN=dockit ca`)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"US"}, name.Country)
+	assert.Equal(t, []string{"dev"}, name.Province)
+	assert.Equal(t, []string{"ekristen"}, name.Organization)
+	assert.Equal(t, []string{"dockit", "ops/infra"}, name.OrganizationalUnit)
+	assert.Equal(t, "dockit ca", name.CommonName)
+
+	for _, subject := range []string{"CN=dockit", "/CN=", "/X=1", "/CN=a/CN=b", "/O"} {
+		_, err := ParseSubject(subject)
+		assert.Error(t, err, subject)
+	}
+}
+
+func Test_IssueCertificate(t *testing.T) {
+	caKey, _, err := GenerateECKey(256)
+	assert.NoError(t, err)
+	ca, _, err := GenerateCA(1, pkix.Name{CommonName: "dockit ca"}, &caKey.PublicKey, caKey, 1)
+	assert.NoError(t, err)
+	assert.True(t, ca.IsCA)
+
+	key, _, err := GenerateECKey(256)
+	assert.NoError(t, err)
+	cert, _, err := IssueCertificate(2, pkix.Name{CommonName: "dockit"}, &key.PublicKey, ca, caKey, 2, 0, 0)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "dockit ca", cert.Issuer.CommonName)
+	assert.Equal(t, x509.KeyUsageDigitalSignature, cert.KeyUsage)
+	assert.Equal(t, ca.NotAfter, cert.NotAfter)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	_, err = cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}})
+	assert.NoError(t, err)
+}